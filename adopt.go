@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// adoptedPID returns PID of the running process to adopt from -adopt-pid or -adopt-pid-file.
+func adoptedPID(opts *options) (int, error) {
+	pid := opts.adoptPID
+	if opts.adoptPIDFile != "" {
+		var err error
+		if pid, err = readDaemonPIDFile(opts.adoptPIDFile); err != nil {
+			return 0, err
+		}
+	}
+
+	if pid == 1 || pid == os.Getpid() {
+		return 0, fmt.Errorf("can't adopt PID %d", pid)
+	}
+	if !daemonAlive(pid) {
+		return 0, fmt.Errorf("process %d is not running", pid)
+	}
+	return pid, nil
+}
+
+// adoptCommand returns the command for the running process that ruc did not start.
+// It is tracked like a daemon (see -daemon-pid-file): signaled on stops and polled for exit,
+// but its output and exit status are not available.
+func adoptCommand(pid int) *command {
+	argv := []string{strconv.Itoa(pid)}
+	if b, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline"); err == nil && len(b) > 0 {
+		argv = strings.Split(strings.TrimRight(string(b), "\x00"), "\x00")
+	}
+
+	p, _ := os.FindProcess(pid) // always succeeds on Unix
+	return &command{
+		Cmd:     &exec.Cmd{Path: argv[0], Args: argv, Process: p},
+		streams: &streams{flush: func() {}},
+		argv:    argv,
+		runID:   newRunID(),
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Environment variables describing -alternate-dir directories.
+const (
+	dataDirEnv         = "RUC_DATA_DIR"          // the directory of the current run
+	previousDataDirEnv = "RUC_PREVIOUS_DATA_DIR" // the directory of the previous run; passed to -promote-cmd only, empty before the first run
+)
+
+// nextDataDir selects the next -alternate-dir directory, running -promote-cmd for it.
+// The selection is not advanced if the command fails, so the next attempt promotes the same directory again.
+func nextDataDir(opts *options, in *instance) error {
+	dir := opts.altDirs[in.altIndex%len(opts.altDirs)]
+
+	if opts.promoteCmd != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), opts.promoteTimeout)
+		defer cancel()
+
+		in.log.Printf("Promoting data directory %s...", dir)
+		cmd := hookCommand(ctx, opts.promoteCmd, in, 0, "", []string{
+			dataDirEnv + "=" + dir,
+			previousDataDirEnv + "=" + in.dataDir,
+		})
+
+		start := time.Now()
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("promote command timed out after %s", opts.promoteTimeout)
+			}
+			return fmt.Errorf("promote command failed: %w", err)
+		}
+		in.log.Printf("Promote command finished in %s.", time.Since(start).Round(time.Millisecond))
+	}
+
+	in.dataDir = dir
+	in.altIndex++
+	return nil
+}
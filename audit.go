@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// auditRecord describes exactly what was started.
+type auditRecord struct {
+	Time   time.Time         `json:"time"`
+	RunID  string            `json:"run_id"`
+	Seed   int64             `json:"seed"`
+	PID    int               `json:"pid"`
+	Argv   []string          `json:"argv"`
+	Path   string            `json:"path"`
+	Dir    string            `json:"dir"`
+	Env    []string          `json:"env"`
+	UID    int               `json:"uid"`
+	GID    int               `json:"gid"`
+	Groups []int             `json:"groups,omitempty"`
+	Limits map[string]string `json:"limits"`
+}
+
+// sensitiveEnvName matches names of environment variables which values are masked in audit records.
+var sensitiveEnvName = regexp.MustCompile(`(?i)(secret|token|passw|credential|private|api_?key|access_?key)`)
+
+// rlimits are resource limits included into audit records.
+var rlimits = []struct {
+	name     string
+	resource int
+}{
+	{"as", syscall.RLIMIT_AS},
+	{"core", syscall.RLIMIT_CORE},
+	{"cpu", syscall.RLIMIT_CPU},
+	{"data", syscall.RLIMIT_DATA},
+	{"fsize", syscall.RLIMIT_FSIZE},
+	{"nofile", syscall.RLIMIT_NOFILE},
+	{"stack", syscall.RLIMIT_STACK},
+}
+
+// newAuditRecord returns audit record for the started command.
+func newAuditRecord(opts *options, cmd *command) *auditRecord {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	// the last value of duplicate variables is used
+	last := make(map[string]int, len(env))
+	for i, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		last[name] = i
+	}
+
+	masked := make([]string, 0, len(env))
+	for i, e := range env {
+		name, value, _ := strings.Cut(e, "=")
+		if last[name] != i || name == trampolineEnv {
+			continue
+		}
+		if sensitiveEnvName.MatchString(name) {
+			value = redacted
+		}
+		masked = append(masked, name+"="+opts.output.redact.redactString(value))
+	}
+
+	argv := make([]string, len(cmd.argv))
+	for i, a := range cmd.argv {
+		argv[i] = opts.output.redact.redactString(a)
+	}
+
+	dir := cmd.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+
+	groups, _ := os.Getgroups()
+
+	limits := make(map[string]string)
+	for _, l := range rlimits {
+		var rl syscall.Rlimit
+		if err := syscall.Getrlimit(l.resource, &rl); err == nil {
+			limits[l.name] = formatRlimit(uint64(rl.Cur)) + "/" + formatRlimit(uint64(rl.Max))
+		}
+	}
+	for _, l := range opts.limits {
+		limits["ruc "+l.name] = fmt.Sprint(l.max)
+	}
+
+	return &auditRecord{
+		Time:   time.Now(),
+		RunID:  cmd.runID,
+		Seed:   cmd.seed,
+		PID:    cmd.Process.Pid,
+		Argv:   argv,
+		Path:   cmd.path,
+		Dir:    dir,
+		Env:    masked,
+		UID:    os.Getuid(),
+		GID:    os.Getgid(),
+		Groups: groups,
+		Limits: limits,
+	}
+}
+
+// formatRlimit formats a single resource limit value.
+func formatRlimit(v uint64) string {
+	if int64(v) == int64(syscall.RLIM_INFINITY) {
+		return "unlimited"
+	}
+	return fmt.Sprint(v)
+}
+
+// audit logs the audit record, or appends it to the file (as JSON line) if path is not empty.
+func audit(path string, r *auditRecord) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Failed to encode audit record: %s", err)
+		return
+	}
+
+	if path == "" {
+		log.Printf("Audit: %s", b)
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err == nil {
+		_, err = f.Write(append(b, '\n'))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to write audit record: %s", err)
+	}
+}
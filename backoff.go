@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// backoff delays restarts of a program that keeps exiting soon after start.
+type backoff struct {
+	initial    time.Duration // 0 disables backoff
+	max        time.Duration
+	multiplier float64
+	reset      time.Duration // uptime after which the program is considered stable
+
+	failures int // consecutive unstable runs
+}
+
+// next records program uptime and returns delay before the next start.
+func (b *backoff) next(uptime time.Duration) time.Duration {
+	if b.initial <= 0 {
+		return 0
+	}
+
+	if uptime >= b.reset {
+		if b.failures > 0 {
+			log.Printf("Program was stable for %s, resetting backoff.", uptime.Round(time.Second))
+			b.failures = 0
+		}
+		return 0
+	}
+
+	b.failures++
+
+	d := float64(b.initial)
+	for i := 1; i < b.failures && d < float64(b.max); i++ {
+		d *= b.multiplier
+	}
+
+	return min(time.Duration(d), b.max)
+}
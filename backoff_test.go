@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := backoff{initial: time.Second, max: 10 * time.Second, multiplier: 2, reset: time.Minute}
+
+	for i, expected := range []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+	} {
+		if d := b.next(time.Second); d != expected {
+			t.Errorf("unstable run %d: expected %s, got %s", i+1, expected, d)
+		}
+	}
+
+	if d := b.next(time.Minute); d != 0 {
+		t.Errorf("stable run: expected no delay, got %s", d)
+	}
+	if d := b.next(time.Second); d != time.Second {
+		t.Errorf("unstable run after reset: expected %s, got %s", time.Second, d)
+	}
+
+	if d := (&backoff{}).next(0); d != 0 {
+		t.Errorf("disabled: expected no delay, got %s", d)
+	}
+}
+
+func TestBackoffSleep(t *testing.T) {
+	fake := fakeClock(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := backoff{initial: time.Second, max: time.Hour, multiplier: 3, reset: time.Minute}
+
+	// hours of restarts are simulated instantly
+	var total time.Duration
+	for i := 0; i < 8; i++ {
+		d := b.next(0)
+		total += d
+
+		done := make(chan error, 1)
+		go func() {
+			done <- sleepUntil(context.Background(), clk.Now().Add(d))
+		}()
+
+		fake.WaitTimers(1)
+		fake.Advance(d - time.Millisecond)
+		select {
+		case err := <-done:
+			t.Fatalf("restart %d: sleep returned %v before the %s backoff delay", i+1, err, d)
+		default:
+		}
+
+		fake.Advance(time.Millisecond)
+		if err := <-done; err != nil {
+			t.Fatalf("restart %d: %s", i+1, err)
+		}
+	}
+
+	// 1s, 3s, 9s, 27s, 81s, 243s, 729s, 2187s
+	if expected := 3280 * time.Second; total != expected {
+		t.Errorf("expected total delay %s, got %s", expected, total)
+	}
+	if now := fake.Now(); !now.Equal(time.Date(2024, 1, 1, 0, 54, 40, 0, time.UTC)) {
+		t.Errorf("unexpected fake time %s", now)
+	}
+}
+
+func TestBackoffSleepCanceled(t *testing.T) {
+	fake := fakeClock(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- sleepUntil(ctx, clk.Now().Add(time.Hour))
+	}()
+
+	fake.WaitTimers(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
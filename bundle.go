@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// maxBundleHistory is the maximal size of the -history-file tail included into a bundle.
+const maxBundleHistory = 1 << 20
+
+// bundleHost describes the host in a bundle.
+type bundleHost struct {
+	Hostname  string    `json:"hostname"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	CPUs      int       `json:"cpus"`
+	Kernel    string    `json:"kernel,omitempty"`  // Linux /proc/version
+	LoadAvg   string    `json:"loadavg,omitempty"` // Linux /proc/loadavg
+	GoVersion string    `json:"go_version"`
+	Ruc       buildInfo `json:"ruc"`
+	Args      []string  `json:"args"` // ruc's arguments, redacted
+	Time      time.Time `json:"time"`
+}
+
+// writeBundle writes failure artifacts as tar.gz archive: the status, ruc's recent logs,
+// recent program's output (with -tail-buffer), the last -history-file records, and host information.
+func writeBundle(w io.Writer, opts *options) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	now := time.Now()
+
+	add := func(name string, b []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0o600,
+			Size:    int64(len(b)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(b)
+		return err
+	}
+	addJSON := func(name string, v any) error {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, append(b, '\n'))
+	}
+
+	if err := addJSON("status.json", current.get()); err != nil {
+		return err
+	}
+
+	if opts.logs != nil {
+		if err := add("ruc.log", opts.logs.recent()); err != nil {
+			return err
+		}
+	}
+
+	if opts.tail != nil {
+		if err := add("output.log", opts.tail.recent()); err != nil {
+			return err
+		}
+	}
+
+	if opts.historyFile != "" {
+		b, err := readFileTail(opts.historyFile, maxBundleHistory)
+		if err == nil {
+			err = add("history.jsonl", b)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	host := bundleHost{
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		GoVersion: runtime.Version(),
+		Ruc:       rucBuild,
+		Args:      make([]string, len(os.Args)),
+		Time:      now,
+	}
+	host.Hostname, _ = os.Hostname()
+	if b, err := os.ReadFile("/proc/version"); err == nil {
+		host.Kernel = string(bytes.TrimSpace(b))
+	}
+	if b, err := os.ReadFile("/proc/loadavg"); err == nil {
+		host.LoadAvg = string(bytes.TrimSpace(b))
+	}
+	for i, a := range os.Args {
+		host.Args[i] = opts.output.redact.redactString(a)
+	}
+	if err := addJSON("host.json", host); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// readFileTail returns up to the last n bytes of the file, starting at a line boundary if it is cut.
+func readFileTail(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var cut bool
+	if off := fi.Size() - n; off > 0 {
+		if _, err = f.Seek(off, io.SeekStart); err != nil {
+			return nil, err
+		}
+		cut = true
+	}
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if cut {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			b = b[i+1:]
+		}
+	}
+	return b, nil
+}
+
+// saveBundle writes a bundle into a new file in -bundle-dir, and returns its path.
+// The file is readable only by the owner, as logs and output may contain secrets.
+func saveBundle(opts *options, runID string) (string, error) {
+	if err := os.MkdirAll(opts.bundleDir, 0o700); err != nil {
+		return "", err
+	}
+
+	name := time.Now().Format("20060102T150405")
+	if runID != "" {
+		name += "-" + runID
+	}
+	path := filepath.Join(opts.bundleDir, name+".tar.gz")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	err = writeBundle(f, opts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// bundle implements `ruc bundle` subcommand.
+func bundle(args []string) {
+	fs, socketF := controlFlagSet("bundle", "bundle [flags]\nSaves the running instance's failure artifacts bundle (status, logs, output, history, host information) as tar.gz.")
+	outputF := fs.String("o", "", "Write the bundle to that file; defaults to ruc-bundle-<time>.tar.gz")
+	_ = fs.Parse(args)
+
+	if *socketF == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	res, err := controlRequest(*socketF, "bundle")
+	if err == nil {
+		path := *outputF
+		if path == "" {
+			path = "ruc-bundle-" + time.Now().Format("20060102T150405") + ".tar.gz"
+		}
+		if err = os.WriteFile(path, []byte(res), 0o600); err == nil {
+			fmt.Println(path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// busyLockInterval is how often -busy-lock is checked while the stop is deferred.
+const busyLockInterval = 100 * time.Millisecond
+
+// tryBusyLock tries to take an exclusive flock(2) on the -busy-lock file that the program holds
+// (shared or exclusive) during critical sections. It returns nil file if the program holds it.
+// The returned file holds the lock; it should be closed after the program exits,
+// so the program can't enter a new critical section while it is being stopped.
+func tryBusyLock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// waitBusyLock waits for up to maxDefer until the program releases the -busy-lock file;
+// see tryBusyLock. It returns nil file if the program still holds it after that.
+func waitBusyLock(path string, maxDefer time.Duration) (*os.File, error) {
+	t := clk.NewTimer(busyLockInterval)
+	defer t.Stop()
+
+	end := clk.Now().Add(maxDefer)
+	for {
+		f, err := tryBusyLock(path)
+		if f != nil || err != nil || clk.Now().After(end) {
+			return f, err
+		}
+
+		<-t.C()
+		t.Reset(busyLockInterval)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// capNames are Linux capabilities' names indexed by their numbers, without CAP_ prefix.
+var capNames = []string{
+	"CHOWN", "DAC_OVERRIDE", "DAC_READ_SEARCH", "FOWNER", "FSETID", "KILL", "SETGID", "SETUID",
+	"SETPCAP", "LINUX_IMMUTABLE", "NET_BIND_SERVICE", "NET_BROADCAST", "NET_ADMIN", "NET_RAW", "IPC_LOCK", "IPC_OWNER",
+	"SYS_MODULE", "SYS_RAWIO", "SYS_CHROOT", "SYS_PTRACE", "SYS_PACCT", "SYS_ADMIN", "SYS_BOOT", "SYS_NICE",
+	"SYS_RESOURCE", "SYS_TIME", "SYS_TTY_CONFIG", "MKNOD", "LEASE", "AUDIT_WRITE", "AUDIT_CONTROL", "SETFCAP",
+	"MAC_OVERRIDE", "MAC_ADMIN", "SYSLOG", "WAKE_ALARM", "BLOCK_SUSPEND", "AUDIT_READ", "PERFMON", "BPF",
+	"CHECKPOINT_RESTORE",
+}
+
+// capAll is the set of all capabilities.
+const capAll = ^uint64(0)
+
+// parseCaps parses comma-separated list of capabilities' names (with or without CAP_ prefix, in any case),
+// or "all", into a set.
+func parseCaps(s string) (uint64, error) {
+	var set uint64
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "ALL" {
+			set = capAll
+			continue
+		}
+
+		name = strings.TrimPrefix(name, "CAP_")
+		c := -1
+		for i, n := range capNames {
+			if n == name {
+				c = i
+			}
+		}
+		if c < 0 {
+			return 0, fmt.Errorf("unknown capability %q", name)
+		}
+		set |= 1 << c
+	}
+	return set, nil
+}
+
+// capLastCap returns the number of the last capability supported by the kernel.
+func capLastCap() int {
+	b, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err == nil {
+		if c, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+			return c
+		}
+	}
+	return len(capNames) - 1
+}
+
+// capUserHeader and capUserData are capget(2) and capset(2) arguments.
+type (
+	capUserHeader struct {
+		version uint32
+		pid     int32
+	}
+	capUserData struct {
+		effective   uint32
+		permitted   uint32
+		inheritable uint32
+	}
+)
+
+// linuxCapabilityVersion3 is _LINUX_CAPABILITY_VERSION_3 with 64-bit sets.
+const linuxCapabilityVersion3 = 0x20080522
+
+// prctl constants.
+const (
+	prCapbsetDrop   = 24
+	prSetNoNewPrivs = 38
+)
+
+// limitCaps limits the bounding, permitted, effective, and inheritable capability sets of the current process
+// (and so of the program it executes next) to the given set.
+func limitCaps(keep uint64) error {
+	for c := 0; c <= capLastCap(); c++ {
+		if keep&(1<<c) != 0 {
+			continue
+		}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapbsetDrop, uintptr(c), 0); errno != 0 {
+			if errno == syscall.EPERM {
+				return errors.New("dropping capabilities requires CAP_SETPCAP; run ruc as root")
+			}
+			return fmt.Errorf("PR_CAPBSET_DROP: %w", errno)
+		}
+	}
+
+	hdr := capUserHeader{version: linuxCapabilityVersion3}
+	var data [2]capUserData
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capget: %w", errno)
+	}
+
+	// ambient capabilities are dropped with inheritable ones
+	for i := range data {
+		k := uint32(keep >> (32 * i))
+		data[i].effective &= k
+		data[i].permitted &= k
+		data[i].inheritable &= k
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset: %w", errno)
+	}
+	return nil
+}
+
+// effectiveCaps returns the effective capability set of the current thread.
+func effectiveCaps() (uint64, error) {
+	hdr := capUserHeader{version: linuxCapabilityVersion3}
+	var data [2]capUserData
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return 0, fmt.Errorf("capget: %w", errno)
+	}
+	return uint64(data[1].effective)<<32 | uint64(data[0].effective), nil
+}
+
+// setNoNewPrivs sets no_new_privs flag of the current thread (and of the program it executes next),
+// so that setuid binaries and file capabilities do not grant the program more privileges.
+func setNoNewPrivs() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("PR_SET_NO_NEW_PRIVS: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCaps(t *testing.T) {
+	for s, expected := range map[string]uint64{
+		"CHOWN":                            1 << 0,
+		"CAP_CHOWN":                        1 << 0,
+		"cap_net_bind_service":             1 << 10,
+		"Net_Raw":                          1 << 13,
+		"NET_BIND_SERVICE,NET_RAW":         1<<10 | 1<<13,
+		" net_bind_service , cap_kill ":    1<<10 | 1<<5,
+		"kill,kill":                        1 << 5,
+		"CHECKPOINT_RESTORE":               1 << 40,
+		"all":                              capAll,
+		"ALL,chown":                        capAll,
+		"SYS_ADMIN,AUDIT_READ,PERFMON,BPF": 1<<21 | 1<<37 | 1<<38 | 1<<39,
+	} {
+		actual, err := parseCaps(s)
+		if err != nil {
+			t.Errorf("%q: %s", s, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("%q: expected %#x, got %#x", s, expected, actual)
+		}
+	}
+
+	for _, s := range []string{"", "NOPE", "CAP_", "CAP_ALL", "chown,", "chown,,kill", "CAP_CAP_CHOWN"} {
+		if _, err := parseCaps(s); err == nil || !strings.Contains(err.Error(), "unknown capability") {
+			t.Errorf("%q: expected unknown capability error, got %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// errNoCaps is returned on systems without Linux capabilities.
+var errNoCaps = errors.New("capabilities and no_new_privs are Linux-specific")
+
+// capAll is the set of all capabilities.
+const capAll = ^uint64(0)
+
+// parseCaps returns an error: capabilities are Linux-specific.
+func parseCaps(s string) (uint64, error) { return 0, errNoCaps }
+
+// limitCaps returns an error: capabilities are Linux-specific.
+func limitCaps(keep uint64) error { return errNoCaps }
+
+// setNoNewPrivs returns an error: no_new_privs is Linux-specific.
+func setNoNewPrivs() error { return errNoCaps }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// watchCert checks the PEM certificate file every interval, and requests a graceful restart
+// when it changes, or margin before the earliest certificate in it expires.
+func watchCert(ctx context.Context, path string, interval, margin time.Duration, restart chan<- string) {
+	t := clk.NewTimer(interval)
+	defer t.Stop()
+
+	expiry := clk.NewTimer(time.Hour)
+	expiry.Stop()
+	defer expiry.Stop()
+
+	var prev []byte
+	var notAfter time.Time
+	var failing bool
+	for {
+		h, na, err := readCert(path)
+		if err != nil {
+			if !failing {
+				log.Printf("Failed to read watched certificate: %s", err)
+			}
+			failing = true
+		} else {
+			failing = false
+
+			if prev != nil && string(h) != string(prev) {
+				requestRestart(restart, "certificate "+path+" changed")
+			}
+			prev = h
+
+			if !na.Equal(notAfter) {
+				notAfter = na
+				expiry.Stop()
+				at := notAfter.Add(-margin)
+				if d := at.Sub(clk.Now()); d > 0 {
+					log.Printf("Certificate %s expires at %s, restart is scheduled at %s.", path, notAfter.Format(time.RFC3339), at.Format(time.RFC3339))
+					expiry.Reset(d)
+				} else {
+					log.Printf("Certificate %s expires at %s, too soon for a restart to help.", path, notAfter.Format(time.RFC3339))
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			t.Reset(interval)
+		case <-expiry.C():
+			requestRestart(restart, "certificate "+path+" expires at "+notAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+// readCert returns SHA-256 hash of the PEM file content, and the earliest expiration time of certificates in it.
+func readCert(path string) ([]byte, time.Time, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var notAfter time.Time
+	for rest := b; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("%s: %w", path, err)
+		}
+		if notAfter.IsZero() || cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+
+	if notAfter.IsZero() {
+		return nil, time.Time{}, errors.New(path + ": no certificates")
+	}
+
+	h := sha256.Sum256(b)
+	return h[:], notAfter, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+)
+
+// checkPrograms implements the part of `ruc check` that is not done by flags parsing:
+// it checks that programs can be found and executed.
+func checkPrograms(opts *options, ps programs) error {
+	for _, p := range ps {
+		if len(p.args) == 0 {
+			// only the adopted process is supervised
+			continue
+		}
+		if _, err := lookProgram(opts, p.args[0]); err != nil {
+			if p.name != "" {
+				return fmt.Errorf("program %s: %w", p.name, err)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
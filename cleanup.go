@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Environment variables passed to -cleanup-cmd in addition to $RUC_RUN_ID and instance's ones.
+const (
+	exitResultEnv = "RUC_EXIT"        // program's exit result, e.g. "exit status 1" or "signal: killed"
+	programPIDEnv = "RUC_PID"         // program's PID, so the command can find leftovers; also passed to -before-stop-cmd
+	exitCodeEnv   = "RUC_EXIT_CODE"   // program's exit code, or 128+signal number; not set if it is unknown
+	exitSignalEnv = "RUC_EXIT_SIGNAL" // the signal that killed the program, e.g. "KILL"; not set if none
+)
+
+// cleanup runs -cleanup-cmd after the program exits, killing its process group after the timeout.
+func cleanup(opts *options, in *instance, pid int, runID string, err error) {
+	result := "exit status 0"
+	if err != nil {
+		result = err.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.cleanupTimeout)
+	defer cancel()
+
+	env := []string{exitResultEnv + "=" + result}
+	if res := in.exit; res != nil {
+		if code, ok := res.status(); ok {
+			env = append(env, exitCodeEnv+"="+strconv.Itoa(code))
+		}
+		if res.signal != 0 {
+			env = append(env, exitSignalEnv+"="+signalName(res.signal))
+		}
+	}
+	cmd := hookCommand(ctx, opts.cleanupCmd, in, pid, runID, env)
+
+	start := time.Now()
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			in.log.Printf("Cleanup command timed out after %s.", opts.cleanupTimeout)
+			return
+		}
+		in.log.Printf("Cleanup command failed: %s", err)
+		return
+	}
+	in.log.Printf("Cleanup command finished in %s.", time.Since(start).Round(time.Millisecond))
+}
+
+// beforeStop runs -before-stop-cmd before the program is asked to exit on a timed recycle,
+// killing its process group after the timeout. It returns the time it took.
+func beforeStop(opts *options, in *instance, pid int, runID string) time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.beforeStopTimeout)
+	defer cancel()
+
+	cmd := hookCommand(ctx, opts.beforeStopCmd, in, pid, runID, nil)
+
+	start := time.Now()
+	err := cmd.Run()
+	d := time.Since(start)
+	switch {
+	case ctx.Err() != nil:
+		in.log.Printf("Before-stop command timed out after %s.", opts.beforeStopTimeout)
+	case err != nil:
+		in.log.Printf("Before-stop command failed: %s", err)
+	default:
+		in.log.Printf("Before-stop command finished in %s.", d.Round(time.Millisecond))
+	}
+	return d
+}
+
+// hookCommand returns /bin/sh -c command for the program's run with ruc's output, in its own process group
+// that is killed when ctx is done.
+func hookCommand(ctx context.Context, command string, in *instance, pid int, runID string, env []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		runIDEnv+"="+runID,
+		programPIDEnv+"="+strconv.Itoa(pid),
+	)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Env = append(cmd.Env, in.env()...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	return cmd
+}
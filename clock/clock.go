@@ -0,0 +1,50 @@
+// Package clock provides an injectable time source for ruc's restart loop,
+// so that hours of restart schedules can be simulated instantly with Fake.
+package clock
+
+import (
+	"time"
+)
+
+// Clock tells the time and creates timers.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTimer creates a new Timer that sends the current time on its channel after at least duration d.
+	NewTimer(d time.Duration) Timer
+
+	// After waits for the duration to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Timer is a single event, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+
+	// Stop prevents the Timer from firing; it returns false if the timer has already expired or been stopped.
+	Stop() bool
+
+	// Reset changes the timer to expire after duration d; it returns true if the timer had been active.
+	Reset(d time.Duration) bool
+}
+
+// Real is the Clock backed by the time package.
+var Real Clock = realClock{}
+
+// realClock implements Clock with the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// realTimer implements Timer with time.Timer.
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
@@ -0,0 +1,171 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that moves only when Advance is called.
+// Timers fire synchronously from Advance in the order of their deadlines.
+// It is safe for concurrent use.
+type Fake struct {
+	m      sync.Mutex
+	now    time.Time
+	timers []*fakeTimer  // armed timers
+	armed  chan struct{} // closed and replaced when a timer is armed
+}
+
+// NewFake returns a Fake clock set to the given time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:   now,
+		armed: make(chan struct{}),
+	}
+}
+
+// Now implements Clock.
+func (f *Fake) Now() time.Time {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.now
+}
+
+// NewTimer implements Clock.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{
+		f: f,
+		c: make(chan time.Time, 1),
+	}
+	t.Reset(d)
+	return t
+}
+
+// After implements Clock.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// Advance moves the clock forward by d, firing timers with deadlines up to the new time.
+// The clock is set to each timer's deadline when it fires.
+func (f *Fake) Advance(d time.Duration) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	end := f.now.Add(d)
+	for len(f.timers) > 0 && !f.timers[0].deadline.After(end) {
+		t := f.timers[0]
+		f.now = t.deadline
+		f.remove(t)
+		t.fire(f.now)
+	}
+	f.now = end
+}
+
+// AdvanceToNext moves the clock to the earliest timer's deadline and fires it.
+// It returns false if there are no armed timers.
+func (f *Fake) AdvanceToNext() bool {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if len(f.timers) == 0 {
+		return false
+	}
+
+	t := f.timers[0]
+	if t.deadline.After(f.now) {
+		f.now = t.deadline
+	}
+	f.remove(t)
+	t.fire(f.now)
+	return true
+}
+
+// Timers returns the number of armed timers.
+func (f *Fake) Timers() int {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return len(f.timers)
+}
+
+// WaitTimers blocks until at least n timers are armed;
+// it lets tests wait until the code under test sleeps.
+func (f *Fake) WaitTimers(n int) {
+	for {
+		f.m.Lock()
+		if len(f.timers) >= n {
+			f.m.Unlock()
+			return
+		}
+		armed := f.armed
+		f.m.Unlock()
+
+		<-armed
+	}
+}
+
+// add arms the timer; f.m should be held.
+func (f *Fake) add(t *fakeTimer) {
+	f.timers = append(f.timers, t)
+	sort.SliceStable(f.timers, func(i, j int) bool { return f.timers[i].deadline.Before(f.timers[j].deadline) })
+
+	close(f.armed)
+	f.armed = make(chan struct{})
+}
+
+// remove disarms the timer, and returns true if it was armed; f.m should be held.
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, ft := range f.timers {
+		if ft == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeTimer implements Timer for Fake.
+type fakeTimer struct {
+	f        *Fake
+	c        chan time.Time
+	deadline time.Time
+}
+
+// C implements Timer.
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop implements Timer.
+func (t *fakeTimer) Stop() bool {
+	t.f.m.Lock()
+	defer t.f.m.Unlock()
+
+	return t.f.remove(t)
+}
+
+// Reset implements Timer. Non-positive durations fire the timer immediately.
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.f.m.Lock()
+	defer t.f.m.Unlock()
+
+	active := t.f.remove(t)
+	t.deadline = t.f.now.Add(d)
+	if d <= 0 {
+		t.fire(t.f.now)
+		return active
+	}
+
+	t.f.add(t)
+	return active
+}
+
+// fire sends the time without blocking, as time.Timer does.
+func (t *fakeTimer) fire(now time.Time) {
+	select {
+	case t.c <- now:
+	default:
+	}
+}
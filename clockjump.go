@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How wall clock-based schedules (-run and -schedule calendar boundaries, -start-at) handle wall clock jumps:
+// changes that are not suspensions, such as NTP steps or manual changes.
+const (
+	clockJumpIgnore   = "ignore"   // pending waits keep following the monotonic clock; later ones use the new wall clock
+	clockJumpReanchor = "reanchor" // pending waits are moved to follow the new wall clock
+)
+
+// clockJumpThreshold is the minimal wall clock change considered a jump.
+const clockJumpThreshold = 2 * time.Second
+
+// clockJumpMode is the -clock-jump mode.
+var clockJumpMode = clockJumpIgnore
+
+// wallJumps is the sum of detected wall clock jumps in nanoseconds; forward jumps are positive.
+var wallJumps atomic.Int64
+
+var (
+	wallJumpedM sync.Mutex
+	wallJumpedC = make(chan struct{}) // closed and replaced on each jump
+)
+
+// wallJumped returns a channel that is closed on the next wall clock jump.
+func wallJumped() <-chan struct{} {
+	wallJumpedM.Lock()
+	defer wallJumpedM.Unlock()
+
+	return wallJumpedC
+}
+
+// parseClockJump checks -clock-jump flag value.
+func parseClockJump(s string) (string, error) {
+	switch s {
+	case clockJumpIgnore, clockJumpReanchor:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown clock jump mode %q", s)
+	}
+}
+
+// watchClock compares wall and monotonic clocks every interval, and reports periods when the system was suspended
+// (wall clock advances then, but monotonic clock does not) to suspended, if it is not nil,
+// and wall clock jumps to jumped, if it is not nil. Jumps are also added to wallJumps.
+//
+// Suspensions and wall clock being set forward are told apart with the boot time clock (that counts suspensions)
+// where it is available; elsewhere they look the same, and only backward jumps are detected.
+func watchClock(ctx context.Context, interval time.Duration, suspended chan<- time.Duration, jumped chan<- time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	prev := time.Now()
+	prevBoot, hasBoot := bootClock()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		now := time.Now()
+		mono := now.Sub(prev)
+		wall := now.Round(0).Sub(prev.Round(0)) // Round(0) strips monotonic clock reading
+		prev = now
+
+		var slept, jump time.Duration
+		if boot, ok := bootClock(); ok && hasBoot {
+			slept = boot - prevBoot - mono
+			jump = wall - (boot - prevBoot)
+			prevBoot = boot
+		} else if d := wall - mono; d > 0 {
+			slept = d
+		} else {
+			jump = d
+		}
+
+		if slept >= suspendThreshold {
+			log.Printf("System was suspended for %s.", slept.Round(time.Second))
+			if suspended != nil {
+				select {
+				case suspended <- slept:
+				default:
+					// the previous one is not handled yet
+				}
+			}
+		}
+
+		if jump >= clockJumpThreshold || jump <= -clockJumpThreshold {
+			dir := "forward"
+			if jump < 0 {
+				dir = "backward"
+			}
+			if clockJumpMode == clockJumpReanchor {
+				log.Printf("Wall clock jumped %s by %s; re-anchoring pending schedules to the new wall clock.", dir, jump.Abs().Round(time.Second))
+			} else {
+				log.Printf("Wall clock jumped %s by %s; pending schedules keep following the monotonic clock.", dir, jump.Abs().Round(time.Second))
+			}
+
+			wallJumps.Add(int64(jump))
+			wallJumpedM.Lock()
+			close(wallJumpedC)
+			wallJumpedC = make(chan struct{})
+			wallJumpedM.Unlock()
+			if jumped != nil {
+				select {
+				case jumped <- jump:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// jumpsSince returns the sum of wall clock jumps detected since wallJumps had the base value.
+func jumpsSince(base int64) time.Duration {
+	return time.Duration(wallJumps.Load() - base)
+}
+
+// sleepUntilWall waits until the wall clock time t, or until ctx is canceled.
+// Timers do not count time while the host is suspended, so the wall clock is checked periodically,
+// and after wall clock jumps; with -clock-jump=ignore, jumps during the wait do not move it.
+func sleepUntilWall(ctx context.Context, t time.Time) error {
+	base := wallJumps.Load()
+	for {
+		jumped := wallJumped()
+		now := clk.Now().Round(0)
+		if clockJumpMode == clockJumpIgnore {
+			now = now.Add(-jumpsSince(base))
+		}
+
+		d := t.Sub(now)
+		if d <= 0 {
+			return nil
+		}
+
+		timer := clk.NewTimer(min(d, time.Minute))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		case <-jumped:
+			timer.Stop()
+		}
+	}
+}
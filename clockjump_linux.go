@@ -0,0 +1,35 @@
+package main
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// clockMonotonic is CLOCK_MONOTONIC: it does not count time with the system suspended,
+	// like kernel log timestamps.
+	clockMonotonic = 1
+
+	// clockBoottime is CLOCK_BOOTTIME: like CLOCK_MONOTONIC, but it also counts time with the system suspended.
+	clockBoottime = 7
+)
+
+// bootClock returns CLOCK_BOOTTIME reading.
+func bootClock() (time.Duration, bool) {
+	return clockGettime(clockBoottime)
+}
+
+// monotonicClock returns CLOCK_MONOTONIC reading.
+func monotonicClock() (time.Duration, bool) {
+	return clockGettime(clockMonotonic)
+}
+
+// clockGettime returns the reading of the clock with the given ID.
+func clockGettime(id uintptr) (time.Duration, bool) {
+	var ts syscall.Timespec
+	if _, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, id, uintptr(unsafe.Pointer(&ts)), 0); errno != 0 {
+		return 0, false
+	}
+	return time.Duration(ts.Nano()), true
+}
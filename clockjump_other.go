@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "time"
+
+// bootClock returns false: the boot time clock is used only on Linux.
+func bootClock() (time.Duration, bool) { return 0, false }
+
+// monotonicClock returns false: it is used only for Linux kernel log timestamps.
+func monotonicClock() (time.Duration, bool) { return 0, false }
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// command is a program instance.
+type command struct {
+	*exec.Cmd
+	*streams
+
+	argv  []string // program's argv
+	path  string   // program's resolved executable path, if known
+	runID string
+	seed  int64
+
+	// gate pipe ends; both are nil if the command is not gated
+	gateR, gateW *os.File
+
+	// -notification-fd pipe ends; the write end is closed after the start, both are nil if not used
+	notifyR, notifyW *os.File
+
+	tmpDir string // per-run temporary directory; empty if not used
+
+	record *recorder // nil if output is not recorded
+
+	lastOutput *atomic.Int64 // Unix nanoseconds of the last output; nil without -expect-output-every
+
+	digest *digestWriter // of stdout; nil without -output-digest
+
+	stdin io.WriteCloser // program's stdin pipe; nil without -stdin-pipe
+
+	cgroup *pidsCgroup // -max-pids cgroup made ahead by -prepare-next, nil if none
+}
+
+// gateFDEnv is the environment variable containing the gate file descriptor number
+// for programs that wait at the gate themselves (-prestart-gate=fd).
+const gateFDEnv = "RUC_GATE_FD"
+
+// newCommand returns a command for starting the program.
+// Gated command waits until it is released before executing the program
+// (or, with -prestart-gate=fd, the program itself waits for that).
+func newCommand(opts *options, in *instance, gated bool) (*command, error) {
+	plan := in.next
+	in.next = nil
+	if plan != nil && plan.stale() {
+		in.log.Printf("Program's executable changed after the next start was prepared, resolving it again.")
+		plan.discard(in)
+		plan = nil
+	}
+	if plan == nil {
+		var err error
+		if plan, err = planExec(opts, in); err != nil {
+			return nil, err
+		}
+	}
+	if opts.envDiff {
+		if in.prevEnv != nil {
+			if d := envDiff(&opts.output.redact, in.prevEnv, plan.env); len(d) > 0 {
+				in.log.Printf("Program's environment changed since the previous run: %s.", strings.Join(d, ", "))
+			}
+		}
+		in.prevEnv = plan.env
+	}
+
+	path, args, argv := plan.path, plan.args, plan.argv
+	argv0 := plan.argv0
+	if opts.altChdir && strings.Contains(args[0], "/") && !filepath.IsAbs(args[0]) {
+		// relative to ruc's working directory, not the data one
+		abs, err := filepath.Abs(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if argv0 == "" {
+			argv0 = args[0]
+		}
+		args = append([]string{abs}, args[1:]...)
+	}
+
+	runID, seed := newRunID(), opts.seed
+	if seed == 0 {
+		seed = newSeed()
+	}
+
+	env := append(slices.Clip(plan.env), runIDEnv+"="+runID, seedEnv+"="+strconv.FormatInt(seed, 10))
+	if in.dataDir != "" {
+		env = append(env, dataDirEnv+"="+in.dataDir)
+	}
+
+	var tc trampolineConfig
+	var useTrampoline bool
+
+	if len(opts.fds.fds) > 0 {
+		env = append(env, opts.fds.env()...)
+		tc.ListenPID = true
+		useTrampoline = true
+	}
+
+	var tmpDir string
+	if opts.tmpDir {
+		var err error
+		if tmpDir, err = os.MkdirTemp("", "ruc-"); err != nil {
+			return nil, err
+		}
+		env = append(env, "TMPDIR="+tmpDir)
+	}
+
+	extraFiles := opts.fds.files()
+
+	var gateR, gateW *os.File
+	if gated {
+		var err error
+		if gateR, gateW, err = os.Pipe(); err != nil {
+			removeTmpDir(tmpDir)
+			return nil, err
+		}
+
+		// socket activation file descriptors should start at 3, so the gate goes after them
+		fd := listenFDsStart + len(extraFiles)
+		extraFiles = append(extraFiles, gateR)
+		if opts.prestartGate == "fd" {
+			env = append(env, gateFDEnv+"="+strconv.Itoa(fd))
+		} else {
+			tc.Gate = fd
+			useTrampoline = true
+		}
+	}
+
+	if len(opts.mounts) > 0 {
+		tc.Mounts = opts.mounts
+		useTrampoline = true
+	}
+
+	if opts.privateNet {
+		tc.Loopback = true
+		useTrampoline = true
+	}
+
+	if opts.caps != nil || opts.noNewPrivs {
+		tc.Caps = opts.caps
+		tc.NoNewPrivs = opts.noNewPrivs
+		useTrampoline = true
+	}
+
+	if opts.seccomp != "" {
+		tc.Seccomp = opts.seccomp
+		useTrampoline = true
+	}
+
+	var notifyR, notifyW *os.File
+	if fd := opts.notifyFD; fd > 0 {
+		var err error
+		if fd < listenFDsStart+len(extraFiles) {
+			err = fmt.Errorf("-notification-fd %d is used by another file descriptor", fd)
+		} else {
+			notifyR, notifyW, err = os.Pipe()
+		}
+		if err != nil {
+			if gated {
+				gateR.Close()
+				gateW.Close()
+			}
+			removeTmpDir(tmpDir)
+			return nil, err
+		}
+
+		// unused descriptors before it are closed
+		for len(extraFiles) < fd-listenFDsStart {
+			extraFiles = append(extraFiles, nil)
+		}
+		extraFiles = append(extraFiles, notifyW)
+	}
+
+	if len(opts.passFDs) > 0 {
+		var err error
+		if extraFiles, err = opts.passFDs.place(extraFiles); err != nil {
+			if gated {
+				gateR.Close()
+				gateW.Close()
+			}
+			if notifyR != nil {
+				notifyR.Close()
+				notifyW.Close()
+			}
+			removeTmpDir(tmpDir)
+			return nil, err
+		}
+		env = append(env, passFDsEnv+"="+opts.passFDs.env())
+	}
+
+	// the trampoline knows its own argv[0] is not the program's one
+	tc.Argv0 = argv0
+
+	var cmd *exec.Cmd
+	if useTrampoline {
+		var err error
+		if cmd, err = trampolineCommand(&tc, args, env); err != nil {
+			if gated {
+				gateR.Close()
+				gateW.Close()
+			}
+			if notifyR != nil {
+				notifyR.Close()
+				notifyW.Close()
+			}
+			removeTmpDir(tmpDir)
+			return nil, err
+		}
+	} else {
+		cmd = exec.Command(args[0], args[1:]...)
+		cmd.Env = env
+		if argv0 != "" {
+			cmd.Args[0] = argv0
+		}
+	}
+
+	if opts.altChdir {
+		cmd.Dir = in.dataDir
+	}
+
+	var alert func(severity, line string)
+	if len(opts.output.alerts) > 0 {
+		alert = func(severity, line string) {
+			in.log.Printf("Program's stderr %s: %s", severity, line)
+			opts.notifier.notify(in, severity, in.status.get().ChildPID, runID, line)
+		}
+	}
+	streams := opts.output.streams(in.status, in.prefix, in.color, alert)
+	cmd.Stdout, cmd.Stderr = streams.stdout, streams.stderr
+
+	var record *recorder
+	if opts.record != "" {
+		redacted := make([]string, len(argv))
+		for i, a := range argv {
+			redacted[i] = opts.output.redact.redactString(a)
+		}
+		var err error
+		if record, err = newRecorder(opts.record, runID, seed, redacted, &opts.output.redact); err != nil {
+			in.log.Printf("Failed to record output: %s", err)
+		} else {
+			cmd.Stdout = io.MultiWriter(cmd.Stdout, record.writer("stdout"))
+			cmd.Stderr = io.MultiWriter(cmd.Stderr, record.writer("stderr"))
+		}
+	}
+
+	var lastOutput *atomic.Int64
+	if opts.outputEvery > 0 {
+		lastOutput = new(atomic.Int64)
+		cmd.Stdout = &activityWriter{w: cmd.Stdout, last: lastOutput}
+		cmd.Stderr = &activityWriter{w: cmd.Stderr, last: lastOutput}
+	}
+
+	// outermost, as it should see program's output as is
+	var digest *digestWriter
+	if opts.digest {
+		digest = newDigestWriter(cmd.Stdout)
+		cmd.Stdout = digest
+	}
+
+	_, outFile := cmd.Stdout.(*os.File)
+	_, errFile := cmd.Stderr.(*os.File)
+	if !outFile || !errFile {
+		// do not wait forever for output copying if the program's children keep pipes open
+		cmd.WaitDelay = time.Second
+	}
+	cmd.ExtraFiles = extraFiles
+
+	// start program in a separate process group to prevent automatic signals propagation (unless asked otherwise)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: !opts.noSetpgid,
+	}
+	if opts.foregroundTTY {
+		cmd.Stdin = os.Stdin
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = 0 // stdin
+	}
+	var stdin io.WriteCloser
+	if opts.stdinPipe {
+		// it fails only if stdin is already set or the command is started
+		stdin, _ = cmd.StdinPipe()
+	}
+	if len(opts.mounts) > 0 {
+		setMountNamespace(cmd.SysProcAttr)
+	}
+	if opts.privateNet {
+		setNetworkNamespace(cmd.SysProcAttr)
+	}
+	setPdeathsig(cmd.SysProcAttr)
+
+	return &command{
+		Cmd:     cmd,
+		streams: streams,
+		argv:    argv,
+		path:    path,
+		runID:   runID,
+		seed:    seed,
+		gateR:   gateR,
+		gateW:   gateW,
+		notifyR: notifyR,
+		notifyW: notifyW,
+		tmpDir:  tmpDir,
+		record:  record,
+
+		lastOutput: lastOutput,
+		digest:     digest,
+		stdin:      stdin,
+		cgroup:     plan.cgroup,
+	}, nil
+}
+
+// execPlan is the program's exec state that does not depend on a particular run:
+// the resolved executable, arguments, and environment.
+// With -prepare-next, it is made for the next start while the program runs.
+type execPlan struct {
+	path  string   // program's resolved executable path, if known
+	args  []string // the executable and program's arguments
+	argv  []string // program's argv
+	argv0 string
+	env   []string // without per-run variables
+
+	fi     os.FileInfo // of path when the plan was made, nil if unknown
+	link   string      // path before resolving symlinks with -resolve-each-run, if any
+	cgroup *pidsCgroup // -max-pids cgroup made ahead for the next run, nil if none
+}
+
+// planExec resolves the program's executable path and environment, including secrets.
+func planExec(opts *options, in *instance) (*execPlan, error) {
+	args := in.args
+	argv0 := opts.argv0
+	var path string
+	if opts.path != "" || opts.programDir != "" {
+		var err error
+		if path, err = resolveProgram(args[0], opts.path, opts.programDir); err != nil {
+			return nil, err
+		}
+		if argv0 == "" {
+			argv0 = args[0]
+		}
+		args = append([]string{path}, args[1:]...)
+	} else {
+		path, _ = exec.LookPath(args[0])
+	}
+
+	var link string
+	if opts.resolveEach && path != "" {
+		link = path
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil, err
+		}
+		if argv0 == "" {
+			argv0 = args[0]
+		}
+		path = real
+		args = append([]string{path}, args[1:]...)
+	}
+
+	if opts.expectSHA256 != nil {
+		if err := checkSHA256(path, args[0], opts.expectSHA256); err != nil {
+			return nil, err
+		}
+	}
+
+	argv := args
+	if argv0 != "" {
+		argv = append([]string{argv0}, args[1:]...)
+	}
+
+	env := programEnv(opts.sanitizeEnv, opts.env)
+	if env == nil {
+		env = os.Environ()
+	}
+	env = append(env, in.env()...)
+
+	if len(opts.secrets) > 0 {
+		// resolve secrets before creating streams, so they are redacted
+		se, err := opts.secrets.env(&opts.output.redact)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, se...)
+	}
+
+	p := &execPlan{
+		path:  path,
+		args:  args,
+		argv:  argv,
+		argv0: argv0,
+		env:   env,
+		link:  link,
+	}
+	if path != "" {
+		p.fi, _ = os.Stat(path)
+	}
+	return p, nil
+}
+
+// prepareNext makes the plan (and -max-pids cgroup) for the next start ahead of time.
+// It returns nil on errors; they are logged, and the next start makes the plan itself.
+func prepareNext(opts *options, in *instance) *execPlan {
+	p, err := planExec(opts, in)
+	if err != nil {
+		in.log.Printf("Failed to prepare the next start: %s", err)
+		return nil
+	}
+
+	if opts.maxPIDs > 0 && opts.pidsCgroups && !opts.systemdRun {
+		if p.cgroup, err = newPIDsCgroup(in.unitNameAt(in.status.get().Iteration+1), opts.maxPIDs); err != nil {
+			in.log.Printf("Failed to prepare cgroup for the next start: %s", err)
+		}
+	}
+	return p
+}
+
+// stale returns true if the plan's executable was changed after the plan was made.
+func (p *execPlan) stale() bool {
+	if p.fi == nil {
+		return false
+	}
+	if p.link != "" {
+		if real, err := filepath.EvalSymlinks(p.link); err != nil || real != p.path {
+			return true
+		}
+	}
+	fi, err := os.Stat(p.path)
+	return err != nil || !sameBinary(p.fi, fi)
+}
+
+// discard releases resources of the plan that is not used.
+func (p *execPlan) discard(in *instance) {
+	if p.cgroup == nil {
+		return
+	}
+	if err := p.cgroup.remove(); err != nil {
+		in.log.Printf("Failed to remove cgroup %s: %s", p.cgroup.dir, err)
+	}
+}
+
+// lookProgram returns the path of the program executable, searched the same way as at start.
+func lookProgram(opts *options, name string) (string, error) {
+	if opts.path != "" || opts.programDir != "" {
+		return resolveProgram(name, opts.path, opts.programDir)
+	}
+	return exec.LookPath(name)
+}
+
+// resolveProgram returns the path of the program executable.
+// Names without slashes are searched in the colon-separated path list (or $PATH, if it is empty);
+// relative paths (including ones in the path list) are resolved against dir (or the current directory, if it is empty).
+func resolveProgram(name, path, dir string) (string, error) {
+	if strings.Contains(name, "/") {
+		if dir != "" && !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		if err := checkExecutable(name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+
+	if path == "" {
+		path = os.Getenv("PATH")
+	}
+	for _, d := range filepath.SplitList(path) {
+		if d == "" {
+			d = "."
+		}
+		if dir != "" && !filepath.IsAbs(d) {
+			d = filepath.Join(dir, d)
+		}
+		if p := filepath.Join(d, name); checkExecutable(p) == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%s: executable file not found in %s", name, path)
+}
+
+// checkSHA256 returns an error if the program executable at path (empty if it was not found) does not have the expected hash.
+// That guards programs run with ruc's privileges against executables replaced between runs.
+func checkSHA256(path, name string, expected []byte) error {
+	if path == "" {
+		return fmt.Errorf("%s: executable file not found", name)
+	}
+
+	h, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(h, expected) {
+		return fmt.Errorf("%s: SHA-256 hash %x does not match -expect-sha256 %x", path, h, expected)
+	}
+	return nil
+}
+
+// checkExecutable returns nil if path is an executable regular file.
+func checkExecutable(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() || fi.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%s: not an executable file", path)
+	}
+	return nil
+}
+
+// start starts the command.
+func (c *command) start() error {
+	err := c.Start()
+	if c.gateR != nil {
+		// only the program needs the read end
+		c.gateR.Close()
+		c.gateR = nil
+		if err != nil {
+			c.gateW.Close()
+			c.gateW = nil
+		}
+	}
+	if c.notifyW != nil {
+		c.notifyW.Close()
+		c.notifyW = nil
+		if err != nil {
+			c.closeNotification()
+		}
+	}
+	return err
+}
+
+// errNotNotified is returned by waitNotification if the program closes -notification-fd without notifying readiness.
+var errNotNotified = errors.New("program closed notification file descriptor without notifying readiness")
+
+// waitNotification waits for the program to write a newline to -notification-fd, as s6 does.
+func (c *command) waitNotification() error {
+	b := make([]byte, 512)
+	for {
+		n, err := c.notifyR.Read(b)
+		if bytes.IndexByte(b[:n], '\n') >= 0 {
+			return nil
+		}
+		if err == io.EOF {
+			return errNotNotified
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// closeNotification closes -notification-fd read end, if any.
+func (c *command) closeNotification() {
+	if c.notifyR != nil {
+		c.notifyR.Close()
+	}
+}
+
+// release lets the gated command execute the program. It does nothing for commands that are not gated.
+func (c *command) release() {
+	if c.gateW == nil {
+		return
+	}
+
+	if _, err := c.gateW.Write([]byte{1}); err != nil {
+		log.Printf("Failed to release program: %s", err)
+	}
+	c.gateW.Close()
+	c.gateW = nil
+}
+
+// abort kills the gated (or overlapping) command without releasing it.
+func (c *command) abort() {
+	_ = c.Process.Kill()
+
+	// closing the gate without writing to it also asks the command to exit
+	if c.gateW != nil {
+		c.gateW.Close()
+		c.gateW = nil
+	}
+
+	err := c.Wait()
+	c.closeNotification()
+	c.flush()
+	if c.record != nil {
+		c.record.close(err)
+	}
+	removeTmpDir(c.tmpDir)
+}
+
+// removeTmpDir removes per-run temporary directory, if any.
+func removeTmpDir(dir string) {
+	if dir == "" {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Failed to remove temporary directory: %s", err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// configEntry is a single flag set by -config file.
+type configEntry struct {
+	name, value string
+	pos         string // file:line
+}
+
+// configReader reads -config file and files included by it.
+type configReader struct {
+	profile  string
+	common   []configEntry // outside sections
+	selected []configEntry // in the profile section
+	found    bool          // profile section exists
+	files    []string      // being read, for include cycles detection
+}
+
+// readConfig reads -config file with flags: "name = value" lines (value may be omitted for boolean flags)
+// with optional [profile] sections, and "include = path" lines. It returns entries without sections,
+// then entries of the given profile (if it is not empty), so the profile overrides them.
+func readConfig(path, profile string) ([]configEntry, error) {
+	r := &configReader{profile: profile}
+	if err := r.read(path, ""); err != nil {
+		return nil, err
+	}
+
+	if profile != "" && !r.found {
+		return nil, fmt.Errorf("%s: no profile %q", path, profile)
+	}
+	return append(r.common, r.selected...), nil
+}
+
+// read reads a single file. Its lines before the first section header belong to the given section
+// (of the including file).
+func (r *configReader) read(path, section string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(r.files, abs) {
+		return fmt.Errorf("%s: include cycle", path)
+	}
+	r.files = append(r.files, abs)
+	defer func() {
+		r.files = r.files[:len(r.files)-1]
+	}()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pos := fmt.Sprintf("%s:%d", path, n)
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.TrimSpace(line[1:len(line)-1]) == "" {
+				return fmt.Errorf("%s: invalid profile section %q", pos, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			r.found = r.found || section == r.profile
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimLeft(strings.TrimSpace(name), "-")
+		value = strings.TrimSpace(value)
+		if !ok {
+			value = "true"
+		}
+
+		switch name {
+		case "config", "profile":
+			return fmt.Errorf("%s: -%s can't be used in config file", pos, name)
+		case "include":
+			if err = r.include(path, value, section); err != nil {
+				return fmt.Errorf("%s: %w", pos, err)
+			}
+			continue
+		}
+
+		e := configEntry{name: name, value: value, pos: pos}
+		switch section {
+		case "":
+			r.common = append(r.common, e)
+		case r.profile:
+			r.selected = append(r.selected, e)
+		}
+	}
+	return s.Err()
+}
+
+// include reads files matching the pattern (relative to the including file's directory) in lexical order,
+// like conf.d/*.conf. A pattern without matches is not an error, but a missing file without wildcards is.
+func (r *configReader) include(from, pattern, section string) error {
+	if pattern == "" || pattern == "true" {
+		return fmt.Errorf("include requires a path")
+	}
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(from), pattern)
+	}
+
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 && !strings.ContainsAny(pattern, "*?[") {
+		paths = []string{pattern}
+	}
+
+	for _, p := range paths {
+		if err = r.read(p, section); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyConfig sets flags from -config file entries, except ones that are set on the command line.
+func applyConfig(entries []configEntry) error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for _, e := range entries {
+		if flag.Lookup(e.name) == nil {
+			return fmt.Errorf("%s: unknown flag -%s", e.pos, e.name)
+		}
+		if set[e.name] {
+			continue
+		}
+		if err := flag.Set(e.name, e.value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for flag -%s: %w", e.pos, e.value, e.name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFiles writes files (name -> content) into a new temporary directory and returns it.
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// configValues returns name=value strings of entries.
+func configValues(entries []configEntry) string {
+	res := make([]string, len(entries))
+	for i, e := range entries {
+		res[i] = e.name + "=" + e.value
+	}
+	return strings.Join(res, " ")
+}
+
+func TestReadConfigProfiles(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"ruc.conf": `
+# common flags
+run = 1h
+-grace=10s
+--verbose
+
+[dev]
+run = 1m
+
+[prod]
+run = 24h
+jitter = 5m
+`,
+	})
+	path := filepath.Join(dir, "ruc.conf")
+
+	for profile, expected := range map[string]string{
+		"":     "run=1h grace=10s verbose=true",
+		"dev":  "run=1h grace=10s verbose=true run=1m",
+		"prod": "run=1h grace=10s verbose=true run=24h jitter=5m",
+	} {
+		entries, err := readConfig(path, profile)
+		if err != nil {
+			t.Errorf("%q: %s", profile, err)
+			continue
+		}
+		if actual := configValues(entries); actual != expected {
+			t.Errorf("%q: expected %q, got %q", profile, expected, actual)
+		}
+	}
+
+	if _, err := readConfig(path, "staging"); err == nil || !strings.Contains(err.Error(), `no profile "staging"`) {
+		t.Errorf("expected no profile error, got %v", err)
+	}
+
+	entries, err := readConfig(path, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := path + ":8"; entries[3].pos != expected {
+		t.Errorf("expected position %s, got %s", expected, entries[3].pos)
+	}
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content string
+		err     string
+	}{
+		"EmptySection":    {"[ ]\n", `:1: invalid profile section "[ ]"`},
+		"UnclosedSection": {"run = 1h\n[dev\n", `:2: invalid profile section "[dev"`},
+		"Config":          {"config = other.conf\n", ":1: -config can't be used in config file"},
+		"Profile":         {"\n-profile = dev\n", ":2: -profile can't be used in config file"},
+	} {
+		dir := writeConfigFiles(t, map[string]string{"ruc.conf": tc.content})
+		if _, err := readConfig(filepath.Join(dir, "ruc.conf"), ""); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected %q error, got %v", name, tc.err, err)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	prev := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = prev })
+
+	flag.CommandLine = flag.NewFlagSet("ruc", flag.ContinueOnError)
+	run := flag.Duration("run", 0, "")
+	grace := flag.Duration("grace", 0, "")
+	verbose := flag.Bool("verbose", false, "")
+	flag.Duration("jitter", 0, "")
+	if err := flag.CommandLine.Parse([]string{"-grace", "3s"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the profile overrides common entries, and the command line overrides both
+	err := applyConfig([]configEntry{
+		{name: "run", value: "1h", pos: "ruc.conf:1"},
+		{name: "grace", value: "10s", pos: "ruc.conf:2"},
+		{name: "verbose", value: "true", pos: "ruc.conf:3"},
+		{name: "run", value: "1m", pos: "ruc.conf:6"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *run != time.Minute || *grace != 3*time.Second || !*verbose {
+		t.Errorf("unexpected flags: -run=%s -grace=%s -verbose=%t", *run, *grace, *verbose)
+	}
+
+	for entry, msg := range map[configEntry]string{
+		{name: "nope", value: "1", pos: "ruc.conf:7"}:   "ruc.conf:7: unknown flag -nope",
+		{name: "jitter", value: "x", pos: "ruc.conf:8"}: `ruc.conf:8: invalid value "x" for flag -jitter`,
+	} {
+		if err = applyConfig([]configEntry{entry}); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%+v: expected %q error, got %v", entry, msg, err)
+		}
+	}
+}
+
+func TestReadConfigIncludes(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"ruc.conf": `
+run = 1h
+include = conf.d/*.conf
+
+[prod]
+include = prod.conf
+grace = 30s
+`,
+		"conf.d/20-b.conf": "jitter = 1m\n",
+		"conf.d/10-a.conf": "grace = 10s\n",
+		"conf.d/30-c.conf": "verbose\n[prod]\nsplay = 2m\n",
+		"conf.d/ignored":   "nope = 1\n",
+
+		// lines before the first section belong to the including section
+		"prod.conf": "run = 24h\n[dev]\nrun = 1m\n",
+	})
+	path := filepath.Join(dir, "ruc.conf")
+
+	for profile, expected := range map[string]string{
+		"":     "run=1h grace=10s jitter=1m verbose=true",
+		"prod": "run=1h grace=10s jitter=1m verbose=true splay=2m run=24h grace=30s",
+		"dev":  "run=1h grace=10s jitter=1m verbose=true run=1m",
+	} {
+		entries, err := readConfig(path, profile)
+		if err != nil {
+			t.Errorf("%q: %s", profile, err)
+			continue
+		}
+		if actual := configValues(entries); actual != expected {
+			t.Errorf("%q: expected %q, got %q", profile, expected, actual)
+		}
+	}
+
+	entries, err := readConfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "conf.d", "20-b.conf") + ":1"; entries[2].pos != expected {
+		t.Errorf("expected position %s, got %s", expected, entries[2].pos)
+	}
+}
+
+func TestReadConfigIncludeErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		files map[string]string
+		err   string
+	}{
+		"Missing": {
+			files: map[string]string{"ruc.conf": "run = 1h\ninclude = missing.conf\n"},
+			err:   "ruc.conf:2: open ",
+		},
+		"NoPath": {
+			files: map[string]string{"ruc.conf": "include\n"},
+			err:   "ruc.conf:1: include requires a path",
+		},
+		"Self": {
+			files: map[string]string{"ruc.conf": "include = ruc.conf\n"},
+			err:   "ruc.conf: include cycle",
+		},
+		"Cycle": {
+			files: map[string]string{
+				"ruc.conf":   "include = a.conf\n",
+				"a.conf":     "include = sub/b.conf\n",
+				"sub/b.conf": "include = ../ruc.conf\n",
+			},
+			err: "ruc.conf: include cycle",
+		},
+		"CycleByGlob": {
+			files: map[string]string{
+				"ruc.conf":      "include = conf.d/*.conf\n",
+				"conf.d/a.conf": "include = *.conf\n",
+			},
+			err: "a.conf: include cycle",
+		},
+		"NestedError": {
+			files: map[string]string{
+				"ruc.conf": "include = a.conf\n",
+				"a.conf":   "\n[ ]\n",
+			},
+			err: "a.conf:2: invalid profile section",
+		},
+	} {
+		dir := writeConfigFiles(t, tc.files)
+		if _, err := readConfig(filepath.Join(dir, "ruc.conf"), ""); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected %q error, got %v", name, tc.err, err)
+		}
+	}
+
+	// the same file may be included twice without a cycle, and a glob without matches is not an error
+	dir := writeConfigFiles(t, map[string]string{
+		"ruc.conf":    "include = common.conf\ninclude = common.conf\ninclude = conf.d/*.conf\n",
+		"common.conf": "verbose\n",
+	})
+	entries, err := readConfig(filepath.Join(dir, "ruc.conf"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "verbose=true verbose=true", configValues(entries); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// controlSocketEnv is the environment variable with the default control socket path.
+const controlSocketEnv = "RUC_CONTROL_SOCKET"
+
+// The control protocol is line-based: a client sends a single command line,
+// and the server responds with zero or more lines of payload, or with a single line
+// starting with controlErrorPrefix, and closes the connection.
+const controlErrorPrefix = "error: "
+
+// serveControl starts listening for control commands on the given Unix socket.
+func serveControl(path string, opts *options) error {
+	// remove stale socket left by the previous instance
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return fmt.Errorf("control socket %s is in use", path)
+		}
+		os.Remove(path)
+	}
+
+	// create the socket inaccessible to others, so they can't connect before chmod;
+	// the umask is process-wide, so it is restored right away
+	umask := syscall.Umask(0o077)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("Control socket stopped: %s", err)
+				}
+				return
+			}
+
+			go handleControl(conn, opts, "")
+		}
+	}()
+
+	// that also removes the socket
+	opts.services.add(func() { l.Close() })
+
+	return nil
+}
+
+// handleControl handles a single control connection.
+// If token is not empty, the command should be preceded by "auth <token>" line.
+func handleControl(conn net.Conn, opts *options, token string) {
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	if token != "" {
+		line, _ := r.ReadString('\n')
+		got, ok := strings.CutPrefix(strings.TrimSpace(line), "auth ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			log.Printf("Rejected unauthenticated control connection from %s.", conn.RemoteAddr())
+			fmt.Fprintf(conn, "%sunauthorized\n", controlErrorPrefix)
+			return
+		}
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		fmt.Fprintf(conn, "%sempty command\n", controlErrorPrefix)
+		return
+	}
+
+	if token != "" {
+		log.Printf("Remote control command %q from %s.", fields[0], conn.RemoteAddr())
+	}
+
+	if fields[0] == "tail" {
+		// streaming command
+		err = handleTail(conn, opts, fields[1:])
+	} else {
+		err = runControl(conn, opts, fields[0], fields[1:])
+	}
+	if err != nil {
+		fmt.Fprintf(conn, "%s%s\n", controlErrorPrefix, err)
+	}
+}
+
+// handleTail streams program's output to the control connection.
+func handleTail(conn net.Conn, opts *options, args []string) error {
+	if opts.tail == nil {
+		return errors.New("output is not buffered; use -tail-buffer")
+	}
+
+	n, err := parseTailLines(args)
+	if err != nil {
+		return err
+	}
+
+	// write errors mean that the client is gone; do not try to report them
+	_ = runTail(conn, opts.tail, n, connClosed(conn))
+	return nil
+}
+
+// runControl executes control command, writing response payload to w.
+func runControl(w io.Writer, opts *options, command string, args []string) error {
+	switch command {
+	case "status":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(current.get())
+
+	case "set":
+		if len(args) == 0 {
+			return errors.New("no settings")
+		}
+		if err := opts.settings.set(args); err != nil {
+			return err
+		}
+		log.Printf("Settings changed: %s.", &opts.settings)
+		_, err := fmt.Fprintln(w, &opts.settings)
+		return err
+
+	case "restart":
+		if len(args) != 0 {
+			return fmt.Errorf("unexpected restart arguments %q", args)
+		}
+		requestRestart(opts.restart, "requested via control socket")
+		_, err := fmt.Fprintln(w, "restarting")
+		return err
+
+	case "send":
+		if opts.stdinLines == nil {
+			return errors.New("program's stdin is not a pipe; use -stdin-pipe")
+		}
+		if len(args) == 0 {
+			return errors.New("nothing to send")
+		}
+		if err := requestSend(opts.stdinLines, strings.Join(args, " ")); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(w, "sent")
+		return err
+
+	case "bundle":
+		if len(args) != 0 {
+			return fmt.Errorf("unexpected bundle arguments %q", args)
+		}
+		// do not send a partial archive on errors
+		var buf bytes.Buffer
+		if err := writeBundle(&buf, opts); err != nil {
+			return err
+		}
+		_, err := buf.WriteTo(w)
+		return err
+
+	case "events":
+		if len(args) != 0 {
+			return fmt.Errorf("unexpected events arguments %q", args)
+		}
+		if events == nil {
+			return errors.New("events are not kept; use -event-ring")
+		}
+		return events.dump(w)
+
+	case "stop":
+		switch {
+		case len(args) == 0:
+			return opts.stopper.stop(w, false)
+		case len(args) == 1 && args[0] == "wait":
+			return opts.stopper.stop(w, true)
+		default:
+			return fmt.Errorf("unexpected stop arguments %q", args)
+		}
+
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// controlRequest sends a command to the control socket and returns response payload.
+func controlRequest(path string, command ...string) (string, error) {
+	conn, err := dialControl(path)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err = fmt.Fprintln(conn, strings.Join(command, " ")); err != nil {
+		return "", err
+	}
+
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+
+	res := string(b)
+	if msg, ok := strings.CutPrefix(res, controlErrorPrefix); ok {
+		return "", errors.New(strings.TrimSpace(msg))
+	}
+
+	return res, nil
+}
+
+// controlFlagSet returns flag set for a control subcommand with -control-socket flag.
+func controlFlagSet(name, usage string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	socketF := fs.String("control-socket", os.Getenv(controlSocketEnv), "Control socket of the running ruc instance, or its remote control address (e.g. tls://host:8182) with $"+controlTokenEnv+" and optional $"+controlCAEnv+"; defaults to $"+controlSocketEnv)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s\n", os.Args[0], usage)
+		fmt.Fprintf(fs.Output(), "Flags:\n")
+		fs.PrintDefaults()
+	}
+	return fs, socketF
+}
+
+// set implements `ruc set` subcommand.
+func set(args []string) {
+	fs, socketF := controlFlagSet("set", "set [flags] key=value...\nSettings: run, grace.")
+	_ = fs.Parse(args)
+
+	if *socketF == "" || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	res, err := controlRequest(*socketF, append([]string{"set"}, fs.Args()...)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Print(res)
+}
+
+// restart implements `ruc restart` subcommand.
+func restart(args []string) {
+	fs, socketF := controlFlagSet("restart", "restart [flags]\nGracefully restarts the program, as a timer would.")
+	_ = fs.Parse(args)
+
+	if *socketF == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	res, err := controlRequest(*socketF, "restart")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Print(res)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestControlSocketMode(t *testing.T) {
+	// even with a permissive umask, the socket is never accessible to others
+	umask := syscall.Umask(0)
+	t.Cleanup(func() { syscall.Umask(umask) })
+
+	path := filepath.Join(t.TempDir(), "ruc.sock")
+	var opts options
+	if err := serveControl(path, &opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(opts.services.close)
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("expected mode 0600, got %#o", perm)
+	}
+
+	if restored := syscall.Umask(0); restored != 0 {
+		t.Errorf("expected umask to be restored to 0, got %#o", restored)
+	}
+
+	if err := serveControl(path, &opts); err == nil {
+		t.Error("expected the socket in use to be refused")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// coreMeta describes a collected core dump; it is saved next to it as meta.json.
+type coreMeta struct {
+	RunID    string    `json:"run_id"`
+	Seed     int64     `json:"seed"`
+	Command  []string  `json:"command"`
+	PID      int       `json:"pid"`
+	Signal   string    `json:"signal"`
+	Time     time.Time `json:"time"`
+	Original string    `json:"original"` // path where the kernel wrote the core
+}
+
+// enableCoreDumps raises ruc's RLIMIT_CORE soft limit (inherited by programs) to the hard limit.
+func enableCoreDumps() error {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CORE, &rl); err != nil {
+		return err
+	}
+	if rl.Cur == rl.Max {
+		return nil
+	}
+
+	rl.Cur = rl.Max
+	return syscall.Setrlimit(syscall.RLIMIT_CORE, &rl)
+}
+
+// coreGlobs returns glob patterns matching the core dump of the process, according to kernel's core_pattern.
+// The second result is not empty if core dumps are piped to a program (e.g. systemd-coredump) instead of files.
+func coreGlobs(pid int, exe, dir string) ([]string, string) {
+	b, err := os.ReadFile("/proc/sys/kernel/core_pattern")
+	if err != nil {
+		// not Linux: BSD and macOS defaults
+		p := strconv.Itoa(pid)
+		return []string{filepath.Join(dir, "core"), filepath.Join(dir, exe+".core"), "/cores/core." + p}, ""
+	}
+
+	pattern := strings.TrimSpace(string(b))
+	if handler, ok := strings.CutPrefix(pattern, "|"); ok {
+		return nil, handler
+	}
+
+	// TASK_COMM_LEN - 1
+	comm := exe
+	if len(comm) > 15 {
+		comm = comm[:15]
+	}
+
+	var glob strings.Builder
+	var hasPID bool
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c != '%' || i == len(pattern)-1 {
+			glob.WriteByte(c)
+			continue
+		}
+
+		i++
+		switch pattern[i] {
+		case '%':
+			glob.WriteByte('%')
+		case 'p', 'P', 'i', 'I':
+			hasPID = true
+			glob.WriteString(strconv.Itoa(pid))
+		case 'e':
+			glob.WriteString(comm)
+		default:
+			glob.WriteByte('*')
+		}
+	}
+
+	res := glob.String()
+	if !hasPID {
+		if b, _ := os.ReadFile("/proc/sys/kernel/core_uses_pid"); strings.TrimSpace(string(b)) == "1" {
+			res += "." + strconv.Itoa(pid)
+		}
+	}
+	if !filepath.IsAbs(res) {
+		res = filepath.Join(dir, res)
+	}
+	return []string{res}, ""
+}
+
+// findCore returns the newest file matching globs that was modified after the given time.
+func findCore(globs []string, after time.Time) (string, error) {
+	var res string
+	var newest time.Time
+	for _, g := range globs {
+		matches, err := filepath.Glob(g)
+		if err != nil {
+			return "", err
+		}
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil || !fi.Mode().IsRegular() || fi.ModTime().Before(after) || fi.ModTime().Before(newest) {
+				continue
+			}
+			res, newest = m, fi.ModTime()
+		}
+	}
+
+	if res == "" {
+		return "", fmt.Errorf("no core dump matching %s", strings.Join(globs, ", "))
+	}
+	return res, nil
+}
+
+// collectCore moves the core dump of the crashed program into a new subdirectory of dir, compresses it,
+// saves its metadata, and removes the oldest collected core dumps over the retention budget.
+// It returns the created directory.
+func collectCore(dir string, retention int64, cmd *command, sig syscall.Signal, startedAt time.Time, argv []string) (string, error) {
+	exe := cmd.path
+	if exe == "" {
+		exe = cmd.argv[0]
+	}
+	wd := cmd.Dir
+	if wd == "" {
+		wd, _ = os.Getwd()
+	}
+
+	globs, handler := coreGlobs(cmd.Process.Pid, filepath.Base(exe), wd)
+	if handler != "" {
+		return "", fmt.Errorf("core dumps are piped to %s", handler)
+	}
+
+	// the kernel writes the core before the process is reaped, but several seconds' clock skew is possible
+	core, err := findCore(globs, startedAt.Add(-time.Second))
+	if err != nil {
+		return "", err
+	}
+
+	res := filepath.Join(dir, time.Now().Format("20060102T150405")+"-"+strconv.Itoa(cmd.Process.Pid))
+	if err = os.MkdirAll(res, 0o700); err != nil {
+		return "", err
+	}
+
+	dst := filepath.Join(res, "core")
+	if err = moveFile(core, dst); err != nil {
+		return "", err
+	}
+	if err = gzipFile(dst); err != nil {
+		log.Printf("Failed to compress core dump: %s", err)
+	} else {
+		// core dumps contain program's memory with secrets
+		_ = os.Chmod(dst+".gz", 0o600)
+	}
+
+	b, err := json.MarshalIndent(coreMeta{
+		RunID:    cmd.runID,
+		Seed:     cmd.seed,
+		Command:  argv,
+		PID:      cmd.Process.Pid,
+		Signal:   signalName(sig),
+		Time:     time.Now(),
+		Original: core,
+	}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(res, "meta.json"), append(b, '\n'), 0o600)
+	}
+	if err != nil {
+		return res, err
+	}
+
+	if retention > 0 {
+		pruneCores(dir, retention)
+	}
+	return res, nil
+}
+
+// moveFile renames the file, or copies and removes it if it is on another file system.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// pruneCores removes the oldest subdirectories of dir until their total size fits into the retention budget.
+func pruneCores(dir string, retention int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Failed to list core dumps: %s", err)
+		return
+	}
+
+	type coreDir struct {
+		path string
+		size int64
+	}
+	var dirs []coreDir
+	var total int64
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		d := coreDir{path: filepath.Join(dir, e.Name())}
+		_ = filepath.WalkDir(d.path, func(_ string, de os.DirEntry, err error) error {
+			if err == nil && de.Type().IsRegular() {
+				if fi, err := de.Info(); err == nil {
+					d.size += fi.Size()
+				}
+			}
+			return nil
+		})
+		dirs = append(dirs, d)
+		total += d.size
+	}
+
+	// timestamps in names sort oldest first
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].path < dirs[j].path })
+
+	// always keep the newest one
+	for _, d := range dirs[:max(len(dirs)-1, 0)] {
+		if total <= retention {
+			break
+		}
+		if err := os.RemoveAll(d.path); err != nil {
+			log.Printf("Failed to remove %s: %s", d.path, err)
+			continue
+		}
+		total -= d.size
+	}
+}
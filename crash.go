@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// crashReport describes ruc's own panic; it is written to -crash-report file.
+type crashReport struct {
+	Time   time.Time `json:"time"`
+	Panic  string    `json:"panic"`
+	Stacks string    `json:"stacks"` // of all goroutines, the panicking one first
+	Status status    `json:"status"` // at the time of the panic
+}
+
+// crashOnce makes concurrent panics report only the first crash.
+var crashOnce sync.Once
+
+// recoverCrash must be deferred at the top of ruc's goroutines.
+// On panic, it writes the crash report (if enabled), kills instances' programs (if kill is true),
+// sends crash notification, and then continues panicking.
+func recoverCrash(opts *options, instances []*instance, kill bool) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	crashOnce.Do(func() {
+		if opts.crashReport != "" {
+			report := crashReport{
+				Time:   time.Now(),
+				Panic:  fmt.Sprint(r),
+				Stacks: string(allStacks()),
+				Status: current.get(),
+			}
+			if err := writeFileAtomic(opts.crashReport, report); err != nil {
+				log.Printf("Failed to write crash report: %s", err)
+			} else {
+				log.Printf("Crash report written to %s.", opts.crashReport)
+			}
+		}
+
+		if kill {
+			killInstances(instances)
+		}
+
+		var in *instance
+		if len(instances) == 1 {
+			in = instances[0]
+		}
+		opts.notifier.notify(in, eventCrash, os.Getpid(), "", fmt.Sprint(r))
+		opts.notifier.wait()
+	})
+
+	panic(r)
+}
+
+// allStacks returns stack traces of all goroutines.
+func allStacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 64<<20 {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// daemonPollInterval is how often the tracked daemon and its PID file are checked.
+const daemonPollInterval = 100 * time.Millisecond
+
+// detectDaemon checks whether the program that exited successfully after running for elapsed time daemonized itself.
+// It returns the daemon's process to track instead of the program, or nil.
+func detectDaemon(opts *options, in *instance, pid int, elapsed time.Duration) *os.Process {
+	if opts.daemonDetect == 0 || elapsed >= opts.daemonDetect {
+		return nil
+	}
+
+	if opts.daemonPIDFile == "" {
+		if !in.daemonWarned {
+			in.daemonWarned = true
+			in.log.Printf(
+				"Program exited successfully only %s after the start; it probably daemonized itself. "+
+					"Run it in the foreground, or use -daemon-pid-file to track the daemon.",
+				elapsed.Round(time.Millisecond),
+			)
+		}
+		return nil
+	}
+
+	// the daemon may write its PID file after the program exits
+	var err error
+	deadline := clk.Now().Add(opts.daemonDetect)
+	for {
+		var daemon int
+		if daemon, err = readDaemonPIDFile(opts.daemonPIDFile); err == nil && daemon != pid && alive(daemon) {
+			in.log.Printf("Program daemonized itself, tracking the daemon (PID %d) from %s.", daemon, opts.daemonPIDFile)
+			p, _ := os.FindProcess(daemon) // always succeeds on Unix
+			return p
+		}
+		if clk.Now().After(deadline) {
+			break
+		}
+		<-clk.After(daemonPollInterval)
+	}
+
+	if err == nil {
+		err = errors.New("no running daemon")
+	}
+	in.log.Printf("Program exited successfully only %s after the start, but the daemon can't be tracked: %s", elapsed.Round(time.Millisecond), err)
+	return nil
+}
+
+// readDaemonPIDFile reads the daemon's PID from the file.
+func readDaemonPIDFile(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("%s: invalid PID %q", path, strings.TrimSpace(string(b)))
+	}
+	return pid, nil
+}
+
+// daemonAlive returns true if the daemon is running.
+// Unlike alive, it treats zombies as exited where /proc is available,
+// as ruc may be the one that should reap them (as PID 1 in a container).
+func daemonAlive(pid int) bool {
+	if !alive(pid) {
+		return false
+	}
+
+	// the state follows the parenthesized command name that may contain spaces
+	b, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	if i := bytes.LastIndexByte(b, ')'); i >= 0 && i+2 < len(b) && b[i+2] == 'Z' {
+		var ws syscall.WaitStatus
+		_, _ = syscall.Wait4(pid, &ws, syscall.WNOHANG, nil)
+		return false
+	}
+	return true
+}
+
+// waitDaemon waits for the tracked daemon to exit, polling it until ctx is canceled.
+// Its exit status is unknown, as it is not ruc's child.
+func waitDaemon(ctx context.Context, pid int) *exitResult {
+	t := clk.NewTimer(daemonPollInterval)
+	defer t.Stop()
+
+	for daemonAlive(pid) {
+		select {
+		case <-ctx.Done():
+			return &exitResult{err: ctx.Err(), code: -1}
+		case <-t.C():
+			t.Reset(daemonPollInterval)
+		}
+	}
+
+	return &exitResult{err: fmt.Errorf("tracked process (PID %d) exited", pid), code: -1}
+}
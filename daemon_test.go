@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDetectDaemon(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Found", func(t *testing.T) {
+		fake := fakeClock(t, start)
+		pidFile := filepath.Join(t.TempDir(), "daemon.pid")
+		opts := &options{daemonDetect: time.Minute, daemonPIDFile: pidFile}
+		in := &instance{log: log.New(testWriter{t}, "", 0)}
+
+		done := make(chan *os.Process, 1)
+		go func() {
+			done <- detectDaemon(opts, in, -1, time.Second)
+		}()
+
+		// the daemon writes its PID file a while after the program exits
+		fake.WaitTimers(1)
+		fake.Advance(10 * time.Second)
+		if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+
+		var p *os.Process
+		advanceWhile(t, fake, daemonPollInterval, func() bool {
+			select {
+			case p = <-done:
+				return true
+			default:
+				return false
+			}
+		})
+		if p == nil || p.Pid != os.Getpid() {
+			t.Fatalf("expected daemon with PID %d, got %v", os.Getpid(), p)
+		}
+		if elapsed := fake.Now().Sub(start); elapsed >= opts.daemonDetect {
+			t.Errorf("expected detection before %s, got %s", opts.daemonDetect, elapsed)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		fake := fakeClock(t, start)
+		opts := &options{daemonDetect: time.Minute, daemonPIDFile: filepath.Join(t.TempDir(), "daemon.pid")}
+		in := &instance{log: log.New(testWriter{t}, "", 0)}
+
+		done := make(chan *os.Process, 1)
+		go func() {
+			done <- detectDaemon(opts, in, -1, time.Second)
+		}()
+
+		// a minute of polling is simulated instantly
+		var p *os.Process
+		var returned bool
+		advanceWhile(t, fake, daemonPollInterval, func() bool {
+			select {
+			case p = <-done:
+				returned = true
+			default:
+			}
+			return returned
+		})
+		if p != nil {
+			t.Fatalf("expected no daemon, got PID %d", p.Pid)
+		}
+		if elapsed := fake.Now().Sub(start); elapsed < opts.daemonDetect {
+			t.Errorf("expected polling for %s, got %s", opts.daemonDetect, elapsed)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		fakeClock(t, start)
+		opts := &options{daemonDetect: time.Second, daemonPIDFile: filepath.Join(t.TempDir(), "daemon.pid")}
+		in := &instance{log: log.New(testWriter{t}, "", 0)}
+
+		// the program ran for longer than -daemon-detect, so it did not daemonize
+		if p := detectDaemon(opts, in, -1, 2*time.Second); p != nil {
+			t.Fatalf("expected no daemon, got PID %d", p.Pid)
+		}
+	})
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file contains a minimal D-Bus client: just enough to call methods with simple arguments.
+// See https://dbus.freedesktop.org/doc/dbus-specification.html.
+
+// dbus message types.
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+)
+
+// dbus header field codes.
+const (
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSignature   = 8
+)
+
+// dbusObjectPath is a D-Bus object path ("o" type).
+type dbusObjectPath string
+
+// dbusVariant is a D-Bus variant ("v" type) with explicit signature.
+type dbusVariant struct {
+	sig   string
+	value any
+}
+
+// dbusStruct is a D-Bus struct ("(...)" type).
+type dbusStruct []any
+
+// dbusConn is a connection to a D-Bus bus.
+type dbusConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	serial uint32
+}
+
+// dbusAddress returns unix socket path for the system or session bus.
+func dbusAddress(user bool) (string, error) {
+	addr := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	def := "/run/dbus/system_bus_socket"
+	if user {
+		addr = os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+		def = ""
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			def = dir + "/bus"
+		}
+	}
+
+	// only the first unix:path= address is supported
+	for _, a := range strings.Split(addr, ";") {
+		if p, ok := strings.CutPrefix(a, "unix:"); ok {
+			for _, kv := range strings.Split(p, ",") {
+				if path, ok := strings.CutPrefix(kv, "path="); ok {
+					return path, nil
+				}
+			}
+		}
+	}
+
+	if def == "" {
+		return "", errors.New("D-Bus session bus address is unknown")
+	}
+	return def, nil
+}
+
+// dialDBus connects and authenticates to the system (or session) bus.
+func dialDBus(user bool) (*dbusConn, error) {
+	path, err := dbusAddress(user)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	c := &dbusConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err = fmt.Fprintf(conn, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		conn.Close()
+		return nil, fmt.Errorf("D-Bus authentication failed: %s", strings.TrimSpace(line))
+	}
+	if _, err = io.WriteString(conn, "BEGIN\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if _, err = c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", ""); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *dbusConn) Close() error {
+	return c.conn.Close()
+}
+
+// call calls a method and waits for a reply; it returns raw reply body.
+func (c *dbusConn) call(dest, path, iface, member, sig string, args ...any) ([]byte, error) {
+	c.serial++
+	serial := c.serial
+
+	var body dbusEncoder
+	for i, a := range args {
+		body.encode(signatureAt(sig, i), a)
+	}
+
+	fields := []any{
+		dbusStruct{byte(dbusFieldPath), dbusVariant{"o", dbusObjectPath(path)}},
+		dbusStruct{byte(dbusFieldInterface), dbusVariant{"s", iface}},
+		dbusStruct{byte(dbusFieldMember), dbusVariant{"s", member}},
+		dbusStruct{byte(dbusFieldDestination), dbusVariant{"s", dest}},
+	}
+	if sig != "" {
+		fields = append(fields, dbusStruct{byte(dbusFieldSignature), dbusVariant{"g", sig}})
+	}
+
+	var msg dbusEncoder
+	msg.buf.Write([]byte{'l', dbusMethodCall, 0, 1})
+	msg.encode("u", uint32(body.buf.Len()))
+	msg.encode("u", serial)
+	msg.encode("a(yv)", fields)
+	msg.align(8)
+	msg.buf.Write(body.buf.Bytes())
+
+	if _, err := c.conn.Write(msg.buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	// skip signals and unrelated replies
+	for {
+		typ, replySerial, errName, replyBody, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if replySerial != serial {
+			continue
+		}
+
+		switch typ {
+		case dbusMethodReturn:
+			return replyBody, nil
+		case dbusError:
+			// error message is the first string argument, if any
+			var text string
+			if len(replyBody) >= 4 {
+				if n := binary.LittleEndian.Uint32(replyBody); int(n)+4 <= len(replyBody) {
+					text = string(replyBody[4 : 4+n])
+				}
+			}
+			return nil, fmt.Errorf("%s: %s", errName, text)
+		}
+	}
+}
+
+// read reads a single message. Only little-endian messages are supported.
+func (c *dbusConn) read() (typ byte, replySerial uint32, errName string, body []byte, err error) {
+	hdr := make([]byte, 16)
+	if _, err = io.ReadFull(c.r, hdr); err != nil {
+		return
+	}
+	if hdr[0] != 'l' {
+		err = fmt.Errorf("unsupported D-Bus message endianness %q", hdr[0])
+		return
+	}
+
+	typ = hdr[1]
+	bodyLen := binary.LittleEndian.Uint32(hdr[4:])
+	fieldsLen := binary.LittleEndian.Uint32(hdr[12:])
+
+	// header fields are padded to 8 bytes (counting 16 bytes already read)
+	fields := make([]byte, (fieldsLen+7)/8*8)
+	if _, err = io.ReadFull(c.r, fields); err != nil {
+		return
+	}
+	body = make([]byte, bodyLen)
+	if _, err = io.ReadFull(c.r, body); err != nil {
+		return
+	}
+
+	// decode header fields array of (yv) structs; offsets are relative to the message start
+	d := dbusDecoder{b: append(hdr, fields[:fieldsLen]...), pos: 16}
+	for d.pos < len(d.b) && err == nil {
+		d.align(8)
+		code := d.byte()
+		sig := d.signature()
+		switch sig {
+		case "s", "o":
+			s := d.string()
+			if code == dbusFieldErrorName {
+				errName = s
+			}
+		case "u":
+			u := d.uint32()
+			if code == dbusFieldReplySerial {
+				replySerial = u
+			}
+		case "g":
+			d.signature()
+		default:
+			err = fmt.Errorf("unexpected D-Bus header field signature %q", sig)
+		}
+		if d.err != nil {
+			err = d.err
+		}
+	}
+
+	return
+}
+
+// signatureAt returns the i-th complete type of the signature.
+func signatureAt(sig string, i int) string {
+	for ; ; i-- {
+		n := typeLen(sig)
+		if i == 0 {
+			return sig[:n]
+		}
+		sig = sig[n:]
+	}
+}
+
+// typeLen returns the length of the first complete type in the signature.
+func typeLen(sig string) int {
+	switch sig[0] {
+	case 'a':
+		return 1 + typeLen(sig[1:])
+	case '(':
+		depth := 0
+		for i := 0; i < len(sig); i++ {
+			switch sig[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		panic("unbalanced D-Bus signature " + sig)
+	default:
+		return 1
+	}
+}
+
+// dbusEncoder marshals values into D-Bus little-endian wire format.
+type dbusEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *dbusEncoder) align(n int) {
+	for e.buf.Len()%n != 0 {
+		e.buf.WriteByte(0)
+	}
+}
+
+func (e *dbusEncoder) encode(sig string, v any) {
+	switch sig[0] {
+	case 'y':
+		e.buf.WriteByte(v.(byte))
+
+	case 'b':
+		var u uint32
+		if v.(bool) {
+			u = 1
+		}
+		e.encode("u", u)
+
+	case 'u':
+		e.align(4)
+		_ = binary.Write(&e.buf, binary.LittleEndian, v.(uint32))
+
+	case 't':
+		e.align(8)
+		_ = binary.Write(&e.buf, binary.LittleEndian, v.(uint64))
+
+	case 's', 'o':
+		var s string
+		if p, ok := v.(dbusObjectPath); ok {
+			s = string(p)
+		} else {
+			s = v.(string)
+		}
+		e.encode("u", uint32(len(s)))
+		e.buf.WriteString(s)
+		e.buf.WriteByte(0)
+
+	case 'g':
+		s := v.(string)
+		e.buf.WriteByte(byte(len(s)))
+		e.buf.WriteString(s)
+		e.buf.WriteByte(0)
+
+	case 'v':
+		vv := v.(dbusVariant)
+		e.encode("g", vv.sig)
+		e.encode(vv.sig, vv.value)
+
+	case '(':
+		e.align(8)
+		inner := sig[1 : len(sig)-1]
+		for i, f := range v.(dbusStruct) {
+			e.encode(signatureAt(inner, i), f)
+		}
+
+	case 'a':
+		elem := sig[1:]
+		e.align(4)
+		lenPos := e.buf.Len()
+		e.buf.Write([]byte{0, 0, 0, 0})
+
+		// padding to the element alignment is not included into the array length
+		switch elem[0] {
+		case '(', 't':
+			e.align(8)
+		}
+		start := e.buf.Len()
+
+		switch vv := v.(type) {
+		case []uint32:
+			for _, u := range vv {
+				e.encode(elem, u)
+			}
+		case []string:
+			for _, s := range vv {
+				e.encode(elem, s)
+			}
+		case []any:
+			for _, a := range vv {
+				e.encode(elem, a)
+			}
+		default:
+			panic(fmt.Sprintf("unsupported D-Bus array value %T", v))
+		}
+
+		binary.LittleEndian.PutUint32(e.buf.Bytes()[lenPos:], uint32(e.buf.Len()-start))
+
+	default:
+		panic("unsupported D-Bus type " + sig)
+	}
+}
+
+// dbusDecoder unmarshals simple values from D-Bus little-endian wire format.
+type dbusDecoder struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (d *dbusDecoder) align(n int) {
+	for d.pos%n != 0 {
+		d.pos++
+	}
+}
+
+func (d *dbusDecoder) next(n int) []byte {
+	if d.err != nil || d.pos+n > len(d.b) {
+		d.err = io.ErrUnexpectedEOF
+		return make([]byte, n)
+	}
+	b := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *dbusDecoder) byte() byte {
+	return d.next(1)[0]
+}
+
+func (d *dbusDecoder) uint32() uint32 {
+	d.align(4)
+	return binary.LittleEndian.Uint32(d.next(4))
+}
+
+func (d *dbusDecoder) string() string {
+	n := d.uint32()
+	s := string(d.next(int(n)))
+	d.next(1)
+	return s
+}
+
+func (d *dbusDecoder) signature() string {
+	n := d.byte()
+	s := string(d.next(int(n)))
+	d.next(1)
+	return s
+}
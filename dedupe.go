@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dedupeDirEnv is the environment variable with the default -dedupe-dir.
+const dedupeDirEnv = "RUC_DEDUPE_DIR"
+
+// defaultDedupeDir returns the default directory of -dedupe locks shared by all ruc instances on the host.
+func defaultDedupeDir() string {
+	if dir := os.Getenv(dedupeDirEnv); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "ruc-dedupe")
+}
+
+// dedupeKey returns the default -dedupe-key: a hash of the working directory and programs' command lines,
+// so the same job started from the same directory gets the same key regardless of ruc's own flags.
+func dedupeKey(progs programs) string {
+	h := sha256.New()
+	wd, _ := os.Getwd()
+	h.Write([]byte(wd))
+	for _, p := range progs {
+		fmt.Fprintf(h, "\x00%s\x00%s", p.name, strings.Join(p.args, "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// acquireDedupe takes the -dedupe lock for the key in dir; it is held until ruc exits.
+// It returns an error with the current owner if another ruc instance holds it.
+func acquireDedupe(dir, key string) (*flockLocker, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err = os.MkdirAll(dir, 0o777); err != nil {
+			return nil, err
+		}
+		// shared by all users, like /tmp
+		_ = os.Chmod(dir, 0o777|os.ModeSticky)
+	}
+
+	l := &flockLocker{path: filepath.Join(dir, key+".lock")}
+	ok, _, err := l.tryLock(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		owner := "unknown"
+		if b, _ := os.ReadFile(l.path); len(b) > 0 {
+			owner = strings.TrimSpace(string(b))
+		}
+		return nil, fmt.Errorf("another ruc instance (%s) is already running this job with -dedupe-key %s", owner, key)
+	}
+
+	return l, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detachedEnv is set for the detached ruc process, so it does not detach again.
+const detachedEnv = "RUC_DETACHED"
+
+// detach re-executes ruc with the same arguments in the background, in a new session,
+// with output redirected to logPath (or discarded if it is empty), and exits.
+// File descriptors opened by flags are closed first, so the detached process could open them again;
+// -pass-fd ones are passed to it with the same numbers.
+func detach(logPath string, fds *fdStore, pass passFDs) {
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "Failed to detach: %s\n", err)
+		os.Exit(1)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		fail(err)
+	}
+
+	if logPath == "" {
+		logPath = os.DevNull
+	}
+	out, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		fail(err)
+	}
+
+	for _, fd := range fds.fds {
+		fd.f.Close()
+	}
+
+	cmd := exec.Command(self, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), detachedEnv+"=1")
+	cmd.Stdout, cmd.Stderr = out, out
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	if cmd.ExtraFiles, err = pass.place(nil); err != nil {
+		fail(err)
+	}
+	if err = cmd.Start(); err != nil {
+		fail(err)
+	}
+
+	fmt.Printf("%d\n", cmd.Process.Pid)
+	os.Exit(0)
+}
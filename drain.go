@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// drain asks the program to finish in-flight work with a POST request to url.
+// The program is expected to respond after it is drained; drain waits for that up to timeout.
+func drain(url string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// waitDrainFile waits until -drain-file does not exist, checking it every -watch-interval.
+// The status reports the instance as drained meanwhile.
+func waitDrainFile(ctx context.Context, opts *options, in *instance) error {
+	if _, err := os.Stat(opts.drainFile); err != nil {
+		return nil
+	}
+
+	in.log.Printf("Drain file %s exists, not starting program until it is removed.", opts.drainFile)
+	in.status.update(func(s *status) {
+		s.Drained = true
+	})
+	defer in.status.update(func(s *status) {
+		s.Drained = false
+	})
+
+	t := clk.NewTimer(opts.watchInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C():
+			t.Reset(opts.watchInterval)
+		}
+
+		if _, err := os.Stat(opts.drainFile); os.IsNotExist(err) {
+			in.log.Printf("Drain file %s is removed, resuming.", opts.drainFile)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWaitDrainFile(t *testing.T) {
+	fake := fakeClock(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	drainFile := filepath.Join(t.TempDir(), "drain")
+	if err := os.WriteFile(drainFile, nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &options{drainFile: drainFile, watchInterval: time.Minute}
+	in := &instance{log: log.New(testWriter{t}, "", 0), status: new(statusTracker)}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- waitDrainFile(context.Background(), opts, in)
+	}()
+
+	// hours of draining are simulated instantly
+	for i := 0; i < 120; i++ {
+		fake.WaitTimers(1)
+		fake.Advance(opts.watchInterval)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("returned %v while the drain file exists", err)
+	default:
+	}
+	if !in.status.get().Drained {
+		t.Error("expected drained status")
+	}
+
+	if err := os.Remove(drainFile); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	var returned bool
+	advanceWhile(t, fake, opts.watchInterval, func() bool {
+		select {
+		case err = <-done:
+			returned = true
+		default:
+		}
+		return returned
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.status.get().Drained {
+		t.Error("expected not drained status")
+	}
+}
+
+func TestWaitDrainFileCanceled(t *testing.T) {
+	fake := fakeClock(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	drainFile := filepath.Join(t.TempDir(), "drain")
+	if err := os.WriteFile(drainFile, nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &options{drainFile: drainFile, watchInterval: time.Minute}
+	in := &instance{log: log.New(testWriter{t}, "", 0), status: new(statusTracker)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- waitDrainFile(ctx, opts, in)
+	}()
+
+	fake.WaitTimers(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// egressQdisc is the handle (major number) of HTB root qdisc shared by all ruc processes on the interface.
+const egressQdisc = 0x52
+
+// egressLimit caps program's egress bandwidth: its processes are put into net_cls cgroup,
+// which classid is matched by tc cgroup filter to HTB class with the rate.
+// Traffic of other processes is not classified, and HTB passes it as is.
+type egressLimit struct {
+	dir   string // net_cls cgroup
+	iface string
+	minor int // of the HTB class
+}
+
+// defaultRouteInterface returns the interface of the IPv4 default route.
+func defaultRouteInterface() (string, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// Iface Destination Gateway ...
+		fields := strings.Fields(s.Text())
+		if len(fields) > 1 && fields[1] == "00000000" {
+			return fields[0], nil
+		}
+	}
+	if err = s.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no default route")
+}
+
+// cgroupNetClsDir returns the directory of ruc's cgroup in net_cls hierarchy (cgroup v1 only).
+func cgroupNetClsDir() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(s.Text(), ":", 3)
+		if len(parts) == 3 && strings.Contains(","+parts[1]+",", ",net_cls,") {
+			return filepath.Join("/sys/fs/cgroup/net_cls", parts[2]), nil
+		}
+	}
+	if err = s.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("net_cls cgroup controller is not available (it requires cgroup v1)")
+}
+
+// checkEgressLimit returns an error if -max-egress can't be enforced.
+func checkEgressLimit() error {
+	if _, err := cgroupNetClsDir(); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("tc"); err != nil {
+		return fmt.Errorf("tc is not available: %w", err)
+	}
+	return nil
+}
+
+// tc runs tc(8) command.
+func tc(args ...string) error {
+	b, err := exec.Command("tc", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tc %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// newEgressLimit creates net_cls cgroup and HTB class limiting egress to rate bytes per second
+// on the interface (the default route one if empty). HTB qdisc and cgroup filter are added if needed,
+// and left in place for other ruc processes.
+func newEgressLimit(name, iface string, rate int64) (*egressLimit, error) {
+	parent, err := cgroupNetClsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	if iface == "" {
+		if iface, err = defaultRouteInterface(); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := exec.Command("tc", "qdisc", "show", "dev", iface, "root").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("tc qdisc show: %w: %s", err, strings.TrimSpace(string(b)))
+	}
+	handle := fmt.Sprintf("%x:", egressQdisc)
+	switch {
+	case strings.Contains(string(b), "htb "+handle):
+		// added by another ruc
+	case len(bytes.TrimSpace(b)) == 0 || strings.Contains(string(b), " 0: root"):
+		// the default one
+		if err = tc("qdisc", "add", "dev", iface, "root", "handle", handle, "htb"); err != nil {
+			return nil, err
+		}
+		if err = tc("filter", "add", "dev", iface, "parent", handle, "protocol", "all", "prio", "10", "handle", "1:", "cgroup"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s already has root qdisc: %s", iface, strings.TrimSpace(string(b)))
+	}
+
+	// classes of other ruc processes may exist; try the next minor numbers
+	l := &egressLimit{iface: iface}
+	for l.minor = os.Getpid()%0xfff0 + 1; ; l.minor++ {
+		err = tc("class", "add", "dev", iface, "parent", handle, "classid", l.classid(), "htb", "rate", strconv.FormatInt(rate, 10)+"bps")
+		if err == nil {
+			break
+		}
+		if !strings.Contains(err.Error(), "File exists") || l.minor >= 0xffff {
+			return nil, err
+		}
+	}
+
+	l.dir = filepath.Join(parent, name)
+	if err = os.Mkdir(l.dir, 0o755); err == nil {
+		err = os.WriteFile(filepath.Join(l.dir, "net_cls.classid"), []byte(strconv.Itoa(egressQdisc<<16|l.minor)), 0o644)
+	}
+	if err != nil {
+		_ = l.remove()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// classid returns HTB class ID in tc format.
+func (l *egressLimit) classid() string {
+	return fmt.Sprintf("%x:%x", egressQdisc, l.minor)
+}
+
+// add moves the process into the cgroup.
+// Processes forked by it before that stay in ruc's cgroup.
+func (l *egressLimit) add(pid int) error {
+	return os.WriteFile(filepath.Join(l.dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0o644)
+}
+
+// remove removes the cgroup and the class. Remaining processes are moved to ruc's cgroup first.
+func (l *egressLimit) remove() error {
+	var errs []error
+	if l.dir != "" {
+		if b, err := os.ReadFile(filepath.Join(l.dir, "cgroup.procs")); err == nil {
+			parent := filepath.Dir(l.dir)
+			for _, pid := range strings.Fields(string(b)) {
+				_ = os.WriteFile(filepath.Join(parent, "cgroup.procs"), []byte(pid), 0o644)
+			}
+		}
+		if err := os.Remove(l.dir); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	if err := tc("class", "del", "dev", l.iface, "classid", l.classid()); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
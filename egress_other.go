@@ -0,0 +1,24 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// errNoEgressLimit is returned on systems without cgroups and tc.
+var errNoEgressLimit = errors.New("-max-egress is Linux-specific")
+
+// egressLimit caps program's egress bandwidth; it is not available on this system.
+type egressLimit struct{}
+
+// checkEgressLimit returns an error: -max-egress is Linux-specific.
+func checkEgressLimit() error { return errNoEgressLimit }
+
+// newEgressLimit returns an error: -max-egress is Linux-specific.
+func newEgressLimit(name, iface string, rate int64) (*egressLimit, error) {
+	return nil, errNoEgressLimit
+}
+
+func (l *egressLimit) add(pid int) error { return errNoEgressLimit }
+func (l *egressLimit) remove() error     { return nil }
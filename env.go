@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sanitizedEnv is the baseline environment applied by -sanitize-env.
+var sanitizedEnv = []string{
+	"TZ=UTC",
+	"LANG=C",
+	"LC_ALL=C",
+	"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+}
+
+// envVars is a list of NAME=value environment variables set for the program.
+type envVars []string
+
+// add adds NAME=value environment variable.
+func (e *envVars) add(s string) error {
+	name, _, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid environment variable %q", s)
+	}
+
+	*e = append(*e, s)
+	return nil
+}
+
+// programEnv returns the program's environment before socket activation variables, secrets, etc.,
+// or nil if the environment is inherited as is.
+// Later duplicate variables override earlier ones (exec.Cmd uses the last value).
+func programEnv(sanitize bool, vars envVars) []string {
+	if !sanitize && len(vars) == 0 {
+		return nil
+	}
+
+	env := os.Environ()
+	if sanitize {
+		res := make([]string, 0, len(env)+len(sanitizedEnv))
+		for _, e := range env {
+			// locale categories are replaced by LC_ALL and LANG
+			if strings.HasPrefix(e, "LC_") || strings.HasPrefix(e, "LANGUAGE=") {
+				continue
+			}
+			res = append(res, e)
+		}
+		env = append(res, sanitizedEnv...)
+	}
+
+	return append(env, vars...)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// envDiff returns changes of the environment since the previous one, sorted by name:
+// "+NAME=value" for added variables, "-NAME" for removed ones, and "~NAME=old -> new" for changed ones.
+// Values are masked as in audit records.
+func envDiff(r *redactor, prev, env []string) []string {
+	before, after := envMap(prev), envMap(env)
+
+	mask := func(name, value string) string {
+		if sensitiveEnvName.MatchString(name) {
+			return redacted
+		}
+		return r.redactString(value)
+	}
+
+	var res []string
+	for name, v := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			res = append(res, "+"+name+"="+mask(name, v))
+		case old != v:
+			res = append(res, "~"+name+"="+mask(name, old)+" -> "+mask(name, v))
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			res = append(res, "-"+name)
+		}
+	}
+
+	slices.SortFunc(res, func(a, b string) int {
+		return strings.Compare(a[1:], b[1:])
+	})
+	return res
+}
+
+// envMap returns environment variables by name; the last value of duplicate variables is used, as by programs.
+func envMap(env []string) map[string]string {
+	res := make(map[string]string, len(env))
+	for _, e := range env {
+		name, value, _ := strings.Cut(e, "=")
+		res[name] = value
+	}
+	return res
+}
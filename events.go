@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// supervisorEvent is ruc's own event kept in the in-memory ring.
+type supervisorEvent struct {
+	Time    time.Time
+	Program string // instance label; empty for a single program
+	PID     int    // program's PID, if any
+	Message string
+}
+
+// eventRing keeps the most recent supervisor events: starts, signals sent, escalations, and exits.
+// A nil ring drops all events.
+type eventRing struct {
+	m      sync.Mutex
+	events []supervisorEvent // circular, next is the oldest one once it is full
+	next   int
+	full   bool
+}
+
+// events is the ring of recent supervisor events; nil if -event-ring is 0.
+var events *eventRing
+
+// newEventRing returns a ring keeping up to size events.
+func newEventRing(size int) *eventRing {
+	return &eventRing{events: make([]supervisorEvent, size)}
+}
+
+// add adds an event, dropping the oldest one if the ring is full.
+func (r *eventRing) add(e supervisorEvent) {
+	if r == nil {
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.events[r.next] = e
+	r.next++
+	if r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// list returns events from the oldest to the newest.
+func (r *eventRing) list() []supervisorEvent {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if !r.full {
+		return append([]supervisorEvent(nil), r.events[:r.next]...)
+	}
+	return append(append([]supervisorEvent(nil), r.events[r.next:]...), r.events[:r.next]...)
+}
+
+// dump writes events as text lines.
+func (r *eventRing) dump(w io.Writer) error {
+	for _, e := range r.list() {
+		line := e.Time.Format("2006-01-02 15:04:05.000")
+		if e.Program != "" {
+			line += " " + e.Program
+		}
+		if e.PID != 0 {
+			line += fmt.Sprintf(" PID %d", e.PID)
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", line, e.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// event records supervisor event for the instance's program.
+func (in *instance) event(pid int, format string, args ...any) {
+	if events == nil {
+		return
+	}
+
+	var program string
+	if in.name != "" || in.replicas > 1 {
+		program = in.label()
+	}
+	events.add(supervisorEvent{
+		Time:    time.Now(),
+		Program: program,
+		PID:     pid,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// dumpEventsOnSignal writes recent supervisor events to ruc's stderr on each SIGUSR1.
+func dumpEventsOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		for range ch {
+			fmt.Fprintf(os.Stderr, "ruc: recent events:\n")
+			_ = events.dump(os.Stderr)
+		}
+	}()
+}
+
+// eventsCommand implements `ruc events` subcommand.
+func eventsCommand(args []string) {
+	fs, socketF := controlFlagSet("events", "events [flags]\nPrints the running instance's recent supervisor events: starts, signals sent, escalations, and exits.")
+	_ = fs.Parse(args)
+
+	if *socketF == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	res, err := controlRequest(*socketF, "events")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Print(res)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// exitResult is the program's exit result decoded once from what exec.Cmd.Wait returned,
+// so cores, OOM detection, history, hooks, and notifications all see the same one.
+type exitResult struct {
+	err    error          // returned by Wait (or injected by -fault-wait-result); nil means exit status 0
+	exited bool           // the program exited by itself and code is its exit code
+	code   int            // -1 if the program was killed by a signal or the result is unknown
+	signal syscall.Signal // the signal that killed the program; 0 if none
+	core   bool           // the signal produced a core dump
+	usage  *exitUsage     // nil if unknown
+}
+
+// exitUsage is the resource usage of the exited program.
+type exitUsage struct {
+	user   time.Duration
+	system time.Duration
+	maxRSS int64 // as reported by getrusage(2): KiB on Linux, bytes on macOS
+}
+
+// newExitResult decodes Wait's error and the process state.
+// The status comes from err, as fault injection may replace it; the usage comes from ps.
+func newExitResult(err error, ps *os.ProcessState) *exitResult {
+	r := &exitResult{err: err, code: -1}
+
+	if ps != nil {
+		r.usage = &exitUsage{
+			user:   ps.UserTime(),
+			system: ps.SystemTime(),
+		}
+		if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+			r.usage.maxRSS = int64(ru.Maxrss)
+		}
+	}
+
+	var ee *exec.ExitError
+	switch {
+	case err == nil:
+		r.exited = true
+		r.code = 0
+	case errors.As(err, &ee):
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			r.signal = ws.Signal()
+			r.core = ws.CoreDump()
+			break
+		}
+		r.exited = true
+		r.code = ee.ExitCode()
+	}
+
+	return r
+}
+
+// status returns shell-like exit status: the exit code, or 128+signal number if the program was killed by a signal.
+// It returns false if it is unknown.
+func (r *exitResult) status() (int, bool) {
+	if r.signal != 0 {
+		return 128 + int(r.signal), true
+	}
+	return r.code, r.exited
+}
+
+// String returns a short description of the result, e.g. "exited with code 1" or "killed by SIGKILL".
+func (r *exitResult) String() string {
+	switch {
+	case r.signal != 0 && r.core:
+		return "killed by SIG" + signalName(r.signal) + " (core dumped)"
+	case r.signal != 0:
+		return "killed by SIG" + signalName(r.signal)
+	case r.exited:
+		return "exited with code " + strconv.Itoa(r.code)
+	case r.err != nil:
+		return "exited: " + r.err.Error()
+	default:
+		return "exited with unknown status"
+	}
+}
+
+// exitWaiter is the only consumer of the program's exec.Cmd.Wait.
+// Once done is closed, the result can be read any number of times.
+type exitWaiter struct {
+	done chan struct{}
+	res  *exitResult
+}
+
+// newExitWaiter returns a waiter that is not done yet.
+func newExitWaiter() *exitWaiter {
+	return &exitWaiter{done: make(chan struct{})}
+}
+
+// finish sets the result and closes done; it should be called exactly once.
+func (w *exitWaiter) finish(res *exitResult) {
+	w.res = res
+	close(w.done)
+}
+
+// result returns the exit result; it should be called only after done is closed.
+func (w *exitWaiter) result() *exitResult {
+	<-w.done
+	return w.res
+}
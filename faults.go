@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Fault injection is a hidden testing mode driven by environment variables.
+// It lets users test their own handling of supervisor anomalies.
+const (
+	// RUC_FAULT_START_DELAY delays each program start by that duration.
+	faultStartDelayEnv = "RUC_FAULT_START_DELAY"
+
+	// RUC_FAULT_DROP_SIGNALS is a comma-separated list of signals (e.g. TERM,KILL) that are not actually sent.
+	faultDropSignalsEnv = "RUC_FAULT_DROP_SIGNALS"
+
+	// RUC_FAULT_WAIT_RESULT replaces program's exit result: "ok", "exit:N", or "error:text".
+	faultWaitResultEnv = "RUC_FAULT_WAIT_RESULT"
+)
+
+// faults describes injected faults.
+type faults struct {
+	startDelay    time.Duration
+	dropSignals   map[syscall.Signal]bool
+	waitResult    error
+	waitResultSet bool
+}
+
+// injected holds faults injected with environment variables.
+var injected faults
+
+// loadFaults loads injected faults from environment variables.
+func loadFaults() error {
+	var f faults
+	var descr []string
+
+	if v := os.Getenv(faultStartDelayEnv); v != "" {
+		d, err := parseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", faultStartDelayEnv, err)
+		}
+		f.startDelay = d
+		descr = append(descr, "start delay "+d.String())
+	}
+
+	if v := os.Getenv(faultDropSignalsEnv); v != "" {
+		f.dropSignals = make(map[syscall.Signal]bool)
+		for _, s := range strings.Split(v, ",") {
+			sig, err := parseSignal(strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("%s: %w", faultDropSignalsEnv, err)
+			}
+			f.dropSignals[sig] = true
+		}
+		descr = append(descr, "dropped signals "+v)
+	}
+
+	if v := os.Getenv(faultWaitResultEnv); v != "" {
+		kind, arg, _ := strings.Cut(v, ":")
+		switch kind {
+		case "ok":
+		case "exit":
+			f.waitResult = errors.New("exit status " + arg)
+		case "error":
+			f.waitResult = errors.New(arg)
+		default:
+			return fmt.Errorf("%s: unexpected value %q", faultWaitResultEnv, v)
+		}
+		f.waitResultSet = true
+		descr = append(descr, "wait result "+v)
+	}
+
+	if len(descr) > 0 {
+		log.Printf("WARNING: fault injection is enabled: %s.", strings.Join(descr, ", "))
+	}
+
+	injected = f
+	return nil
+}
+
+// signalProcess sends signal to the program's process (or to its whole process group),
+// unless dropped by fault injection.
+func signalProcess(p *os.Process, sig syscall.Signal, group bool) error {
+	if injected.dropSignals[sig] {
+		log.Printf("Fault injection: dropping SIG%s.", signalName(sig))
+		return nil
+	}
+
+	if group {
+		return syscall.Kill(-p.Pid, sig)
+	}
+	return p.Signal(sig)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed with systemd socket activation protocol.
+const listenFDsStart = 3
+
+// storedFD is a file descriptor owned by ruc and passed to each program instance.
+type storedFD struct {
+	name string
+	f    *os.File
+}
+
+// fdStore holds file descriptors passed to each program instance
+// using systemd socket activation protocol (LISTEN_FDS, LISTEN_FDNAMES, and LISTEN_PID environment variables);
+// see sd_listen_fds(3).
+type fdStore struct {
+	fds []storedFD
+}
+
+// add opens file descriptor described by spec and adds it to the store.
+//
+//	[name=]tcp:host:port   - TCP listening socket
+//	[name=]udp:host:port   - UDP socket
+//	[name=]unix:/path      - Unix listening socket
+//	[name=]file:/path      - file opened for reading and appending (created if needed)
+//	[name=]fifo:/path      - named pipe opened for reading and writing (created if needed)
+func (s *fdStore) add(spec string) error {
+	name, spec, ok := strings.Cut(spec, "=")
+	if !ok {
+		spec = name
+		name = ""
+	}
+
+	kind, addr, ok := strings.Cut(spec, ":")
+	if !ok || addr == "" {
+		return fmt.Errorf("invalid file descriptor specification %q", spec)
+	}
+	if name == "" {
+		name = kind
+	}
+
+	var f *os.File
+	var err error
+	switch kind {
+	case "tcp", "unix":
+		var l net.Listener
+		if l, err = net.Listen(kind, addr); err != nil {
+			return err
+		}
+		f, err = l.(interface{ File() (*os.File, error) }).File()
+		l.Close()
+
+	case "udp":
+		var c net.PacketConn
+		if c, err = net.ListenPacket(kind, addr); err != nil {
+			return err
+		}
+		f, err = c.(*net.UDPConn).File()
+		c.Close()
+
+	case "file":
+		f, err = os.OpenFile(addr, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o666)
+
+	case "fifo":
+		if err = syscall.Mkfifo(addr, 0o666); err != nil && !os.IsExist(err) {
+			return err
+		}
+		f, err = os.OpenFile(addr, os.O_RDWR, 0)
+
+	default:
+		return fmt.Errorf("unknown file descriptor kind %q", kind)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.fds = append(s.fds, storedFD{name: name, f: f})
+	return nil
+}
+
+// inherit adds file descriptors passed to ruc itself with socket activation protocol,
+// and unsets corresponding environment variables.
+func (s *fdStore) inherit() {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return
+	}
+
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		s.fds = append(s.fds, storedFD{name: name, f: os.NewFile(uintptr(fd), name)})
+	}
+}
+
+// files returns files to be passed as exec.Cmd.ExtraFiles.
+func (s *fdStore) files() []*os.File {
+	res := make([]*os.File, len(s.fds))
+	for i, fd := range s.fds {
+		res[i] = fd.f
+	}
+	return res
+}
+
+// env returns environment variables for the program, except LISTEN_PID that is set by the trampoline.
+func (s *fdStore) env() []string {
+	names := make([]string, len(s.fds))
+	for i, fd := range s.fds {
+		names[i] = fd.name
+	}
+
+	return []string{
+		"LISTEN_FDS=" + strconv.Itoa(len(s.fds)),
+		"LISTEN_FDNAMES=" + strings.Join(names, ":"),
+	}
+}
+
+// passFDsEnv is the environment variable listing -pass-fd descriptors passed to the program, as in flags.
+const passFDsEnv = "RUC_PASS_FDS"
+
+// passedFD is a file descriptor inherited by ruc from its parent and passed to each program instance
+// with the same number.
+type passedFD struct {
+	fd   int
+	name string
+	f    *os.File
+}
+
+// passFDs are -pass-fd descriptors.
+type passFDs []passedFD
+
+// add adds N[:name] file descriptor; it should be open.
+func (p *passFDs) add(spec string) error {
+	n, name, _ := strings.Cut(spec, ":")
+	fd, err := strconv.Atoi(n)
+	if err != nil || fd < listenFDsStart {
+		return fmt.Errorf("invalid file descriptor %q, expected %d or more", n, listenFDsStart)
+	}
+
+	f := os.NewFile(uintptr(fd), "fd "+n)
+	if _, err = f.Stat(); err != nil {
+		return fmt.Errorf("file descriptor %d is not open", fd)
+	}
+	syscall.CloseOnExec(fd)
+
+	*p = append(*p, passedFD{fd: fd, name: name, f: f})
+	return nil
+}
+
+// place puts descriptors into exec.Cmd.ExtraFiles at their numbers, padding it with nil (closed) ones.
+func (p passFDs) place(files []*os.File) ([]*os.File, error) {
+	for _, pfd := range p {
+		i := pfd.fd - listenFDsStart
+		switch {
+		case i >= len(files):
+			for len(files) < i {
+				files = append(files, nil)
+			}
+			files = append(files, pfd.f)
+		case files[i] == nil:
+			files[i] = pfd.f
+		default:
+			return nil, fmt.Errorf("-pass-fd %d is used by another file descriptor", pfd.fd)
+		}
+	}
+	return files, nil
+}
+
+// env returns passFDsEnv value: comma-separated N[:name] descriptors.
+func (p passFDs) env() string {
+	specs := make([]string, len(p))
+	for i, pfd := range p {
+		specs[i] = strconv.Itoa(pfd.fd)
+		if pfd.name != "" {
+			specs[i] += ":" + pfd.name
+		}
+	}
+	return strings.Join(specs, ",")
+}
+
+// closeInheritedFDs sets close-on-exec flag on file descriptors inherited by ruc from its parent
+// (other than stdio and the kept ones), so they do not leak into the program.
+// It returns descriptors that did not have that flag.
+func closeInheritedFDs(keep []int) []int {
+	var fds []int
+	if entries, err := os.ReadDir("/dev/fd"); err == nil {
+		for _, e := range entries {
+			if fd, err := strconv.Atoi(e.Name()); err == nil {
+				fds = append(fds, fd)
+			}
+		}
+	} else {
+		var rl syscall.Rlimit
+		n := 1024
+		if syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl) == nil {
+			n = int(min(rl.Cur, 1<<16))
+		}
+		for fd := 0; fd < n; fd++ {
+			fds = append(fds, fd)
+		}
+	}
+
+	var res []int
+	for _, fd := range fds {
+		if fd <= 2 || slices.Contains(keep, fd) {
+			continue
+		}
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+		if errno != 0 || flags&syscall.FD_CLOEXEC != 0 {
+			continue
+		}
+		syscall.CloseOnExec(fd)
+		res = append(res, fd)
+	}
+	return res
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteSize is a flag.Value for sizes in bytes with optional K, M, G, or T suffixes (powers of 1024).
+type byteSize int64
+
+func (b *byteSize) String() string {
+	v := int64(*b)
+	for _, u := range []string{"T", "G", "M", "K"} {
+		m := sizeUnits[u]
+		if v != 0 && v%m == 0 {
+			return strconv.FormatInt(v/m, 10) + u
+		}
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	m := int64(1)
+	if n := len(s); n > 0 {
+		if u, ok := sizeUnits[s[n-1:]]; ok {
+			m = u
+			s = s[:n-1]
+		}
+	}
+
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+
+	*b = byteSize(v * m)
+	return nil
+}
+
+var sizeUnits = map[string]int64{
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// durationUnits are units of time.ParseDuration.
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"µs": time.Microsecond, // U+00B5 micro sign
+	"μs": time.Microsecond, // U+03BC Greek letter mu
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// parseDuration is time.ParseDuration that also accepts days ("d") and weeks ("w") units,
+// e.g. "1w", "2d", "1d12h", or "1h30m1d". Values out of time.Duration range are rejected.
+func parseDuration(s string) (time.Duration, error) {
+	invalid := fmt.Errorf("invalid duration %q", s)
+	outOfRange := fmt.Errorf("invalid duration %q: out of range", s)
+
+	rest := s
+	neg := strings.HasPrefix(rest, "-")
+	if neg || strings.HasPrefix(rest, "+") {
+		rest = rest[1:]
+	}
+	if rest == "0" {
+		return 0, nil
+	}
+	if rest == "" {
+		return 0, invalid
+	}
+
+	var d time.Duration
+	for rest != "" {
+		// a number followed by a unit
+		i := strings.IndexFunc(rest, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+		if i <= 0 {
+			return 0, invalid
+		}
+		j := strings.IndexFunc(rest[i:], func(r rune) bool { return (r >= '0' && r <= '9') || r == '.' })
+		if j < 0 {
+			j = len(rest) - i
+		}
+		number, unit := rest[:i], rest[i:i+j]
+		rest = rest[i+j:]
+
+		var v time.Duration
+		switch unit {
+		case "d", "w":
+			f, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, invalid
+			}
+			f *= float64(24 * time.Hour)
+			if unit == "w" {
+				f *= 7
+			}
+			if f >= math.MaxInt64 {
+				return 0, outOfRange
+			}
+			v = time.Duration(f)
+
+		default:
+			var err error
+			if v, err = time.ParseDuration(number + unit); err != nil {
+				// time.ParseDuration does not tell overflows from syntax errors
+				if f, ferr := strconv.ParseFloat(number, 64); ferr == nil && f >= 1 && durationUnits[unit] != 0 && f*float64(durationUnits[unit]) >= math.MaxInt64 {
+					return 0, outOfRange
+				}
+				return 0, invalid
+			}
+		}
+
+		if d > math.MaxInt64-v {
+			return 0, outOfRange
+		}
+		d += v
+	}
+
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+// durationValue is a flag.Value for durations parsed by parseDuration.
+type durationValue time.Duration
+
+func (d *durationValue) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *durationValue) Set(s string) error {
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = durationValue(v)
+	return nil
+}
+
+// durationVar is flag.DurationVar with durations parsed by parseDuration.
+func durationVar(p *time.Duration, name string, value time.Duration, usage string) {
+	*p = value
+	flag.Var((*durationValue)(p), name, usage)
+}
+
+// durationFlag is flag.Duration with durations parsed by parseDuration.
+func durationFlag(name string, value time.Duration, usage string) *time.Duration {
+	p := new(time.Duration)
+	durationVar(p, name, value, usage)
+	return p
+}
+
+// isFlagSet returns true if the flag with the given name was set on the command line.
+func isFlagSet(name string) bool {
+	var set bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	day := 24 * time.Hour
+
+	for s, expected := range map[string]time.Duration{
+		"1d":       day,
+		"2w":       14 * day,
+		"1d12h":    36 * time.Hour,
+		"1h1d":     25 * time.Hour,
+		"1w1d1h":   8*day + time.Hour,
+		"1.5d":     36 * time.Hour,
+		"0.5w":     84 * time.Hour,
+		"-1d":      -day,
+		"-1d12h":   -36 * time.Hour,
+		"+2d":      2 * day,
+		"0":        0,
+		"90s":      90 * time.Second,
+		"1h30m":    90 * time.Minute,
+		"1.5h":     90 * time.Minute,
+		"300ms":    300 * time.Millisecond,
+		"2µs":      2 * time.Microsecond,
+		"-5m":      -5 * time.Minute,
+		"106751d":  106751 * day,
+		"2562047h": 2562047 * time.Hour,
+	} {
+		actual, err := parseDuration(s)
+		if err != nil {
+			t.Errorf("%q: %s", s, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("%q: expected %s, got %s", s, expected, actual)
+		}
+	}
+
+	for s, msg := range map[string]string{
+		"":            "invalid",
+		"-":           "invalid",
+		"d":           "invalid",
+		"1":           "invalid",
+		"1x":          "invalid",
+		"1.2.3d":      "invalid",
+		"1d-1h":       "invalid",
+		"106752d":     "out of range",
+		"1000000w":    "out of range",
+		"106751d24h":  "out of range",
+		"9999999999h": "out of range",
+	} {
+		if _, err := parseDuration(s); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expected %q error, got %v", s, msg, err)
+		}
+	}
+}
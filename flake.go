@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// flakeRun describes a single run of `ruc flake`.
+type flakeRun struct {
+	Run       int      `json:"run"`      // 1-based
+	Instance  int      `json:"instance"` // zero-based -stress instance
+	RunID     string   `json:"run_id"`
+	Seed      int64    `json:"seed"`
+	Command   []string `json:"command"`
+	ExitCode  int      `json:"exit_code"` // 128+signal number if killed by a signal
+	Error     string   `json:"error,omitempty"`
+	TimedOut  bool     `json:"timed_out,omitempty"`
+	Duration  string   `json:"duration"`
+	Artifacts string   `json:"artifacts,omitempty"` // directory with output.log and run.json
+}
+
+// flakeSummary is the machine-readable summary printed by `ruc flake`.
+type flakeSummary struct {
+	Runs     int       `json:"runs"`
+	Passed   int       `json:"passed"`
+	Failure  *flakeRun `json:"failure,omitempty"`
+	Duration string    `json:"duration"`
+}
+
+// flake implements `ruc flake` subcommand: it runs the program (in several concurrent instances with -stress)
+// until the first failure, saves the failed run's output and seed, and prints JSON summary to stdout.
+func flake(args []string) {
+	fs := flag.NewFlagSet("flake", flag.ExitOnError)
+	runsF := fs.Int("runs", 100, "Maximal number of runs")
+	var timeoutF time.Duration
+	fs.Var((*durationValue)(&timeoutF), "timeout", "Kill a run that takes longer than that, and count it as a failure; 0 means no limit")
+	artifactsF := fs.String("artifacts", "ruc-flake", "Save the failed run's output and description into a subdirectory of that directory")
+	seedF := fs.Int64("seed", 0, "Pass that seed as $"+seedEnv+" to all runs instead of random ones, to reproduce a failure")
+	verboseF := fs.Bool("verbose", false, "Also copy program's output to ruc's stderr")
+	stressF := fs.Int("stress", 1, "Run that many instances of the program concurrently; the first failure kills all of them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s flake [flags] program [program arguments]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Runs program until it fails or -runs are done, and prints JSON summary.\n")
+		fmt.Fprintf(fs.Output(), "Exits with 0 if all runs passed, 1 if one failed, and 2 on usage errors.\n")
+		fmt.Fprintf(fs.Output(), "Flags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 || *runsF <= 0 || *stressF <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	start := time.Now()
+	var summary flakeSummary
+	var m sync.Mutex
+	stop := make(chan struct{}) // closed on the first failure
+	var wg sync.WaitGroup
+	for instance := 0; instance < *stressF; instance++ {
+		wg.Add(1)
+		go func(instance int) {
+			defer wg.Done()
+
+			for {
+				m.Lock()
+				if summary.Failure != nil || summary.Runs >= *runsF {
+					m.Unlock()
+					return
+				}
+				summary.Runs++
+				r := &flakeRun{
+					Run:      summary.Runs,
+					Instance: instance,
+					RunID:    newRunID(),
+					Seed:     *seedF,
+					Command:  fs.Args(),
+				}
+				m.Unlock()
+
+				if r.Seed == 0 {
+					r.Seed = newSeed()
+				}
+
+				output, stopped, err := flakeOnce(r, timeoutF, *verboseF, stop)
+
+				m.Lock()
+				var failed bool
+				switch {
+				case stopped:
+					// killed because of another instance's failure
+					summary.Runs--
+				case err == nil:
+					summary.Passed++
+				case summary.Failure == nil:
+					r.Error = err.Error()
+					r.ExitCode, _ = exitStatus(err)
+					summary.Failure = r
+					failed = true
+					close(stop)
+				}
+				m.Unlock()
+
+				if !failed {
+					continue
+				}
+
+				dir := filepath.Join(*artifactsF, r.RunID)
+				if err = saveFlakeArtifacts(dir, r, output); err != nil {
+					fmt.Fprintf(os.Stderr, "ruc flake: failed to save artifacts: %s\n", err)
+				} else {
+					r.Artifacts = dir
+				}
+				return
+			}
+		}(instance)
+	}
+	wg.Wait()
+	summary.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	_ = e.Encode(summary)
+
+	if summary.Failure != nil {
+		os.Exit(1)
+	}
+}
+
+// flakeOnce runs the program once, and returns its combined output and exit error.
+// The program is killed if stop is closed; stopped is true then.
+func flakeOnce(r *flakeRun, timeout time.Duration, verbose bool, stop <-chan struct{}) (output []byte, stopped bool, err error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	if verbose {
+		w = io.MultiWriter(&buf, os.Stderr)
+	}
+
+	cmd := exec.Command(r.Command[0], r.Command[1:]...)
+	cmd.Env = append(os.Environ(), runIDEnv+"="+r.RunID, seedEnv+"="+strconv.FormatInt(r.Seed, 10))
+	cmd.Stdout = w
+	cmd.Stderr = w
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+
+	start := time.Now()
+	defer func() {
+		r.Duration = time.Since(start).Round(time.Millisecond).String()
+	}()
+
+	if err = cmd.Start(); err != nil {
+		return nil, false, err
+	}
+
+	var t *time.Timer
+	if timeout > 0 {
+		t = time.AfterFunc(timeout, func() {
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		})
+	}
+
+	done := make(chan struct{})
+	var m sync.Mutex
+	go func() {
+		select {
+		case <-stop:
+			m.Lock()
+			stopped = true
+			m.Unlock()
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+
+	err = cmd.Wait()
+	close(done)
+	if t != nil && !t.Stop() {
+		r.TimedOut = true
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	return buf.Bytes(), stopped, err
+}
+
+// saveFlakeArtifacts writes the failed run's output and description into dir.
+func saveFlakeArtifacts(dir string, r *flakeRun, output []byte) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "output.log"), output, 0o644); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "run.json"), append(b, '\n'), 0o644)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// fleetSlots are fleet-wide recycle slots: keys prefix/0 ... prefix/N-1 of etcd or Consul.
+// Holding one of them allows the periodic restart, so only N identically configured ruc instances
+// recycle their programs at the same time.
+type fleetSlots struct {
+	slots []locker
+}
+
+// newFleetSlots returns n slots created by newLocker for their keys.
+func newFleetSlots(prefix string, n int, newLocker func(key string) locker) *fleetSlots {
+	s := &fleetSlots{slots: make([]locker, n)}
+	for i := range s.slots {
+		s.slots[i] = newLocker(prefix + "/" + strconv.Itoa(i))
+	}
+	return s
+}
+
+// acquire tries all slots every interval until one of them is acquired, and returns it.
+// It returns nil if ctx is canceled.
+// Losing the slot is ignored: the restart is already happening then.
+func (s *fleetSlots) acquire(ctx context.Context, interval time.Duration, in *instance) locker {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		for i, l := range s.slots {
+			ok, _, err := l.tryLock(ctx)
+			if err != nil {
+				in.log.Printf("Failed to acquire fleet recycle slot %d: %s", i, err)
+			}
+			if ok {
+				in.log.Printf("Fleet recycle slot %d acquired.", i)
+				return l
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			// nothing
+		}
+	}
+}
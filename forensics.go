@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// forensicFiles are /proc/<pid>/ files copied into the snapshot.
+var forensicFiles = []string{"cmdline", "environ", "status", "stat", "wchan", "stack", "limits", "maps", "cgroup"}
+
+// forensicTaskFiles are /proc/<pid>/task/<tid>/ files copied into the snapshot for each thread.
+var forensicTaskFiles = []string{"status", "wchan", "stack", "syscall"}
+
+// snapshot captures the state of the hung process into a new timestamped directory inside dir,
+// optionally with a core dump made by gcore. Snapshot may contain secrets (for example, in environ),
+// so it is readable only by the owner.
+// It returns the created directory.
+func snapshot(dir string, pid int, gcore bool, gcoreTimeout time.Duration) (string, error) {
+	res := filepath.Join(dir, time.Now().Format("20060102T150405")+"-"+strconv.Itoa(pid))
+	if err := os.MkdirAll(res, 0o700); err != nil {
+		return "", err
+	}
+
+	// collect errors instead of failing, to capture as much as possible
+	var errs []string
+	copyFile := func(src, dst string) {
+		b, err := os.ReadFile(src)
+		if err != nil {
+			errs = append(errs, err.Error())
+			return
+		}
+		if err = os.WriteFile(dst, b, 0o600); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	proc := filepath.Join("/proc", strconv.Itoa(pid))
+	for _, f := range forensicFiles {
+		copyFile(filepath.Join(proc, f), filepath.Join(res, f))
+	}
+
+	// file descriptors
+	if entries, err := os.ReadDir(filepath.Join(proc, "fd")); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		var fds strings.Builder
+		for _, e := range entries {
+			target, err := os.Readlink(filepath.Join(proc, "fd", e.Name()))
+			if err != nil {
+				target = err.Error()
+			}
+			fmt.Fprintf(&fds, "%s -> %s\n", e.Name(), target)
+		}
+		if err = os.WriteFile(filepath.Join(res, "fds"), []byte(fds.String()), 0o600); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	// threads
+	if entries, err := os.ReadDir(filepath.Join(proc, "task")); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		for _, e := range entries {
+			taskDir := filepath.Join(res, "task", e.Name())
+			if err := os.MkdirAll(taskDir, 0o700); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			for _, f := range forensicTaskFiles {
+				copyFile(filepath.Join(proc, "task", e.Name(), f), filepath.Join(taskDir, f))
+			}
+		}
+	}
+
+	if gcore {
+		ctx, cancel := context.WithTimeout(context.Background(), gcoreTimeout)
+		out, err := exec.CommandContext(ctx, "gcore", "-o", filepath.Join(res, "core"), strconv.Itoa(pid)).CombinedOutput()
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("gcore: %s: %s", err, strings.TrimSpace(string(out))))
+		}
+	}
+
+	if len(errs) > 0 {
+		if err := os.WriteFile(filepath.Join(res, "errors"), []byte(strings.Join(errs, "\n")+"\n"), 0o600); err != nil {
+			log.Printf("Failed to write forensic snapshot errors: %s", err)
+		}
+	}
+
+	return res, nil
+}
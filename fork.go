@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+// maxForkDelay is the maximal delay between -fork-retries.
+const maxForkDelay = time.Minute
+
+// resourcesExhausted returns true if the program could not be started because fork or exec ran out of resources,
+// so retrying later may succeed.
+func resourcesExhausted(err error) bool {
+	return errors.Is(err, syscall.ENOMEM) || errors.Is(err, syscall.EAGAIN)
+}
+
+// freeMemory shrinks ruc's output and log buffers and returns freed memory to the OS, for -fork-retry-free.
+func freeMemory(opts *options, in *instance) {
+	if opts.tail != nil {
+		in.log.Printf("Shrinking -tail-buffer to %d bytes.", opts.tail.shrink())
+	}
+	if opts.logs != nil {
+		opts.logs.shrink()
+	}
+	debug.FreeOSMemory()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// genFlags are `ruc gen` flags describing the service, not passed to ruc.
+type genFlags struct {
+	description string
+	user        string
+	workDir     string
+
+	// launchd
+	label      string
+	stdoutPath string
+	stderrPath string
+}
+
+// genFlagNames are names of genFlags.
+var genFlagNames = []string{
+	"unit-description", "unit-user", "unit-working-directory",
+	"unit-label", "unit-stdout-path", "unit-stderr-path",
+}
+
+// genKinds are service definition kinds supported by `ruc gen`.
+var genKinds = map[string]func(w io.Writer, opts *options, ps programs, args []string, gf *genFlags) error{
+	"systemd": genSystemd,
+	"launchd": genLaunchd,
+}
+
+// stripGenFlags removes genFlags from ruc's command-line flags, leaving program arguments (the last n) as is.
+func stripGenFlags(args []string, n int) []string {
+	flags, rest := args[:len(args)-n], args[len(args)-n:]
+
+	res := make([]string, 0, len(args))
+	for i := 0; i < len(flags); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(flags[i], "-"), "=")
+		var gen bool
+		for _, g := range genFlagNames {
+			gen = gen || (name == g && strings.HasPrefix(flags[i], "-"))
+		}
+		switch {
+		case !gen:
+			res = append(res, flags[i])
+		case !hasValue:
+			i++ // skip the value
+		}
+	}
+	return append(res, rest...)
+}
+
+// serviceDescription returns the default service description.
+func serviceDescription(ps programs) string {
+	names := make([]string, len(ps))
+	for i, p := range ps {
+		names[i] = p.name
+		if names[i] == "" {
+			names[i] = filepath.Base(p.args[0])
+		}
+	}
+	return strings.Join(names, ", ") + " (supervised by ruc)"
+}
+
+// serviceLabel returns the default launchd job label.
+func serviceLabel(ps programs) string {
+	name := ps[0].name
+	if name == "" {
+		name = filepath.Base(ps[0].args[0])
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' || (r >= '0' && r <= '9') || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') {
+			return r
+		}
+		return '_'
+	}, name)
+	return "com.github.aleksi.ruc." + name
+}
+
+// stopTimeout returns how long the service manager should wait for ruc to stop the program gracefully.
+func stopTimeout(opts *options) time.Duration {
+	d := opts.settings.grace + 5*time.Second
+	if opts.drainURL != "" {
+		d += opts.drainTimeout
+	}
+	return d
+}
+
+// systemdQuote quotes the argument for systemd unit's command line.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\;") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(s) + `"`
+}
+
+// genSystemd writes systemd service unit running ruc with args.
+func genSystemd(w io.Writer, opts *options, ps programs, args []string, gf *genFlags) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := []string{systemdQuote(self)}
+	for _, a := range args {
+		cmd = append(cmd, systemdQuote(a))
+	}
+
+	fmt.Fprintf(w, "# Generated by `ruc gen systemd`.\n")
+	fmt.Fprintf(w, "[Unit]\n")
+	fmt.Fprintf(w, "Description=%s\n", gf.description)
+	fmt.Fprintf(w, "Wants=network-online.target\n")
+	fmt.Fprintf(w, "After=network-online.target\n")
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "[Service]\n")
+	fmt.Fprintf(w, "Type=simple\n")
+	fmt.Fprintf(w, "ExecStart=%s\n", strings.Join(cmd, " "))
+	fmt.Fprintf(w, "WorkingDirectory=%s\n", gf.workDir)
+	if gf.user != "" {
+		fmt.Fprintf(w, "User=%s\n", gf.user)
+	}
+	fmt.Fprintf(w, "# ruc restarts the program itself\n")
+	fmt.Fprintf(w, "Restart=no\n")
+	fmt.Fprintf(w, "# SIGTERM goes to ruc only, which stops the program gracefully; leftovers are killed after that\n")
+	fmt.Fprintf(w, "KillMode=mixed\n")
+	fmt.Fprintf(w, "TimeoutStopSec=%d\n", int(stopTimeout(opts).Round(time.Second)/time.Second))
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "[Install]\n")
+	fmt.Fprintf(w, "WantedBy=multi-user.target\n")
+	return nil
+}
+
+// genLaunchd writes launchd property list running ruc with args.
+func genLaunchd(w io.Writer, opts *options, ps programs, args []string, gf *genFlags) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	str := func(s string) string {
+		var b strings.Builder
+		_ = xml.EscapeText(&b, []byte(s))
+		return "<string>" + b.String() + "</string>"
+	}
+
+	if gf.label == "" {
+		gf.label = serviceLabel(ps)
+	}
+	if gf.stdoutPath == "" {
+		gf.stdoutPath = filepath.Join(gf.workDir, gf.label+".log")
+	}
+	if gf.stderrPath == "" {
+		gf.stderrPath = gf.stdoutPath
+	}
+
+	fmt.Fprintf(w, "%s", xml.Header)
+	fmt.Fprintf(w, `<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">`+"\n")
+	fmt.Fprintf(w, "<!-- Generated by `ruc gen launchd`: %s. -->\n", strings.ReplaceAll(gf.description, "--", "- -"))
+	fmt.Fprintf(w, "<plist version=\"1.0\">\n")
+	fmt.Fprintf(w, "<dict>\n")
+	fmt.Fprintf(w, "\t<key>Label</key>\n\t%s\n", str(gf.label))
+	fmt.Fprintf(w, "\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, a := range append([]string{self}, args...) {
+		fmt.Fprintf(w, "\t\t%s\n", str(a))
+	}
+	fmt.Fprintf(w, "\t</array>\n")
+	fmt.Fprintf(w, "\t<key>WorkingDirectory</key>\n\t%s\n", str(gf.workDir))
+	if gf.user != "" {
+		fmt.Fprintf(w, "\t<key>UserName</key>\n\t%s\n", str(gf.user))
+	}
+	fmt.Fprintf(w, "\t<key>RunAtLoad</key>\n\t<true/>\n")
+	fmt.Fprintf(w, "\t<!-- ruc restarts the program itself -->\n")
+	fmt.Fprintf(w, "\t<key>KeepAlive</key>\n\t<false/>\n")
+	fmt.Fprintf(w, "\t<!-- time between SIGTERM to ruc and SIGKILL -->\n")
+	fmt.Fprintf(w, "\t<key>ExitTimeOut</key>\n\t<integer>%d</integer>\n", int(stopTimeout(opts).Round(time.Second)/time.Second))
+	fmt.Fprintf(w, "\t<key>StandardOutPath</key>\n\t%s\n", str(gf.stdoutPath))
+	fmt.Fprintf(w, "\t<key>StandardErrorPath</key>\n\t%s\n", str(gf.stderrPath))
+	fmt.Fprintf(w, "</dict>\n")
+	fmt.Fprintf(w, "</plist>\n")
+	return nil
+}
@@ -0,0 +1,3 @@
+module github.com/AlekSi/ruc
+
+go 1.21
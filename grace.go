@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	// adaptiveGraceRuns is the number of the most recent shutdowns -adaptive-grace is based on.
+	adaptiveGraceRuns = 20
+
+	// adaptiveGraceMinRuns is the number of shutdowns required before -adaptive-grace replaces -grace.
+	adaptiveGraceMinRuns = 3
+
+	// adaptiveGraceHeadroom is the multiplier of the historical shutdown time for slower than usual shutdowns.
+	adaptiveGraceHeadroom = 1.5
+)
+
+// adaptiveGrace returns the grace period tuned from the program's shutdown times recorded in -history-file:
+// the 95th percentile of the recent ones with some headroom, within -adaptive-grace-min and -adaptive-grace-max.
+// It returns grace if there are not enough records yet.
+//
+// The real shutdown time of runs that were killed after the grace period is unknown, so it is taken as twice
+// the recorded one; that lets the grace period grow quickly after premature SIGKILLs.
+func adaptiveGrace(opts *options, in *instance, grace time.Duration) time.Duration {
+	records, err := readHistory(opts.historyFile)
+	if err != nil && !os.IsNotExist(err) {
+		in.log.Printf("Failed to read history for -adaptive-grace: %s", err)
+	}
+
+	var shutdowns []time.Duration
+	for i := len(records) - 1; i >= 0 && len(shutdowns) < adaptiveGraceRuns; i-- {
+		r := records[i]
+		if r.Program != in.name || r.Shutdown <= 0 {
+			continue
+		}
+
+		d := time.Duration(r.Shutdown * float64(time.Second))
+		if r.GraceExceeded {
+			d *= 2
+		}
+		shutdowns = append(shutdowns, d)
+	}
+
+	if len(shutdowns) < adaptiveGraceMinRuns {
+		return grace
+	}
+
+	slices.Sort(shutdowns)
+	p95 := shutdowns[(len(shutdowns)*95+99)/100-1]
+	res := time.Duration(float64(p95) * adaptiveGraceHeadroom).Round(100 * time.Millisecond)
+	res = min(max(res, opts.adaptiveGraceMin), opts.adaptiveGraceMax)
+
+	if res != in.adaptiveGrace {
+		in.log.Printf("Adaptive grace period is %s, based on %d previous shutdowns (95th percentile %s).", res, len(shutdowns), p95.Round(time.Millisecond))
+		in.adaptiveGrace = res
+	}
+	return res
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// statusFileEnv is the environment variable with the default status file path.
+const statusFileEnv = "RUC_STATUS_FILE"
+
+// alive returns true if process with the given PID exists.
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// health implements `ruc health` subcommand.
+// It exits with 0 status if the program is running (and ready), and with 1 otherwise.
+func health(args []string) {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	statusFileF := fs.String("status-file", os.Getenv(statusFileEnv), "Status file of the running ruc instance; defaults to $"+statusFileEnv)
+	quietF := fs.Bool("quiet", false, "Do not print anything")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s health [flags]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Exits with 0 status if the program is up, and with 1 otherwise.\n")
+		fmt.Fprintf(fs.Output(), "Flags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if *statusFileF == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	unhealthy := func(format string, a ...any) {
+		if !*quietF {
+			fmt.Printf(format+"\n", a...)
+		}
+		os.Exit(1)
+	}
+
+	s, err := readStatusFile(*statusFileF)
+	if err != nil {
+		unhealthy("unhealthy: %s", err)
+	}
+
+	if !alive(s.PID) {
+		unhealthy("unhealthy: ruc (PID %d) is not running", s.PID)
+	}
+
+	if s.State != stateRunning {
+		unhealthy("unhealthy: program is %s", s.State)
+	}
+
+	if !alive(s.ChildPID) {
+		unhealthy("unhealthy: program (PID %d) is not running", s.ChildPID)
+	}
+
+	if !*quietF {
+		fmt.Printf("healthy: program (PID %d) is running\n", s.ChildPID)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// heartbeat touches the file every interval while the program is healthy:
+// it is running, ready, and passes the readiness probe (if set).
+func heartbeat(ctx context.Context, path string, interval time.Duration, p probe) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	var failing bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		s := current.get()
+		if s.State != stateRunning || !alive(s.ChildPID) {
+			continue
+		}
+
+		if p != nil {
+			checkCtx, cancel := context.WithTimeout(ctx, interval)
+			err := p(checkCtx)
+			cancel()
+			if err != nil {
+				continue
+			}
+		}
+
+		if err := touch(path); err != nil {
+			if !failing {
+				log.Printf("Failed to touch heartbeat file: %s", err)
+				failing = true
+			}
+			continue
+		}
+		failing = false
+	}
+}
+
+// touch creates the file if it does not exist, and updates its modification time.
+func touch(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// historyFileEnv is the environment variable with the default run history journal path.
+const historyFileEnv = "RUC_HISTORY_FILE"
+
+// historyRecord describes a single completed run in the history journal.
+type historyRecord struct {
+	RunID         string    `json:"run_id"`
+	Program       string    `json:"program,omitempty"` // in -program mode
+	Replica       int       `json:"replica,omitempty"` // in -replicas mode
+	Iteration     int       `json:"iteration"`
+	PID           int       `json:"pid"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Duration      float64   `json:"duration"`             // seconds
+	ExitCode      int       `json:"exit_code"`            // -1 if killed by a signal or unknown
+	Signal        string    `json:"signal,omitempty"`     // signal that killed the program
+	Escalation    string    `json:"escalation,omitempty"` // the last stop step taken by ruc: drain, SIGTERM, or SIGKILL
+	OOMKilled     bool      `json:"oom_killed,omitempty"`
+	GraceExceeded bool      `json:"grace_exceeded,omitempty"` // SIGKILL was needed after the grace period
+	Shutdown      float64   `json:"shutdown,omitempty"`       // seconds between SIGTERM (or -before-stop-cmd) and the exit
+	Error         string    `json:"error,omitempty"`
+	UserTime      float64   `json:"user_time"`   // seconds
+	SystemTime    float64   `json:"system_time"` // seconds
+	MaxRSS        int64     `json:"max_rss"`     // as reported by getrusage(2): KiB on Linux, bytes on macOS
+
+	Output *outputDigest `json:"output,omitempty"` // of stdout, with -output-digest
+}
+
+// outputDigest describes program's stdout of a single run.
+type outputDigest struct {
+	Bytes  int64  `json:"bytes"`
+	Lines  int64  `json:"lines"` // newline characters; an unterminated last line is not counted
+	SHA256 string `json:"sha256"`
+}
+
+// failed returns true if the program exited with non-zero status, or was killed.
+func (r *historyRecord) failed() bool {
+	return r.ExitCode != 0 || r.Error != ""
+}
+
+// newHistoryRecord returns history record for the command that exited with the given result
+// in the given state; graceStart is the start of the grace period, if it was asked to exit.
+func newHistoryRecord(cmd *command, in *instance, start, graceStart time.Time, st state, oomKilled bool, res *exitResult) *historyRecord {
+	end := time.Now()
+	r := &historyRecord{
+		RunID:     cmd.runID,
+		Program:   in.name,
+		Replica:   in.index,
+		Iteration: in.status.get().Iteration,
+		PID:       cmd.Process.Pid,
+		Start:     start,
+		End:       end,
+		Duration:  end.Sub(start).Seconds(),
+		OOMKilled: oomKilled,
+	}
+
+	switch st {
+	case stateDraining:
+		r.Escalation = "drain"
+	case stateStopping:
+		r.Escalation = "SIGTERM"
+	case stateKilling:
+		r.Escalation = "SIGKILL"
+		r.GraceExceeded = true
+	}
+	if (st == stateStopping || st == stateKilling) && !graceStart.IsZero() {
+		r.Shutdown = end.Sub(graceStart).Seconds()
+	}
+
+	r.ExitCode = res.code
+	if res.signal != 0 {
+		r.Signal = signalName(res.signal)
+	}
+	if u := res.usage; u != nil {
+		r.UserTime = u.user.Seconds()
+		r.SystemTime = u.system.Seconds()
+		r.MaxRSS = u.maxRSS
+	}
+
+	if cmd.digest != nil {
+		r.Output = cmd.digest.digest()
+	}
+
+	var ee *exec.ExitError
+	if res.err != nil && !errors.As(res.err, &ee) {
+		r.Error = res.err.Error()
+	}
+
+	return r
+}
+
+// appendHistory appends the record to the journal file as a JSON line.
+func appendHistory(path string, r *historyRecord) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Failed to encode history record: %s", err)
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err == nil {
+		_, err = f.Write(append(b, '\n'))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to write history record: %s", err)
+	}
+}
+
+// readHistory reads all journal records; malformed lines (e.g. partially written) are skipped.
+func readHistory(path string) ([]historyRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var res []historyRecord
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		var r historyRecord
+		if json.Unmarshal(s.Bytes(), &r) == nil {
+			res = append(res, r)
+		}
+	}
+	return res, s.Err()
+}
+
+// history implements `ruc history` subcommand.
+func history(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	historyFileF := fs.String("history-file", os.Getenv(historyFileEnv), "Run history journal; defaults to $"+historyFileEnv)
+	sinceF := fs.Duration("since", 0, "Only show runs started within that period (e.g. 168h)")
+	failedF := fs.Bool("failed", false, "Only show failed runs: exited with non-zero status, killed by a signal, or failed to be waited for")
+	escalatedF := fs.Bool("escalated", false, "Only show runs that ruc had to kill with SIGKILL")
+	lastF := fs.Int("last", 0, "Only show that many most recent runs; 0 means all")
+	formatF := fs.String("format", "text", "Output format: text, json (JSON lines), or csv")
+	rollupF := fs.String("rollup", "", "Instead of runs, show their counts, wall time, CPU time, and peak memory aggregated per program and period, for cost attribution: daily or weekly (weeks start on Monday)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s history [flags]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Shows completed runs recorded with -history-file.\n")
+		fmt.Fprintf(fs.Output(), "Flags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if *historyFileF == "" || fs.NArg() != 0 || (*rollupF != "" && !slices.Contains(rollupPeriods, *rollupF)) {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	records, err := readHistory(*historyFileF)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	var res []historyRecord
+	for _, r := range records {
+		if *sinceF > 0 && time.Since(r.Start) > *sinceF {
+			continue
+		}
+		if *failedF && !r.failed() {
+			continue
+		}
+		if *escalatedF && r.Escalation != "SIGKILL" {
+			continue
+		}
+		res = append(res, r)
+	}
+	if *lastF > 0 && len(res) > *lastF {
+		res = res[len(res)-*lastF:]
+	}
+
+	if *rollupF != "" {
+		err = writeRollups(os.Stdout, *formatF, rollupHistory(res, *rollupF))
+	} else {
+		err = writeHistory(os.Stdout, *formatF, res)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// writeHistory writes records in the given format.
+func writeHistory(w io.Writer, format string, records []historyRecord) error {
+	switch format {
+	case "json":
+		e := json.NewEncoder(w)
+		for _, r := range records {
+			if err := e.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{
+			"run_id", "iteration", "pid", "start", "end", "duration", "exit_code", "signal",
+			"escalation", "oom_killed", "error", "user_time", "system_time", "max_rss",
+			"output_bytes", "output_lines", "output_sha256",
+		})
+		for _, r := range records {
+			var out [3]string
+			if o := r.Output; o != nil {
+				out = [3]string{strconv.FormatInt(o.Bytes, 10), strconv.FormatInt(o.Lines, 10), o.SHA256}
+			}
+			_ = cw.Write([]string{
+				r.RunID, strconv.Itoa(r.Iteration), strconv.Itoa(r.PID),
+				r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), fmt.Sprint(r.Duration),
+				strconv.Itoa(r.ExitCode), r.Signal, r.Escalation, strconv.FormatBool(r.OOMKilled), r.Error,
+				fmt.Sprint(r.UserTime), fmt.Sprint(r.SystemTime), strconv.FormatInt(r.MaxRSS, 10),
+				out[0], out[1], out[2],
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case "text":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "START\tDURATION\tEXIT\tSIGNAL\tESCALATION\tCPU\tMAX RSS\tRUN ID")
+		for _, r := range records {
+			exit := strconv.Itoa(r.ExitCode)
+			if r.OOMKilled {
+				exit += " (OOM)"
+			}
+			if r.Error != "" {
+				exit = r.Error
+			}
+			cpu := time.Duration((r.UserTime + r.SystemTime) * float64(time.Second)).Round(time.Millisecond)
+			dur := time.Duration(r.Duration * float64(time.Second)).Round(time.Millisecond)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+				r.Start.Local().Format("2006-01-02 15:04:05"), dur, exit, r.Signal, r.Escalation, cpu, r.MaxRSS, r.RunID)
+		}
+		return tw.Flush()
+
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hostConditionTimeout is the maximal time of a single -defer-recycle-when check.
+const hostConditionTimeout = 10 * time.Second
+
+// hostCondition is a -defer-recycle-when condition of the host that defers timed recycles while it holds,
+// for laptops and edge devices.
+type hostCondition struct {
+	kind    string  // "battery", "thermal", or "cmd"
+	temp    float64 // thermal threshold in degrees Celsius
+	command string  // run with /bin/sh -c
+}
+
+// parseHostCondition parses -defer-recycle-when flag value: battery, thermal:degrees, or cmd:command.
+func parseHostCondition(s string) (*hostCondition, error) {
+	kind, arg, _ := strings.Cut(s, ":")
+	switch kind {
+	case "battery":
+		if arg != "" {
+			return nil, fmt.Errorf("invalid battery condition %q", s)
+		}
+		return &hostCondition{kind: kind}, nil
+	case "thermal":
+		t, err := strconv.ParseFloat(arg, 64)
+		if err != nil || t <= 0 {
+			return nil, fmt.Errorf("invalid thermal condition %q, expected thermal:degrees (e.g. thermal:80)", s)
+		}
+		return &hostCondition{kind: kind, temp: t}, nil
+	case "cmd":
+		if arg == "" {
+			return nil, errors.New("cmd condition requires a command")
+		}
+		return &hostCondition{kind: kind, command: arg}, nil
+	default:
+		return nil, fmt.Errorf("unknown condition %q, expected battery, thermal:degrees, or cmd:command", s)
+	}
+}
+
+// check returns the reason to defer recycles, or an empty string if the condition does not hold.
+//
+// The battery condition holds while any power supply is discharging, and the thermal one while any thermal zone
+// is at the threshold or above; both use Linux sysfs, and never hold without it.
+// The command condition holds while the command exits with non-zero status; its output is the reason.
+func (c *hostCondition) check(ctx context.Context) (string, error) {
+	switch c.kind {
+	case "battery":
+		paths, _ := filepath.Glob("/sys/class/power_supply/*/status")
+		for _, p := range paths {
+			if b, err := os.ReadFile(p); err == nil && strings.TrimSpace(string(b)) == "Discharging" {
+				return "running on battery", nil
+			}
+		}
+		return "", nil
+
+	case "thermal":
+		paths, _ := filepath.Glob("/sys/class/thermal/thermal_zone*/temp")
+		for _, p := range paths {
+			b, err := os.ReadFile(p)
+			if err != nil {
+				continue
+			}
+			// in millidegrees
+			if m, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && float64(m)/1000 >= c.temp {
+				return fmt.Sprintf("%s is at %.1f°C", filepath.Base(filepath.Dir(p)), float64(m)/1000), nil
+			}
+		}
+		return "", nil
+
+	default:
+		ctx, cancel := context.WithTimeout(ctx, hostConditionTimeout)
+		defer cancel()
+
+		out, err := exec.CommandContext(ctx, "/bin/sh", "-c", c.command).CombinedOutput()
+		var ee *exec.ExitError
+		switch {
+		case err == nil:
+			return "", nil
+		case ctx.Err() == nil && errors.As(err, &ee):
+			if line, _, _ := bytes.Cut(bytes.TrimSpace(out), []byte("\n")); len(line) > 0 {
+				return string(line), nil
+			}
+			return "condition command exited with status " + strconv.Itoa(ee.ExitCode()), nil
+		default:
+			return "", err
+		}
+	}
+}
+
+// checkHostConditions returns the reason of the first holding condition, or an empty string.
+// Failed checks are logged and treated as not holding, so they do not postpone recycles.
+func checkHostConditions(ctx context.Context, opts *options, in *instance) string {
+	for _, c := range opts.hostConds {
+		reason, err := c.check(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				in.log.Printf("Failed to check -defer-recycle-when %s condition: %s", c.kind, err)
+			}
+			continue
+		}
+		if reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
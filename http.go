@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+func init() {
+	expvar.Publish("ruc", expvar.Func(func() any {
+		return current.get()
+	}))
+}
+
+// newHTTPHandler returns handler for the optional HTTP listener.
+// Triggers are fired by POST /trigger if not nil.
+// Triggers, debug endpoints (that expose ruc's command line), and XML-RPC methods that stop and start programs
+// require the token; they are disabled if it is empty.
+func newHTTPHandler(ctx context.Context, instances []*instance, triggers chan<- string, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	// supervisord's path, so supervisorctl and other tools work with serverurl=http://host:port
+	rpc := &xmlrpcHandler{ctx: ctx, instances: instances, token: token}
+	mux.Handle("/RPC2", rpc)
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		_ = e.Encode(current.get())
+	})
+
+	mux.HandleFunc("/pid", func(w http.ResponseWriter, r *http.Request) {
+		pid := current.get().ChildPID
+		if pid == 0 {
+			http.Error(w, "program is not running", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, pid)
+	})
+
+	// for load balancers and orchestrators: the program is running and not degraded
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		s := current.get()
+		switch {
+		case s.State != stateRunning && s.State != stateBusy:
+			http.Error(w, "program is "+string(s.State), http.StatusServiceUnavailable)
+		case s.Degraded != "":
+			http.Error(w, "program is degraded: "+s.Degraded, http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprintln(w, "ok")
+		}
+	})
+
+	if triggers != nil {
+		mux.HandleFunc("/trigger", rpc.requireToken(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "use POST", http.StatusMethodNotAllowed)
+				return
+			}
+			requestRestart(triggers, "POST /trigger from "+r.RemoteAddr)
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprintln(w, "ok")
+		}))
+	}
+
+	// for external load balancers: the program is ready and not within its stop window
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !current.get().Serving {
+			http.Error(w, "program is not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, "ok")
+	})
+
+	// both expose the command line, that may contain secrets
+	mux.HandleFunc("/debug/vars", rpc.requireToken(expvar.Handler().ServeHTTP))
+	mux.HandleFunc("/debug/pprof/", rpc.requireToken(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", rpc.requireToken(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", rpc.requireToken(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", rpc.requireToken(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", rpc.requireToken(pprof.Trace))
+
+	return mux
+}
+
+// serveHTTP starts HTTP listener on the given address; it is shut down with services.
+func serveHTTP(ctx context.Context, addr string, instances []*instance, triggers chan<- string, token string, svcs *services) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Serving HTTP on http://%s/.", l.Addr())
+
+	srv := &http.Server{Handler: newHTTPHandler(ctx, instances, triggers, token)}
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server stopped: %s", err)
+		}
+	}()
+
+	svcs.add(func() {
+		// let in-flight requests (like status polls) finish
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shut down HTTP server: %s", err)
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPAuth(t *testing.T) {
+	ctx := context.Background()
+
+	for name, tc := range map[string]struct {
+		token string
+		auth  func(r *http.Request)
+		code  int
+	}{
+		"NoToken": {
+			auth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer ") },
+			code: http.StatusForbidden,
+		},
+		"Missing": {
+			token: "secret",
+			code:  http.StatusUnauthorized,
+		},
+		"Wrong": {
+			token: "secret",
+			auth:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") },
+			code:  http.StatusUnauthorized,
+		},
+		"Bearer": {
+			token: "secret",
+			auth:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") },
+			code:  http.StatusOK,
+		},
+		"BasicPassword": {
+			token: "secret",
+			auth:  func(r *http.Request) { r.SetBasicAuth("user", "secret") },
+			code:  http.StatusOK,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			triggers := make(chan string, 1)
+			h := newHTTPHandler(ctx, nil, triggers, tc.token)
+
+			for _, req := range []struct{ method, path string }{
+				{"POST", "/trigger"},
+				{"GET", "/debug/vars"},
+				{"GET", "/debug/pprof/"},
+				{"GET", "/debug/pprof/cmdline"},
+				{"GET", "/debug/pprof/symbol"},
+			} {
+				r := httptest.NewRequest(req.method, req.path, nil)
+				if tc.auth != nil {
+					tc.auth(r)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+
+				if w.Code != tc.code {
+					t.Errorf("%s %s: expected status %d, got %d: %s", req.method, req.path, tc.code, w.Code, w.Body)
+				}
+			}
+
+			select {
+			case reason := <-triggers:
+				if tc.code != http.StatusOK {
+					t.Errorf("unexpected trigger %q", reason)
+				}
+			default:
+				if tc.code == http.StatusOK {
+					t.Error("expected trigger")
+				}
+			}
+		})
+	}
+
+	// status endpoints do not require the token
+	h := newHTTPHandler(ctx, nil, nil, "secret")
+	for _, path := range []string{"/status", "/healthz", "/ready", "/pid"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+			t.Errorf("%s: unexpected status %d", path, w.Code)
+		}
+	}
+}
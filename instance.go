@@ -0,0 +1,681 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Environment variables passed to the program in -replicas and -program modes.
+const (
+	replicaEnv     = "RUC_REPLICA"  // zero-based replica index
+	replicasEnv    = "RUC_REPLICAS" // total number of replicas
+	programNameEnv = "RUC_PROGRAM"  // program name
+	portEnv        = "PORT"         // assigned with -port-base
+)
+
+// program is a named program supervised in -program mode.
+type program struct {
+	name  string
+	args  []string
+	needs []string // names of programs that should be ready before this one is started
+	group []string // names of programs recycled after this one crashes
+	run   *period  // overrides -run for this program, if set
+}
+
+// programNameRE matches valid program names; they are used in systemd unit names.
+var programNameRE = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// programs is a list of -program flag values.
+type programs []program
+
+// add adds program from name=command (or name:command) specification; command is run with /bin/sh -c.
+func (ps *programs) add(s string) error {
+	// names can't contain either separator
+	i := strings.IndexAny(s, "=:")
+	if i < 0 || i == len(s)-1 {
+		return fmt.Errorf("invalid program specification %q", s)
+	}
+	name, command := s[:i], s[i+1:]
+	if !programNameRE.MatchString(name) {
+		return fmt.Errorf("invalid program name %q", name)
+	}
+	if ps.find(name) != nil {
+		return fmt.Errorf("duplicate program %q", name)
+	}
+
+	*ps = append(*ps, program{name: name, args: []string{"/bin/sh", "-c", command}})
+	return nil
+}
+
+// find returns the program with the given name, or nil.
+func (ps programs) find(name string) *program {
+	for i := range ps {
+		if ps[i].name == name {
+			return &ps[i]
+		}
+	}
+	return nil
+}
+
+// addNeeds adds program dependencies from name=dep[,dep...] specification.
+func (ps programs) addNeeds(s string) error {
+	name, deps, ok := strings.Cut(s, "=")
+	if !ok || deps == "" {
+		return fmt.Errorf("invalid dependency specification %q", s)
+	}
+
+	p := ps.find(name)
+	if p == nil {
+		return fmt.Errorf("unknown program %q", name)
+	}
+	for _, d := range strings.Split(deps, ",") {
+		if ps.find(d) == nil {
+			return fmt.Errorf("unknown program %q", d)
+		}
+		p.needs = append(p.needs, d)
+	}
+
+	return nil
+}
+
+// addRun sets program's run period from name=period specification.
+func (ps programs) addRun(s string) error {
+	name, v, ok := strings.Cut(s, "=")
+	if !ok || v == "" {
+		return fmt.Errorf("invalid run period specification %q", s)
+	}
+
+	p := ps.find(name)
+	if p == nil {
+		return fmt.Errorf("unknown program %q", name)
+	}
+	p.run = new(period)
+	return p.run.Set(v)
+}
+
+// addGroup adds restart group from name,name[,name...] specification, or all;
+// a crash of one member gracefully restarts the others.
+func (ps programs) addGroup(s string) error {
+	var names []string
+	if s == "all" {
+		for _, p := range ps {
+			names = append(names, p.name)
+		}
+	} else {
+		names = strings.Split(s, ",")
+	}
+	if len(names) < 2 {
+		return fmt.Errorf("invalid restart group specification %q: at least two programs are needed", s)
+	}
+
+	for _, n := range names {
+		p := ps.find(n)
+		if p == nil {
+			return fmt.Errorf("unknown program %q", n)
+		}
+		for _, m := range names {
+			if m != n && !slices.Contains(p.group, m) {
+				p.group = append(p.group, m)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkCycles returns an error if programs' dependencies have a cycle.
+func (ps programs) checkCycles() error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+
+		marks[name] = visiting
+		for _, d := range ps.find(name).needs {
+			if err := visit(d, path); err != nil {
+				return err
+			}
+		}
+		marks[name] = visited
+		return nil
+	}
+
+	for _, p := range ps {
+		if err := visit(p.name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// instance is a single restart loop of the program; there are several of them in -replicas and -program modes.
+type instance struct {
+	name     string // program name in -program mode
+	index    int
+	replicas int
+	args     []string
+	port     int // 0 if not assigned
+	status   *statusTracker
+	log      *log.Logger
+	prefix   string // of output lines
+	color    string // ANSI escape sequence for prefix
+
+	scheduler     runScheduler
+	run           *period // overrides -run with -program-run, if set
+	backoff       backoff
+	backoffDelay  time.Duration // before the next start
+	prestarted    *command      // gated next program instance
+	next          *execPlan     // prepared next start, with -prepare-next
+	startFailures int           // consecutive -start-timeout failures
+	failures      int           // consecutive failed runs, for -bundle-dir
+	graceExceeded bool          // the last run needed SIGKILL after the grace period
+	exit          *exitResult   // of the last run's program; nil if it did not start
+	lastBoundary  time.Time     // -schedule boundary of the last start, zero if unknown
+	recycling     bool          // holds one of opts.recycling slots until the next run is up
+	fleetSlot     locker        // one of opts.fleetSlots held until the next run is up, nil if none
+	dataDir       string        // -alternate-dir of the current (or the last) run
+	altIndex      int           // of the next -alternate-dir
+	triggered     bool          // the last run was stopped by -trigger, so the next one starts without waiting
+	prevEnv       []string      // of the previous run without per-run variables, for -log-env-diff
+	daemonWarned  bool          // about the program that probably daemonized itself, see -daemon-detect
+	adopt         int           // PID of the running process adopted instead of the first start, see -adopt-pid
+	adaptiveGrace time.Duration // the last logged -adaptive-grace period
+
+	restart     chan string        // graceful restart requests with reasons
+	trigger     chan string        // -trigger firings with descriptions, nil without it
+	suspended   chan time.Duration // system suspension periods
+	clockJumped chan time.Duration // wall clock jumps, with -clock-jump=reanchor
+	stdin       chan string        // lines for the program's stdin, nil without -stdin-pipe
+
+	deps       []*instance // should be ready before the start
+	dependents []*instance // restarted after this one is restarted
+	group      []*instance // restarted after this one crashes
+
+	upM   sync.Mutex
+	upC   chan struct{} // closed while the program is ready
+	isUp  bool
+	wasUp bool // program was ready at least once
+
+	holdM sync.Mutex
+	held  chan struct{} // non-nil while the program is stopped via XML-RPC; closed when it is started again
+}
+
+// newInstances returns instances running programs, each in the given number of replicas.
+// A single instance uses the global status tracker and logger; several instances report into them.
+func newInstances(opts *options, ps programs, replicas int) []*instance {
+	if len(ps) == 1 && replicas <= 1 {
+		return []*instance{{
+			name:        ps[0].name,
+			replicas:    1,
+			args:        ps[0].args,
+			run:         ps[0].run,
+			port:        opts.portBase,
+			status:      current,
+			log:         log.Default(),
+			scheduler:   runScheduler{mode: opts.intervalMode},
+			backoff:     opts.backoff,
+			restart:     opts.restart,
+			trigger:     opts.triggers,
+			suspended:   opts.suspended,
+			clockJumped: opts.clockJumped,
+			stdin:       opts.stdinLines,
+			upC:         make(chan struct{}),
+		}}
+	}
+
+	n := len(ps) * replicas
+	current.update(func(s *status) {
+		s.Instances = make([]status, n)
+	})
+
+	res := make([]*instance, 0, n)
+	byName := make(map[string][]*instance)
+	for pi, p := range ps {
+		for r := 0; r < replicas; r++ {
+			i := len(res)
+			in := &instance{
+				name:      p.name,
+				index:     r,
+				replicas:  replicas,
+				args:      p.args,
+				run:       p.run,
+				log:       log.New(log.Writer(), "", log.Flags()),
+				scheduler: runScheduler{mode: opts.intervalMode},
+				backoff:   opts.backoff,
+				restart:   make(chan string, 1),
+				upC:       make(chan struct{}),
+			}
+			in.status = &statusTracker{
+				s: status{
+					Name:  in.label(),
+					PID:   os.Getpid(),
+					State: stateWaiting,
+				},
+			}
+			in.status.onUpdate = func(is status) {
+				current.update(func(s *status) {
+					s.Instances[i] = is
+					s.aggregate()
+				})
+			}
+			if opts.portBase > 0 {
+				// as foreman does
+				in.port = opts.portBase + pi*opts.portStep + r
+			}
+			if opts.triggers != nil {
+				in.trigger = make(chan string, 1)
+			}
+			if opts.suspended != nil {
+				in.suspended = make(chan time.Duration, 1)
+			}
+			if opts.clockJumped != nil {
+				in.clockJumped = make(chan time.Duration, 1)
+			}
+			if opts.stdinLines != nil {
+				in.stdin = make(chan string, cap(opts.stdinLines))
+			}
+			in.setLogPrefix("")
+			res = append(res, in)
+			byName[p.name] = append(byName[p.name], in)
+		}
+	}
+
+	for _, p := range ps {
+		for _, in := range byName[p.name] {
+			for _, d := range p.needs {
+				for _, dep := range byName[d] {
+					in.deps = append(in.deps, dep)
+					dep.dependents = append(dep.dependents, in)
+				}
+			}
+			for _, g := range p.group {
+				in.group = append(in.group, byName[g]...)
+			}
+		}
+	}
+
+	// broadcast requests to all instances
+	go func() {
+		for reason := range opts.restart {
+			for _, in := range res {
+				requestRestart(in.restart, reason)
+			}
+		}
+	}()
+	if opts.triggers != nil {
+		go func() {
+			for reason := range opts.triggers {
+				for _, in := range res {
+					requestRestart(in.trigger, reason)
+				}
+			}
+		}()
+	}
+	if opts.suspended != nil {
+		go func() {
+			for d := range opts.suspended {
+				for _, in := range res {
+					select {
+					case in.suspended <- d:
+					default:
+					}
+				}
+			}
+		}()
+	}
+	if opts.clockJumped != nil {
+		go func() {
+			for d := range opts.clockJumped {
+				for _, in := range res {
+					select {
+					case in.clockJumped <- d:
+					default:
+					}
+				}
+			}
+		}()
+	}
+	if opts.stdinLines != nil {
+		go func() {
+			for line := range opts.stdinLines {
+				for _, in := range res {
+					select {
+					case in.stdin <- line:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	return res
+}
+
+// label returns program name and replica index, if any.
+func (in *instance) label() string {
+	l := in.name
+	if in.replicas > 1 {
+		l += "#" + strconv.Itoa(in.index)
+	}
+	return l
+}
+
+// setLogPrefix sets instance's log prefix with the given run ID (if any).
+func (in *instance) setLogPrefix(runID string) {
+	p := "ruc"
+	switch {
+	case in.name != "":
+		p += " " + in.label()
+	case in.replicas > 1:
+		p += in.label()
+	}
+	if runID != "" {
+		p += "[" + runID + "]"
+	}
+	in.log.SetPrefix(p + ": ")
+}
+
+// unitName returns a name for the current iteration's systemd scope or cgroup.
+func (in *instance) unitName() string {
+	return in.unitNameAt(in.status.get().Iteration)
+}
+
+// unitNameAt returns unitName for the given iteration.
+func (in *instance) unitNameAt(iteration int) string {
+	name := fmt.Sprintf("ruc-%d", os.Getpid())
+	if in.name != "" {
+		name += "-" + in.name
+	}
+	if in.replicas > 1 {
+		name += fmt.Sprintf("-r%d", in.index)
+	}
+	return name + fmt.Sprintf("-%d", iteration)
+}
+
+// env returns environment variables identifying the program and the replica, if there are several of them,
+// and the assigned port.
+func (in *instance) env() []string {
+	var res []string
+	if in.port > 0 {
+		res = append(res, portEnv+"="+strconv.Itoa(in.port))
+	}
+	if in.name != "" {
+		res = append(res, programNameEnv+"="+in.name)
+	}
+	if in.replicas > 1 {
+		res = append(res,
+			replicaEnv+"="+strconv.Itoa(in.index),
+			replicasEnv+"="+strconv.Itoa(in.replicas),
+		)
+	}
+	return res
+}
+
+// releaseSlot releases the recycling slot and the fleet recycle slot, if instance holds them.
+func (in *instance) releaseSlot(opts *options) {
+	if in.recycling {
+		<-opts.recycling
+		in.recycling = false
+	}
+	if in.fleetSlot != nil {
+		in.fleetSlot.unlock()
+		in.fleetSlot = nil
+		in.log.Printf("Fleet recycle slot released.")
+	}
+}
+
+// up is called when the program becomes ready.
+// It releases the recycling slot, unblocks dependents waiting for the start,
+// and restarts them if the program was restarted.
+func (in *instance) up(opts *options) {
+	in.releaseSlot(opts)
+
+	in.upM.Lock()
+	if !in.isUp {
+		close(in.upC)
+		in.isUp = true
+	}
+	restarted := in.wasUp
+	in.wasUp = true
+	in.upM.Unlock()
+
+	if restarted {
+		for _, d := range in.dependents {
+			requestRestart(d.restart, "dependency "+in.label()+" restarted")
+		}
+	}
+}
+
+// crashed is called when the program exits unsuccessfully without being stopped by ruc.
+// It restarts other members of its restart group.
+func (in *instance) crashed() {
+	for _, g := range in.group {
+		requestRestart(g.restart, "restart group member "+in.label()+" crashed")
+	}
+}
+
+// down is called when the program exits.
+func (in *instance) down() {
+	in.upM.Lock()
+	defer in.upM.Unlock()
+
+	if in.isUp {
+		in.upC = make(chan struct{})
+		in.isUp = false
+	}
+}
+
+// ready returns a channel that is closed while the program is ready.
+func (in *instance) ready() <-chan struct{} {
+	in.upM.Lock()
+	defer in.upM.Unlock()
+
+	return in.upC
+}
+
+// waitDeps waits until all dependencies are ready, or ctx is canceled.
+func (in *instance) waitDeps(ctx context.Context) error {
+	for _, d := range in.deps {
+		c := d.ready()
+		select {
+		case <-c:
+			continue
+		default:
+		}
+
+		in.log.Printf("Waiting for %s to be ready...", d.label())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c:
+		}
+	}
+	return nil
+}
+
+// setLastBoundary records -schedule boundary of the start, also in -catch-up-file.
+func (in *instance) setLastBoundary(opts *options, b time.Time) {
+	in.lastBoundary = b
+	if opts.catchUpFile == "" {
+		return
+	}
+
+	if err := os.WriteFile(opts.catchUpFile, []byte(b.Format(time.RFC3339)+"\n"), 0o644); err != nil {
+		in.log.Printf("Failed to write -catch-up-file: %s", err)
+	}
+}
+
+// hold prevents further program starts until release; it returns false if the instance is already held.
+func (in *instance) hold() bool {
+	in.holdM.Lock()
+	defer in.holdM.Unlock()
+
+	if in.held != nil {
+		return false
+	}
+	in.held = make(chan struct{})
+	return true
+}
+
+// release allows program starts again; it returns false if the instance is not held.
+func (in *instance) release() bool {
+	in.holdM.Lock()
+	defer in.holdM.Unlock()
+
+	if in.held == nil {
+		return false
+	}
+	close(in.held)
+	in.held = nil
+	return true
+}
+
+// isHeld returns true if program starts are prevented by hold.
+func (in *instance) isHeld() bool {
+	in.holdM.Lock()
+	defer in.holdM.Unlock()
+
+	return in.held != nil
+}
+
+// waitRelease waits until the instance is not held, or ctx is canceled.
+func (in *instance) waitRelease(ctx context.Context) error {
+	in.holdM.Lock()
+	c := in.held
+	in.holdM.Unlock()
+
+	if c == nil {
+		return nil
+	}
+
+	in.log.Printf("Program is stopped, waiting for it to be started...")
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c:
+		return nil
+	}
+}
+
+// killInstances kills process groups of running programs, and waits a bit for them to be reaped.
+func killInstances(instances []*instance) {
+	var pids []int
+	for _, in := range instances {
+		if pid := in.status.get().ChildPID; pid != 0 {
+			in.log.Printf("Killing program (PID %d) and its process group.", pid)
+			err := syscall.Kill(-pid, syscall.SIGKILL)
+			if err == syscall.ESRCH {
+				// program is not a process group leader with -no-setpgid
+				err = syscall.Kill(pid, syscall.SIGKILL)
+			}
+			if err != nil {
+				in.log.Printf("Failed to send SIGKILL: %s", err)
+			}
+			pids = append(pids, pid)
+		}
+	}
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var left bool
+		for _, pid := range pids {
+			left = left || alive(pid)
+		}
+		if !left {
+			return
+		}
+	}
+}
+
+// loop runs iterations until ctx is canceled or an iteration fails.
+func (in *instance) loop(ctx context.Context, opts *options) error {
+	// do not leave the program unsupervised if ruc crashes
+	defer recoverCrash(opts, []*instance{in}, true)
+
+	defer in.releaseSlot(opts)
+	defer func() {
+		if in.prestarted != nil {
+			in.log.Printf("Aborting prestarted program (PID %d).", in.prestarted.Process.Pid)
+			in.prestarted.abort()
+		}
+		if in.next != nil {
+			in.next.discard(in)
+		}
+	}()
+
+	// spread replicas' restarts evenly over the run period
+	runPeriod, _, _ := opts.settings.get()
+	if in.run != nil {
+		runPeriod = *in.run
+	}
+	if in.index > 0 && runPeriod.s == "" {
+		delay := time.Duration(int64(runPeriod.d) * int64(in.index) / int64(in.replicas))
+		in.log.Printf("Staggering the first start by %s.", delay)
+		if err := sleepUntil(ctx, clk.Now().Add(delay)); err != nil {
+			return nil // ctx is canceled
+		}
+	}
+
+	for attempt := 1; ctx.Err() == nil && !opts.finishing(); attempt++ {
+		err := iterate(ctx, opts, in)
+		if len(in.args) == 0 {
+			// there is no program to start after the adopted process
+			return err
+		}
+		if errors.Is(err, errStartFailed) && in.startFailures < opts.startRetries {
+			in.startFailures++
+			delay := opts.startDelay << (in.startFailures - 1)
+			in.log.Printf("Start failed: %s; retry %d of %d in %s.", err, in.startFailures, opts.startRetries, delay)
+			if err := sleepUntil(ctx, clk.Now().Add(delay)); err != nil {
+				return nil // ctx is canceled
+			}
+			attempt-- // failed starts do not count as -retry attempts
+			continue
+		}
+		in.startFailures = 0
+
+		if opts.retry == 0 {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch {
+		case err == nil && ctx.Err() == nil:
+			in.log.Printf("Program succeeded on attempt %d.", attempt)
+			return nil
+		case err == nil:
+			return nil // ctx is canceled
+		case attempt >= opts.retry:
+			in.log.Printf("Program failed on the last attempt %d.", attempt)
+			return err
+		}
+
+		in.log.Printf("Attempt %d of %d failed: %s; retrying in %s.", attempt, opts.retry, err, opts.retryDelay)
+		if err := sleepUntil(ctx, clk.Now().Add(opts.retryDelay)); err != nil {
+			return nil // ctx is canceled
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// limit is a threshold for the program's resource usage; crossing it causes a graceful restart.
+type limit struct {
+	name   string
+	max    int
+	usage  func(pid int) (int, error)
+	format func(v int) string // of usage and max values; nil for plain numbers
+}
+
+// monitorLimits checks program's resource usage every interval, and requests a graceful restart
+// when any limit is crossed. It returns when ctx is canceled or a restart is requested.
+func monitorLimits(ctx context.Context, pid int, limits []limit, interval time.Duration, restart chan<- string) {
+	t := clk.NewTimer(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			t.Reset(interval)
+		}
+
+		for _, l := range limits {
+			v, err := l.usage(pid)
+			if err != nil {
+				continue // program is likely exiting
+			}
+			if v > l.max {
+				format := strconv.Itoa
+				if l.format != nil {
+					format = l.format
+				}
+				requestRestart(restart, fmt.Sprintf("%s %s exceeds limit %s", l.name, format(v), format(l.max)))
+				return
+			}
+		}
+	}
+}
+
+// procFDs returns the number of open file descriptors of the process.
+func procFDs(pid int) (int, error) {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// cpuTimeLimit returns -max-cpu-time limit. CPU time of program's children is counted after they exit.
+func cpuTimeLimit(budget time.Duration) limit {
+	return limit{
+		name: "CPU time",
+		max:  int(budget / time.Millisecond),
+		usage: func(pid int) (int, error) {
+			d, err := procStatCPUTime(pid, true)
+			return int(d / time.Millisecond), err
+		},
+		format: func(v int) string {
+			return (time.Duration(v) * time.Millisecond).String()
+		},
+	}
+}
+
+// procThreads returns the number of threads of the process.
+func procThreads(pid int) (int, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if v, ok := strings.CutPrefix(line, "Threads:"); ok {
+			return strconv.Atoi(strings.TrimSpace(v))
+		}
+	}
+	return 0, fmt.Errorf("no threads count for process %d", pid)
+}
+
+// initPIDsLimit checks whether -max-pids can be enforced with cgroups, and sets RLIMIT_NPROC if it can't.
+func initPIDsLimit(opts *options) {
+	if opts.systemdRun {
+		opts.pidsCgroups = true
+		return
+	}
+
+	c, err := newPIDsCgroup(fmt.Sprintf("ruc-%d-check", os.Getpid()), opts.maxPIDs)
+	if err == nil {
+		opts.pidsCgroups = true
+		_ = c.remove()
+		return
+	}
+
+	log.Printf("Can't create pids cgroup (%s), falling back to RLIMIT_NPROC that limits all processes of ruc's user.", err)
+	if os.Geteuid() == 0 {
+		log.Printf("RLIMIT_NPROC is not enforced for root.")
+	}
+	if err = limitNPROC(opts.maxPIDs); err != nil {
+		log.Printf("Failed to set RLIMIT_NPROC: %s", err)
+	}
+}
+
+// watchPIDsLimit checks program's cgroup every -limit-interval, and requests a graceful restart
+// when its forks fail because of -max-pids limit. find returns nil if the cgroup is not ready yet.
+// It returns when ctx is canceled or a restart is requested.
+func watchPIDsLimit(ctx context.Context, opts *options, in *instance, find func() *pidsCgroup) {
+	t := clk.NewTimer(opts.limitInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			t.Reset(opts.limitInterval)
+		}
+
+		c := find()
+		if c == nil {
+			continue
+		}
+
+		// cgroups are created for each run, so counters start from zero
+		if hits, err := c.limitHits(); err != nil || hits == 0 {
+			continue
+		}
+
+		in.log.Printf("Program hit -max-pids limit of %d.", opts.maxPIDs)
+		in.status.update(func(s *status) {
+			s.PIDsLimitHits++
+		})
+		requestRestart(in.restart, fmt.Sprintf("tasks limit %d reached", opts.maxPIDs))
+		return
+	}
+}
+
+// limitNPROC sets ruc's RLIMIT_NPROC (inherited by programs) to max, or to the hard limit if it is lower;
+// it limits the number of all processes of ruc's real user, and is not enforced for root.
+func limitNPROC(max int) error {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(rlimitNPROC, &rl); err != nil {
+		return err
+	}
+
+	hard := rl.Max
+	setRlim(&rl.Cur, max)
+	setRlim(&rl.Max, max)
+	if rl.Max > hard {
+		rl.Cur, rl.Max = hard, hard
+	}
+	return syscall.Setrlimit(rlimitNPROC, &rl)
+}
+
+// setRlim sets syscall.Rlimit field, which is int64 on some systems and uint64 on others.
+func setRlim[T int64 | uint64](f *T, v int) {
+	*f = T(v)
+}
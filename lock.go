@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// locker is an external lock acquired before each iteration.
+type locker interface {
+	// tryLock makes a single attempt to acquire the lock.
+	// It returns false (and nil error) if the lock is held by someone else.
+	// Returned channel is closed if the lock is lost.
+	tryLock(ctx context.Context) (bool, <-chan struct{}, error)
+
+	// unlock releases the lock.
+	unlock()
+}
+
+// acquireLock calls l.tryLock every interval until the lock is acquired or ctx is canceled.
+// Returned context is canceled when the lock is lost; it should be canceled by the caller
+// before calling l.unlock.
+func acquireLock(ctx context.Context, l locker, interval time.Duration) (context.Context, context.CancelFunc, error) {
+	t := clk.NewTimer(interval)
+	defer t.Stop()
+
+	for waiting := false; ; waiting = true {
+		ok, lost, err := l.tryLock(ctx)
+		if err != nil {
+			log.Printf("Failed to acquire lock: %s", err)
+		}
+		if ok {
+			log.Printf("Lock acquired.")
+			lockCtx, cancel := context.WithCancel(ctx)
+			go func() {
+				select {
+				case <-lost:
+					log.Printf("Lock lost.")
+					cancel()
+				case <-lockCtx.Done():
+				}
+			}()
+			return lockCtx, cancel, nil
+		}
+
+		if !waiting && err == nil {
+			log.Printf("Lock is held by someone else, waiting...")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-t.C():
+			t.Reset(interval)
+		}
+	}
+}
+
+// lockOwner returns a value identifying this process for lock owners inspection.
+func lockOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// flockLocker is a locker using flock(2) on a local (or shared) file.
+type flockLocker struct {
+	path string
+	f    *os.File
+}
+
+func (l *flockLocker) tryLock(ctx context.Context) (bool, <-chan struct{}, error) {
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return false, nil, nil
+		}
+		return false, nil, err
+	}
+
+	// that's a purely informational content
+	_ = f.Truncate(0)
+	_, _ = f.WriteAt([]byte(lockOwner()+"\n"), 0)
+
+	l.f = f
+	return true, nil, nil // flock can't be lost
+}
+
+func (l *flockLocker) unlock() {
+	if err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN); err != nil {
+		log.Printf("Failed to unlock %s: %s", l.path, err)
+	}
+	l.f.Close()
+	l.f = nil
+}
+
+// postJSON sends HTTP request with JSON-encoded in body, and decodes JSON response into out (if it is not nil).
+func postJSON(ctx context.Context, method, u string, header http.Header, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &httpStatusError{code: resp.StatusCode, body: strings.TrimSpace(string(b))}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// httpStatusError is returned by postJSON for non-200 responses.
+type httpStatusError struct {
+	code int
+	body string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s: %s", e.code, http.StatusText(e.code), e.body)
+}
+
+// keepAlive calls f every period until it fails or stop is closed.
+// Returned channel is closed when f fails.
+func keepAlive(period time.Duration, stop <-chan struct{}, f func(ctx context.Context) error) <-chan struct{} {
+	lost := make(chan struct{})
+	go func() {
+		t := time.NewTicker(period)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), period)
+			err := f(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Failed to keep lock alive: %s", err)
+				close(lost)
+				return
+			}
+		}
+	}()
+	return lost
+}
+
+// etcdLocker is a locker using etcd v3 JSON gRPC gateway.
+type etcdLocker struct {
+	endpoint string
+	key      string
+	ttl      time.Duration
+
+	lease string
+	stop  chan struct{}
+}
+
+func (l *etcdLocker) tryLock(ctx context.Context) (bool, <-chan struct{}, error) {
+	var grant struct {
+		ID string `json:"ID"`
+	}
+	in := map[string]any{"TTL": int64((l.ttl + time.Second - 1) / time.Second)} // rounded up
+	if err := postJSON(ctx, "POST", l.endpoint+"/v3/lease/grant", nil, in, &grant); err != nil {
+		return false, nil, err
+	}
+
+	key := base64.StdEncoding.EncodeToString([]byte(l.key))
+	value := base64.StdEncoding.EncodeToString([]byte(lockOwner()))
+	var txn struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	in = map[string]any{
+		"compare": []any{map[string]any{"key": key, "target": "CREATE", "create_revision": "0"}},
+		"success": []any{map[string]any{"request_put": map[string]any{"key": key, "value": value, "lease": grant.ID}}},
+	}
+	if err := postJSON(ctx, "POST", l.endpoint+"/v3/kv/txn", nil, in, &txn); err != nil || !txn.Succeeded {
+		l.revoke(grant.ID)
+		return false, nil, err
+	}
+
+	l.lease = grant.ID
+	l.stop = make(chan struct{})
+	lost := keepAlive(l.ttl/3, l.stop, func(ctx context.Context) error {
+		var res struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+		in := map[string]any{"ID": l.lease}
+		if err := postJSON(ctx, "POST", l.endpoint+"/v3/lease/keepalive", nil, in, &res); err != nil {
+			return err
+		}
+		if ttl, _ := strconv.Atoi(res.Result.TTL); ttl <= 0 {
+			return fmt.Errorf("lease %s expired", l.lease)
+		}
+		return nil
+	})
+	return true, lost, nil
+}
+
+func (l *etcdLocker) revoke(lease string) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl)
+	defer cancel()
+
+	if err := postJSON(ctx, "POST", l.endpoint+"/v3/lease/revoke", nil, map[string]any{"ID": lease}, nil); err != nil {
+		log.Printf("Failed to revoke etcd lease %s: %s", lease, err)
+	}
+}
+
+func (l *etcdLocker) unlock() {
+	close(l.stop)
+	l.revoke(l.lease) // that also deletes the key
+	l.lease = ""
+}
+
+// consulLocker is a locker using Consul sessions and KV store.
+type consulLocker struct {
+	addr string
+	key  string
+	ttl  time.Duration
+
+	session string
+	stop    chan struct{}
+}
+
+// consulHeader returns Consul HTTP API request headers.
+func consulHeader() http.Header {
+	h := make(http.Header)
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		h.Set("X-Consul-Token", token)
+	}
+	return h
+}
+
+func (l *consulLocker) tryLock(ctx context.Context) (bool, <-chan struct{}, error) {
+	var session struct {
+		ID string `json:"ID"`
+	}
+	in := map[string]any{
+		"Name":      "ruc " + lockOwner(),
+		"TTL":       l.ttl.String(),
+		"Behavior":  "release",
+		"LockDelay": "0s",
+	}
+	if err := postJSON(ctx, "PUT", l.addr+"/v1/session/create", consulHeader(), in, &session); err != nil {
+		return false, nil, err
+	}
+
+	var acquired bool
+	u := l.addr + "/v1/kv/" + strings.TrimPrefix(l.key, "/") + "?acquire=" + url.QueryEscape(session.ID)
+	if err := postJSON(ctx, "PUT", u, consulHeader(), lockOwner(), &acquired); err != nil || !acquired {
+		l.destroy(session.ID)
+		return false, nil, err
+	}
+
+	l.session = session.ID
+	l.stop = make(chan struct{})
+	lost := keepAlive(l.ttl/3, l.stop, func(ctx context.Context) error {
+		return postJSON(ctx, "PUT", l.addr+"/v1/session/renew/"+l.session, consulHeader(), nil, nil)
+	})
+	return true, lost, nil
+}
+
+func (l *consulLocker) destroy(session string) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl)
+	defer cancel()
+
+	if err := postJSON(ctx, "PUT", l.addr+"/v1/session/destroy/"+session, consulHeader(), nil, nil); err != nil {
+		log.Printf("Failed to destroy Consul session %s: %s", session, err)
+	}
+}
+
+func (l *consulLocker) unlock() {
+	close(l.stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl)
+	defer cancel()
+
+	u := l.addr + "/v1/kv/" + strings.TrimPrefix(l.key, "/") + "?release=" + url.QueryEscape(l.session)
+	if err := postJSON(ctx, "PUT", u, consulHeader(), nil, nil); err != nil {
+		log.Printf("Failed to release Consul lock: %s", err)
+	}
+
+	l.destroy(l.session)
+	l.session = ""
+}
@@ -2,105 +2,1367 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
-func run(ctx context.Context, run, grace time.Duration, args []string) error {
-	runT := time.NewTicker(run)
-	defer runT.Stop()
+// options holds command-line flags values.
+type options struct {
+	settings         settings
+	readyProbe       probe
+	readyInterval    time.Duration
+	notifyFD         int // -notification-fd
+	schedule         schedule
+	catchUp          string
+	catchUpFile      string
+	minInterval      time.Duration
+	chaos            bool
+	restartOnOOM     bool
+	retry            int // attempts until success; 0 disables retry mode
+	retryDelay       time.Duration
+	startRetries     int
+	startTimeout     time.Duration
+	startDelay       time.Duration // before the first start retry; doubled for the next ones
+	limits           []limit
+	limitInterval    time.Duration
+	usageInterval    time.Duration // -resources-interval
+	notifier         notifier
+	maxPIDs          int
+	pidsCgroups      bool // -max-pids is enforced with cgroups (not RLIMIT_NPROC)
+	maxEgress        int64
+	egressIface      string
+	drainURL         string
+	drainTimeout     time.Duration
+	busyLock         string
+	maxDefer         time.Duration
+	prestart         time.Duration
+	unreadyBefore    time.Duration
+	prepareNext      bool
+	countdown        []time.Duration // descending
+	graceProgress    time.Duration
+	adaptiveGrace    bool
+	adaptiveGraceMin time.Duration
+	adaptiveGraceMax time.Duration
+	pauseStopped     bool
+	runCmd           string        // computes the run period of each run
+	outputEvery      time.Duration // -expect-output-every
+	resolveEach      bool          // -resolve-each-run
+	expectSHA256     []byte        // of the program executable, nil if not pinned
+	binaryChange     bool          // -restart-on-binary-change
+	watchInterval    time.Duration
+	prestartGate     string
+	restartMode      string
+	killMode         string
+	noSetpgid        bool
+	foregroundTTY    bool
+	stdinPipe        bool
+	heartbeat        string // -stdin-heartbeat line, empty if none
+	stdinInterval    time.Duration
+	stdinLines       chan string // lines for the program's stdin sent with `ruc send`
+	mounts           bindMountList
+	privateNet       bool           // -private-network
+	publish          publishedPorts // in the program's network namespace
+	seccomp          string
+	caps             *uint64 // capabilities to keep, nil to keep all
+	noNewPrivs       bool
+	intervalMode     string
+	restart          chan string   // graceful restart requests with reasons
+	finish           chan struct{} // closed to finish the current iterations without restarting
+	recycling        chan struct{} // slots for replicas being recycled, nil if unlimited
+	fleetSlots       *fleetSlots   // nil if not used
+	services         services
+	portBase         int
+	portStep         int
+	suspend          string
+	suspended        chan time.Duration // system suspension periods
+	clockJumped      chan time.Duration // wall clock jumps, with -clock-jump=reanchor
+	backoff          backoff
+	lock             locker
+	registrar        registrar
+	lockInterval     time.Duration
 
-	// start program in a separate process group to prevent automatic signals propagation
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-	}
-	if err := cmd.Start(); err != nil {
-		return err
-	}
+	pidFile   string
+	drainFile string
 
-	// receive program exit status asynchronously
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
+	dedupe *flockLocker // held while ruc runs, nil without -dedupe
 
-	// wait for ctx to be canceled, program to exit, or for runT to tick
-	select {
-	case <-ctx.Done():
-		// nothing
-	case err := <-done:
-		return err
-	case <-runT.C:
-		// nothing
-	}
+	cleanupCmd     string
+	cleanupTimeout time.Duration
 
-	// ask program to exit
-	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
-		log.Printf("Failed to send SIGTERM: %s", err)
-	}
+	beforeStopCmd     string
+	beforeStopTimeout time.Duration
+
+	paceFeedback string // URL or cmd:command
+	paceDelay    time.Duration
+	paceMax      time.Duration
 
-	// wait for program to exit, or for graceT to tick; ignore ctx even if it is already canceled
-	graceT := time.NewTicker(grace)
-	defer graceT.Stop()
+	hostConds     []*hostCondition // -defer-recycle-when
+	deferInterval time.Duration
+	deferMax      time.Duration
+
+	forkRetries int
+	forkFree    bool // -fork-retry-free
+
+	daemonDetect  time.Duration
+	daemonPIDFile string
+	adoptPID      int
+	adoptPIDFile  string
+
+	trigger  *externalTrigger // starts iterations instead of the run period timer, nil if not set
+	triggers chan string      // fired triggers with descriptions
+
+	altDirs        []string
+	altChdir       bool
+	promoteCmd     string
+	promoteTimeout time.Duration
+
+	forensicsDir     string
+	forensicsGcore   bool
+	forensicsTimeout time.Duration
+	coreDir          string
+	coreRetention    int64
+
+	seed        int64 // fixed seed for all runs; 0 means random
+	audit       bool
+	envDiff     bool // -log-env-diff
+	historyFile string
+	resultFile  string
+	digest      bool // -output-digest
+	crashReport string
+	auditFile   string
+	argv0       string
+	path        string
+	programDir  string
+	sanitizeEnv bool
+	tmpDir      bool
+	record      string // directory for output recordings
+	keepFailed  bool
+	env         envVars
+	tail        *tailBuffer
+	logs        *tailBuffer // ruc's recent logs for bundles
+	bundleDir   string
+	bundleAfter int // consecutive failures
+	stopper     *stopper
+	fds         fdStore
+	passFDs     passFDs
+	secrets     secrets
+	output      output
+
+	systemdRun   bool
+	systemdUser  bool
+	systemdSlice string
+
+	terminationGracePeriod time.Duration
+	terminationGraceMargin time.Duration
+}
+
+// finishing returns true if current iterations should be the last ones.
+func (o *options) finishing() bool {
 	select {
-	case err := <-done:
-		return err
-	case <-graceT.C:
-		// nothing
+	case <-o.finish:
+		return true
+	default:
+		return false
 	}
+}
 
-	// kill program
-	if err := cmd.Process.Signal(syscall.SIGKILL); err != nil {
-		log.Printf("Failed to send SIGKILL: %s", err)
+func main() {
+	if tc := os.Getenv(trampolineEnv); tc != "" {
+		trampoline(tc, os.Args[1:])
 	}
 
-	// wait for program to exit
-	return <-done
-}
+	// `ruc up` is the main mode with programs from Procfile
+	var up bool
 
-func main() {
-	runF := flag.Duration("run", time.Minute, "Period between starting a program and sending it SIGTERM")
-	graceF := flag.Duration("grace", 10*time.Second, "Period between sending a program SIGTERM and SIGKILL")
+	// `ruc gen <kind>` is the main mode that prints a service definition instead of running programs
+	var gen string
+	var schema bool // `ruc gen schema` prints JSON Schema of -config files
+
+	// `ruc check` is the main mode that validates flags and programs, and exits
+	var check bool
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "version", "-version", "--version":
+			printVersion(os.Args[2:])
+			return
+		case "health":
+			health(os.Args[2:])
+			return
+		case "set":
+			set(os.Args[2:])
+			return
+		case "timeout":
+			timeout(os.Args[2:])
+			return
+		case "history":
+			history(os.Args[2:])
+			return
+		case "status":
+			statusCommand(os.Args[2:])
+			return
+		case "tail":
+			tail(os.Args[2:])
+			return
+		case "attach":
+			attach(os.Args[2:])
+			return
+		case "stop":
+			stop(os.Args[2:])
+			return
+		case "restart":
+			restart(os.Args[2:])
+			return
+		case "send":
+			send(os.Args[2:])
+			return
+		case "bundle":
+			bundle(os.Args[2:])
+			return
+		case "events":
+			eventsCommand(os.Args[2:])
+			return
+		case "signals":
+			signalsCommand(os.Args[2:])
+			return
+		case "replay":
+			replay(os.Args[2:])
+			return
+		case "flake":
+			flake(os.Args[2:])
+			return
+		case "check":
+			check = true
+			os.Args = append(os.Args[:1:1], os.Args[2:]...)
+		case "up":
+			up = true
+			os.Args = append(os.Args[:1:1], os.Args[2:]...)
+		case "gen":
+			if len(os.Args) == 3 && os.Args[2] == "schema" {
+				schema = true
+				break
+			}
+			if len(os.Args) < 3 || genKinds[os.Args[2]] == nil {
+				fmt.Fprintf(os.Stderr, "Usage: %s gen systemd|launchd [flags] [--] [program] [program arguments]\n", os.Args[0])
+				fmt.Fprintf(os.Stderr, "       %s gen schema\n", os.Args[0])
+				os.Exit(2)
+			}
+			gen = os.Args[2]
+			os.Args = append(os.Args[:1:1], os.Args[3:]...)
+		}
+	}
+
+	var opts options
+	opts.settings.run = period{d: time.Minute}
+	flag.Var(&opts.settings.run, "run", "Period between starting a program (or it becoming ready) and sending it SIGTERM, or a schedule (@hourly, @daily, @weekly, @monthly, @yearly) to send it at calendar boundaries")
+	flag.StringVar(&opts.runCmd, "run-cmd", "", "Compute the run period of each run with that command (run with /bin/sh -c, with -run period in $"+runCmdEnv+") printing a duration or a schedule, e.g. to use shorter periods at night; -run is used if it fails")
+	durationVar(&opts.settings.grace, "grace", 10*time.Second, "Period between sending a program SIGTERM and SIGKILL")
+	flag.BoolVar(&opts.adaptiveGrace, "adaptive-grace", false, "Tune the grace period from the program's recent shutdown times recorded in -history-file (their 95th percentile with 50% headroom), within -adaptive-grace-min and -adaptive-grace-max; -grace is used until there are enough records")
+	durationVar(&opts.adaptiveGraceMin, "adaptive-grace-min", time.Second, "Minimal -adaptive-grace period")
+	durationVar(&opts.adaptiveGraceMax, "adaptive-grace-max", 5*time.Minute, "Maximal -adaptive-grace period")
+	durationVar(&opts.graceProgress, "grace-progress", 5*time.Second, "Log that often that ruc is still waiting for the program to exit after SIGTERM, and how much of the grace period is left; 0 disables that")
+	flag.Func("ready-probe", "Readiness probe: tcp://host:port, http(s)://host/path, file:///path, or cmd:command", func(s string) error {
+		p, err := parseProbe(s)
+		opts.readyProbe = p
+		return err
+	})
+	durationVar(&opts.readyInterval, "ready-interval", time.Second, "Period between readiness probe checks")
+	flag.IntVar(&opts.notifyFD, "notification-fd", 0, "Pass program the write end of a pipe as that file descriptor (3 or more), and consider it ready when it writes a newline there, as s6's notification-fd; can't be used with -ready-probe")
+	lockFlockF := flag.String("lock-flock", "", "Acquire flock(2) lock on that file before each iteration")
+	lockEtcdF := flag.String("lock-etcd", "", "Acquire etcd lock with that key before each iteration")
+	lockEtcdEndpointF := flag.String("lock-etcd-endpoint", "http://127.0.0.1:2379", "etcd v3 JSON gateway endpoint")
+	lockConsulF := flag.String("lock-consul", "", "Acquire Consul lock with that KV key before each iteration")
+	lockConsulAddrF := flag.String("lock-consul-addr", "http://127.0.0.1:8500", "Consul HTTP API address (for both -lock-consul and -register-consul)")
+	recycleSlotsEtcdF := flag.String("recycle-slots-etcd", "", "Before the periodic restart, acquire one of -recycle-slots etcd locks with keys <that prefix>/<slot number>, and hold it until the next program instance is ready, for rolling restarts of a fleet")
+	recycleSlotsConsulF := flag.String("recycle-slots-consul", "", "Before the periodic restart, acquire one of -recycle-slots Consul locks with KV keys <that prefix>/<slot number>, and hold it until the next program instance is ready, for rolling restarts of a fleet")
+	recycleSlotsF := flag.Int("recycle-slots", 1, "The maximal number of ruc instances fleet-wide recycling their programs at the same time, with -recycle-slots-etcd or -recycle-slots-consul")
+	lockTTLF := durationFlag("lock-ttl", 15*time.Second, "TTL of etcd lease or Consul session; lock is lost if it is not renewed in time")
+	registerFileF := flag.String("register-file", "", "Create that file while the program is running and ready, and remove it before stopping the program")
+	registerConsulF := flag.String("register-consul", "", "Register program in Consul as a service with that name while it is running and ready, and deregister it before stopping the program")
+	registerConsulAddressF := flag.String("register-consul-address", "", "Address of the -register-consul service; defaults to the Consul agent's address")
+	registerConsulPortF := flag.Int("register-consul-port", 0, "Port of the -register-consul service")
+	dedupeF := flag.Bool("dedupe", false, "Exit if another ruc instance on this host runs the same job (the same programs' command lines in the same working directory, or the same -dedupe-key), even if started from a different terminal or unit")
+	dedupeKeyF := flag.String("dedupe-key", "", "Use that key for -dedupe instead of a hash of the command line (implies -dedupe)")
+	dedupeDirF := flag.String("dedupe-dir", defaultDedupeDir(), "Directory of -dedupe locks shared by ruc instances; defaults to $"+dedupeDirEnv+" or ruc-dedupe in the temporary directory")
+	durationVar(&opts.lockInterval, "lock-interval", 5*time.Second, "Period between lock acquisition attempts")
+	durationVar(&opts.terminationGracePeriod, "termination-grace-period", 0, "Period after ruc's SIGTERM before it is killed (e.g. pod's terminationGracePeriodSeconds); defaults to $"+terminationGracePeriodEnv)
+	flag.Func("pass-fd", "File descriptor N[:name] inherited by ruc (e.g. a pipe from the parent) and passed to each program instance with the same number, listed in $"+passFDsEnv+"; may be repeated", opts.passFDs.add)
+	var inheritFDsF []int
+	flag.Func("inherit-fds", "Leave these comma-separated file descriptors inherited by ruc open for program as is (others except stdio are closed for it); they may be replaced by ones passed by ruc itself, prefer -pass-fd with fixed numbers; may be repeated", func(s string) error {
+		for _, n := range strings.Split(s, ",") {
+			fd, err := strconv.Atoi(strings.TrimSpace(n))
+			if err != nil || fd < listenFDsStart {
+				return fmt.Errorf("invalid file descriptor %q, expected %d or more", n, listenFDsStart)
+			}
+			inheritFDsF = append(inheritFDsF, fd)
+		}
+		return nil
+	})
+	flag.Func("fd", "File descriptor passed to each program instance with socket activation protocol: [name=]tcp:host:port, [name=]udp:host:port, [name=]unix:/path, [name=]file:/path, or [name=]fifo:/path; may be repeated", opts.fds.add)
+	flag.StringVar(&opts.path, "path", "", "Search program in that colon-separated list of directories instead of ruc's $PATH")
+	flag.Func("expect-sha256", "Refuse to start program if SHA-256 hash (in hex) of its executable does not match that one, checked before each start", func(s string) error {
+		b, err := hex.DecodeString(s)
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid SHA-256 hash %q", s)
+		}
+		opts.expectSHA256 = b
+		return nil
+	})
+	flag.BoolVar(&opts.resolveEach, "resolve-each-run", false, "Follow symlinks of the program executable at each start, and run the target (keeping argv[0]), so a run is not affected by a symlink switched while it starts; the executable is searched in -path at each start anyway")
+	flag.StringVar(&opts.programDir, "program-dir", "", "Resolve relative program path and -path entries against that directory instead of ruc's current one")
+	flag.StringVar(&opts.historyFile, "history-file", os.Getenv(historyFileEnv), "Append a record about each completed run to that journal (for `ruc history`); defaults to $"+historyFileEnv)
+	flag.StringVar(&opts.resultFile, "result-file", "", "Atomically replace that JSON file with the record about each completed run (the same as in -history-file), for tools tracking ruc")
+	flag.BoolVar(&opts.digest, "output-digest", false, "Record the byte count, line count, and SHA256 of each run's stdout in -history-file and -result-file records, so consumers can detect truncated or empty outputs")
+	flag.StringVar(&opts.crashReport, "crash-report", "", "Write a JSON crash report with stacks and the current status to that file if ruc itself panics (including the forced exit)")
+	flag.BoolVar(&opts.envDiff, "log-env-diff", false, "Log which program's environment variables were added, removed, or changed since the previous run (e.g. by -secret-env), with values masked as by -audit")
+	eventRingF := flag.Int("event-ring", 256, "Keep that many recent ruc's own events (starts, signals sent, escalations, and exits) in memory, printed to stderr on SIGUSR1 and by the events subcommand; 0 disables that")
+	flag.BoolVar(&opts.audit, "audit", false, "Log program's argv, executable path, environment, user, groups, and limits at each start, with secrets masked")
+	flag.StringVar(&opts.auditFile, "audit-file", "", "Append -audit records to that file as JSON lines instead of logging them (implies -audit)")
+	flag.Int64Var(&opts.seed, "seed", 0, "Pass that seed to each run as $"+seedEnv+" instead of a random one, to reproduce a randomized run")
+	flag.StringVar(&opts.argv0, "argv0", "", "Pass that as program's argv[0] instead of the program name (e.g. for multi-call binaries)")
+	flag.BoolVar(&opts.sanitizeEnv, "sanitize-env", false, "Set program's baseline environment: "+strings.Join(sanitizedEnv, ", ")+", and no other LC_* variables")
+	flag.BoolVar(&opts.tmpDir, "tmpdir", false, "Create a fresh temporary directory for each run, pass it to program as $TMPDIR, and remove it after program exits")
+	flag.StringVar(&opts.record, "record", "", "Record each run's output with timestamps into <run ID>.jsonl file in that directory, for `ruc replay`")
+	flag.BoolVar(&opts.keepFailed, "keep-failed", false, "Do not remove -tmpdir directory of a failed run")
+	flag.Func("env", "Set program's environment variable NAME=value, overriding inherited and -sanitize-env ones; may be repeated", opts.env.add)
+	flag.Func("secret-env", "Set program's environment variable to a secret resolved before each start: NAME=file:/path or NAME=vault:path/field; may be repeated", opts.secrets.add)
+	var outputFIFOsF []string
+	flag.Func("output-fifo", "Create named pipe and copy program's output into it across restarts; output is dropped (not blocking the program) while the pipe is full because there is no reader or it is too slow; may be repeated", func(s string) error {
+		outputFIFOsF = append(outputFIFOsF, s)
+		return nil
+	})
+	flag.Func("stdout", "Destination of program's stdout: inherit (ruc's stdout), discard, file:/path, or syslog[:tag]; default inherit", func(s string) error {
+		return opts.output.setDestination(false, s)
+	})
+	flag.Func("stderr", "Destination of program's stderr: inherit (ruc's stderr), discard, file:/path, or syslog[:tag]; default inherit", func(s string) error {
+		return opts.output.setDestination(true, s)
+	})
+	flag.BoolVar(&opts.output.merge, "merge-output", false, "Copy program's stdout and stderr into -output-file, -output-fifo, and -tail-buffer line by line, prefixing lines with \"stdout: \" or \"stderr: \"")
+	var tailBufferF byteSize
+	flag.Var(&tailBufferF, "tail-buffer", "Keep that much of program's recent output (e.g. 1M) in memory, and stream it with live output to `ruc tail` via -control-socket; 0 disables buffering")
+	outputFileF := flag.String("output-file", "", "Append program's output to that file")
+	var outputMaxSizeF, outputRetentionF byteSize
+	flag.Var(&outputMaxSizeF, "output-max-size", "Rotate -output-file when it reaches that size (e.g. 100M); 0 disables rotation")
+	outputCompressF := flag.Bool("output-compress", false, "Compress rotated -output-file files with gzip")
+	flag.Var(&outputRetentionF, "output-retention", "Remove the oldest rotated -output-file files when their total size exceeds that (e.g. 1G); 0 means no limit")
+	flag.Func("output-filter", "Only pass program's output lines matching that regular expression, or, if prefixed with !, not matching it; may be repeated", opts.output.addFilter)
+	durationVar(&opts.outputEvery, "expect-output-every", 0, "Report program as degraded (in status, HTTP /healthz, and degraded events to notifiers) while it does not write any output for that long, without restarting it; 0 disables")
+	stderrAlertsF := flag.Bool("stderr-alerts", false, "Log program's stderr lines looking like errors (ERROR, level=error) and fatal errors (FATAL, PANIC, CRITICAL, Go panics) in ruc's log, and send them as error and fatal events to notifiers")
+	flag.Func("stderr-alert", "Handle program's stderr lines matching regular expression like -stderr-alerts: error=regexp or fatal=regexp; checked in order before built-in -stderr-alerts patterns; may be repeated", opts.output.addAlert)
+	flag.Func("restart-on-output", "Gracefully restart program as soon as its output matches that regular expression", func(s string) error {
+		var err error
+		opts.output.restartOn, err = regexp.Compile(s)
+		return err
+	})
+	flag.Func("kill-on-output", "Kill program and fail as soon as its output matches that regular expression", func(s string) error {
+		var err error
+		opts.output.killOn, err = regexp.Compile(s)
+		return err
+	})
+	flag.BoolVar(&opts.output.parseStatus, "parse-status", false, "Parse program's health status from \""+statusLinePrefix+"...\" lines on its stdout")
+	flag.Func("recycle-on-status", "Gracefully restart program when it reports one of those comma-separated statuses (implies -parse-status)", func(s string) error {
+		opts.output.parseStatus = true
+		opts.output.recycleOn = make(map[string]bool)
+		for _, st := range strings.Split(s, ",") {
+			if st = strings.TrimSpace(st); st != "" {
+				opts.output.recycleOn[st] = true
+			}
+		}
+		return nil
+	})
+	flag.Func("directives", "Obey those comma-separated \""+directivePrefix+"...\" lines on program's stdout: EXTEND=duration (extend the current run period), RESTART (gracefully restart), READY (start the run period only after it; can't be used with -ready-probe or -notification-fd)", func(s string) error {
+		var err error
+		opts.output.directives, err = parseDirectives(s)
+		return err
+	})
+	flag.Func("redact", "Mask secrets matching that regular expression (or its groups) in program's output and ruc's logs; may be repeated", opts.output.redact.addPattern)
+	flag.Func("redact-env", "Mask the value of that environment variable in program's output and ruc's logs; may be repeated", opts.output.redact.addEnv)
+	flag.Func("schedule", "Start program only at calendar boundaries (@hourly, @daily, @weekly, @monthly, @yearly), like cron", func(s string) error {
+		sch, err := parseSchedule(s)
+		opts.schedule = sch
+		return err
+	})
+	initialDelayF := durationFlag("initial-delay", 0, "Wait that long before the first start, for example to let other services settle after boot")
+	startAtF := flag.String("start-at", "", "Wait until that time before the first start: HH:MM in -timezone (today, or tomorrow if it already passed), or RFC 3339 timestamp")
+	flag.Func("timezone", "Time zone of -schedule and -run calendar boundaries and of -start-at (e.g. Europe/Berlin), with DST transitions taken into account; defaults to the local one", func(s string) error {
+		loc, err := time.LoadLocation(s)
+		if err != nil {
+			return err
+		}
+		scheduleLocation = loc
+		return nil
+	})
+	opts.catchUp = catchUpSkip
+	flag.Func("catch-up", "What to do with -schedule boundaries missed while ruc was down (see -catch-up-file), the host was suspended, or the previous run overran, like anacron: skip (wait for the next one), run-once, or run-all (once per missed boundary); default skip", func(s string) error {
+		switch s {
+		case catchUpSkip, catchUpRunOnce, catchUpRunAll:
+			opts.catchUp = s
+			return nil
+		default:
+			return fmt.Errorf("unknown catch-up policy %q", s)
+		}
+	})
+	flag.StringVar(&opts.catchUpFile, "catch-up-file", "", "Keep the last -schedule boundary the program was started for in that file, so -catch-up works across ruc restarts")
+	durationVar(&opts.minInterval, "min-interval", 0, "Minimal period between consecutive program starts")
+	flag.StringVar(&opts.cleanupCmd, "cleanup-cmd", "", "Run that command with /bin/sh -c after each program exit (including SIGKILL), to release external resources; $"+runIDEnv+", $"+programPIDEnv+", $"+exitResultEnv+", and $"+exitCodeEnv+" describe the run")
+	durationVar(&opts.cleanupTimeout, "cleanup-timeout", time.Minute, "Kill -cleanup-cmd process group that runs longer than that")
+	flag.StringVar(&opts.beforeStopCmd, "before-stop-cmd", "", "On timed restarts (when the run period ends, not on crashes or ruc's shutdown), run that command with /bin/sh -c right before sending SIGTERM (e.g. to trigger program's checkpoint); $"+runIDEnv+" and $"+programPIDEnv+" describe the run; its time is deducted from the grace period")
+	durationVar(&opts.beforeStopTimeout, "before-stop-timeout", 30*time.Second, "Kill -before-stop-cmd process group that runs longer than that")
+	flag.Func("pace-feedback", "Poll downstream overload feedback before restarts: URL (GET; 429 and 503 responses mean overload, for Retry-After seconds if given) or cmd:command (run with /bin/sh -c; empty or ok output means no overload, a duration or other output means overload); while downstream is overloaded, the run period is extended and the next start is delayed", func(s string) error {
+		if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") && !strings.HasPrefix(s, "cmd:") {
+			return fmt.Errorf("invalid pace feedback %q, expected URL or cmd:command", s)
+		}
+		opts.paceFeedback = s
+		return nil
+	})
+	durationVar(&opts.paceDelay, "pace-delay", time.Minute, "Delay restarts by that much when -pace-feedback reports overload without a duration")
+	durationVar(&opts.paceMax, "pace-max-delay", time.Hour, "Maximal total delay of a single restart caused by -pace-feedback")
+	flag.Func("trigger", "Lock-step mode: start each iteration (stopping the running program first) only when that external trigger fires, instead of when the run period ends: fifo:path (a line written to that named pipe), http (POST /trigger with -control-token-file token on -http listener), or signal[:name] (SIGUSR2 by default)", func(s string) error {
+		t, err := parseTrigger(s)
+		opts.trigger = t
+		return err
+	})
+	flag.Func("alternate-dir", "Alternate program's data directories on successive runs, blue/green style: each run gets the next one of them in $"+dataDirEnv+"; may be repeated", func(s string) error {
+		opts.altDirs = append(opts.altDirs, s)
+		return nil
+	})
+	flag.BoolVar(&opts.altChdir, "alternate-chdir", false, "Also run program in its -alternate-dir directory")
+	flag.StringVar(&opts.promoteCmd, "promote-cmd", "", "Before each start with -alternate-dir, run that command with /bin/sh -c to sync or promote data into the next directory ($"+dataDirEnv+") from the previous one ($"+previousDataDirEnv+", empty before the first run); the run fails if it fails")
+	durationVar(&opts.promoteTimeout, "promote-timeout", 10*time.Minute, "Kill -promote-cmd process group that runs longer than that")
+	flag.StringVar(&opts.drainURL, "drain-url", "", "Before sending SIGTERM, POST to that program's URL (e.g. http://127.0.0.1:8080/drain) and wait for the response")
+	durationVar(&opts.drainTimeout, "drain-timeout", 30*time.Second, "Maximum time to wait for -drain-url response")
+	flag.Func("countdown", "Log the time left until the program is restarted at those comma-separated checkpoints before the run period ends (e.g. 5m,1m,10s)", func(s string) error {
+		var ds []time.Duration
+		for _, f := range strings.Split(s, ",") {
+			d, err := parseDuration(strings.TrimSpace(f))
+			if err != nil {
+				return err
+			}
+			if d <= 0 {
+				return fmt.Errorf("checkpoint must be positive: %q", f)
+			}
+			ds = append(ds, d)
+		}
+		slices.Sort(ds)
+		slices.Reverse(ds)
+		opts.countdown = slices.Compact(ds)
+		return nil
+	})
+	flag.StringVar(&opts.busyLock, "busy-lock", "", "Before stopping the program, wait until it releases flock(2) on that file it holds during critical sections; ruc then holds the lock until the program exits")
+	durationVar(&opts.maxDefer, "max-defer", 5*time.Minute, "Maximum time to wait for -busy-lock release before stopping the program anyway")
+	opts.restartMode = restartModeSequential
+	flag.Func("restart-mode", "How program is recycled: sequential (stop it, then start the next instance), or overlap (start the next instance, wait for it to pass -ready-probe or -notification-fd, then stop the current one; for SO_REUSEPORT servers); default sequential", func(s string) error {
+		switch s {
+		case restartModeSequential, restartModeOverlap:
+			opts.restartMode = s
+			return nil
+		default:
+			return fmt.Errorf("unknown restart mode %q", s)
+		}
+	})
+	durationVar(&opts.unreadyBefore, "unready-before", 0, "Stop reporting program as ready (HTTP /ready, -register-file, -register-consul) that long before the run period ends, so load balancers stop sending it traffic before it is stopped; 0 reports it until the stop")
+	durationVar(&opts.prestart, "prestart", 0, "Start the next program instance that long before the current one is stopped, and hold it at a gate until the current one exits; 0 disables prestart")
+	flag.BoolVar(&opts.prepareNext, "prepare-next", false, "Prepare the next start while program runs: look up its executable, build its environment (resolving -secret-env), and create -max-pids cgroup, so the next program starts right after the current one exits")
+	flag.Func("prestart-gate", "Where -prestart instance is held: exec (before executing the program), or fd (program waits for a byte on file descriptor $"+gateFDEnv+" itself after initialization)", func(s string) error {
+		switch s {
+		case "exec", "fd":
+			opts.prestartGate = s
+			return nil
+		default:
+			return fmt.Errorf("unknown gate %q", s)
+		}
+	})
+	opts.intervalMode = intervalModeDelay
+	flag.Func("interval-mode", "How -run periods of consecutive iterations are scheduled: delay (each period starts with the program, so shutdown stretches the cycle), or rate (a period every -run from the first start regardless of shutdown time, skipping overrun ones); default delay", func(s string) error {
+		switch s {
+		case intervalModeDelay, intervalModeRate:
+			opts.intervalMode = s
+			return nil
+		default:
+			return fmt.Errorf("unknown interval mode %q", s)
+		}
+	})
+	opts.suspend = suspendExclude
+	opts.killMode = killModeProcess
+	flag.Func("kill-mode", "Which processes are signaled, as systemd's KillMode=: process (SIGTERM and SIGKILL to the program only), mixed (SIGTERM to the program, SIGKILL to its process group), or control-group (both to the process group); default process", func(s string) error {
+		switch s {
+		case killModeProcess, killModeMixed, killModeControlGroup:
+			opts.killMode = s
+			return nil
+		default:
+			return fmt.Errorf("unknown kill mode %q", s)
+		}
+	})
+	flag.BoolVar(&opts.noSetpgid, "no-setpgid", false, "Keep program in ruc's process group, so terminal-generated signals (e.g. Ctrl-C) reach it directly; ruc then does not restart it after the first SIGINT, but still enforces periods; requires -kill-mode=process")
+	flag.BoolVar(&opts.foregroundTTY, "foreground-tty", false, "Connect program to ruc's terminal stdin, and make its process group the terminal's foreground one while it runs, so it can read from the terminal; ruc takes the terminal back after each exit")
+	flag.BoolVar(&opts.stdinPipe, "stdin-pipe", false, "Connect program's stdin to a pipe kept open by ruc, to write lines into it with ruc send subcommand (e.g. reload commands for REPL-style daemons)")
+	flag.StringVar(&opts.heartbeat, "stdin-heartbeat", "", "Write that line to program's stdin every -stdin-heartbeat-interval; implies -stdin-pipe")
+	durationVar(&opts.stdinInterval, "stdin-heartbeat-interval", 30*time.Second, "Interval of -stdin-heartbeat lines")
+	flag.StringVar(&opts.seccomp, "seccomp", "", "Apply that OCI (Docker) seccomp profile JSON file to program before executing it; also sets no_new_privs")
+	flag.Func("bind", "Bind-mount src[:dst] path (dst defaults to src) in program's private mount namespace, as bubblewrap's --bind; requires ruc running as root; may be repeated, mounts are made in order", func(s string) error {
+		return opts.mounts.add(s, false)
+	})
+	flag.Func("ro-bind", "Bind-mount src[:dst] path read-only (including mounts under it), as bubblewrap's --ro-bind; e.g. -ro-bind=/ -bind=/var/spool/job leaves only the latter writable; may be repeated", func(s string) error {
+		return opts.mounts.add(s, true)
+	})
+	flag.BoolVar(&opts.privateNet, "private-network", false, "Run each program instance in its own network namespace with only the loopback interface; requires ruc running as root")
+	flag.Func("publish", "Forward TCP connections to [host-address:]host-port on the host to that port on the loopback interface of the current program's network namespace (e.g. 8080:80), so the recycled program stays reachable at a stable address; implies -private-network; may be repeated", opts.publish.add)
+	var dropCapsF, addCapsF uint64
+	flag.Func("drop-caps", "Drop these comma-separated capabilities (e.g. net_raw,sys_admin), or all, from program's bounding, permitted, and inheritable sets; requires ruc running as root; may be repeated", func(s string) error {
+		c, err := parseCaps(s)
+		dropCapsF |= c
+		return err
+	})
+	flag.Func("cap-add", "Keep these comma-separated capabilities dropped by -drop-caps (e.g. -drop-caps=all -cap-add=net_bind_service); may be repeated", func(s string) error {
+		c, err := parseCaps(s)
+		addCapsF |= c
+		return err
+	})
+	flag.BoolVar(&opts.noNewPrivs, "no-new-privs", false, "Set program's no_new_privs flag, so setuid binaries and file capabilities can't grant it more privileges")
+	flag.BoolVar(&opts.pauseStopped, "pause-stopped", false, "Do not count time while the program is stopped (by SIGSTOP, a debugger, or a frozen cgroup) toward the run period; Linux only")
+	flag.Func("suspend", "How time with the system suspended is accounted: exclude (from periods), include (into periods), or recycle (restart program after resume); default exclude", func(s string) error {
+		var err error
+		opts.suspend, err = parseSuspend(s)
+		return err
+	})
+	flag.Func("clock-jump", "What pending -run and -schedule calendar boundaries and -start-at do when the wall clock jumps (e.g. NTP step or manual change, but not suspension): ignore (keep waiting by the monotonic clock) or reanchor (follow the new wall clock); jumps are logged either way; default ignore", func(s string) error {
+		var err error
+		clockJumpMode, err = parseClockJump(s)
+		return err
+	})
+	maxFDsF := flag.Int("max-fds", 0, "Gracefully restart program when it has more open file descriptors than that; 0 disables the limit")
+	maxThreadsF := flag.Int("max-threads", 0, "Gracefully restart program when it has more threads than that; 0 disables the limit")
+	maxCPUTimeF := durationFlag("max-cpu-time", 0, "Gracefully restart program when it used more CPU time (user and system) than that in the current run, unlike -run wall-clock period; 0 disables the limit")
+	flag.IntVar(&opts.maxPIDs, "max-pids", 0, "Limit the number of program's processes and threads with pids cgroup (a systemd scope's TasksMax with -systemd-run), and gracefully restart it when the limit is hit; falls back to RLIMIT_NPROC of ruc's user if cgroups are not available; 0 disables the limit")
+	var maxEgressF byteSize
+	flag.Var(&maxEgressF, "max-egress", "Limit program's egress bandwidth to that many bytes per second (e.g. 10M) with net_cls cgroup and tc HTB class; requires root, cgroup v1 net_cls controller, and tc; 0 disables the limit")
+	flag.StringVar(&opts.egressIface, "egress-interface", "", "Network interface for -max-egress; defaults to the default route one")
+	durationVar(&opts.limitInterval, "limit-interval", 10*time.Second, "Period between checks of program's resource usage limits (-max-fds, -max-threads, -max-cpu-time, -max-pids)")
+	durationVar(&opts.usageInterval, "resources-interval", 0, "Sample program's CPU usage, RSS, open file descriptors, and threads from /proc that often, and report them in status (ruc status, /status and /debug/vars of -http); 0 disables sampling")
+	flag.Func("notify", "Send program's events (start, exit, failure, kill, oom, error and fatal for -stderr-alerts, degraded) and ruc's crashes (crash) as JSON to that notifier: name=URL (POSTed to) or name=cmd:command (run with /bin/sh -c, on stdin); without -notify-rule, all events are sent to all notifiers; may be repeated", opts.notifier.addTarget)
+	flag.Func("notify-rule", "Send events of that kind to notifiers only after count of them within the window: kind[:count[/window]]=notifier[,notifier...], e.g. kill:3/10m=pager or failure:5=chat (consecutive failures); may be repeated", opts.notifier.addRule)
+	flag.BoolVar(&opts.restartOnOOM, "restart-on-oom", false, "Restart program killed by the OOM killer (subject to -backoff) instead of exiting")
+	var programsF programs
+	flag.Func("program", "Supervise that named program instead of the one given by arguments: name=command or name:command, run with /bin/sh -c; each program has its own restart loop, with other flags shared; may be repeated", programsF.add)
+	var programRunF []string
+	flag.Func("program-run", "Override -run period for that -program: name=period (a duration or a schedule); may be repeated", func(s string) error {
+		programRunF = append(programRunF, s)
+		return nil
+	})
+	var needsF []string
+	flag.Func("needs", "Declare -program dependencies: name=dep[,dep...]; the program is started after its dependencies are ready, and restarted after they are restarted; may be repeated", func(s string) error {
+		needsF = append(needsF, s)
+		return nil
+	})
+	var groupsF []string
+	flag.Func("restart-group", "Declare -program restart group: name,name[,name...] or all; when one member crashes (and is restarted, e.g. with -retry), the others are gracefully restarted too; may be repeated", func(s string) error {
+		groupsF = append(groupsF, s)
+		return nil
+	})
+	var procfileF, envFileF *string
+	if up {
+		procfileF = flag.String("procfile", "Procfile", "Read programs from that foreman-style Procfile")
+		envFileF = flag.String("env-file", ".env", "Read programs' environment variables from that file, if it exists; -env flags override them")
+	}
+	flag.IntVar(&opts.portBase, "port-base", 0, "Assign each program a unique $"+portEnv+": the base for the first -program, increased by -port-step for each next one, and by 1 for each replica; 0 disables assignment")
+	flag.IntVar(&opts.portStep, "port-step", 100, "Difference between -port-base ports of consecutive programs")
+	replicasF := flag.Int("replicas", 1, "Run that many copies of the program, each with its own restart loop, staggering their restarts over -run period; $"+replicaEnv+" is set to the zero-based replica index")
+	maxUnavailableF := flag.Int("max-unavailable", 1, "In -replicas mode, the maximal number of replicas stopped for the periodic restart at the same time; 0 disables the limit")
+	flag.IntVar(&opts.retry, "retry", 0, "Run program until it succeeds, at most that many times, then exit with its last exit status; each attempt is limited by -run period; 0 restarts program after each run as usual")
+	durationVar(&opts.retryDelay, "retry-delay", time.Second, "Delay between -retry attempts")
+	flag.IntVar(&opts.startRetries, "start-retries", 0, "Retry starting program that many times if it can't be started (e.g. its binary is being replaced), or fails -start-timeout, before exiting")
+	durationVar(&opts.startTimeout, "start-timeout", 0, "Stop program that does not pass -ready-probe in that time (or, without probe, fail if it exits unsuccessfully sooner), and treat it as a failed start; 0 waits for readiness indefinitely")
+	durationVar(&opts.startDelay, "start-retry-delay", time.Second, "Delay before the first -start-retries retry; doubled for each next one")
+	flag.Func("defer-recycle-when", "Defer periodic restarts while that host condition holds: battery (discharging), thermal:degrees (any thermal zone at that Celsius temperature or above), or cmd:command (exits with non-zero status, printing the reason), for laptops and edge devices; may be repeated", func(s string) error {
+		c, err := parseHostCondition(s)
+		if err != nil {
+			return err
+		}
+		opts.hostConds = append(opts.hostConds, c)
+		return nil
+	})
+	durationVar(&opts.deferInterval, "defer-recycle-interval", time.Minute, "Extend run period by that much each time -defer-recycle-when condition holds at its end, checking it again then")
+	durationVar(&opts.deferMax, "defer-recycle-max", time.Hour, "The maximal total -defer-recycle-when deferral of a single run")
+	flag.IntVar(&opts.forkRetries, "fork-retries", 5, "Retry starting program that many times when fork or exec fails because of exhausted resources (ENOMEM, EAGAIN), with -start-retry-delay doubled for each next retry up to "+maxForkDelay.String()+"; they do not count as -start-retries; 0 disables that")
+	flag.BoolVar(&opts.forkFree, "fork-retry-free", false, "Before -fork-retries retries, free ruc's memory: shrink -tail-buffer and its own log buffer by half, and return freed memory to the OS")
+	durationVar(&opts.daemonDetect, "daemon-detect", time.Second, "Warn if program exits successfully sooner than that after the start, as programs that daemonize themselves do, instead of restarting it silently; 0 disables that")
+	flag.IntVar(&opts.adoptPID, "adopt-pid", 0, "Take over the already running process with that PID instead of the first start, to migrate it to ruc without an outage: it is stopped when the run period ends (its output and exit status are not available), and then the program, if given, is started as usual; without a program, ruc exits after the process exits")
+	flag.StringVar(&opts.adoptPIDFile, "adopt-pid-file", "", "Like -adopt-pid, with PID read from that file")
+	flag.StringVar(&opts.daemonPIDFile, "daemon-pid-file", "", "When program exits successfully sooner than -daemon-detect, track the daemon with PID from that file as the program (signal it on stops and restart it when it exits), waiting up to -daemon-detect for the file")
+	holdOnFailureF := flag.Bool("hold-on-failure", false, "When ruc gives up on a failed program, keep running with status, control socket, and HTTP listener available for inspection until SIGINT or SIGTERM, instead of exiting")
+	drainOnStopF := flag.Bool("drain-on-stop", false, "On the first SIGINT or SIGTERM, let the current run end at its -run deadline (or by itself) without restarting; the second one stops it as usual")
+	keepOnForceExitF := flag.Bool("keep-on-force-exit", false, "Do not kill program's process group when ruc is forced to exit by the second SIGINT or SIGTERM")
+	passSignalsF := flag.Bool("pass-signals", false, "Pass SIGINT and SIGTERM to program instead of shutting down; only -run period (and other restart triggers) recycle it")
+	flag.BoolVar(&opts.chaos, "chaos", false, "Stop program at a random point within each run period, for resilience testing")
+	durationVar(&opts.backoff.initial, "backoff", 0, "Delay restart of a program that exited before -backoff-reset, doubling the delay for each consecutive such exit; 0 disables backoff")
+	durationVar(&opts.backoff.max, "backoff-max", 5*time.Minute, "Maximal -backoff delay")
+	flag.Float64Var(&opts.backoff.multiplier, "backoff-multiplier", 2, "Multiplier of the -backoff delay for each consecutive unstable run")
+	durationVar(&opts.backoff.reset, "backoff-reset", time.Minute, "Program uptime after which it is considered stable and -backoff delay is reset")
+	var watchContentF []string
+	flag.Func("watch-content", "Gracefully restart program when the content of that file changes; may be repeated", func(s string) error {
+		watchContentF = append(watchContentF, s)
+		return nil
+	})
+	var watchCertF []string
+	flag.Func("watch-cert", "Gracefully restart program when that PEM certificate file changes, or -watch-cert-margin before the certificate expires; may be repeated", func(s string) error {
+		watchCertF = append(watchCertF, s)
+		return nil
+	})
+	watchCertMarginF := durationFlag("watch-cert-margin", time.Hour, "Period before -watch-cert certificate expiration to restart program")
+	flag.BoolVar(&opts.binaryChange, "restart-on-binary-change", false, "Gracefully restart program when its executable (searched the same way as at start) is replaced on disk, for example by a deploy")
+	durationVar(&opts.watchInterval, "watch-interval", 10*time.Second, "Period between -watch-content, -watch-cert, -restart-on-binary-change, and -drain-file checks")
+	flag.StringVar(&opts.drainFile, "drain-file", "", "While that file exists (e.g. created by orchestration before host maintenance), let the current run end as usual, but do not start the next one, reporting drained status; starts resume when it is removed")
+	heartbeatFileF := flag.String("heartbeat-file", "", "Touch that file periodically while the program is healthy")
+	heartbeatIntervalF := durationFlag("heartbeat-interval", 10*time.Second, "Period between -heartbeat-file touches")
+	controlSocketF := flag.String("control-socket", os.Getenv(controlSocketEnv), "Listen for control commands (e.g. `ruc set`) on that Unix socket; defaults to $"+controlSocketEnv)
+	controlListenF := flag.String("control-listen", "", "Also listen for remote control commands on that TCP address with TLS; requires -control-tls-cert, -control-tls-key, and -control-token-file")
+	controlTLSCertF := flag.String("control-tls-cert", "", "PEM certificate file for -control-listen")
+	controlTLSKeyF := flag.String("control-tls-key", "", "PEM private key file for -control-listen")
+	controlTokenFileF := flag.String("control-token-file", "", "File with the bearer token that -control-listen clients should send via $"+controlTokenEnv+", and -http clients should send as a bearer token or basic authentication password to stop and start programs, trigger, and debug")
+	detachF := flag.Bool("detach", false, "Run ruc in the background, printing its PID; requires -control-socket for `ruc attach`, and enables -tail-buffer of 1M by default")
+	detachLogF := flag.String("detach-log", "", "Append output of -detach'ed ruc and its program to that file instead of discarding it")
+	httpF := flag.String("http", "", "Serve status, expvar, pprof, and supervisord-compatible XML-RPC API (at /RPC2) on that address (e.g. 127.0.0.1:8181); pprof, expvar, POST /trigger, and XML-RPC methods that stop and start programs require -control-token-file token")
+	flag.BoolVar(&opts.systemdRun, "systemd-run", false, "Run program in a transient systemd scope unit")
+	flag.BoolVar(&opts.systemdUser, "systemd-user", false, "Use user's systemd instance instead of the system one")
+	flag.StringVar(&opts.systemdSlice, "systemd-slice", "", "Slice for the transient systemd scope unit")
+	flag.StringVar(&opts.bundleDir, "bundle-dir", "", "Save a failure artifacts bundle (status, ruc's logs, output with -tail-buffer, -history-file records, host information) as tar.gz into that directory after -bundle-after consecutive failures; see also `ruc bundle`")
+	flag.IntVar(&opts.bundleAfter, "bundle-after", 3, "Number of consecutive failed runs after which -bundle-dir bundle is saved")
+	flag.StringVar(&opts.forensicsDir, "forensics-dir", "", "Capture /proc state of the program into a new subdirectory there before killing it with SIGKILL")
+	flag.BoolVar(&opts.forensicsGcore, "forensics-gcore", false, "Also capture a core dump with gcore into -forensics-dir")
+	durationVar(&opts.forensicsTimeout, "forensics-gcore-timeout", time.Minute, "Maximum time for gcore to capture a core dump")
+	flag.StringVar(&opts.coreDir, "core-dir", "", "Enable core dumps of the program, and move a crashed program's core dump into a new subdirectory there, compressed, with run metadata")
+	var coreRetentionF byteSize
+	flag.Var(&coreRetentionF, "core-retention", "Remove the oldest -core-dir core dumps when their total size exceeds that (e.g. 10G); 0 means no limit")
+	flag.StringVar(&opts.pidFile, "pid-file", "", "Write the current program's PID to that file")
+	flag.StringVar(&current.path, "status-file", os.Getenv(statusFileEnv), "Write status to that file (for `ruc health`); defaults to $"+statusFileEnv)
+	durationVar(&opts.terminationGraceMargin, "termination-grace-margin", 2*time.Second, "Safety margin subtracted from -termination-grace-period")
+	var gf genFlags
+	if gen != "" {
+		flag.StringVar(&gf.description, "unit-description", "", "Service description; defaults to program names")
+		flag.StringVar(&gf.user, "unit-user", "", "Run the service as that user")
+		wd, _ := os.Getwd()
+		flag.StringVar(&gf.workDir, "unit-working-directory", wd, "Service working directory, against which relative paths in flags are resolved")
+	}
+	if gen == "launchd" {
+		flag.StringVar(&gf.label, "unit-label", "", "launchd job label; defaults to com.github.aleksi.ruc.<program name>")
+		flag.StringVar(&gf.stdoutPath, "unit-stdout-path", "", "File for ruc's and program's stdout; defaults to <label>.log in -unit-working-directory")
+		flag.StringVar(&gf.stderrPath, "unit-stderr-path", "", "File for ruc's and program's stderr; defaults to -unit-stdout-path")
+	}
+	configF := flag.String("config", "", "Read flags from that file: name = value lines (value may be omitted for boolean flags), with [profile] sections used only with -profile, and include = path lines (e.g. conf.d/*.conf, relative to the file); command line flags override them")
+	profileF := flag.String("profile", "", "Also use flags of that [profile] section of -config file (e.g. dev, prod, or soak), overriding ones outside sections")
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [program] [program arguments]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [--] [program] [program arguments]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s health [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s set [flags] key=value...\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s timeout [flags] duration program [program arguments]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s history [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s status [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s tail [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s attach [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s stop [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s restart [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s send [flags] text...\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s bundle [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s events [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s up [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s flake [flags] program [program arguments]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s replay [flags] file\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s gen systemd|launchd [flags] [--] [program] [program arguments]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s gen schema\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s check [flags] [--] [program] [program arguments]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s signals\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s version [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Flags parsing stops at the first non-flag argument, or after --;\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "use -- to run a program that starts with a dash or has the same name as a subcommand.\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "Flags:\n")
 		flag.PrintDefaults()
 	}
+	if schema {
+		if err := genSchema(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	flag.Parse()
-	if flag.NArg() == 0 {
-		flag.Usage()
+	if *profileF != "" && *configF == "" {
+		fmt.Fprintf(flag.CommandLine.Output(), "-profile requires -config.\n")
+		os.Exit(2)
+	}
+	if *configF != "" {
+		entries, err := readConfig(*configF, *profileF)
+		if err == nil {
+			err = applyConfig(entries)
+		}
+		if err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "%s.\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if up {
+		if len(programsF) > 0 || flag.NArg() > 0 {
+			fmt.Fprintf(flag.CommandLine.Output(), "Programs are read from -procfile by `ruc up`.\n")
+			os.Exit(2)
+		}
+
+		var err error
+		if programsF, err = readProcfile(*procfileF); err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "%s\n", err)
+			os.Exit(2)
+		}
+
+		vars, err := readDotEnv(*envFileF, isFlagSet("env-file"))
+		if err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "%s\n", err)
+			os.Exit(2)
+		}
+		opts.env = append(vars, opts.env...)
+	}
+
+	adopting := opts.adoptPID != 0 || opts.adoptPIDFile != ""
+	if len(programsF) == 0 {
+		if (flag.NArg() == 0 && !adopting) || len(needsF) > 0 || len(groupsF) > 0 || len(programRunF) > 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		programsF = programs{{args: flag.Args()}}
+	} else {
+		if flag.NArg() > 0 {
+			fmt.Fprintf(flag.CommandLine.Output(), "Program arguments can't be used with -program.\n")
+			os.Exit(2)
+		}
+		for _, s := range needsF {
+			if err := programsF.addNeeds(s); err != nil {
+				fmt.Fprintf(flag.CommandLine.Output(), "%s\n", err)
+				os.Exit(2)
+			}
+		}
+		for _, s := range groupsF {
+			if err := programsF.addGroup(s); err != nil {
+				fmt.Fprintf(flag.CommandLine.Output(), "%s\n", err)
+				os.Exit(2)
+			}
+		}
+		for _, s := range programRunF {
+			if err := programsF.addRun(s); err != nil {
+				fmt.Fprintf(flag.CommandLine.Output(), "%s\n", err)
+				os.Exit(2)
+			}
+		}
+		if err := programsF.checkCycles(); err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "%s\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if gen != "" {
+		if *detachF {
+			fmt.Fprintf(flag.CommandLine.Output(), "-detach can't be used with `ruc gen`.\n")
+			os.Exit(2)
+		}
+		if gf.description == "" {
+			gf.description = serviceDescription(programsF)
+		}
+		if err := genKinds[gen](os.Stdout, &opts, programsF, stripGenFlags(os.Args[1:], flag.NArg()), &gf); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var firstStart time.Time
+	switch {
+	case *initialDelayF < 0:
+		fmt.Fprintf(flag.CommandLine.Output(), "-initial-delay can't be negative.\n")
+		os.Exit(2)
+	case *initialDelayF > 0 && *startAtF != "":
+		fmt.Fprintf(flag.CommandLine.Output(), "Only one of -initial-delay and -start-at can be used.\n")
+		os.Exit(2)
+	case *initialDelayF > 0:
+		firstStart = clk.Now().Add(*initialDelayF)
+	case *startAtF != "":
+		var err error
+		if firstStart, err = parseStartAt(*startAtF, clk.Now()); err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "%s.\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if *stderrAlertsF {
+		opts.output.alerts = append(opts.output.alerts, defaultAlerts...)
+	}
+
+	if (opts.catchUp != catchUpSkip || opts.catchUpFile != "") && opts.schedule == "" {
+		fmt.Fprintf(flag.CommandLine.Output(), "-catch-up and -catch-up-file require -schedule.\n")
+		os.Exit(2)
+	}
+
+	if *outputFileF != "" && !check {
+		w, err := newRotatingFile(*outputFileF, int64(outputMaxSizeF), *outputCompressF, int64(outputRetentionF))
+		if err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "%s\n", err)
+			os.Exit(2)
+		}
+		opts.output.extra = append(opts.output.extra, w)
+	}
+
+	if !check {
+		for _, path := range outputFIFOsF {
+			w, err := newFIFOWriter(path)
+			if err != nil {
+				fmt.Fprintf(flag.CommandLine.Output(), "%s\n", err)
+				os.Exit(2)
+			}
+			opts.output.extra = append(opts.output.extra, w)
+		}
+	}
+
+	if opts.foregroundTTY {
+		switch {
+		case !check && !hasTerminal(0):
+			fmt.Fprintf(flag.CommandLine.Output(), "-foreground-tty requires stdin to be the controlling terminal.\n")
+			os.Exit(2)
+		case opts.noSetpgid || opts.prestart > 0 || *replicasF > 1 || len(programsF) > 1:
+			fmt.Fprintf(flag.CommandLine.Output(), "-foreground-tty can't be used with -no-setpgid, -prestart, -replicas, or several -program flags.\n")
+			os.Exit(2)
+		}
+
+		// ruc takes the terminal back while it is in the background
+		signal.Ignore(syscall.SIGTTOU)
+	}
+
+	if opts.bundleDir != "" && opts.bundleAfter <= 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "-bundle-after should be positive.\n")
+		os.Exit(2)
+	}
+
+	if opts.heartbeat != "" {
+		if opts.stdinInterval <= 0 {
+			fmt.Fprintf(flag.CommandLine.Output(), "-stdin-heartbeat-interval should be positive.\n")
+			os.Exit(2)
+		}
+		opts.stdinPipe = true
+	}
+	if opts.stdinPipe {
+		if opts.foregroundTTY {
+			fmt.Fprintf(flag.CommandLine.Output(), "-stdin-pipe can't be used with -foreground-tty.\n")
+			os.Exit(2)
+		}
+		opts.stdinLines = make(chan string, 8)
+	}
+
+	if opts.adaptiveGrace {
+		if opts.historyFile == "" {
+			fmt.Fprintf(flag.CommandLine.Output(), "-adaptive-grace requires -history-file.\n")
+			os.Exit(2)
+		}
+		if opts.adaptiveGraceMin <= 0 || opts.adaptiveGraceMin > opts.adaptiveGraceMax {
+			fmt.Fprintf(flag.CommandLine.Output(), "-adaptive-grace-min should be positive and not greater than -adaptive-grace-max.\n")
+			os.Exit(2)
+		}
+	}
+
+	if opts.digest && opts.historyFile == "" && opts.resultFile == "" {
+		fmt.Fprintf(flag.CommandLine.Output(), "-output-digest requires -history-file or -result-file.\n")
+		os.Exit(2)
+	}
+
+	if opts.prepareNext && (opts.prestart > 0 || opts.restartMode == restartModeOverlap) {
+		fmt.Fprintf(flag.CommandLine.Output(), "-prepare-next can't be used with -prestart or -restart-mode=overlap, which start the next program ahead themselves.\n")
+		os.Exit(2)
+	}
+
+	if opts.trigger != nil {
+		switch {
+		case opts.prestart > 0 || opts.restartMode == restartModeOverlap:
+			fmt.Fprintf(flag.CommandLine.Output(), "-trigger can't be used with -prestart or -restart-mode=overlap, as it stops the running program before starting the next one.\n")
+			os.Exit(2)
+		case opts.schedule != "" || len(opts.countdown) > 0:
+			fmt.Fprintf(flag.CommandLine.Output(), "-trigger can't be used with -schedule or -countdown, as it replaces the run period timer.\n")
+			os.Exit(2)
+		case opts.trigger.kind == "http" && (*httpF == "" || *controlTokenFileF == ""):
+			fmt.Fprintf(flag.CommandLine.Output(), "-trigger=http requires -http and -control-token-file.\n")
+			os.Exit(2)
+		}
+		opts.triggers = make(chan string, 1)
+	}
+
+	if adopting {
+		switch {
+		case opts.adoptPID != 0 && opts.adoptPIDFile != "":
+			fmt.Fprintf(flag.CommandLine.Output(), "Only one of -adopt-pid and -adopt-pid-file can be used.\n")
+			os.Exit(2)
+		case opts.adoptPID < 0:
+			fmt.Fprintf(flag.CommandLine.Output(), "-adopt-pid should be positive.\n")
+			os.Exit(2)
+		case len(programsF[0].args) == 0 && (opts.prestart > 0 || opts.restartMode == restartModeOverlap || opts.retry > 0):
+			fmt.Fprintf(flag.CommandLine.Output(), "Without a program, -adopt-pid can't be used with -prestart, -restart-mode=overlap, or -retry.\n")
+			os.Exit(2)
+		}
+	}
+
+	if opts.daemonPIDFile != "" && opts.daemonDetect == 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "-daemon-pid-file requires -daemon-detect.\n")
+		os.Exit(2)
+	}
+
+	if len(opts.altDirs) > 0 && (opts.prestart > 0 || opts.restartMode == restartModeOverlap) {
+		fmt.Fprintf(flag.CommandLine.Output(), "-alternate-dir can't be used with -prestart or -restart-mode=overlap, which start the next program while the current one uses its directory.\n")
+		os.Exit(2)
+	}
+	if (opts.altChdir || opts.promoteCmd != "") && len(opts.altDirs) == 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "-alternate-chdir and -promote-cmd require -alternate-dir.\n")
+		os.Exit(2)
+	}
+
+	if opts.restartMode == restartModeOverlap {
+		switch {
+		case opts.readyProbe == nil && opts.notifyFD == 0:
+			fmt.Fprintf(flag.CommandLine.Output(), "-restart-mode=overlap requires -ready-probe or -notification-fd.\n")
+			os.Exit(2)
+		case opts.prestart > 0:
+			fmt.Fprintf(flag.CommandLine.Output(), "-restart-mode=overlap can't be used with -prestart.\n")
+			os.Exit(2)
+		}
+	}
+
+	if opts.output.directives[directiveReady] && (opts.readyProbe != nil || opts.notifyFD != 0) {
+		fmt.Fprintf(flag.CommandLine.Output(), "READY directive can't be used with -ready-probe or -notification-fd.\n")
+		os.Exit(2)
+	}
+
+	if opts.notifyFD != 0 {
+		// socket activation descriptors go first, then the prestart gate
+		used := listenFDsStart + len(opts.fds.fds)
+		if opts.prestart > 0 {
+			used++
+		}
+		switch {
+		case opts.readyProbe != nil:
+			fmt.Fprintf(flag.CommandLine.Output(), "-notification-fd can't be used with -ready-probe.\n")
+			os.Exit(2)
+		case opts.notifyFD < used:
+			fmt.Fprintf(flag.CommandLine.Output(), "-notification-fd should be %d or more.\n", used)
+			os.Exit(2)
+		}
+	}
+
+	if err := opts.notifier.check(); err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n", err)
 		os.Exit(2)
 	}
 
-	log.SetPrefix("ruc: ")
+	if addCapsF != 0 && dropCapsF == 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "-cap-add requires -drop-caps.\n")
+		os.Exit(2)
+	}
+	if dropCapsF != 0 {
+		keep := capAll&^dropCapsF | addCapsF
+		opts.caps = &keep
+	}
+
+	if opts.seccomp != "" {
+		// the trampoline may run in another working directory
+		p, err := filepath.Abs(opts.seccomp)
+		if err == nil {
+			err = checkSeccomp(p)
+		}
+		if err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "-seccomp: %s\n", err)
+			os.Exit(2)
+		}
+		opts.seccomp = p
+	}
+
+	if opts.noSetpgid && opts.killMode != killModeProcess {
+		fmt.Fprintf(flag.CommandLine.Output(), "-no-setpgid requires -kill-mode=process.\n")
+		os.Exit(2)
+	}
+
+	if *controlListenF != "" && (*controlTLSCertF == "" || *controlTLSKeyF == "" || *controlTokenFileF == "") {
+		fmt.Fprintf(flag.CommandLine.Output(), "-control-listen requires -control-tls-cert, -control-tls-key, and -control-token-file.\n")
+		os.Exit(2)
+	}
+
+	if *detachF && os.Getenv(detachedEnv) == "" {
+		if *controlSocketF == "" {
+			fmt.Fprintf(flag.CommandLine.Output(), "-detach requires -control-socket.\n")
+			os.Exit(2)
+		}
+		if !check {
+			detach(*detachLogF, &opts.fds, opts.passFDs)
+		}
+	}
+	os.Unsetenv(detachedEnv)
+	if *detachF && tailBufferF == 0 {
+		tailBufferF = 1 << 20
+	}
+
+	if tailBufferF > 0 {
+		opts.tail = newTailBuffer(int(tailBufferF))
+		opts.output.extra = append(opts.output.extra, opts.tail)
+	}
+
+	if *lockTTLF < time.Second {
+		// etcd lease and Consul session TTLs are whole seconds
+		fmt.Fprintf(flag.CommandLine.Output(), "-lock-ttl should be at least 1s.\n")
+		os.Exit(2)
+	}
+
+	var locks int
+	if *lockFlockF != "" {
+		opts.lock = &flockLocker{path: *lockFlockF}
+		locks++
+	}
+	if *lockEtcdF != "" {
+		opts.lock = &etcdLocker{endpoint: strings.TrimSuffix(*lockEtcdEndpointF, "/"), key: *lockEtcdF, ttl: *lockTTLF}
+		locks++
+	}
+	if *lockConsulF != "" {
+		opts.lock = &consulLocker{addr: strings.TrimSuffix(*lockConsulAddrF, "/"), key: *lockConsulF, ttl: *lockTTLF}
+		locks++
+	}
+	if locks > 1 {
+		fmt.Fprintf(flag.CommandLine.Output(), "Only one of -lock-flock, -lock-etcd, and -lock-consul can be used.\n")
+		os.Exit(2)
+	}
+
+	switch {
+	case *recycleSlotsEtcdF != "" && *recycleSlotsConsulF != "":
+		fmt.Fprintf(flag.CommandLine.Output(), "Only one of -recycle-slots-etcd and -recycle-slots-consul can be used.\n")
+		os.Exit(2)
+	case *recycleSlotsF < 1:
+		fmt.Fprintf(flag.CommandLine.Output(), "-recycle-slots should be positive.\n")
+		os.Exit(2)
+	case *recycleSlotsEtcdF != "":
+		opts.fleetSlots = newFleetSlots(*recycleSlotsEtcdF, *recycleSlotsF, func(key string) locker {
+			return &etcdLocker{endpoint: strings.TrimSuffix(*lockEtcdEndpointF, "/"), key: key, ttl: *lockTTLF}
+		})
+	case *recycleSlotsConsulF != "":
+		opts.fleetSlots = newFleetSlots(*recycleSlotsConsulF, *recycleSlotsF, func(key string) locker {
+			return &consulLocker{addr: strings.TrimSuffix(*lockConsulAddrF, "/"), key: key, ttl: *lockTTLF}
+		})
+	}
+
+	if *maxFDsF > 0 {
+		opts.limits = append(opts.limits, limit{name: "open file descriptors", max: *maxFDsF, usage: procFDs})
+	}
+	if *maxThreadsF > 0 {
+		opts.limits = append(opts.limits, limit{name: "threads", max: *maxThreadsF, usage: procThreads})
+	}
+	if *maxCPUTimeF > 0 {
+		opts.limits = append(opts.limits, cpuTimeLimit(*maxCPUTimeF))
+	}
+
+	if *replicasF < 1 {
+		fmt.Fprintf(flag.CommandLine.Output(), "-replicas should be positive.\n")
+		os.Exit(2)
+	}
+	if len(programsF) > 1 && opts.expectSHA256 != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "-expect-sha256 can't be used with several -program flags.\n")
+		os.Exit(2)
+	}
+	if (*replicasF > 1 || len(programsF) > 1) && (opts.pidFile != "" || opts.daemonPIDFile != "" || adopting || len(opts.publish) > 0 || opts.lock != nil || opts.fleetSlots != nil || *registerFileF != "" || *registerConsulF != "" || opts.catchUpFile != "") {
+		fmt.Fprintf(flag.CommandLine.Output(), "-pid-file, -daemon-pid-file, -adopt-*, -publish, -lock-*, -recycle-slots-*, -register-*, and -catch-up-file can't be used with -replicas or several -program flags.\n")
+		os.Exit(2)
+	}
+
+	if *replicasF > 1 && *maxUnavailableF > 0 {
+		opts.recycling = make(chan struct{}, *maxUnavailableF)
+	}
+
+	if *registerFileF != "" && *registerConsulF != "" {
+		fmt.Fprintf(flag.CommandLine.Output(), "Only one of -register-file and -register-consul can be used.\n")
+		os.Exit(2)
+	}
+	if *registerFileF != "" {
+		opts.registrar = &fileRegistrar{path: *registerFileF}
+	}
+	if *registerConsulF != "" {
+		opts.registrar = &consulRegistrar{
+			addr:    strings.TrimSuffix(*lockConsulAddrF, "/"),
+			name:    *registerConsulF,
+			address: *registerConsulAddressF,
+			port:    *registerConsulPortF,
+		}
+	}
+
+	if len(opts.publish) > 0 {
+		opts.privateNet = true
+	}
+
+	if len(opts.hostConds) > 0 && opts.deferInterval <= 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "-defer-recycle-interval should be positive.\n")
+		os.Exit(2)
+	}
+
+	if *dedupeKeyF != "" && !programNameRE.MatchString(*dedupeKeyF) {
+		fmt.Fprintf(flag.CommandLine.Output(), "Invalid -dedupe-key %q: only letters, digits, '_', '.', and '-' are allowed.\n", *dedupeKeyF)
+		os.Exit(2)
+	}
+
+	if check {
+		if err := checkPrograms(&opts, programsF); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Configuration is valid.\n")
+		return
+	}
+
+	log.SetPrefix(logPrefix)
 	log.SetFlags(log.Ltime)
 
+	if *controlSocketF != "" || *controlListenF != "" || opts.bundleDir != "" {
+		opts.logs = newTailBuffer(256 << 10)
+		log.SetOutput(io.MultiWriter(log.Writer(), opts.logs))
+	}
+
+	if *dedupeF || *dedupeKeyF != "" {
+		key := *dedupeKeyF
+		if key == "" {
+			key = dedupeKey(programsF)
+		}
+		l, err := acquireDedupe(*dedupeDirF, key)
+		if err != nil {
+			log.Fatalf("Failed to acquire dedupe lock: %s", err)
+		}
+		opts.dedupe = l
+	}
+
+	// pass file descriptors from our own socket activation
+	opts.fds.inherit()
+
+	if fds := closeInheritedFDs(inheritFDsF); len(fds) > 0 {
+		log.Printf("Not passing file descriptors %v inherited by ruc to program; use -pass-fd or -inherit-fds to pass them.", fds)
+	}
+
+	watchInfoSignal()
+
+	if opts.maxPIDs > 0 {
+		initPIDsLimit(&opts)
+	}
+
+	if opts.maxEgress = int64(maxEgressF); opts.maxEgress > 0 {
+		if err := checkEgressLimit(); err != nil {
+			log.Fatalf("Failed to limit egress bandwidth: %s", err)
+		}
+	}
+
+	if opts.coreDir != "" {
+		opts.coreRetention = int64(coreRetentionF)
+		if err := enableCoreDumps(); err != nil {
+			log.Printf("Failed to enable core dumps: %s", err)
+		}
+	}
+
+	if err := loadFaults(); err != nil {
+		log.Fatal(err)
+	}
+
+	if opts.terminationGracePeriod == 0 {
+		opts.terminationGracePeriod = envTerminationGracePeriod()
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
+	opts.stopper = newStopper(cancel)
+
+	opts.restart = make(chan string, 1)
+
+	if *controlSocketF != "" {
+		if err := serveControl(*controlSocketF, &opts); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var controlToken string
+	if *controlTokenFileF != "" {
+		var err error
+		if controlToken, err = readControlToken(*controlTokenFileF); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *controlListenF != "" {
+		if err := serveRemoteControl(*controlListenF, *controlTLSCertF, *controlTLSKeyF, controlToken, &opts); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	opts.finish = make(chan struct{})
+	if opts.suspend != suspendExclude {
+		opts.suspended = make(chan time.Duration, 1)
+	}
+	if clockJumpMode == clockJumpReanchor {
+		opts.clockJumped = make(chan time.Duration, 1)
+	}
+	go watchClock(ctx, time.Second, opts.suspended, opts.clockJumped)
+	for _, path := range watchContentF {
+		go watchContent(ctx, path, opts.watchInterval, opts.restart)
+	}
+	for _, path := range watchCertF {
+		go watchCert(ctx, path, opts.watchInterval, *watchCertMarginF, opts.restart)
+	}
+
+	if *heartbeatFileF != "" {
+		go heartbeat(ctx, *heartbeatFileF, *heartbeatIntervalF, opts.readyProbe)
+	}
+
+	if *eventRingF > 0 {
+		events = newEventRing(*eventRingF)
+		if opts.trigger == nil || opts.trigger.sig != syscall.SIGUSR1 {
+			dumpEventsOnSignal()
+		}
+	}
+
+	if opts.trigger != nil {
+		if err := opts.trigger.watch(ctx, opts.triggers); err != nil {
+			log.Fatalf("Failed to watch trigger: %s", err)
+		}
+	}
+
+	instances := newInstances(&opts, programsF, *replicasF)
+	if adopting {
+		pid, err := adoptedPID(&opts)
+		if err != nil {
+			log.Fatalf("Failed to adopt process: %s", err)
+		}
+		instances[0].adopt = pid
+	}
+	if opts.catchUpFile != "" {
+		b, err := readCatchUpFile(opts.catchUpFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		instances[0].lastBoundary = b
+	}
+	if up || len(programsF) > 1 {
+		setPrefixes(instances)
+	}
+
+	for _, p := range opts.publish {
+		if err := p.publish(func() int { return current.get().ChildPID }, &opts.services); err != nil {
+			log.Fatalf("Failed to publish port: %s", err)
+		}
+	}
+
+	if *httpF != "" {
+		if err := serveHTTP(ctx, *httpF, instances, opts.triggers, controlToken, &opts.services); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// handle termination signals: first one gracefully, force exit on the second one
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
+		// programs are killed below unless -keep-on-force-exit is set
+		defer recoverCrash(&opts, instances, false)
+
+		if *passSignalsF {
+			for s := range signals {
+				for _, in := range instances {
+					if pid := in.status.get().ChildPID; pid != 0 {
+						in.log.Printf("Got %v (%d) signal, passing it to program (PID %d).", s, s.(syscall.Signal), pid)
+						in.event(pid, "passed SIG%s", signalName(s.(syscall.Signal)))
+						if err := syscall.Kill(pid, s.(syscall.Signal)); err != nil {
+							in.log.Printf("Failed to pass signal: %s", err)
+						}
+					}
+				}
+			}
+		}
+
 		s := <-signals
+		switch {
+		case opts.noSetpgid && s == syscall.SIGINT:
+			// the terminal sent it to the program too; let it handle that, and enforce only the timer
+			log.Printf("Got %v (%d) signal, letting the program handle it, and not restarting it...", s, s.(syscall.Signal))
+			close(opts.finish)
+			s = <-signals
+		case *drainOnStopF:
+			log.Printf("Got %v (%d) signal, finishing the current run without restarting...", s, s.(syscall.Signal))
+			close(opts.finish)
+			s = <-signals
+		}
+
 		log.Printf("Got %v (%d) signal, shutting down...", s, s.(syscall.Signal))
+		setTerminationDeadline(opts.terminationGracePeriod, opts.terminationGraceMargin)
 		cancel()
 
 		s = <-signals
+		if !*keepOnForceExitF {
+			killInstances(instances)
+		}
 		log.Panicf("Got %v (%d) signal, exiting!", s, s.(syscall.Signal))
 	}()
+	if !firstStart.IsZero() {
+		log.Printf("Delaying the first start until %s.", firstStart.Format(time.DateTime))
+		sleep := sleepUntil
+		if *startAtF != "" {
+			sleep = sleepUntilWall
+		}
+		if err := sleep(ctx, firstStart); err != nil {
+			exit(&opts, 0) // ctx is canceled
+			return
+		}
+	}
 
-	for {
-		if err := run(ctx, *runF, *graceF, flag.Args()); err != nil {
-			log.Fatal(err)
+	if len(instances) == 1 {
+		err := instances[0].loop(ctx, &opts)
+		if err != nil && *holdOnFailureF {
+			holdOn(ctx, err)
+		}
+		if code, ok := exitStatus(err); ok && err != nil && opts.retry > 0 {
+			exit(&opts, code)
+		}
+		if err != nil {
+			log.Print(err)
+			exit(&opts, 1)
 		}
+		exit(&opts, 0)
+		return
+	}
+
+	// the first failure stops all instances
+	runCtx, stopAll := context.WithCancel(ctx)
+	defer stopAll()
+	errs := make(chan error, len(instances))
+	for _, in := range instances {
+		go func(in *instance) {
+			err := in.loop(runCtx, &opts)
+			if err != nil {
+				in.log.Printf("%s", err)
+				stopAll()
+			}
+			errs <- err
+		}(in)
+	}
+	var failed error
+	for range instances {
+		if err := <-errs; err != nil && failed == nil {
+			failed = err
+		}
+	}
+	if failed != nil && *holdOnFailureF {
+		holdOn(ctx, failed)
+	}
+	if failed != nil {
+		exit(&opts, 1)
 	}
+	exit(&opts, 0)
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AlekSi/ruc/clock"
+	"github.com/AlekSi/ruc/testutil"
+)
+
+func TestMain(m *testing.M) {
+	testutil.Main(m)
+}
+
+// fakeClock replaces clk with a fake clock set to now for the duration of the test.
+func fakeClock(t *testing.T, now time.Time) *clock.Fake {
+	t.Helper()
+
+	fake := clock.NewFake(now)
+	prev := clk
+	clk = fake
+	t.Cleanup(func() { clk = prev })
+	return fake
+}
+
+// advanceWhile advances the fake clock by step every time the code under test waits for a timer,
+// until done returns true. It fails the test if that takes too long in real time.
+func advanceWhile(t *testing.T, fake *clock.Fake, step time.Duration, done func() bool) {
+	t.Helper()
+
+	limit := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(limit) {
+			t.Fatalf("still waiting at %s", fake.Now())
+		}
+
+		if fake.Timers() == 0 {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		fake.Advance(step)
+	}
+}
+
+// testWriter writes to the test log.
+type testWriter struct {
+	t *testing.T
+}
+
+func (w testWriter) Write(b []byte) (int, error) {
+	w.t.Log(string(b))
+	return len(b), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// bindMount is a -bind or -ro-bind mount made in program's mount namespace.
+type bindMount struct {
+	Src      string `json:"src"`
+	Dst      string `json:"dst"`
+	ReadOnly bool   `json:"ro,omitempty"`
+}
+
+// bindMountList is a list of -bind and -ro-bind mounts in the order they were given.
+type bindMountList []bindMount
+
+// add adds src[:dst] mount; dst defaults to src.
+func (l *bindMountList) add(spec string, readOnly bool) error {
+	src, dst, ok := strings.Cut(spec, ":")
+	if !ok {
+		dst = src
+	}
+	if src == "" || dst == "" {
+		return fmt.Errorf("invalid bind mount %q", spec)
+	}
+
+	// the trampoline may run in another working directory
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	if dst, err = filepath.Abs(dst); err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(src); err != nil {
+		return err
+	}
+	if dst == "/" && src != "/" {
+		return fmt.Errorf("invalid bind mount %q: only / can be mounted on /", spec)
+	}
+
+	*l = append(*l, bindMount{Src: src, Dst: dst, ReadOnly: readOnly})
+	return nil
+}
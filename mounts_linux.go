@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setMountNamespace makes the program start in a new mount namespace, so -bind and -ro-bind mounts are private to it.
+func setMountNamespace(attr *syscall.SysProcAttr) {
+	attr.Cloneflags |= syscall.CLONE_NEWNS
+}
+
+// mountFlags are mount options that should be preserved when remounting a mount read-only.
+var mountFlags = map[string]uintptr{
+	"nosuid":     syscall.MS_NOSUID,
+	"nodev":      syscall.MS_NODEV,
+	"noexec":     syscall.MS_NOEXEC,
+	"noatime":    syscall.MS_NOATIME,
+	"nodiratime": syscall.MS_NODIRATIME,
+	"relatime":   syscall.MS_RELATIME,
+}
+
+// unescapeMountinfo decodes octal escapes (e.g. \040 for space) of /proc/self/mountinfo fields.
+func unescapeMountinfo(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// remount remounts the bind mount at dir (and, if recursive, all mounts under it) read-only or read-write,
+// preserving other options.
+func remount(dir string, readOnly, recursive bool) error {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	type mount struct {
+		point string
+		flags uintptr
+	}
+	var mounts []mount
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 {
+			continue
+		}
+
+		point := unescapeMountinfo(fields[4])
+		if point != dir && (!recursive || !strings.HasPrefix(point, strings.TrimSuffix(dir, "/")+"/")) {
+			continue
+		}
+
+		var flags uintptr
+		for _, o := range strings.Split(fields[5], ",") {
+			flags |= mountFlags[o]
+		}
+		mounts = append(mounts, mount{point: point, flags: flags})
+	}
+	if err = s.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range mounts {
+		flags := syscall.MS_BIND | syscall.MS_REMOUNT | m.flags
+		if readOnly {
+			flags |= syscall.MS_RDONLY
+		}
+		if err = syscall.Mount("", m.point, "", flags, ""); err != nil {
+			return fmt.Errorf("remounting %s: %w", m.point, err)
+		}
+	}
+	return nil
+}
+
+// bindMounts makes mounts in the current mount namespace in order, without propagating them to the parent one.
+func bindMounts(mounts []bindMount) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %w", err)
+	}
+
+	for _, m := range mounts {
+		// a mount over the root directory would not be visible, so the namespace's own root is remounted instead
+		if m.Dst != "/" {
+			if err := syscall.Mount(m.Src, m.Dst, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+				return fmt.Errorf("bind-mounting %s to %s: %w", m.Src, m.Dst, err)
+			}
+		}
+
+		// binds of paths under read-only mounts are read-only too, so writable ones are remounted read-write
+		if err := remount(m.Dst, m.ReadOnly, m.ReadOnly); err != nil {
+			return err
+		}
+	}
+	return nil
+}
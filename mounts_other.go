@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setMountNamespace does nothing: mount namespaces are Linux-specific.
+func setMountNamespace(attr *syscall.SysProcAttr) {}
+
+// bindMounts returns an error: mount namespaces are Linux-specific.
+func bindMounts(mounts []bindMount) error {
+	return errors.New("bind mounts are Linux-specific")
+}
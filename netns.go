@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// publishedPort is a -publish TCP port: ruc listens on the host address and forwards connections
+// to the port on the loopback interface of the current program's network namespace.
+type publishedPort struct {
+	host string // host address with port
+	port int    // in the program's namespace
+}
+
+// publishedPorts is a list of -publish ports.
+type publishedPorts []publishedPort
+
+// add adds [host-address:]host-port:port; the host address defaults to all interfaces.
+func (p *publishedPorts) add(s string) error {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return fmt.Errorf("invalid published port %q, expected [host-address:]host-port:port", s)
+	}
+
+	port, err := strconv.Atoi(s[i+1:])
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid published port %q: bad port %q", s, s[i+1:])
+	}
+
+	host := s[:i]
+	if !strings.Contains(host, ":") || strings.HasSuffix(host, "]") {
+		// only the host port is given
+		host = ":" + host
+	}
+	if _, _, err = net.SplitHostPort(host); err != nil {
+		return fmt.Errorf("invalid published port %q: %w", s, err)
+	}
+
+	*p = append(*p, publishedPort{host: host, port: port})
+	return nil
+}
+
+// publish starts forwarding connections to the program with PID returned by pid (0 if it is not running)
+// until services are closed.
+func (p publishedPort) publish(pid func() int, svcs *services) error {
+	l, err := net.Listen("tcp", p.host)
+	if err != nil {
+		return err
+	}
+	log.Printf("Publishing program's port %d on %s.", p.port, l.Addr())
+
+	var wg sync.WaitGroup
+	svcs.add(func() {
+		l.Close()
+		wg.Wait()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("Failed to accept connection to published port %d: %s", p.port, err)
+				}
+				return
+			}
+
+			go p.forward(conn, pid())
+		}
+	}()
+
+	return nil
+}
+
+// forward copies data between the accepted connection and the program's port in its namespace.
+func (p publishedPort) forward(conn net.Conn, pid int) {
+	defer conn.Close()
+
+	if pid == 0 {
+		// the program is being restarted; the client should retry
+		return
+	}
+
+	upstream, err := dialNetworkNamespace(pid, net.JoinHostPort("127.0.0.1", strconv.Itoa(p.port)))
+	if err != nil {
+		log.Printf("Failed to forward connection from %s to program's port %d: %s", conn.RemoteAddr(), p.port, err)
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		if c, ok := upstream.(*net.TCPConn); ok {
+			_ = c.CloseWrite()
+		}
+		close(done)
+	}()
+
+	_, _ = io.Copy(conn, upstream)
+	if c, ok := conn.(*net.TCPConn); ok {
+		_ = c.CloseWrite()
+	}
+	<-done
+}
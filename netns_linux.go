@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// setNetworkNamespace makes the program start in a new network namespace with only the loopback interface.
+func setNetworkNamespace(attr *syscall.SysProcAttr) {
+	attr.Cloneflags |= syscall.CLONE_NEWNET
+}
+
+// loopbackUp brings up the loopback interface, which is down in a new network namespace.
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// struct ifreq: the interface name followed by the flags union member
+	var ifr [40]byte
+	copy(ifr[:], "lo")
+	binary.NativeEndian.PutUint16(ifr[syscall.IFNAMSIZ:], syscall.IFF_UP|syscall.IFF_RUNNING)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr[0]))); errno != 0 {
+		return fmt.Errorf("SIOCSIFFLAGS: %w", errno)
+	}
+	return nil
+}
+
+// dialNetworkNamespace connects to the TCP address in the network namespace of the process.
+func dialNetworkNamespace(pid int, addr string) (net.Conn, error) {
+	nr, ok := seccompSyscalls["setns"]
+	if !ok {
+		return nil, errors.New("setns system call number is not known for this architecture")
+	}
+
+	ns, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer ns.Close()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		// the socket is created in the namespace of the calling thread, so it is moved there and back;
+		// threads exiting locked could trigger the program's parent death signal, that follows the thread that started it
+		runtime.LockOSThread()
+
+		orig, err := os.Open("/proc/thread-self/ns/net")
+		if err != nil {
+			runtime.UnlockOSThread()
+			res <- result{err: err}
+			return
+		}
+		defer orig.Close()
+
+		if err = setns(nr, ns); err != nil {
+			runtime.UnlockOSThread()
+			res <- result{err: err}
+			return
+		}
+
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		res <- result{conn: conn, err: err}
+
+		if err := setns(nr, orig); err != nil {
+			// the thread is left locked, so it exits with this goroutine instead of running others in a wrong namespace
+			log.Printf("Failed to restore network namespace of the thread: %s", err)
+			return
+		}
+		runtime.UnlockOSThread()
+	}()
+
+	r := <-res
+	return r.conn, r.err
+}
+
+// setns moves the calling thread to the network namespace of nsFile; nr is setns system call number.
+func setns(nr uint32, nsFile *os.File) error {
+	if _, _, errno := syscall.RawSyscall(uintptr(nr), nsFile.Fd(), syscall.CLONE_NEWNET, 0); errno != 0 {
+		return fmt.Errorf("setns: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlekSi/ruc/testutil"
+)
+
+// freeAddr returns a loopback address with a currently free TCP port.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// echoRoundTrip connects to addr, and checks that the message is echoed back.
+func echoRoundTrip(addr, msg string) error {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = io.WriteString(conn, msg); err != nil {
+		return err
+	}
+	_ = conn.(*net.TCPConn).CloseWrite()
+
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	if string(b) != msg {
+		return fmt.Errorf("expected %q, got %q", msg, b)
+	}
+	return nil
+}
+
+func TestPublishManyConnections(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("-publish requires root for network namespaces")
+	}
+
+	bin := testutil.BuildRuc(t)
+	j := testutil.NewJournal(t)
+	addr := freeAddr(t)
+
+	r := testutil.StartRuc(t, bin, []string{"-run", "1h", "-publish", addr + ":8080"}, testutil.Script{{Listen: "127.0.0.1:8080"}}, j)
+	j.Wait(t, testutil.EventStart, 1, 10*time.Second)
+
+	// the child may not listen yet
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		err := echoRoundTrip(addr, "ping")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("published port is not reachable: %s\n%s", err, r.Output())
+		}
+	}
+
+	// each connection is dialed from a thread moved to the program's namespace;
+	// threads must not exit, as that could kill the program with its parent death signal
+	const workers, conns = 10, 50
+	errs := make(chan error, workers*conns)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < conns; i++ {
+				if err := echoRoundTrip(addr, fmt.Sprintf("message %d %d", w, i)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("connection failed: %s", err)
+	}
+
+	if n := j.Count(t, testutil.EventStart); n != 1 {
+		t.Fatalf("expected program to be started once, got %d starts\n%s", n, r.Output())
+	}
+
+	r.Stop(10 * time.Second)
+	events := j.Events(t)
+	if last := events[len(events)-1]; last.Kind != testutil.EventExit || j.Count(t, testutil.EventTerm) != 1 {
+		t.Fatalf("expected program to exit after SIGTERM, got events %+v\n%s", events, r.Output())
+	}
+}
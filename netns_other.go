@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// errNoNetworkNamespaces is returned on systems without network namespaces.
+var errNoNetworkNamespaces = errors.New("network namespaces are Linux-specific")
+
+// setNetworkNamespace does nothing: network namespaces are Linux-specific.
+func setNetworkNamespace(attr *syscall.SysProcAttr) {}
+
+// loopbackUp returns an error: network namespaces are Linux-specific.
+func loopbackUp() error { return errNoNetworkNamespaces }
+
+// dialNetworkNamespace returns an error: network namespaces are Linux-specific.
+func dialNetworkNamespace(pid int, addr string) (net.Conn, error) { return nil, errNoNetworkNamespaces }
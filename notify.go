@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notification event kinds.
+const (
+	eventStart    = "start"    // program started
+	eventExit     = "exit"     // program exited, successfully or not
+	eventFailure  = "failure"  // program exited unsuccessfully
+	eventKill     = "kill"     // program was sent SIGKILL
+	eventOOM      = "oom"      // program was killed by the OOM killer
+	eventDegraded = "degraded" // program did not write output for -expect-output-every
+	eventCrash    = "crash"    // ruc itself panicked
+	eventError    = "error"    // program's stderr line matched an error -stderr-alert pattern
+	eventFatal    = "fatal"    // program's stderr line matched a fatal -stderr-alert pattern
+)
+
+// notifyTimeout is the maximal time of a single notification delivery.
+const notifyTimeout = 10 * time.Second
+
+// notification is a JSON-encoded event sent to notifiers.
+type notification struct {
+	Kind          string    `json:"kind"`
+	Program       string    `json:"program,omitempty"` // program name and replica index of an instance
+	PID           int       `json:"pid,omitempty"`
+	RunID         string    `json:"run_id,omitempty"`
+	Message       string    `json:"message"`
+	GraceExceeded bool      `json:"grace_exceeded,omitempty"` // exited program was sent SIGKILL after the grace period
+	ExitCode      *int      `json:"exit_code,omitempty"`      // exited program's code, or 128+signal number
+	Signal        string    `json:"signal,omitempty"`         // the signal that killed the exited program
+	Count         int       `json:"count"`                    // number of matching events that triggered the rule
+	Time          time.Time `json:"time"`
+	Hostname      string    `json:"hostname,omitempty"`
+	Ruc           string    `json:"ruc"` // ruc's version, VCS revision, and platform
+}
+
+// notifyRule sends notifications to notifiers when count events of the kind happen within the window.
+type notifyRule struct {
+	kind      string
+	count     int
+	window    time.Duration // 0 means no window
+	notifiers []string
+	times     []time.Time // of matching events since the last notification
+}
+
+// notifier routes program's events to webhooks and commands according to rules.
+type notifier struct {
+	m       sync.Mutex
+	targets map[string]string // name -> URL or cmd:command
+	rules   []*notifyRule
+	wg      sync.WaitGroup
+}
+
+// addTarget adds name=URL or name=cmd:command notifier.
+func (n *notifier) addTarget(spec string) error {
+	name, target, ok := strings.Cut(spec, "=")
+	if !ok || name == "" || target == "" {
+		return fmt.Errorf("invalid notifier %q, expected name=URL or name=cmd:command", spec)
+	}
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") && !strings.HasPrefix(target, "cmd:") {
+		return fmt.Errorf("invalid notifier %q: unknown target %q", name, target)
+	}
+	if _, ok = n.targets[name]; ok {
+		return fmt.Errorf("duplicate notifier %q", name)
+	}
+
+	if n.targets == nil {
+		n.targets = make(map[string]string)
+	}
+	n.targets[name] = target
+	return nil
+}
+
+// addRule adds kind[:count[/window]]=notifier[,notifier...] rule.
+func (n *notifier) addRule(spec string) error {
+	match, names, ok := strings.Cut(spec, "=")
+	if !ok || names == "" {
+		return fmt.Errorf("invalid notification rule %q, expected kind[:count[/window]]=notifier[,notifier...]", spec)
+	}
+
+	r := &notifyRule{count: 1, notifiers: strings.Split(names, ",")}
+	kind, threshold, _ := strings.Cut(match, ":")
+	switch kind {
+	case eventStart, eventExit, eventFailure, eventKill, eventOOM, eventCrash, eventError, eventFatal, eventDegraded:
+		r.kind = kind
+	default:
+		return fmt.Errorf("invalid notification rule %q: unknown event kind %q", spec, kind)
+	}
+
+	if threshold != "" {
+		count, window, ok := strings.Cut(threshold, "/")
+		var err error
+		if r.count, err = strconv.Atoi(count); err != nil || r.count <= 0 {
+			return fmt.Errorf("invalid notification rule %q: invalid count %q", spec, count)
+		}
+		if ok {
+			if r.window, err = parseDuration(window); err != nil || r.window <= 0 {
+				return fmt.Errorf("invalid notification rule %q: invalid window %q", spec, window)
+			}
+		}
+	}
+
+	n.rules = append(n.rules, r)
+	return nil
+}
+
+// check returns an error if rules refer to unknown notifiers.
+func (n *notifier) check() error {
+	for _, r := range n.rules {
+		for _, name := range r.notifiers {
+			if _, ok := n.targets[name]; !ok {
+				return fmt.Errorf("notification rule for %s refers to unknown notifier %q", r.kind, name)
+			}
+		}
+	}
+	return nil
+}
+
+// notify handles the instance's event (in is nil for ruc's own events): it sends the notification to notifiers of matching rules,
+// or to all notifiers if there are no rules.
+// Successful exits reset failure counts, so failure rules match consecutive failures.
+func (n *notifier) notify(in *instance, kind string, pid int, runID, message string) {
+	if len(n.targets) == 0 {
+		return
+	}
+
+	now := time.Now()
+	ev := notification{
+		Kind:    kind,
+		PID:     pid,
+		RunID:   runID,
+		Message: message,
+		Count:   1,
+		Time:    now,
+		Ruc:     rucBuild.String(),
+	}
+	if in != nil {
+		ev.Program = in.label()
+		exit := kind == eventExit || kind == eventFailure || kind == eventOOM
+		ev.GraceExceeded = exit && in.graceExceeded
+		if exit && in.exit != nil {
+			if code, ok := in.exit.status(); ok {
+				ev.ExitCode = &code
+			}
+			if in.exit.signal != 0 {
+				ev.Signal = signalName(in.exit.signal)
+			}
+		}
+	}
+	ev.Hostname, _ = os.Hostname()
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	if len(n.rules) == 0 {
+		for name := range n.targets {
+			n.send(name, ev)
+		}
+		return
+	}
+
+	for _, r := range n.rules {
+		if kind == eventExit && message == "exit status 0" && r.kind == eventFailure {
+			r.times = nil
+		}
+		if r.kind != kind {
+			continue
+		}
+
+		r.times = append(r.times, now)
+		if r.window > 0 {
+			i := 0
+			for i < len(r.times) && now.Sub(r.times[i]) > r.window {
+				i++
+			}
+			r.times = r.times[i:]
+		}
+		if len(r.times) < r.count {
+			continue
+		}
+
+		ev.Count = len(r.times)
+		r.times = nil
+		for _, name := range r.notifiers {
+			n.send(name, ev)
+		}
+	}
+}
+
+// send delivers the notification to the notifier asynchronously.
+func (n *notifier) send(name string, ev notification) {
+	target := n.targets[name]
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+
+		if err := deliver(ctx, target, ev); err != nil {
+			log.Printf("Failed to send %s notification to %s: %s", ev.Kind, name, err)
+		}
+	}()
+}
+
+// wait waits for notifications being sent.
+func (n *notifier) wait() {
+	n.wg.Wait()
+}
+
+// deliver POSTs JSON-encoded notification to the URL, or passes it to the cmd:command's stdin.
+func deliver(ctx context.Context, target string, ev notification) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	if command, ok := strings.CutPrefix(target, "cmd:"); ok {
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+		cmd.Stdin = bytes.NewReader(b)
+		cmd.Env = append(os.Environ(), "RUC_EVENT="+ev.Kind, "RUC_EVENT_MESSAGE="+ev.Message)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+		}
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
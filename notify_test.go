@@ -0,0 +1,105 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifierAddRule(t *testing.T) {
+	for spec, expected := range map[string]notifyRule{
+		"failure=ops":          {kind: eventFailure, count: 1, notifiers: []string{"ops"}},
+		"oom=ops,pager":        {kind: eventOOM, count: 1, notifiers: []string{"ops", "pager"}},
+		"failure:3=pager":      {kind: eventFailure, count: 3, notifiers: []string{"pager"}},
+		"kill:5/10m=ops":       {kind: eventKill, count: 5, window: 10 * time.Minute, notifiers: []string{"ops"}},
+		"error:10/1d=ops":      {kind: eventError, count: 10, window: 24 * time.Hour, notifiers: []string{"ops"}},
+		"degraded:2/1h30m=ops": {kind: eventDegraded, count: 2, window: 90 * time.Minute, notifiers: []string{"ops"}},
+		"start=ops":            {kind: eventStart, count: 1, notifiers: []string{"ops"}},
+		"exit=ops":             {kind: eventExit, count: 1, notifiers: []string{"ops"}},
+		"crash=ops":            {kind: eventCrash, count: 1, notifiers: []string{"ops"}},
+		"fatal=ops":            {kind: eventFatal, count: 1, notifiers: []string{"ops"}},
+	} {
+		var n notifier
+		if err := n.addRule(spec); err != nil {
+			t.Errorf("%q: %s", spec, err)
+			continue
+		}
+
+		actual := n.rules[0]
+		if actual.kind != expected.kind || actual.count != expected.count || actual.window != expected.window ||
+			!slices.Equal(actual.notifiers, expected.notifiers) {
+			t.Errorf("%q: expected %+v, got %+v", spec, expected, *actual)
+		}
+	}
+
+	for spec, msg := range map[string]string{
+		"":                  "expected kind",
+		"failure":           "expected kind",
+		"failure=":          "expected kind",
+		"nope=ops":          `unknown event kind "nope"`,
+		"=ops":              `unknown event kind ""`,
+		"failure:0=ops":     `invalid count "0"`,
+		"failure:-1=ops":    `invalid count "-1"`,
+		"failure:x=ops":     `invalid count "x"`,
+		"failure:/1h=ops":   `invalid count ""`,
+		"failure:3/=ops":    `invalid window ""`,
+		"failure:3/0s=ops":  `invalid window "0s"`,
+		"failure:3/-1h=ops": `invalid window "-1h"`,
+		"failure:3/x=ops":   `invalid window "x"`,
+	} {
+		var n notifier
+		if err := n.addRule(spec); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expected %q error, got %v", spec, msg, err)
+		}
+	}
+}
+
+func TestNotifierAddTarget(t *testing.T) {
+	var n notifier
+	for _, spec := range []string{
+		"ops=https://example.com/hook",
+		"dev=http://127.0.0.1:8080/hook",
+		"pager=cmd:pager-send --to=oncall",
+	} {
+		if err := n.addTarget(spec); err != nil {
+			t.Errorf("%q: %s", spec, err)
+		}
+	}
+	if expected := "cmd:pager-send --to=oncall"; n.targets["pager"] != expected {
+		t.Errorf("expected %q, got %q", expected, n.targets["pager"])
+	}
+
+	for spec, msg := range map[string]string{
+		"ops":                     "expected name=URL",
+		"=https://example.com":    "expected name=URL",
+		"ops=":                    "expected name=URL",
+		"ops=ftp://example.com":   "unknown target",
+		"ops=example.com/hook":    "unknown target",
+		"ops=https://example.org": `duplicate notifier "ops"`,
+	} {
+		if err := n.addTarget(spec); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expected %q error, got %v", spec, msg, err)
+		}
+	}
+}
+
+func TestNotifierCheck(t *testing.T) {
+	var n notifier
+	if err := n.addTarget("ops=https://example.com/hook"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.addRule("failure:3=ops"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.addRule("oom=ops,pager"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.check(); err == nil || !strings.Contains(err.Error(), `unknown notifier "pager"`) {
+		t.Errorf("expected unknown notifier error, got %v", err)
+	}
+}
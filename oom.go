@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errOOMKilled is wrapped into the program's exit error when it was killed by the OOM killer.
+var errOOMKilled = errors.New("program was killed by the OOM killer")
+
+// oomWatch detects whether the program was killed by the OOM killer.
+type oomWatch struct {
+	pid    int
+	events string // memory.events (cgroup v2) or memory.oom_control (cgroup v1) file; empty if unknown
+	kills  int    // oom_kill counter value when the program was started
+
+	start    time.Duration // CLOCK_MONOTONIC reading when the program was started, if hasStart
+	hasStart bool
+}
+
+// newOOMWatch returns a watch for the running program with the given PID.
+func newOOMWatch(pid int) *oomWatch {
+	w := &oomWatch{pid: pid}
+	w.start, w.hasStart = monotonicClock()
+
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return w
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(s.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		var path string
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			path = filepath.Join("/sys/fs/cgroup", parts[2], "memory.events")
+		case strings.Contains(","+parts[1]+",", ",memory,"):
+			path = filepath.Join("/sys/fs/cgroup/memory", parts[2], "memory.oom_control")
+		default:
+			continue
+		}
+
+		if kills, err := readOOMKills(path); err == nil {
+			w.events = path
+			w.kills = kills
+			break
+		}
+	}
+
+	return w
+}
+
+// killed returns true if the program that exited with the given result was killed by the OOM killer.
+func (w *oomWatch) killed(res *exitResult) bool {
+	if res.signal != syscall.SIGKILL {
+		return false
+	}
+
+	if w.events != "" {
+		if kills, err := readOOMKills(w.events); err == nil {
+			return kills > w.kills
+		}
+	}
+
+	// records since the start only, as the PID could be reused
+	if !w.hasStart {
+		return false
+	}
+	return kmsgOOMKilled(w.pid, w.start)
+}
+
+// readOOMKills returns oom_kill counter value from cgroup's memory.events or memory.oom_control file.
+func readOOMKills(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if v, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			return strconv.Atoi(v)
+		}
+	}
+	return 0, fmt.Errorf("%s: no oom_kill counter", path)
+}
+
+// kmsgOOMKilled returns true if the kernel log contains a message about the OOM killer killing that process
+// not earlier than since (CLOCK_MONOTONIC reading). That usually requires privileges.
+func kmsgOOMKilled(pid int, since time.Duration) bool {
+	// not os.File, as it would wait for the next record in the poller instead of returning EAGAIN
+	fd, err := syscall.Open("/dev/kmsg", syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(fd)
+
+	// each read returns a single record; it ends with EAGAIN error
+	buf := make([]byte, 8192)
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err != nil {
+			if err == syscall.EPIPE || err == syscall.EINTR {
+				continue // record was overwritten, or the read was interrupted
+			}
+			return false
+		}
+		if kmsgOOMRecord(string(buf[:n]), pid, since) {
+			return true
+		}
+	}
+}
+
+// kmsgOOMRecord returns true if /dev/kmsg record "priority,sequence,timestamp,flags[,...];message"
+// is about the OOM killer killing that process, and its timestamp (in microseconds) is not earlier than since.
+func kmsgOOMRecord(record string, pid int, since time.Duration) bool {
+	header, msg, ok := strings.Cut(record, ";")
+	if !ok {
+		return false
+	}
+
+	fields := strings.Split(header, ",")
+	if len(fields) < 3 {
+		return false
+	}
+	usec, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || time.Duration(usec)*time.Microsecond < since {
+		return false
+	}
+
+	// "Out of memory: Killed process 1234 (name) ..." or "Memory cgroup out of memory: Killed process 1234 ..."
+	return strings.Contains(msg, fmt.Sprintf("Killed process %d ", pid))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestKmsgOOMRecord(t *testing.T) {
+	since := 100 * time.Second
+
+	for name, tc := range map[string]struct {
+		record   string
+		expected bool
+	}{
+		"Match": {
+			record:   "3,1234,100000001,-;Out of memory: Killed process 42 (sh) total-vm:1kB\n",
+			expected: true,
+		},
+		"MatchCgroup": {
+			record:   "3,1234,200000000,-,caller=T1;Memory cgroup out of memory: Killed process 42 (sh)\n",
+			expected: true,
+		},
+		"AtStart": {
+			record:   "3,1234,100000000,-;Out of memory: Killed process 42 (sh)\n",
+			expected: true,
+		},
+		"BeforeStart": {
+			record:   "3,1234,99999999,-;Out of memory: Killed process 42 (sh)\n",
+			expected: false,
+		},
+		"OtherPID": {
+			record:   "3,1234,200000000,-;Out of memory: Killed process 420 (sh)\n",
+			expected: false,
+		},
+		"OtherMessage": {
+			record:   "6,1234,200000000,-;process 42 (sh) exited\n",
+			expected: false,
+		},
+		"BadTimestamp": {
+			record:   "3,1234,x,-;Out of memory: Killed process 42 (sh)\n",
+			expected: false,
+		},
+		"NoHeader": {
+			record:   "Out of memory: Killed process 42 (sh)\n",
+			expected: false,
+		},
+	} {
+		if actual := kmsgOOMRecord(tc.record, 42, since); actual != tc.expected {
+			t.Errorf("%s: expected %t, got %t", name, tc.expected, actual)
+		}
+	}
+}
+
+func TestReadOOMKills(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		content  string
+		expected int
+		err      bool
+	}{
+		"MemoryEvents": {
+			content:  "low 0\nhigh 0\nmax 3\noom 2\noom_kill 1\noom_group_kill 0\n",
+			expected: 1,
+		},
+		"OOMControl": {
+			content:  "oom_kill_disable 0\nunder_oom 0\noom_kill 5\n",
+			expected: 5,
+		},
+		"NoCounter": {
+			content: "low 0\nhigh 0\n",
+			err:     true,
+		},
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(tc.content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := readOOMKills(path)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected error, got %d", name, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if actual != tc.expected {
+			t.Errorf("%s: expected %d, got %d", name, tc.expected, actual)
+		}
+	}
+}
+
+func TestOOMWatchUsesCounter(t *testing.T) {
+	events := filepath.Join(t.TempDir(), "memory.events")
+	write := func(kills string) {
+		t.Helper()
+		if err := os.WriteFile(events, []byte("oom 0\noom_kill "+kills+"\n"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// PID 1 would match if kmsg were used; the counter is authoritative
+	w := &oomWatch{pid: 1, events: events, kills: 2, hasStart: true}
+
+	write("2")
+	if w.killed(&exitResult{signal: syscall.SIGKILL}) {
+		t.Error("expected not killed with unchanged counter")
+	}
+
+	write("3")
+	if !w.killed(&exitResult{signal: syscall.SIGKILL}) {
+		t.Error("expected killed with incremented counter")
+	}
+	if w.killed(&exitResult{signal: syscall.SIGTERM}) {
+		t.Error("expected not killed by SIGTERM")
+	}
+}
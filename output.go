@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"log/syslog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// output routes program's stdout and stderr.
+type output struct {
+	// destinations of program's stdout and stderr instead of ruc's own ones, if not nil
+	stdout, stderr io.Writer
+	syslog         bool // one of destinations expects whole lines
+
+	// additional destinations for both streams, besides ruc's own stdout and stderr;
+	// they should never return errors, as that would stop the copying
+	extra []io.Writer
+
+	merge   bool       // write whole tagged lines to extra destinations
+	linesMu sync.Mutex // serializes tagged lines of all streams
+
+	filters []outputFilter
+	redact  redactor
+	alerts  []alertPattern // checked in order on stderr lines
+
+	restartOn *regexp.Regexp
+	killOn    *regexp.Regexp
+
+	parseStatus bool            // parse statusLinePrefix lines on stdout
+	recycleOn   map[string]bool // restart on those statuses
+	directives  map[string]bool // obey those directives on stdout
+}
+
+// statusLinePrefix is the prefix of stdout lines with the program's health status.
+const statusLinePrefix = "RUC: STATUS="
+
+// parseStatusLine returns the program's health status from the given line, if it is a status line.
+func parseStatusLine(line []byte) (string, bool) {
+	st, ok := bytes.CutPrefix(bytes.TrimRight(line, "\r\n"), []byte(statusLinePrefix))
+	if !ok {
+		return "", false
+	}
+	return string(bytes.TrimSpace(st)), true
+}
+
+// directivePrefix is the prefix of stdout lines with directives to ruc, such as "RUC: RESTART".
+const directivePrefix = "RUC: "
+
+// Directives the program can print.
+const (
+	directiveExtend  = "EXTEND"  // EXTEND=duration: extend the current run period
+	directiveRestart = "RESTART" // gracefully restart
+	directiveReady   = "READY"   // the program is ready; waited for before starting the run period
+)
+
+// parseDirectives parses comma-separated list of enabled directives.
+func parseDirectives(s string) (map[string]bool, error) {
+	res := make(map[string]bool)
+	for _, d := range strings.Split(s, ",") {
+		d = strings.ToUpper(strings.TrimSpace(d))
+		switch d {
+		case directiveExtend, directiveRestart, directiveReady:
+			res[d] = true
+		default:
+			return nil, fmt.Errorf("unknown directive %q", d)
+		}
+	}
+	return res, nil
+}
+
+// parseDirectiveLine returns the directive and its argument from the given line, if it is a directive line.
+func parseDirectiveLine(line []byte) (directive, arg string, ok bool) {
+	d, ok := bytes.CutPrefix(bytes.TrimRight(line, "\r\n"), []byte(directivePrefix))
+	if !ok {
+		return "", "", false
+	}
+	directive, arg, _ = strings.Cut(string(bytes.TrimSpace(d)), "=")
+	return directive, arg, true
+}
+
+// setDestination sets destination of program's stdout or stderr:
+//
+//	inherit        - ruc's own stdout or stderr (default)
+//	discard        - /dev/null
+//	file:/path     - file opened for appending (created if needed)
+//	syslog[:tag]   - local syslog, with info priority for stdout and err priority for stderr
+func (o *output) setDestination(stderr bool, s string) error {
+	var w io.Writer
+	var err error
+	switch {
+	case s == "inherit":
+		// nothing
+	case s == "discard":
+		w, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	case strings.HasPrefix(s, "file:"):
+		w, err = os.OpenFile(strings.TrimPrefix(s, "file:"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+	case s == "syslog", strings.HasPrefix(s, "syslog:"):
+		prio := syslog.LOG_INFO
+		if stderr {
+			prio = syslog.LOG_ERR
+		}
+		w, err = syslog.New(prio|syslog.LOG_DAEMON, strings.TrimPrefix(strings.TrimPrefix(s, "syslog"), ":"))
+		o.syslog = true
+	default:
+		return fmt.Errorf("unknown destination %q", s)
+	}
+	if err != nil {
+		return err
+	}
+
+	if stderr {
+		o.stderr = w
+	} else {
+		o.stdout = w
+	}
+	return nil
+}
+
+// outputFilter includes or excludes output lines matching regular expression.
+type outputFilter struct {
+	re      *regexp.Regexp
+	exclude bool
+}
+
+// addFilter adds output filter: regular expression for lines to include,
+// or, if prefixed with "!", for lines to exclude.
+func (o *output) addFilter(s string) error {
+	f := outputFilter{exclude: strings.HasPrefix(s, "!")}
+
+	var err error
+	if f.re, err = regexp.Compile(strings.TrimPrefix(s, "!")); err != nil {
+		return err
+	}
+
+	o.filters = append(o.filters, f)
+	return nil
+}
+
+// pass returns true if the line passes filters: it matches any include filter (if there are some),
+// and does not match any exclude filter.
+func (o *output) pass(line []byte) bool {
+	var included, hasIncludes bool
+	for _, f := range o.filters {
+		if f.exclude {
+			if f.re.Match(line) {
+				return false
+			}
+			continue
+		}
+
+		hasIncludes = true
+		if !included && f.re.Match(line) {
+			included = true
+		}
+	}
+
+	return included || !hasIncludes
+}
+
+// alertPattern maps stderr lines matching regular expression to an alert severity;
+// severities are also notification event kinds.
+type alertPattern struct {
+	severity string
+	re       *regexp.Regexp
+}
+
+// defaultAlerts are -stderr-alerts patterns for common log formats; fatal ones go first.
+var defaultAlerts = []alertPattern{
+	{eventFatal, regexp.MustCompile(`\b(?:FATAL|PANIC|CRITICAL)\b|\blevel=(?:fatal|panic|critical)\b|^panic: `)},
+	{eventError, regexp.MustCompile(`\bERROR\b|\blevel=error\b`)},
+}
+
+// addAlert adds severity=regexp stderr alert pattern.
+func (o *output) addAlert(s string) error {
+	severity, expr, ok := strings.Cut(s, "=")
+	if !ok || (severity != eventError && severity != eventFatal) {
+		return fmt.Errorf("invalid stderr alert %q, expected error=regexp or fatal=regexp", s)
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+
+	o.alerts = append(o.alerts, alertPattern{severity: severity, re: re})
+	return nil
+}
+
+// alertSeverity returns the severity of the first alert pattern matching the line, or an empty string.
+func (o *output) alertSeverity(line []byte) string {
+	for _, a := range o.alerts {
+		if a.re.Match(line) {
+			return a.severity
+		}
+	}
+	return ""
+}
+
+// trigger is an action requested by the program's output.
+type trigger struct {
+	kill   bool          // kill immediately instead of graceful restart
+	ready  bool          // the program is ready instead
+	extend time.Duration // extend the run period instead
+	reason string
+}
+
+// streams are program's stdout and stderr writers for a single run.
+type streams struct {
+	stdout   io.Writer
+	stderr   io.Writer
+	flush    func()       // should be called after the program exits and all output is copied
+	triggers chan trigger // actions requested by the output
+}
+
+// streams returns writers for program's stdout and stderr; program's status reports are tracked by t.
+// If prefix is not empty, it is added to all lines; color is an ANSI escape sequence for it on ruc's own streams.
+// Stderr lines matching alert patterns are passed (redacted) to alert.
+func (o *output) streams(t *statusTracker, prefix, color string, alert func(severity, line string)) *streams {
+	stdout, stderr := o.stdout, o.stderr
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+
+	s := &streams{
+		stdout:   stdout,
+		stderr:   stderr,
+		flush:    func() {},
+		triggers: make(chan trigger, 8),
+	}
+
+	merge := o.merge && len(o.extra) > 0
+	lines := len(o.filters) > 0 || o.redact.active() || o.restartOn != nil || o.killOn != nil || o.parseStatus || len(o.directives) > 0 || len(o.alerts) > 0 || merge || o.syslog || prefix != ""
+	if len(o.extra) == 0 && !lines {
+		// let the program write directly
+		return s
+	}
+
+	tag := func(w io.Writer, tag string) io.Writer {
+		if tag == "" {
+			return w
+		}
+		return &taggedWriter{m: &o.linesMu, tag: tag, w: w}
+	}
+	outTag, errTag := prefix, prefix
+	if color != "" && prefix != "" {
+		// only ruc's own streams are colored
+		if o.stdout == nil {
+			outTag = color + prefix + "\x1b[0m"
+		}
+		if o.stderr == nil {
+			errTag = color + prefix + "\x1b[0m"
+		}
+	}
+	s.stdout = tag(stdout, outTag)
+	s.stderr = tag(stderr, errTag)
+
+	if len(o.extra) > 0 {
+		extraOutTag, extraErrTag := prefix, prefix
+		if merge {
+			extraOutTag += "stdout: "
+			extraErrTag += "stderr: "
+		}
+		extra := io.MultiWriter(o.extra...)
+		s.stdout = io.MultiWriter(s.stdout, tag(extra, extraOutTag))
+		s.stderr = io.MultiWriter(s.stderr, tag(extra, extraErrTag))
+	}
+	if !lines {
+		return s
+	}
+
+	outLW := newLineWriter(o.lineFunc(s.stdout, true, t, s.triggers, nil))
+	errLW := newLineWriter(o.lineFunc(s.stderr, false, t, s.triggers, alert))
+	s.stdout, s.stderr = outLW, errLW
+	s.flush = func() {
+		outLW.flush()
+		errLW.flush()
+	}
+
+	return s
+}
+
+// lineFunc returns a function that processes a single line of stdout or stderr and writes it to w.
+func (o *output) lineFunc(w io.Writer, stdout bool, t *statusTracker, triggers chan<- trigger, alert func(severity, line string)) func(line []byte) {
+	sendTrigger := func(t trigger) {
+		select {
+		case triggers <- t:
+		default:
+			// there are enough triggers already
+		}
+	}
+
+	return func(line []byte) {
+		// check unfiltered and unredacted line
+		switch {
+		case o.killOn != nil && o.killOn.Match(line):
+			sendTrigger(trigger{kill: true, reason: "output matched -kill-on-output"})
+		case o.restartOn != nil && o.restartOn.Match(line):
+			sendTrigger(trigger{reason: "output matched -restart-on-output"})
+		}
+
+		if stdout && o.parseStatus {
+			if st, ok := parseStatusLine(line); ok {
+				t.update(func(s *status) {
+					s.ProgramStatus = st
+				})
+				if o.recycleOn[st] {
+					sendTrigger(trigger{reason: fmt.Sprintf("program reported %s status", st)})
+				}
+			}
+		}
+
+		if stdout && len(o.directives) > 0 {
+			if d, arg, ok := parseDirectiveLine(line); ok && o.directives[d] {
+				switch d {
+				case directiveExtend:
+					if ext, err := parseDuration(arg); err == nil && ext > 0 {
+						sendTrigger(trigger{extend: ext, reason: "program requested extension"})
+					}
+				case directiveRestart:
+					sendTrigger(trigger{reason: "program requested restart"})
+				case directiveReady:
+					sendTrigger(trigger{ready: true, reason: "program reported readiness"})
+				}
+			}
+		}
+
+		// filtered out lines still raise alerts
+		if alert != nil {
+			if severity := o.alertSeverity(line); severity != "" {
+				msg := line
+				if o.redact.active() {
+					msg = o.redact.redact(msg)
+				}
+				alert(severity, string(bytes.TrimRight(msg, "\r\n")))
+			}
+		}
+
+		if !o.pass(line) {
+			return
+		}
+
+		if o.redact.active() {
+			line = o.redact.redact(line)
+		}
+
+		_, _ = w.Write(line)
+	}
+}
+
+// maxLineLength is the maximum length of a line after which it is processed even without a newline.
+const maxLineLength = 64 * 1024
+
+// lineWriter splits written data into lines and passes them to f (with trailing newlines, if any).
+type lineWriter struct {
+	m   sync.Mutex
+	buf []byte
+	f   func(line []byte)
+}
+
+func newLineWriter(f func(line []byte)) *lineWriter {
+	return &lineWriter{f: f}
+}
+
+// Write implements io.Writer.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.f(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+
+	if len(w.buf) >= maxLineLength {
+		w.f(w.buf)
+		w.buf = nil
+	}
+
+	return len(p), nil
+}
+
+// flush passes the incomplete last line, if any, to f.
+func (w *lineWriter) flush() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if len(w.buf) > 0 {
+		w.f(w.buf)
+		w.buf = nil
+	}
+}
+
+// taggedWriter prefixes each written line with a tag, and writes it with a single call
+// while holding a mutex shared with other streams' writers, so lines are never interleaved.
+type taggedWriter struct {
+	m   *sync.Mutex
+	tag string
+	w   io.Writer
+}
+
+// Write implements io.Writer. p should be a single line; newline is added if it is missing.
+func (w *taggedWriter) Write(p []byte) (int, error) {
+	b := make([]byte, 0, len(w.tag)+len(p)+1)
+	b = append(append(b, w.tag...), p...)
+	if !bytes.HasSuffix(b, []byte("\n")) {
+		b = append(b, '\n')
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if _, err := w.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// activityWriter records the time of the last write.
+type activityWriter struct {
+	w    io.Writer
+	last *atomic.Int64 // Unix nanoseconds
+}
+
+// Write implements io.Writer.
+func (w *activityWriter) Write(p []byte) (int, error) {
+	w.last.Store(clk.Now().UnixNano())
+	return w.w.Write(p)
+}
+
+// digestWriter counts and hashes program's stdout for -output-digest.
+// It is written only by the copying goroutine, and read after the program exits.
+type digestWriter struct {
+	w     io.Writer
+	n     int64
+	lines int64
+	hash  hash.Hash
+}
+
+// newDigestWriter returns a digestWriter writing to w.
+func newDigestWriter(w io.Writer) *digestWriter {
+	return &digestWriter{w: w, hash: sha256.New()}
+}
+
+// Write implements io.Writer.
+func (w *digestWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	w.lines += int64(bytes.Count(p, []byte{'\n'}))
+	_, _ = w.hash.Write(p)
+	return w.w.Write(p)
+}
+
+// digest returns the digest of the output written so far.
+func (w *digestWriter) digest() *outputDigest {
+	return &outputDigest{
+		Bytes:  w.n,
+		Lines:  w.lines,
+		SHA256: hex.EncodeToString(w.hash.Sum(nil)),
+	}
+}
+
+// fifoWriter writes to a named pipe that is held open by ruc across program restarts.
+// If pipe is full (there is no reader, or it is too slow), output is dropped to avoid blocking the program.
+type fifoWriter struct {
+	path     string
+	fd       int
+	dropping atomic.Bool
+}
+
+// newFIFOWriter creates (if needed) and opens a named pipe.
+func newFIFOWriter(path string) (*fifoWriter, error) {
+	if err := syscall.Mkfifo(path, 0o644); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s exists and is not a named pipe", path)
+	}
+
+	// open for both reading and writing so that open does not block without a reader,
+	// and writes do not fail with EPIPE when reader goes away
+	fd, err := syscall.Open(path, syscall.O_RDWR|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	return &fifoWriter{path: path, fd: fd}, nil
+}
+
+// Write implements io.Writer. It never returns an error.
+//
+// Writes up to PIPE_BUF bytes are atomic, so lines are not interleaved or cut unless they are longer than that.
+func (w *fifoWriter) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		n, err := syscall.Write(w.fd, p[written:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			if !w.dropping.Swap(true) {
+				log.Printf("Output FIFO %s is full, dropping output: %s", w.path, err)
+			}
+			return len(p), nil
+		}
+		written += n
+	}
+
+	if w.dropping.Swap(false) {
+		log.Printf("Output FIFO %s is not full anymore.", w.path)
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/AlekSi/ruc/testutil"
+)
+
+func TestFIFOWriterDropsWhenFull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output")
+	w, err := newFIFOWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(w.fd) })
+
+	// there is no reader: writes fill the pipe buffer, and then are dropped without blocking or errors
+	line := bytes.Repeat([]byte("x"), 1023)
+	line = append(line, '\n')
+	for i := 0; i < 1024 && !w.dropping.Load(); i++ {
+		if n, err := w.Write(line); n != len(line) || err != nil {
+			t.Fatalf("Write: %d, %v", n, err)
+		}
+	}
+	if !w.dropping.Load() {
+		t.Fatal("expected the pipe to become full")
+	}
+
+	r, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// the reader gets whole buffered lines, then output written after draining
+	buf := make([]byte, 1<<20)
+	n, _ := r.Read(buf)
+	if n == 0 || n%len(line) != 0 {
+		t.Fatalf("expected whole lines, read %d bytes", n)
+	}
+
+	if _, err = w.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+	if w.dropping.Load() {
+		t.Error("expected writes to resume after the reader drained the pipe")
+	}
+	if n, _ = r.Read(buf); string(buf[:n]) != "after\n" {
+		t.Errorf("expected %q, got %q", "after\n", buf[:n])
+	}
+}
+
+func TestOutputFIFONotCreatedByCheckAndGen(t *testing.T) {
+	bin := testutil.BuildRuc(t)
+
+	for _, args := range [][]string{{"check"}, {"gen", "systemd"}} {
+		path := filepath.Join(t.TempDir(), "output")
+		args = append(args, "-output-fifo", path, "true")
+		if out, err := exec.Command(bin, args...).CombinedOutput(); err != nil {
+			t.Fatalf("ruc %s: %s\n%s", strings.Join(args, " "), err, out)
+		}
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("ruc %s created the FIFO: %v", args[0], err)
+		}
+	}
+}
+
+func TestOutputFilters(t *testing.T) {
+	for name, tc := range map[string]struct {
+		filters []string
+		pass    []string
+		drop    []string
+	}{
+		"None": {
+			pass: []string{"anything", ""},
+		},
+		"Include": {
+			filters: []string{"^ERROR", "WARN"},
+			pass:    []string{"ERROR: boom", "a WARN b"},
+			drop:    []string{"INFO: ok", " ERROR"},
+		},
+		"Exclude": {
+			filters: []string{"!healthz", "!^DEBUG"},
+			pass:    []string{"GET /", "INFO DEBUG"},
+			drop:    []string{"GET /healthz", "DEBUG: x"},
+		},
+		"ExcludeWins": {
+			filters: []string{"GET", "!healthz"},
+			pass:    []string{"GET /"},
+			drop:    []string{"GET /healthz", "POST /"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var o output
+			for _, f := range tc.filters {
+				if err := o.addFilter(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, l := range tc.pass {
+				if !o.pass([]byte(l)) {
+					t.Errorf("expected %q to pass", l)
+				}
+			}
+			for _, l := range tc.drop {
+				if o.pass([]byte(l)) {
+					t.Errorf("expected %q to be dropped", l)
+				}
+			}
+		})
+	}
+
+	var o output
+	if err := o.addFilter("!("); err == nil {
+		t.Error("expected invalid regular expression to be rejected")
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	for s, expected := range map[string][]string{
+		"RESTART":              {directiveRestart},
+		"restart":              {directiveRestart},
+		" Ready , extend ":     {directiveReady, directiveExtend},
+		"EXTEND,RESTART,READY": {directiveExtend, directiveRestart, directiveReady},
+		"restart,RESTART":      {directiveRestart},
+	} {
+		actual, err := parseDirectives(s)
+		if err != nil {
+			t.Errorf("%q: %s", s, err)
+			continue
+		}
+		if len(actual) != len(expected) {
+			t.Errorf("%q: expected %q, got %v", s, expected, actual)
+		}
+		for _, d := range expected {
+			if !actual[d] {
+				t.Errorf("%q: expected %s to be enabled, got %v", s, d, actual)
+			}
+		}
+	}
+
+	for _, s := range []string{"", "STOP", "RESTART,", "RESTART,STOP", "RUC: RESTART", "EXTEND=1m"} {
+		if _, err := parseDirectives(s); err == nil || !strings.Contains(err.Error(), "unknown directive") {
+			t.Errorf("%q: expected unknown directive error, got %v", s, err)
+		}
+	}
+}
+
+func TestParseDirectiveLine(t *testing.T) {
+	for line, tc := range map[string]struct {
+		directive string
+		arg       string
+		ok        bool
+	}{
+		"RUC: RESTART\n":      {directiveRestart, "", true},
+		"RUC: RESTART\r\n":    {directiveRestart, "", true},
+		"RUC: READY":          {directiveReady, "", true},
+		"RUC:   READY  \n":    {directiveReady, "", true},
+		"RUC: EXTEND=10m\n":   {directiveExtend, "10m", true},
+		"RUC: EXTEND=\n":      {directiveExtend, "", true},
+		"RUC: EXTEND=a=b\n":   {directiveExtend, "a=b", true},
+		"RUC: restart\n":      {"restart", "", true},
+		"RUC: \n":             {"", "", true},
+		"RUC: STATUS=ok\n":    {"STATUS", "ok", true},
+		"RUC:RESTART\n":       {"", "", false},
+		"ruc: RESTART\n":      {"", "", false},
+		" RUC: RESTART\n":     {"", "", false},
+		"log: RUC: RESTART\n": {"", "", false},
+		"\n":                  {"", "", false},
+	} {
+		directive, arg, ok := parseDirectiveLine([]byte(line))
+		if directive != tc.directive || arg != tc.arg || ok != tc.ok {
+			t.Errorf("%q: expected %q, %q, %t; got %q, %q, %t", line, tc.directive, tc.arg, tc.ok, directive, arg, ok)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+)
+
+// Restart modes.
+const (
+	restartModeSequential = "sequential" // stop the current instance, then start the next one
+	restartModeOverlap    = "overlap"    // start the next instance, wait for it to become ready, then stop the current one
+)
+
+// waitOverlapReady waits for the next program instance started in overlap restart mode to become ready,
+// for up to -start-timeout (if set).
+// The readiness notification is consumed, so the next run does not wait for it again.
+func waitOverlapReady(ctx context.Context, opts *options, next *command) error {
+	if opts.startTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.startTimeout)
+		defer cancel()
+	}
+
+	if opts.readyProbe != nil {
+		return waitReady(ctx, opts.readyProbe, opts.readyInterval)
+	}
+
+	notified := make(chan error, 1)
+	go func() {
+		notified <- next.waitNotification()
+	}()
+
+	var err error
+	select {
+	case err = <-notified:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	next.closeNotification()
+	next.notifyR = nil
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paceTimeout is the maximal time of a single -pace-feedback poll.
+const paceTimeout = 10 * time.Second
+
+// pollPace polls -pace-feedback and returns how long restarts should be delayed because downstream is overloaded;
+// 0 means it is not.
+//
+// For URLs, 429 and 503 responses mean overload, for Retry-After seconds if given;
+// other responses with non-2xx status are errors.
+// Commands are run with /bin/sh -c; empty output or "ok" means no overload, a duration (e.g. 30s) means overload for it,
+// and any other output means overload; non-zero exit status is an error.
+// Overload without duration lasts for -pace-delay.
+func pollPace(ctx context.Context, opts *options) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, paceTimeout)
+	defer cancel()
+
+	if command, ok := strings.CutPrefix(opts.paceFeedback, "cmd:"); ok {
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+		cmd.Stderr = os.Stderr
+		b, err := cmd.Output()
+		if err != nil {
+			return 0, err
+		}
+
+		switch s := strings.TrimSpace(string(b)); s {
+		case "", "ok":
+			return 0, nil
+		default:
+			if d, err := parseDuration(s); err == nil && d >= 0 {
+				return d, nil
+			}
+			return opts.paceDelay, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", opts.paceFeedback, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			return time.Duration(s) * time.Second, nil
+		}
+		return opts.paceDelay, nil
+	case resp.StatusCode >= 300:
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return 0, nil
+	}
+}
+
+// waitPace delays the start while -pace-feedback reports overload, for up to -pace-max-delay in total.
+// Feedback errors are logged and do not delay the start.
+func waitPace(ctx context.Context, opts *options, in *instance) error {
+	var total time.Duration
+	for total < opts.paceMax {
+		d, err := pollPace(ctx, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			in.log.Printf("Failed to poll -pace-feedback: %s", err)
+			return nil
+		}
+		if d == 0 {
+			return nil
+		}
+
+		d = min(d, opts.paceMax-total)
+		in.log.Printf("Downstream is overloaded, delaying the next start by %s.", d)
+		if err = sleepUntil(ctx, clk.Now().Add(d)); err != nil {
+			return err
+		}
+		total += d
+	}
+	return nil
+}
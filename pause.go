@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stoppedInterval is how often -pause-stopped checks whether the program is stopped.
+const stoppedInterval = time.Second
+
+// procStopped returns true if the process is stopped by a signal or a debugger,
+// or its cgroup (v2) is frozen.
+func procStopped(pid int) (bool, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false, err
+	}
+
+	// the command name may contain spaces and parentheses
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 || i+2 >= len(b) {
+		return false, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	switch b[i+2] {
+	case 'T', 't':
+		return true, nil
+	}
+
+	return cgroupFrozen(pid), nil
+}
+
+// cgroupFrozen returns true if the process' cgroup v2 is frozen.
+func cgroupFrozen(pid int) bool {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		path, ok := strings.CutPrefix(s.Text(), "0::")
+		if !ok {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join("/sys/fs/cgroup", path, "cgroup.events"))
+		if err != nil {
+			return false
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line == "frozen 1" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// watchStopped sends true to ch when the program is stopped, and false when it is continued.
+// It returns when ctx is canceled.
+func watchStopped(ctx context.Context, pid int, interval time.Duration, ch chan<- bool) {
+	t := clk.NewTimer(interval)
+	defer t.Stop()
+
+	var stopped bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			t.Reset(interval)
+		}
+
+		// skip the check if the program is exiting
+		s, err := procStopped(pid)
+		if err != nil || s == stopped {
+			continue
+		}
+		stopped = s
+
+		select {
+		case ch <- stopped:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
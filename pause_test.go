@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchStopped(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc:", err)
+	}
+
+	fake := fakeClock(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan bool)
+	go watchStopped(ctx, cmd.Process.Pid, stoppedInterval, ch)
+
+	// the state changes are reported on the next check
+	receive := func() bool {
+		t.Helper()
+
+		var stopped, received bool
+		advanceWhile(t, fake, stoppedInterval, func() bool {
+			select {
+			case stopped = <-ch:
+				received = true
+			default:
+			}
+			return received
+		})
+		return stopped
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGSTOP); err != nil {
+		t.Fatal(err)
+	}
+	if !receive() {
+		t.Fatal("expected stopped")
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGCONT); err != nil {
+		t.Fatal(err)
+	}
+	if receive() {
+		t.Fatal("expected continued")
+	}
+}
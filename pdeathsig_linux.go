@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+// setPdeathsig makes the kernel kill the program if ruc dies without cleaning up (e.g. by the OOM killer).
+func setPdeathsig(attr *syscall.SysProcAttr) {
+	attr.Pdeathsig = syscall.SIGKILL
+}
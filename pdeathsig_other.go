@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// setPdeathsig does nothing: parent death signal is Linux-specific.
+func setPdeathsig(attr *syscall.SysProcAttr) {}
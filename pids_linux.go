@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// rlimitNPROC is RLIMIT_NPROC resource number; package syscall does not define it.
+const rlimitNPROC = 6
+
+// pidsCgroup is a cgroup that limits the number of program's tasks with pids controller.
+type pidsCgroup struct {
+	dir string
+	own bool // created by ruc, and should be removed by it
+}
+
+// cgroupPIDsDir returns the directory of the process' cgroup with pids controller
+// (in pids hierarchy with cgroup v1, or in the unified one with cgroup v2).
+func cgroupPIDsDir(pid string) (string, error) {
+	f, err := os.Open("/proc/" + pid + "/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var unified string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(s.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		switch {
+		case strings.Contains(","+parts[1]+",", ",pids,"):
+			return filepath.Join("/sys/fs/cgroup/pids", parts[2]), nil
+		case parts[0] == "0" && parts[1] == "":
+			unified = filepath.Join("/sys/fs/cgroup", parts[2])
+		}
+	}
+	if err = s.Err(); err != nil {
+		return "", err
+	}
+
+	if unified == "" {
+		return "", errors.New("pids cgroup controller is not available")
+	}
+	return unified, nil
+}
+
+// newPIDsCgroup creates a child cgroup of ruc's one with the given name and pids.max limit.
+func newPIDsCgroup(name string, max int) (*pidsCgroup, error) {
+	parent, err := cgroupPIDsDir("self")
+	if err != nil {
+		return nil, err
+	}
+
+	// cgroup v2 requires enabling the controller for children; that is not needed (and fails) with v1
+	if _, err = os.Stat(filepath.Join(parent, "cgroup.subtree_control")); err == nil {
+		_ = os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+pids"), 0o644)
+	}
+
+	dir := filepath.Join(parent, name)
+	if err = os.Mkdir(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(filepath.Join(dir, "pids.max"), []byte(strconv.Itoa(max)), 0o644); err != nil {
+		_ = os.Remove(dir)
+		return nil, err
+	}
+
+	return &pidsCgroup{dir: dir, own: true}, nil
+}
+
+// pidsCgroupOf returns the existing cgroup of the process (for example, a systemd scope with TasksMax).
+func pidsCgroupOf(pid int) (*pidsCgroup, error) {
+	dir, err := cgroupPIDsDir(strconv.Itoa(pid))
+	if err != nil {
+		return nil, err
+	}
+	return &pidsCgroup{dir: dir}, nil
+}
+
+// add moves the process into the cgroup.
+// Processes forked by it before that stay in ruc's cgroup.
+func (c *pidsCgroup) add(pid int) error {
+	return os.WriteFile(filepath.Join(c.dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0o644)
+}
+
+// limitHits returns the number of times forks failed because of pids.max limit.
+func (c *pidsCgroup) limitHits() (int, error) {
+	b, err := os.ReadFile(filepath.Join(c.dir, "pids.events"))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if v, ok := strings.CutPrefix(line, "max "); ok {
+			return strconv.Atoi(strings.TrimSpace(v))
+		}
+	}
+	return 0, fmt.Errorf("%s: no max counter", c.dir)
+}
+
+// remove kills processes left in the cgroup created by ruc, and removes it.
+func (c *pidsCgroup) remove() error {
+	if !c.own {
+		return nil
+	}
+
+	var err error
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		b, _ := os.ReadFile(filepath.Join(c.dir, "cgroup.procs"))
+		for _, f := range strings.Fields(string(b)) {
+			if pid, _ := strconv.Atoi(f); pid > 0 {
+				_ = syscall.Kill(pid, syscall.SIGKILL)
+			}
+		}
+
+		if err = os.Remove(c.dir); err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// rlimitNPROC is RLIMIT_NPROC resource number on BSDs and macOS; package syscall does not define it.
+const rlimitNPROC = 7
+
+// errNoCgroups is returned on systems without cgroups.
+var errNoCgroups = errors.New("cgroups are Linux-specific")
+
+// pidsCgroup is a cgroup that limits the number of program's tasks; it is not available on this system.
+type pidsCgroup struct {
+	dir string
+}
+
+// newPIDsCgroup returns an error: cgroups are Linux-specific.
+func newPIDsCgroup(name string, max int) (*pidsCgroup, error) { return nil, errNoCgroups }
+
+// pidsCgroupOf returns an error: cgroups are Linux-specific.
+func pidsCgroupOf(pid int) (*pidsCgroup, error) { return nil, errNoCgroups }
+
+func (c *pidsCgroup) add(pid int) error       { return errNoCgroups }
+func (c *pidsCgroup) limitHits() (int, error) { return 0, errNoCgroups }
+func (c *pidsCgroup) remove() error           { return nil }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// probe checks whether the program is ready; it returns nil if it is.
+type probe func(ctx context.Context) error
+
+// parseProbe parses readiness probe specification:
+//
+//	tcp://host:port      - TCP connection can be established
+//	http(s)://host/path  - GET request returns 2xx or 3xx status code
+//	file:///path         - file exists
+//	cmd:command          - shell command exits with zero status
+func parseProbe(s string) (probe, error) {
+	switch {
+	case strings.HasPrefix(s, "tcp://"):
+		addr := strings.TrimPrefix(s, "tcp://")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid TCP probe %q: %w", s, err)
+		}
+		return func(ctx context.Context) error {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}, nil
+
+	case strings.HasPrefix(s, "http://"), strings.HasPrefix(s, "https://"):
+		if _, err := http.NewRequest("GET", s, nil); err != nil {
+			return nil, fmt.Errorf("invalid HTTP probe %q: %w", s, err)
+		}
+		return func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, "GET", s, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				return fmt.Errorf("unexpected status %s", resp.Status)
+			}
+			return nil
+		}, nil
+
+	case strings.HasPrefix(s, "file://"):
+		path := strings.TrimPrefix(s, "file://")
+		if path == "" {
+			return nil, fmt.Errorf("invalid file probe %q: empty path", s)
+		}
+		return func(ctx context.Context) error {
+			_, err := os.Stat(path)
+			return err
+		}, nil
+
+	case strings.HasPrefix(s, "cmd:"):
+		command := strings.TrimPrefix(s, "cmd:")
+		if command == "" {
+			return nil, fmt.Errorf("invalid command probe %q: empty command", s)
+		}
+		return func(ctx context.Context) error {
+			return exec.CommandContext(ctx, "/bin/sh", "-c", command).Run()
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown probe %q", s)
+	}
+}
+
+// waitReady calls probe every interval until it succeeds or ctx is canceled.
+func waitReady(ctx context.Context, p probe, interval time.Duration) error {
+	t := clk.NewTimer(interval)
+	defer t.Stop()
+
+	for {
+		// do not let a single hanging check block the whole wait
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		err := p(checkCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C():
+			t.Reset(interval)
+		}
+	}
+}
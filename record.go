@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// recordEntry is a single line of a -record file: the header, an output chunk, or the exit trailer.
+type recordEntry struct {
+	// header
+	RunID   string     `json:"run_id,omitempty"`
+	Seed    int64      `json:"seed,omitempty"`
+	Command []string   `json:"command,omitempty"`
+	Start   *time.Time `json:"start,omitempty"`
+
+	// output chunk
+	Offset time.Duration `json:"offset,omitempty"` // since the start
+	Stream string        `json:"stream,omitempty"` // stdout or stderr
+	Data   string        `json:"data,omitempty"`
+
+	// trailer
+	Exit *string `json:"exit,omitempty"`
+}
+
+// recorder writes program's output chunks with timestamps into a JSON lines file.
+type recorder struct {
+	m      sync.Mutex
+	f      *os.File
+	e      *json.Encoder
+	start  time.Time
+	redact *redactor
+}
+
+// newRecorder creates dir/<run ID>.jsonl file and writes the header.
+func newRecorder(dir, runID string, seed int64, argv []string, redact *redactor) (*recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, runID+".jsonl"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &recorder{
+		f:      f,
+		e:      json.NewEncoder(f),
+		start:  time.Now(),
+		redact: redact,
+	}
+	r.e.SetEscapeHTML(false)
+	if err = r.e.Encode(recordEntry{RunID: runID, Seed: seed, Command: argv, Start: &r.start}); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// recordWriter is an io.Writer for a single stream.
+type recordWriter struct {
+	r      *recorder
+	stream string
+}
+
+// Write implements io.Writer. Write errors are ignored so they do not stop the output.
+func (w *recordWriter) Write(p []byte) (int, error) {
+	w.r.m.Lock()
+	defer w.r.m.Unlock()
+
+	if w.r.f == nil {
+		return len(p), nil
+	}
+
+	data := p
+	if w.r.redact.active() {
+		data = w.r.redact.redact(p)
+	}
+	_ = w.r.e.Encode(recordEntry{Offset: time.Since(w.r.start), Stream: w.stream, Data: string(data)})
+	return len(p), nil
+}
+
+// writer returns writer for the given stream.
+func (r *recorder) writer(stream string) io.Writer {
+	return &recordWriter{r: r, stream: stream}
+}
+
+// close writes the trailer with program's exit result, and closes the file.
+func (r *recorder) close(err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	exit := "exit status 0"
+	if err != nil {
+		exit = err.Error()
+	}
+	_ = r.e.Encode(recordEntry{Offset: time.Since(r.start), Exit: &exit})
+	_ = r.f.Close()
+	r.f = nil
+}
+
+// replay implements `ruc replay` subcommand.
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speedF := fs.Float64("speed", 1, "Playback speed multiplier; 0 prints everything without delays")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay [flags] file\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Re-prints program's output recorded with -record, with the original timing.\n")
+		fmt.Fprintf(fs.Output(), "Flags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 || *speedF < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := replayFile(fs.Arg(0), *speedF, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// replayFile writes recorded output to stdout and stderr, sleeping between chunks.
+func replayFile(path string, speed float64, stdout, stderr io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+
+	start := time.Now()
+	for s.Scan() {
+		var e recordEntry
+		if err = json.Unmarshal(s.Bytes(), &e); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if speed > 0 {
+			time.Sleep(time.Until(start.Add(time.Duration(float64(e.Offset) / speed))))
+		}
+
+		switch {
+		case e.RunID != "":
+			fmt.Fprintf(stderr, "ruc replay: run %s (seed %d) started at %s: %v\n", e.RunID, e.Seed, e.Start.Local().Format(time.DateTime), e.Command)
+		case e.Exit != nil:
+			fmt.Fprintf(stderr, "ruc replay: program exited after %s: %s\n", e.Offset.Round(time.Millisecond), *e.Exit)
+		case e.Stream == "stderr":
+			_, _ = io.WriteString(stderr, e.Data)
+		default:
+			_, _ = io.WriteString(stdout, e.Data)
+		}
+	}
+
+	return s.Err()
+}
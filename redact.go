@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redacted replaces secrets.
+const redacted = "[REDACTED]"
+
+// redactor masks secrets in program's output and ruc's own logs.
+type redactor struct {
+	res []*regexp.Regexp
+
+	m      sync.RWMutex // protects values added while the program runs
+	values [][]byte
+}
+
+// addPattern adds a regular expression matching secrets.
+// If it contains capturing groups, only them are masked; otherwise, the whole match is.
+// Patterns matching the empty string are rejected, as they would mask nothing between every byte.
+func (r *redactor) addPattern(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	if re.MatchString("") {
+		return fmt.Errorf("pattern %q matches the empty string", s)
+	}
+
+	r.res = append(r.res, re)
+	return nil
+}
+
+// addEnv adds the value of the environment variable with the given name as a secret.
+func (r *redactor) addEnv(name string) error {
+	if name == "" || strings.Contains(name, "=") {
+		return fmt.Errorf("invalid environment variable name %q", name)
+	}
+
+	r.addValue(os.Getenv(name))
+	return nil
+}
+
+// addValue adds a secret value; it is safe to call it concurrently with redact.
+func (r *redactor) addValue(v string) {
+	if v == "" {
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, value := range r.values {
+		if string(value) == v {
+			return
+		}
+	}
+	r.values = append(r.values, []byte(v))
+}
+
+// active returns true if there is anything to redact.
+func (r *redactor) active() bool {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return len(r.res) > 0 || len(r.values) > 0
+}
+
+// redact returns b with secrets masked. It may return b itself if there is nothing to mask.
+func (r *redactor) redact(b []byte) []byte {
+	r.m.RLock()
+	values := r.values
+	r.m.RUnlock()
+
+	for _, v := range values {
+		if bytes.Contains(b, v) {
+			b = bytes.ReplaceAll(b, v, []byte(redacted))
+		}
+	}
+
+	for _, re := range r.res {
+		matches := re.FindAllSubmatchIndex(b, -1)
+		if matches == nil {
+			continue
+		}
+
+		var res []byte
+		var last int
+		for _, m := range matches {
+			// whole match if there are no groups, or groups otherwise
+			pairs := m[:2]
+			if len(m) > 2 {
+				pairs = m[2:]
+			}
+
+			for i := 0; i < len(pairs); i += 2 {
+				start, end := pairs[i], pairs[i+1]
+				if start < last || start == end { // unmatched (-1), nested, or empty group or match (like \b)
+					continue
+				}
+				res = append(res, b[last:start]...)
+				res = append(res, redacted...)
+				last = end
+			}
+		}
+		b = append(res, b[last:]...)
+	}
+
+	return b
+}
+
+// redactString is redact for strings.
+func (r *redactor) redactString(s string) string {
+	if !r.active() {
+		return s
+	}
+	return string(r.redact([]byte(s)))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// registrar registers the program in service discovery while it is running,
+// so it could be removed from load balancing before being stopped.
+type registrar interface {
+	// register registers the program instance with the given PID.
+	register(ctx context.Context, pid int) error
+
+	// deregister removes registration.
+	deregister(ctx context.Context) error
+}
+
+// registrarTimeout is the maximum time for a single registration or deregistration.
+const registrarTimeout = 5 * time.Second
+
+// fileRegistrar is a registrar that creates a file with instance information,
+// and removes it on deregistration.
+type fileRegistrar struct {
+	path string
+}
+
+func (r *fileRegistrar) register(ctx context.Context, pid int) error {
+	host, _ := os.Hostname()
+	return writeFileAtomic(r.path, map[string]any{"host": host, "pid": pid})
+}
+
+func (r *fileRegistrar) deregister(ctx context.Context) error {
+	return os.Remove(r.path)
+}
+
+// consulRegistrar is a registrar using Consul agent's service API.
+type consulRegistrar struct {
+	addr    string
+	name    string
+	address string
+	port    int
+}
+
+// id returns service ID unique for this ruc process.
+func (r *consulRegistrar) id() string {
+	return fmt.Sprintf("%s-%s", r.name, lockOwner())
+}
+
+func (r *consulRegistrar) register(ctx context.Context, pid int) error {
+	in := map[string]any{
+		"ID":      r.id(),
+		"Name":    r.name,
+		"Address": r.address,
+		"Port":    r.port,
+		"Meta":    map[string]string{"pid": fmt.Sprint(pid)},
+	}
+	return postJSON(ctx, "PUT", r.addr+"/v1/agent/service/register", consulHeader(), in, nil)
+}
+
+func (r *consulRegistrar) deregister(ctx context.Context) error {
+	return postJSON(ctx, "PUT", r.addr+"/v1/agent/service/deregister/"+url.PathEscape(r.id()), consulHeader(), nil, nil)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Environment variables used by control subcommands for remote control.
+const (
+	controlTokenEnv = "RUC_CONTROL_TOKEN" // bearer token
+	controlCAEnv    = "RUC_CONTROL_CA"    // PEM file with CA certificates to verify the server; system roots are used if empty
+)
+
+// remoteControlScheme is the prefix of remote control addresses (e.g. tls://host:port) accepted by -control-socket of subcommands.
+const remoteControlScheme = "tls://"
+
+// readControlToken reads bearer token from the file.
+func readControlToken(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("%s: empty token", path)
+	}
+	return token, nil
+}
+
+// serveRemoteControl starts listening for control commands on the given TCP address with TLS.
+// Clients should send "auth <token>" line before the command.
+func serveRemoteControl(addr, certFile, keyFile, token string, opts *options) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	l, err := tls.Listen("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Listening for remote control commands on %s.", l.Addr())
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("Remote control listener stopped: %s", err)
+				}
+				return
+			}
+
+			go handleControl(conn, opts, token)
+		}
+	}()
+
+	opts.services.add(func() { l.Close() })
+
+	return nil
+}
+
+// dialControl connects to the control socket, or to the remote control address with tls:// prefix,
+// authenticating with the token from $RUC_CONTROL_TOKEN.
+func dialControl(addr string) (net.Conn, error) {
+	host, ok := strings.CutPrefix(addr, remoteControlScheme)
+	if !ok {
+		return net.DialTimeout("unix", addr, 5*time.Second)
+	}
+
+	token := os.Getenv(controlTokenEnv)
+	if token == "" {
+		return nil, errors.New("$" + controlTokenEnv + " is required for remote control")
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if ca := os.Getenv(controlCAEnv); ca != "" {
+		b, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("%s: no certificates", ca)
+		}
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", host, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = fmt.Fprintf(conn, "auth %s\n", token); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resourceUsage is program's resource usage sampled from /proc.
+// It covers the program's process only, not its children.
+type resourceUsage struct {
+	CPUPercent float64   `json:"cpu_percent"` // since the previous sample; may exceed 100 for multi-threaded programs
+	RSS        int64     `json:"rss"`         // resident set size in bytes
+	FDs        int       `json:"fds"`
+	Threads    int       `json:"threads"`
+	SampledAt  time.Time `json:"sampled_at"`
+}
+
+// clockTicks is the unit of /proc/<pid>/stat CPU times (USER_HZ), which is 100 on all Linux architectures.
+const clockTicks = 100
+
+// procCPUTime returns CPU time (user and system) used by the process.
+func procCPUTime(pid int) (time.Duration, error) {
+	return procStatCPUTime(pid, false)
+}
+
+// procStatCPUTime returns CPU time used by the process and, if children is true, by its waited-for children.
+func procStatCPUTime(pid int, children bool) (time.Duration, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// the command name may contain spaces and parentheses
+	i := strings.LastIndexByte(string(b), ')')
+	if i < 0 {
+		return 0, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 15 {
+		return 0, fmt.Errorf("invalid stat of process %d", pid)
+	}
+
+	// utime and stime are the 14th and 15th fields, counting the PID and the command name;
+	// cutime and cstime are the next ones
+	times := fields[11:13]
+	if children {
+		times = fields[11:15]
+	}
+	var ticks int64
+	for _, f := range times {
+		t, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		ticks += t
+	}
+	return time.Duration(ticks) * time.Second / clockTicks, nil
+}
+
+// procRSS returns resident set size of the process in bytes.
+func procRSS(pid int) (int64, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/statm", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("invalid statm of process %d", pid)
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * int64(os.Getpagesize()), nil
+}
+
+// formatRSS returns human-readable size, e.g. 12.3M.
+func formatRSS(v int64) string {
+	for _, u := range []string{"T", "G", "M", "K"} {
+		if m := sizeUnits[u]; v >= m {
+			return strconv.FormatFloat(float64(v)/float64(m), 'f', 1, 64) + u
+		}
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// sampleResources samples program's resource usage every interval into the status.
+// It returns when ctx is canceled, removing the usage from the status.
+func sampleResources(ctx context.Context, pid int, interval time.Duration, st *statusTracker) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	defer st.update(func(s *status) {
+		s.Resources = nil
+	})
+
+	lastCPU, err := procCPUTime(pid)
+	lastTime := time.Now()
+	if err != nil {
+		return // program is likely exiting
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		// skip the sample if the program is exiting
+		cpu, err := procCPUTime(pid)
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		u := resourceUsage{
+			CPUPercent: 100 * float64(cpu-lastCPU) / float64(now.Sub(lastTime)),
+			SampledAt:  now,
+		}
+		lastCPU, lastTime = cpu, now
+
+		if u.RSS, err = procRSS(pid); err != nil {
+			continue
+		}
+		if u.FDs, err = procFDs(pid); err != nil {
+			continue
+		}
+		if u.Threads, err = procThreads(pid); err != nil {
+			continue
+		}
+
+		st.update(func(s *status) {
+			s.Resources = &u
+		})
+	}
+}
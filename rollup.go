@@ -0,0 +1,166 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// rollup aggregates resource usage of completed runs over a calendar day or week, for cost attribution.
+type rollup struct {
+	Start    time.Time `json:"start"`             // of the day or the week (Monday) in the local time zone
+	Program  string    `json:"program,omitempty"` // in -program mode
+	Runs     int       `json:"runs"`
+	Failures int       `json:"failures"`
+	WallTime float64   `json:"wall_time"` // seconds
+	CPUTime  float64   `json:"cpu_time"`  // user and system seconds
+	PeakRSS  int64     `json:"peak_rss"`  // the maximal MaxRSS of runs
+}
+
+// rollupPeriods are valid rollup periods.
+var rollupPeriods = []string{"daily", "weekly"}
+
+// rollupStart returns the start of the rollup period ("daily" or "weekly") containing t.
+func rollupStart(period string, t time.Time) time.Time {
+	t = t.Local()
+	y, m, d := t.Date()
+	if period == "weekly" {
+		d -= (int(t.Weekday()) + 6) % 7
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+// addRollup adds the record to the rollup of its program and period, adding a new one if needed.
+// Runs are attributed to the period they started in.
+func addRollup(rollups []rollup, period string, r *historyRecord) []rollup {
+	start := rollupStart(period, r.Start)
+	i := slices.IndexFunc(rollups, func(ru rollup) bool {
+		return ru.Start.Equal(start) && ru.Program == r.Program
+	})
+	if i < 0 {
+		rollups = append(rollups, rollup{Start: start, Program: r.Program})
+		i = len(rollups) - 1
+	}
+
+	ru := &rollups[i]
+	ru.Runs++
+	if r.failed() {
+		ru.Failures++
+	}
+	ru.WallTime += r.Duration
+	ru.CPUTime += r.UserTime + r.SystemTime
+	ru.PeakRSS = max(ru.PeakRSS, r.MaxRSS)
+	return rollups
+}
+
+// rollupHistory aggregates records into rollups of the given period, ordered by start and program.
+func rollupHistory(records []historyRecord, period string) []rollup {
+	var res []rollup
+	for i := range records {
+		res = addRollup(res, period, &records[i])
+	}
+
+	slices.SortStableFunc(res, func(a, b rollup) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Program, b.Program)
+	})
+	return res
+}
+
+// writeRollups writes rollups in the given format, like writeHistory.
+func writeRollups(w io.Writer, format string, rollups []rollup) error {
+	switch format {
+	case "json":
+		e := json.NewEncoder(w)
+		for _, ru := range rollups {
+			if err := e.Encode(ru); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"start", "program", "runs", "failures", "wall_time", "cpu_time", "peak_rss"})
+		for _, ru := range rollups {
+			_ = cw.Write([]string{
+				ru.Start.Format(time.RFC3339), ru.Program, strconv.Itoa(ru.Runs), strconv.Itoa(ru.Failures),
+				fmt.Sprint(ru.WallTime), fmt.Sprint(ru.CPUTime), strconv.FormatInt(ru.PeakRSS, 10),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case "text":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "START\tPROGRAM\tRUNS\tFAILED\tWALL\tCPU\tPEAK RSS")
+		for _, ru := range rollups {
+			wall := time.Duration(ru.WallTime * float64(time.Second)).Round(time.Second)
+			cpu := time.Duration(ru.CPUTime * float64(time.Second)).Round(time.Millisecond)
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%d\n",
+				ru.Start.Format("2006-01-02"), ru.Program, ru.Runs, ru.Failures, wall, cpu, ru.PeakRSS)
+		}
+		return tw.Flush()
+
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// budgetDays is the number of days the in-memory rollups are kept for.
+const budgetDays = 28
+
+// budget keeps daily and weekly rollups of runs completed by this ruc process,
+// published as "ruc_budget" expvar, so they can be scraped without -history-file.
+var budget budgetTracker
+
+func init() {
+	expvar.Publish("ruc_budget", expvar.Func(func() any {
+		return budget.get()
+	}))
+}
+
+// budgetTracker accumulates rollups of completed runs.
+type budgetTracker struct {
+	m      sync.Mutex
+	daily  []rollup
+	weekly []rollup
+}
+
+// add accounts the completed run, and drops rollups older than budgetDays.
+func (b *budgetTracker) add(r *historyRecord) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -budgetDays)
+	for _, p := range []struct {
+		rollups *[]rollup
+		period  string
+	}{{&b.daily, "daily"}, {&b.weekly, "weekly"}} {
+		start := rollupStart(p.period, cutoff)
+		*p.rollups = slices.DeleteFunc(addRollup(*p.rollups, p.period, r), func(ru rollup) bool {
+			return ru.Start.Before(start)
+		})
+	}
+}
+
+// get returns copies of rollups.
+func (b *budgetTracker) get() map[string][]rollup {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return map[string][]rollup{
+		"daily":  slices.Clone(b.daily),
+		"weekly": slices.Clone(b.weekly),
+	}
+}
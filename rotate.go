@@ -0,0 +1,214 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatingFile is a file writer that rotates the file when it reaches the maximum size.
+// Rotated files are optionally compressed, and the oldest of them are removed
+// when their total size exceeds the retention budget.
+type rotatingFile struct {
+	path      string
+	maxSize   int64 // 0 means no rotation
+	compress  bool
+	retention int64 // 0 means no limit
+
+	m       sync.Mutex
+	f       *os.File
+	size    int64
+	failing bool
+
+	bg sync.Mutex // serializes background compression and pruning
+}
+
+// newRotatingFile opens (or creates) the file for appending.
+func newRotatingFile(path string, maxSize int64, compress bool, retention int64) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:      path,
+		maxSize:   maxSize,
+		compress:  compress,
+		retention: retention,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// open opens the file; r.m should be held.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = fi.Size()
+	return nil
+}
+
+// Write implements io.Writer. It never returns an error; failures are logged.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		r.rotate()
+	}
+
+	if r.f == nil {
+		// reopening failed during the previous rotation; try again
+		if err := r.open(); err != nil {
+			r.fail(err)
+			return len(p), nil
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	if err != nil {
+		r.fail(err)
+	} else if r.failing {
+		log.Printf("Writing to %s works again.", r.path)
+		r.failing = false
+	}
+
+	return len(p), nil
+}
+
+// fail logs the error once until writes start working again; r.m should be held.
+func (r *rotatingFile) fail(err error) {
+	if !r.failing {
+		log.Printf("Failed to write to %s: %s", r.path, err)
+		r.failing = true
+	}
+}
+
+// rotate renames the current file and opens a new one; r.m should be held.
+func (r *rotatingFile) rotate() {
+	r.f.Close()
+	r.f = nil
+
+	rotated := r.path + "." + time.Now().Format("20060102T150405.000000")
+	if err := os.Rename(r.path, rotated); err != nil {
+		log.Printf("Failed to rotate %s: %s", r.path, err)
+		rotated = ""
+	}
+
+	if err := r.open(); err != nil {
+		r.fail(err)
+	}
+
+	go r.cleanup(rotated)
+}
+
+// cleanup compresses the rotated file (if enabled and rotated is not empty),
+// and removes the oldest rotated files over the retention budget.
+func (r *rotatingFile) cleanup(rotated string) {
+	r.bg.Lock()
+	defer r.bg.Unlock()
+
+	if r.compress && rotated != "" {
+		if err := gzipFile(rotated); err != nil {
+			log.Printf("Failed to compress %s: %s", rotated, err)
+		}
+	}
+
+	if r.retention > 0 {
+		r.prune()
+	}
+}
+
+// prune removes the oldest rotated files until their total size fits into the retention budget.
+func (r *rotatingFile) prune() {
+	matches, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		log.Printf("Failed to list rotated files: %s", err)
+		return
+	}
+
+	type file struct {
+		path string
+		size int64
+	}
+	var files []file
+	var total int64
+	for _, m := range matches {
+		// skip temporary files being compressed
+		if strings.HasSuffix(m, ".tmp") {
+			continue
+		}
+
+		fi, err := os.Stat(m)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, file{path: m, size: fi.Size()})
+		total += fi.Size()
+	}
+
+	// timestamps in names sort oldest first
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+
+	for _, f := range files {
+		if total <= r.retention {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			log.Printf("Failed to remove %s: %s", f.path, err)
+			continue
+		}
+		total -= f.size
+	}
+}
+
+// gzipFile compresses the file into a new file with .gz suffix, and removes the original.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Remove(path)
+}
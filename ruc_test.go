@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlekSi/ruc/testutil"
+)
+
+func TestRestartAfterRunPeriod(t *testing.T) {
+	bin := testutil.BuildRuc(t)
+	j := testutil.NewJournal(t)
+
+	r := testutil.StartRuc(t, bin, []string{"-run", "300ms", "-grace", "5s"}, testutil.Script{{ShutdownDelay: 100 * time.Millisecond}}, j)
+	events := j.Wait(t, testutil.EventStart, 3, 10*time.Second)
+
+	// each run is stopped with SIGTERM, and the next one starts after the previous one exits
+	var kinds []string
+	for _, e := range events {
+		if e.Run < 2 {
+			kinds = append(kinds, e.Kind)
+		}
+	}
+	if expected := "start term exit start term exit"; strings.Join(kinds, " ") != expected {
+		t.Errorf("expected events %q, got %q\n%s", expected, strings.Join(kinds, " "), r.Output())
+	}
+
+	if code := r.Stop(10 * time.Second); code != 0 {
+		t.Errorf("expected exit code 0, got %d\n%s", code, r.Output())
+	}
+}
+
+func TestKillAfterGracePeriod(t *testing.T) {
+	bin := testutil.BuildRuc(t)
+	j := testutil.NewJournal(t)
+
+	r := testutil.StartRuc(t, bin, []string{"-run", "200ms", "-grace", "300ms"}, testutil.Script{{IgnoreTerm: true}}, j)
+	code := r.Wait(10 * time.Second)
+
+	// the killed program is a failure, so it is not restarted
+	if code == 0 {
+		t.Errorf("expected non-zero exit code\n%s", r.Output())
+	}
+	events := j.Events(t)
+	if len(events) != 2 || events[0].Kind != testutil.EventStart || events[1].Kind != testutil.EventTerm {
+		t.Errorf("expected start and ignored SIGTERM, got %+v\n%s", events, r.Output())
+	}
+	if !strings.Contains(r.Output(), "SIGKILL") {
+		t.Errorf("expected SIGKILL escalation to be logged\n%s", r.Output())
+	}
+}
+
+func TestExitFailure(t *testing.T) {
+	bin := testutil.BuildRuc(t)
+	j := testutil.NewJournal(t)
+
+	r := testutil.StartRuc(t, bin, []string{"-run", "1h"}, testutil.Script{{ExitCode: 3, RunFor: 10 * time.Millisecond}}, j)
+	if code := r.Wait(10 * time.Second); code != 1 {
+		t.Errorf("expected exit code 1, got %d\n%s", code, r.Output())
+	}
+	if n := j.Count(t, testutil.EventStart); n != 1 {
+		t.Errorf("expected failed program not to be restarted, got %d starts", n)
+	}
+}
+
+func TestBackoffRestarts(t *testing.T) {
+	bin := testutil.BuildRuc(t)
+	j := testutil.NewJournal(t)
+
+	flags := []string{"-run", "1h", "-backoff", "200ms", "-backoff-max", "800ms", "-backoff-reset", "1m"}
+	r := testutil.StartRuc(t, bin, flags, testutil.Script{{RunFor: 10 * time.Millisecond}}, j)
+	events := j.Wait(t, testutil.EventStart, 5, 20*time.Second)
+	r.Stop(10 * time.Second)
+
+	exits := make(map[int]time.Time)
+	for _, e := range events {
+		switch e.Kind {
+		case testutil.EventExit:
+			exits[e.Run] = e.Time
+
+		case testutil.EventStart:
+			if e.Run == 0 {
+				continue
+			}
+
+			// 200ms, 400ms, 800ms, 800ms
+			min := min(200*time.Millisecond<<(e.Run-1), 800*time.Millisecond)
+			if d := e.Time.Sub(exits[e.Run-1]); d < min {
+				t.Errorf("run %d started %s after the previous exit, expected at least %s", e.Run, d, min)
+			}
+		}
+	}
+}
+
+func TestControlSocket(t *testing.T) {
+	bin := testutil.BuildRuc(t)
+	j := testutil.NewJournal(t)
+	socket := filepath.Join(t.TempDir(), "ruc.sock")
+
+	r := testutil.StartRuc(t, bin, []string{"-run", "1h", "-control-socket", socket}, nil, j)
+	start := j.Wait(t, testutil.EventStart, 1, 10*time.Second)[0]
+
+	// the socket may not be ready yet
+	var out []byte
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		var err error
+		if out, err = exec.Command(bin, "status", "-control-socket", socket).CombinedOutput(); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ruc status: %s\n%s\n%s", err, out, r.Output())
+		}
+	}
+	if !strings.Contains(string(out), strconv.Itoa(start.PID)) {
+		t.Errorf("expected status to include PID %d, got:\n%s", start.PID, out)
+	}
+
+	if out, err := exec.Command(bin, "restart", "-control-socket", socket).CombinedOutput(); err != nil {
+		t.Fatalf("ruc restart: %s\n%s\n%s", err, out, r.Output())
+	}
+	events := j.Wait(t, testutil.EventStart, 2, 10*time.Second)
+	if kinds := events[1].Kind + " " + events[2].Kind; kinds != "term exit" {
+		t.Errorf("expected graceful stop before the restart, got %+v", events)
+	}
+
+	if code := r.Stop(10 * time.Second); code != 0 {
+		t.Errorf("expected exit code 0, got %d\n%s", code, r.Output())
+	}
+}
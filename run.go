@@ -0,0 +1,1228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AlekSi/ruc/clock"
+)
+
+// startCommand creates and starts a new program instance.
+func startCommand(opts *options, in *instance, gated bool) (*command, error) {
+	cmd, err := newCommand(opts, in, gated)
+	if err != nil {
+		return nil, err
+	}
+	if injected.startDelay > 0 {
+		log.Printf("Fault injection: delaying start by %s.", injected.startDelay)
+		time.Sleep(injected.startDelay)
+	}
+	if err := cmd.start(); err != nil {
+		if cmd.record != nil {
+			cmd.record.close(err)
+		}
+		removeTmpDir(cmd.tmpDir)
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// errStartFailed is wrapped into the error of the program that did not become ready within -start-timeout.
+var errStartFailed = errors.New("program failed to start")
+
+// startWithRetries starts the program, retrying failed starts up to -start-retries times with exponential backoff.
+// Starts failed because of exhausted resources are retried up to -fork-retries times separately.
+func startWithRetries(ctx context.Context, opts *options, in *instance) (*command, error) {
+	delay := opts.startDelay
+	forkDelay := opts.startDelay
+	var forkRetry int
+	for retry := 1; ; retry++ {
+		cmd, err := startCommand(opts, in, false)
+		if err != nil && resourcesExhausted(err) && forkRetry < opts.forkRetries {
+			forkRetry++
+			in.status.update(func(s *status) {
+				s.ForkFailures++
+			})
+			in.log.Printf("Failed to start program because of exhausted resources: %s; retry %d of %d in %s.", err, forkRetry, opts.forkRetries, forkDelay)
+			if opts.forkFree {
+				freeMemory(opts, in)
+			}
+			if sleepUntil(ctx, clk.Now().Add(forkDelay)) != nil {
+				return nil, err // ctx is canceled
+			}
+			forkDelay = min(forkDelay*2, maxForkDelay)
+			retry-- // they do not count as -start-retries
+			continue
+		}
+		if err == nil || retry > opts.startRetries {
+			return cmd, err
+		}
+
+		in.log.Printf("Failed to start program: %s; retry %d of %d in %s.", err, retry, opts.startRetries, delay)
+		if sleepUntil(ctx, clk.Now().Add(delay)) != nil {
+			return nil, err // ctx is canceled
+		}
+		delay *= 2
+	}
+}
+
+// run starts the program (or releases the prestarted one) and supervises it until it exits.
+func run(ctx context.Context, opts *options, in *instance) (err error) {
+	// restart requests made before the start are already satisfied
+	select {
+	case <-in.restart:
+	default:
+	}
+
+	cmd := in.prestarted
+	in.prestarted = nil
+	adopted := in.adopt != 0
+	switch {
+	case adopted:
+		cmd = adoptCommand(in.adopt)
+		in.adopt = 0
+		in.setLogPrefix(cmd.runID)
+		in.log.Printf("Adopted running %s (PID %d).", opts.output.redact.redactString(strings.Join(cmd.argv, " ")), cmd.Process.Pid)
+		in.event(cmd.Process.Pid, "adopted (run %s)", cmd.runID)
+	case cmd != nil && cmd.gateW == nil:
+		in.setLogPrefix(cmd.runID)
+		in.log.Printf("Took over overlapping program (PID %d).", cmd.Process.Pid)
+	case cmd != nil:
+		in.setLogPrefix(cmd.runID)
+		cmd.release()
+		in.log.Printf("Released prestarted program (PID %d).", cmd.Process.Pid)
+		in.event(cmd.Process.Pid, "released prestarted program (run %s)", cmd.runID)
+	default:
+		if cmd, err = startWithRetries(ctx, opts, in); err != nil {
+			return err
+		}
+		in.setLogPrefix(cmd.runID)
+		in.log.Printf("Started %s (PID %d, seed %d).", opts.output.redact.redactString(strings.Join(in.args, " ")), cmd.Process.Pid, cmd.seed)
+		in.event(cmd.Process.Pid, "started (run %s)", cmd.runID)
+	}
+	defer in.setLogPrefix("")
+
+	// the run period of this run, if it is set by -program-run or computed by -run-cmd
+	runOverride := in.run
+	if opts.runCmd != "" {
+		configured, _, _ := opts.settings.get()
+		if in.run != nil {
+			configured = *in.run
+		}
+		if p, err := periodFromCommand(ctx, opts.runCmd, configured); err != nil {
+			in.log.Printf("Failed to compute run period with -run-cmd, using %s: %s", configured.String(), err)
+		} else {
+			in.log.Printf("Run period of this run is %s (from -run-cmd).", p.String())
+			runOverride = &p
+		}
+	}
+
+	if (opts.audit || opts.auditFile != "") && !adopted {
+		audit(opts.auditFile, newAuditRecord(opts, cmd))
+	}
+
+	if opts.prepareNext {
+		// the next start is prepared while this program runs
+		next := make(chan *execPlan, 1)
+		go func() {
+			next <- prepareNext(opts, in)
+		}()
+		defer func() {
+			in.next = <-next
+		}()
+	}
+
+	if cmd.tmpDir != "" {
+		defer func() {
+			if err != nil && opts.keepFailed {
+				in.log.Printf("Keeping temporary directory %s of the failed run.", cmd.tmpDir)
+				return
+			}
+			removeTmpDir(cmd.tmpDir)
+		}()
+	}
+
+	startedAt := clk.Now()
+	in.status.update(func(s *status) {
+		s.State = stateStarting
+		s.ChildPID = cmd.Process.Pid
+		s.StartedAt = &startedAt
+		s.RunID = cmd.runID
+		s.Seed = cmd.seed
+		s.ProgramStatus = ""
+		s.RunPeriod = ""
+		if runOverride != nil {
+			s.RunPeriod = runOverride.String()
+		}
+	})
+	if adopted {
+		opts.notifier.notify(in, eventStart, cmd.Process.Pid, cmd.runID, "running process adopted")
+	} else {
+		opts.notifier.notify(in, eventStart, cmd.Process.Pid, cmd.runID, "program started")
+	}
+
+	if opts.pidFile != "" {
+		if err := os.WriteFile(opts.pidFile, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0o644); err != nil {
+			in.log.Printf("Failed to write PID file: %s", err)
+		}
+		defer func() {
+			if err := os.Remove(opts.pidFile); err != nil {
+				in.log.Printf("Failed to remove PID file: %s", err)
+			}
+		}()
+	}
+
+	if opts.systemdRun {
+		unit := in.unitName() + ".scope"
+		if err := startSystemdScope(opts.systemdUser, unit, opts.systemdSlice, cmd.Process.Pid, opts.maxPIDs); err != nil {
+			in.log.Printf("Failed to start systemd scope %s: %s", unit, err)
+		} else {
+			// kill whatever is left in the scope after the program exits
+			defer func() {
+				if err := stopSystemdUnit(opts.systemdUser, unit); err != nil {
+					in.log.Printf("Failed to stop systemd scope %s: %s", unit, err)
+				}
+			}()
+
+			if opts.maxPIDs > 0 {
+				pid := cmd.Process.Pid
+				pidsCtx, pidsCancel := context.WithCancel(ctx)
+				defer pidsCancel()
+				go watchPIDsLimit(pidsCtx, opts, in, func() *pidsCgroup {
+					// the scope may be not created yet
+					if c, err := pidsCgroupOf(pid); err == nil && filepath.Base(c.dir) == unit {
+						return c
+					}
+					return nil
+				})
+			}
+		}
+	} else if opts.maxPIDs > 0 && opts.pidsCgroups {
+		c, err := cmd.cgroup, error(nil)
+		if c == nil {
+			c, err = newPIDsCgroup(in.unitName(), opts.maxPIDs)
+		}
+		if err == nil {
+			if err = c.add(cmd.Process.Pid); err != nil {
+				_ = c.remove()
+			}
+		}
+		if err != nil {
+			in.log.Printf("Failed to limit program's tasks with cgroup: %s", err)
+		} else {
+			defer func() {
+				if err := c.remove(); err != nil {
+					in.log.Printf("Failed to remove cgroup %s: %s", c.dir, err)
+				}
+			}()
+
+			pidsCtx, pidsCancel := context.WithCancel(ctx)
+			defer pidsCancel()
+			go watchPIDsLimit(pidsCtx, opts, in, func() *pidsCgroup { return c })
+		}
+	}
+
+	if opts.maxEgress > 0 {
+		l, err := newEgressLimit(in.unitName(), opts.egressIface, opts.maxEgress)
+		if err == nil {
+			if err = l.add(cmd.Process.Pid); err != nil {
+				_ = l.remove()
+			}
+		}
+		if err != nil {
+			in.log.Printf("Failed to limit program's egress bandwidth: %s", err)
+		} else {
+			defer func() {
+				if err := l.remove(); err != nil {
+					in.log.Printf("Failed to remove egress bandwidth limit: %s", err)
+				}
+			}()
+		}
+	}
+
+	oom := newOOMWatch(cmd.Process.Pid)
+
+	if len(opts.limits) > 0 {
+		limitsCtx, limitsCancel := context.WithCancel(ctx)
+		defer limitsCancel()
+		go monitorLimits(limitsCtx, cmd.Process.Pid, opts.limits, opts.limitInterval, in.restart)
+	}
+
+	if opts.outputEvery > 0 {
+		outputCtx, outputCancel := context.WithCancel(ctx)
+		defer outputCancel()
+		go watchOutput(outputCtx, opts, in, cmd)
+	}
+
+	if cmd.stdin != nil {
+		stdinCtx, stdinCancel := context.WithCancel(ctx)
+		defer stdinCancel()
+		go feedStdin(stdinCtx, opts, in, cmd.stdin)
+	}
+
+	if opts.binaryChange && cmd.path != "" {
+		binaryCtx, binaryCancel := context.WithCancel(ctx)
+		defer binaryCancel()
+		go watchBinary(binaryCtx, opts, in, cmd.path)
+	}
+
+	if opts.usageInterval > 0 {
+		resourcesCtx, resourcesCancel := context.WithCancel(ctx)
+		defer resourcesCancel()
+		go sampleResources(resourcesCtx, cmd.Process.Pid, opts.usageInterval, in.status)
+	}
+
+	var stopped chan bool
+	if opts.pauseStopped {
+		stoppedCtx, stoppedCancel := context.WithCancel(ctx)
+		defer stoppedCancel()
+		stopped = make(chan bool)
+		go watchStopped(stoppedCtx, cmd.Process.Pid, stoppedInterval, stopped)
+	}
+
+	// receive program exit status asynchronously
+	exited := newExitWaiter()
+	var daemon *os.Process // tracked instead of the program that daemonized itself, see -daemon-pid-file
+	if adopted {
+		// it is not ruc's child, so it is tracked like a daemon
+		daemon = cmd.Process
+	}
+	daemonCtx, daemonCancel := context.WithCancel(context.Background())
+	defer daemonCancel()
+	go func() {
+		if adopted {
+			exited.finish(waitDaemon(daemonCtx, cmd.Process.Pid))
+			return
+		}
+
+		err := cmd.Wait()
+		cmd.closeNotification()
+		if opts.foregroundTTY {
+			if err := reclaimTerminal(); err != nil {
+				in.log.Printf("Failed to take the terminal back: %s", err)
+			}
+		}
+		cmd.flush()
+		if cmd.record != nil {
+			cmd.record.close(err)
+		}
+		if errors.Is(err, exec.ErrWaitDelay) {
+			// program exited successfully, but its children are still holding output pipes
+			in.log.Printf("Program exited, but its output is still open; not waiting for it.")
+			err = nil
+		}
+		if injected.waitResultSet {
+			in.log.Printf("Fault injection: replacing program's exit result %v with %v.", err, injected.waitResult)
+			err = injected.waitResult
+		}
+		exited.finish(newExitResult(err, cmd.ProcessState))
+	}()
+
+	// wait for program to become ready (if probe or notification is set) before starting the run period
+	var ready chan error
+	st := stateRunning
+	switch {
+	case opts.readyProbe != nil:
+		readyCtx, readyCancel := context.WithCancel(ctx)
+		defer readyCancel()
+
+		ready = make(chan error, 1)
+		go func() {
+			ready <- waitReady(readyCtx, opts.readyProbe, opts.readyInterval)
+		}()
+
+		st = stateStarting
+	case cmd.notifyR != nil:
+		ready = make(chan error, 1)
+		go func() {
+			err := cmd.waitNotification()
+			if errors.Is(err, errNotNotified) {
+				in.log.Printf("Program closed notification file descriptor without notifying readiness.")
+			}
+			ready <- err
+		}()
+
+		st = stateStarting
+	case opts.output.directives[directiveReady]:
+		// waiting for the directive trigger
+		st = stateStarting
+	default:
+		in.status.setState(stateRunning)
+		in.up(opts)
+	}
+	defer in.down()
+
+	var runStart, graceStart, deadline time.Time
+	var jumpsBase int64        // wallJumps value at runStart
+	var extended time.Duration // by EXTEND directives
+	var pausedAt time.Time     // when the program was stopped with -pause-stopped
+
+	// fraction of the run period after which the program is stopped in chaos mode
+	chaos := rand.Float64()
+
+	runStart, jumpsBase = clk.Now(), wallJumps.Load()
+	var killErr error            // set if program was killed or stopped on ruc's own initiative
+	var prestarted bool          // the next instance was (attempted to be) prestarted
+	var waitSlot chan<- struct{} // set while waiting for other replicas to be recycled
+	var fleetSlot chan locker    // set while waiting for a fleet recycle slot
+	fleetCtx, fleetCancel := context.WithCancel(ctx)
+	defer func() {
+		fleetCancel()
+		if fleetSlot != nil {
+			// the program exited while ruc was waiting
+			if l := <-fleetSlot; l != nil {
+				l.unlock()
+			}
+		}
+	}()
+
+	// (de)register program in service discovery while it is running, and report it as serving traffic
+	var registered bool
+	register := func() {
+		in.status.update(func(s *status) {
+			s.Serving = true
+		})
+		if opts.registrar == nil {
+			return
+		}
+		regCtx, regCancel := context.WithTimeout(context.Background(), registrarTimeout)
+		defer regCancel()
+		if err := opts.registrar.register(regCtx, cmd.Process.Pid); err != nil {
+			in.log.Printf("Failed to register program: %s", err)
+			return
+		}
+		registered = true
+		in.log.Printf("Program is registered.")
+	}
+	deregister := func() {
+		if in.status.get().Serving {
+			in.status.update(func(s *status) {
+				s.Serving = false
+			})
+		}
+		if !registered {
+			return
+		}
+		registered = false
+		regCtx, regCancel := context.WithTimeout(context.Background(), registrarTimeout)
+		defer regCancel()
+		if err := opts.registrar.deregister(regCtx); err != nil {
+			in.log.Printf("Failed to deregister program: %s", err)
+			return
+		}
+		in.log.Printf("Program is deregistered.")
+	}
+	defer deregister()
+	if st == stateRunning {
+		register()
+	}
+
+	// ask program to exit
+	var adaptedGrace time.Duration // with -adaptive-grace, 0 until SIGTERM is sent
+	term := func() {
+		st = stateStopping
+		in.status.setState(st)
+		if opts.adaptiveGrace {
+			_, grace, _ := opts.settings.get()
+			adaptedGrace = adaptiveGrace(opts, in, grace)
+		}
+		graceStart = clk.Now()
+		in.event(cmd.Process.Pid, "sent SIGTERM")
+		if err := signalProcess(cmd.Process, syscall.SIGTERM, opts.killMode == killModeControlGroup && daemon == nil); err != nil {
+			in.log.Printf("Failed to send SIGTERM: %s", err)
+			in.event(cmd.Process.Pid, "failed to send SIGTERM: %s", err)
+		}
+	}
+
+	// wait for program to leave critical sections (if configured),
+	// then ask it to finish in-flight work (if configured), then to exit
+	var busy chan *os.File
+	var busyChecked bool
+	var busyLock *os.File // released after the program exits
+	defer func() {
+		if busyLock != nil {
+			busyLock.Close()
+		}
+		if busy != nil {
+			// the program exited while ruc was waiting
+			go func() {
+				if f := <-busy; f != nil {
+					f.Close()
+				}
+			}()
+		}
+	}()
+	var drained chan error
+	var timed bool                       // the program is stopped because its run period ended
+	var beforeStopped chan time.Duration // -before-stop-cmd duration
+
+	var pacing chan time.Duration // set while polling -pace-feedback at the end of the run period
+	var paced bool                // -pace-feedback was polled for the current deadline
+	var paceExtended time.Duration
+
+	var hostChecking chan string // set while checking -defer-recycle-when at the end of the run period
+	var hostChecked bool         // -defer-recycle-when was checked for the current deadline
+	var hostDeferred time.Duration
+
+	// run -before-stop-cmd on timed recycles, then ask program to exit
+	stopSignal := func() {
+		if opts.beforeStopCmd == "" || !timed {
+			term()
+			return
+		}
+
+		st = stateDraining
+		in.status.setState(st)
+		in.log.Printf("Running before-stop command...")
+		beforeStopped = make(chan time.Duration, 1)
+		go func() {
+			beforeStopped <- beforeStop(opts, in, cmd.Process.Pid, cmd.runID)
+		}()
+	}
+
+	stop := func() {
+		if opts.busyLock != "" && !busyChecked {
+			busyChecked = true
+			f, err := tryBusyLock(opts.busyLock)
+			switch {
+			case err != nil:
+				in.log.Printf("Failed to lock busy lock file: %s", err)
+			case f != nil:
+				busyLock = f
+			default:
+				st = stateBusy
+				in.status.setState(st)
+				in.log.Printf("Program is busy, deferring stop for up to %s...", opts.maxDefer)
+				busy = make(chan *os.File, 1)
+				go func() {
+					f, err := waitBusyLock(opts.busyLock, opts.maxDefer)
+					switch {
+					case err != nil:
+						in.log.Printf("Failed to lock busy lock file: %s", err)
+					case f == nil:
+						in.log.Printf("Program is still busy after %s, stopping it anyway.", opts.maxDefer)
+					}
+					busy <- f
+				}()
+				return
+			}
+		}
+
+		deregister()
+
+		if opts.drainURL == "" {
+			stopSignal()
+			return
+		}
+
+		st = stateDraining
+		in.status.setState(st)
+		in.log.Printf("Draining program...")
+		drained = make(chan error, 1)
+		go func() {
+			drained <- drain(opts.drainURL, opts.drainTimeout)
+		}()
+	}
+
+	// with -restart-mode=overlap, start the next instance and stop program after it becomes ready
+	var overlapNext *command
+	var overlapReady chan error
+	defer func() {
+		if overlapNext != nil {
+			in.log.Printf("Aborting the next program instance (PID %d).", overlapNext.Process.Pid)
+			overlapNext.abort()
+		}
+	}()
+	recycle := func() {
+		if overlapReady != nil {
+			return
+		}
+		if opts.restartMode != restartModeOverlap || st != stateRunning || ctx.Err() != nil {
+			stop()
+			return
+		}
+
+		next, err := startCommand(opts, in, false)
+		if err != nil {
+			in.log.Printf("Failed to start the next program instance: %s; stopping the current one first.", err)
+			stop()
+			return
+		}
+		in.log.Printf("Started the next program instance %s (PID %d, run %s, seed %d), waiting for it to become ready.", opts.output.redact.redactString(strings.Join(in.args, " ")), next.Process.Pid, next.runID, next.seed)
+		in.event(next.Process.Pid, "started the next program instance (run %s)", next.runID)
+		overlapNext = next
+		overlapReady = make(chan error, 1)
+		go func() {
+			overlapReady <- waitOverlapReady(ctx, opts, next)
+		}()
+	}
+
+	// kill program
+	kill := func() {
+		if st == stateStopping {
+			in.event(cmd.Process.Pid, "grace period expired after %s, escalating to SIGKILL", clk.Now().Sub(graceStart).Round(time.Millisecond))
+		}
+		in.event(cmd.Process.Pid, "sent SIGKILL")
+		st = stateKilling
+		in.status.setState(st)
+		opts.notifier.notify(in, eventKill, cmd.Process.Pid, cmd.runID, "program was sent SIGKILL")
+		if err := signalProcess(cmd.Process, syscall.SIGKILL, opts.killMode != killModeProcess && daemon == nil); err != nil {
+			in.log.Printf("Failed to send SIGKILL: %s", err)
+			in.event(cmd.Process.Pid, "failed to send SIGKILL: %s", err)
+		}
+	}
+
+	// start the run period
+	becomeReady := func() {
+		in.log.Printf("Program is ready.")
+		st = stateRunning
+		in.status.setState(st)
+		in.up(opts)
+		runStart, jumpsBase = clk.Now(), wallJumps.Load()
+		register()
+	}
+
+	// wait for ctx to be canceled (ignored after SIGTERM is sent), program to exit, or for periods to end;
+	// periods can be changed while we wait
+	var timer, prestartTimer, countdownTimer, progressTimer, unreadyTimer deadlineTimer
+	defer timer.stop()
+	defer prestartTimer.stop()
+	defer unreadyTimer.stop()
+	defer countdownTimer.stop()
+	defer progressTimer.stop()
+	var progressAt time.Time   // the next -grace-progress message
+	var countdownFor time.Time // deadline the countdown checkpoints are for
+	var countdownNext int      // index of the next -countdown checkpoint
+	var unready bool           // deregistered -unready-before the end of the run period
+	for {
+		runPeriod, gracePeriod, changed := opts.settings.get()
+		if runOverride != nil {
+			runPeriod = *runOverride
+		}
+
+		switch st {
+		case stateRunning:
+			var skipped int64
+			deadline, skipped = in.scheduler.end(runPeriod, runStart)
+			if skipped > 0 {
+				in.log.Printf("Previous iteration overran %d run period(s) of %s, skipping them.", skipped, runPeriod.String())
+			}
+			if runPeriod.s != "" && clockJumpMode == clockJumpReanchor {
+				// follow the calendar boundary after wall clock jumps
+				deadline = deadline.Add(-jumpsSince(jumpsBase))
+			}
+			if opts.chaos {
+				// stop at the same random point of the period even if it is changed
+				deadline = runStart.Add(time.Duration(chaos * float64(deadline.Sub(runStart))))
+			}
+			deadline = deadline.Add(extended)
+		case stateStarting:
+			deadline = startedAt.Add(opts.startTimeout)
+		case stateStopping:
+			if adaptedGrace > 0 {
+				gracePeriod = adaptedGrace
+			}
+			deadline = graceStart.Add(fitGrace(gracePeriod))
+		}
+		if (st == stateRunning && opts.trigger == nil && waitSlot == nil && fleetSlot == nil && overlapReady == nil && pacing == nil && hostChecking == nil && pausedAt.IsZero()) || st == stateStopping || (st == stateStarting && opts.startTimeout > 0) {
+			timer.set(deadline)
+
+			if d := in.status.get().Deadline; d == nil || !d.Equal(deadline) {
+				deadline := deadline
+				in.status.update(func(s *status) {
+					s.Deadline = &deadline
+				})
+			}
+		} else {
+			timer.stop()
+		}
+
+		// start the next instance shortly before this one is stopped
+		if st == stateRunning && pausedAt.IsZero() && opts.prestart > 0 && !prestarted && ctx.Err() == nil {
+			prestartTimer.set(deadline.Add(-opts.prestart))
+		} else {
+			prestartTimer.stop()
+		}
+
+		// stop serving traffic shortly before the program is stopped; the run period may be extended after that
+		if st == stateRunning && opts.trigger == nil && pausedAt.IsZero() && opts.unreadyBefore > 0 {
+			at := deadline.Add(-opts.unreadyBefore)
+			if unready && at.After(clk.Now()) {
+				unready = false
+				in.log.Printf("Run period is extended, marking program as ready again.")
+				register()
+			}
+			if !unready {
+				unreadyTimer.set(at)
+			} else {
+				unreadyTimer.stop()
+			}
+		} else {
+			unreadyTimer.stop()
+		}
+
+		// log the time left at -countdown checkpoints, skipping the ones already passed
+		if st == stateRunning && waitSlot == nil && pausedAt.IsZero() && len(opts.countdown) > 0 {
+			if !countdownFor.Equal(deadline) {
+				countdownFor = deadline
+				countdownNext = 0
+			}
+			now := clk.Now()
+			for countdownNext < len(opts.countdown) && !deadline.Add(-opts.countdown[countdownNext]).After(now) {
+				countdownNext++
+			}
+			if countdownNext < len(opts.countdown) {
+				countdownTimer.set(deadline.Add(-opts.countdown[countdownNext]))
+			} else {
+				countdownTimer.stop()
+			}
+		} else {
+			countdownTimer.stop()
+		}
+
+		// report waiting during the grace period
+		if st == stateStopping && opts.graceProgress > 0 {
+			if progressAt.IsZero() {
+				progressAt = graceStart.Add(opts.graceProgress)
+			}
+			if progressAt.Before(deadline) {
+				progressTimer.set(progressAt)
+			} else {
+				progressTimer.stop()
+			}
+		} else {
+			progressTimer.stop()
+		}
+
+		var ctxDone <-chan struct{}
+		if st == stateStarting || st == stateRunning {
+			ctxDone = ctx.Done()
+		}
+
+		select {
+		case <-exited.done:
+			res := exited.result()
+			if daemon == nil && res.err == nil && (st == stateStarting || st == stateRunning) {
+				if daemon = detectDaemon(opts, in, cmd.Process.Pid, clk.Now().Sub(startedAt)); daemon != nil {
+					in.event(daemon.Pid, "tracking daemon of PID %d", cmd.Process.Pid)
+					cmd.Process = daemon
+					in.status.update(func(s *status) {
+						s.ChildPID = daemon.Pid
+					})
+					if opts.pidFile != "" {
+						if err := os.WriteFile(opts.pidFile, []byte(strconv.Itoa(daemon.Pid)+"\n"), 0o644); err != nil {
+							in.log.Printf("Failed to write PID file: %s", err)
+						}
+					}
+					exited = newExitWaiter()
+					go func() {
+						exited.finish(waitDaemon(daemonCtx, daemon.Pid))
+					}()
+					break
+				}
+			}
+			if daemon != nil && st != stateStarting && st != stateRunning {
+				// the daemon's exit status is unknown, but ruc asked it to exit
+				res.err = nil
+			}
+			if adopted && res.err != nil {
+				// its exit status is unknown, and it is replaced by the program anyway
+				in.log.Printf("Adopted process exited by itself.")
+				res.err = nil
+			}
+			in.exit = res
+			in.event(cmd.Process.Pid, "%s", res)
+			err := res.err
+			if opts.killMode != killModeProcess && daemon == nil {
+				// the group outlives its leader
+				if syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) == nil {
+					in.log.Printf("Killed processes left in the program's process group.")
+				}
+			}
+			if res.core && opts.coreDir != "" {
+				argv := make([]string, len(cmd.argv))
+				for i, a := range cmd.argv {
+					argv[i] = opts.output.redact.redactString(a)
+				}
+				if dir, err := collectCore(opts.coreDir, opts.coreRetention, cmd, res.signal, startedAt, argv); err != nil {
+					in.log.Printf("Failed to collect core dump: %s", err)
+				} else {
+					in.log.Printf("Core dump saved to %s.", dir)
+				}
+			}
+			if err != nil && (st == stateStarting || st == stateRunning) {
+				in.crashed()
+			}
+			in.graceExceeded = st == stateKilling
+			if in.graceExceeded {
+				in.log.Printf("Program exited only after SIGKILL: the grace period was exceeded.")
+				in.status.update(func(s *status) {
+					s.GraceExceeded++
+				})
+			}
+			oomKilled := st != stateKilling && oom.killed(res)
+			r := newHistoryRecord(cmd, in, startedAt, graceStart, st, oomKilled, res)
+			budget.add(r)
+			if opts.historyFile != "" {
+				appendHistory(opts.historyFile, r)
+			}
+			if opts.resultFile != "" {
+				if err := writeFileAtomic(opts.resultFile, r); err != nil {
+					in.log.Printf("Failed to write result file: %s", err)
+				}
+			}
+			if killErr != nil {
+				return killErr
+			}
+			if err != nil && opts.startTimeout > 0 {
+				// exited before becoming ready, or too soon without readiness probe
+				if st == stateStarting || (st == stateRunning && opts.readyProbe == nil && opts.notifyFD == 0 && !opts.output.directives[directiveReady] && clk.Now().Sub(startedAt) < opts.startTimeout) {
+					return fmt.Errorf("%w: %w", errStartFailed, err)
+				}
+			}
+			if oomKilled {
+				in.log.Printf("Program was killed by the OOM killer.")
+				in.status.update(func(s *status) {
+					s.OOMKills++
+				})
+				return fmt.Errorf("%w (%s)", errOOMKilled, err)
+			}
+			return err
+
+		case err := <-ready:
+			ready = nil
+			if err == nil && st == stateStarting {
+				becomeReady()
+			}
+
+		case <-ctxDone:
+			stop()
+
+		case f := <-busy:
+			busy = nil
+			if st != stateBusy {
+				if f != nil {
+					f.Close()
+				}
+				break
+			}
+			if f != nil {
+				in.log.Printf("Program is not busy anymore.")
+				busyLock = f
+			}
+			stop()
+
+		case err := <-overlapReady:
+			overlapReady = nil
+			next := overlapNext
+			overlapNext = nil
+			if err != nil {
+				in.log.Printf("Next program instance (PID %d) did not become ready: %s; aborting it.", next.Process.Pid, err)
+				next.abort()
+			} else {
+				in.log.Printf("Next program instance (PID %d) is ready.", next.Process.Pid)
+				in.prestarted = next
+			}
+			if st == stateRunning {
+				stop()
+			}
+
+		case err := <-drained:
+			drained = nil
+			if st != stateDraining {
+				break
+			}
+			if err != nil {
+				in.log.Printf("Failed to drain program: %s", err)
+			} else {
+				in.log.Printf("Program is drained.")
+			}
+			stopSignal()
+
+		case d := <-beforeStopped:
+			beforeStopped = nil
+			if st != stateDraining {
+				break
+			}
+			term()
+			// the command's time is deducted from the grace period
+			graceStart = graceStart.Add(-d)
+
+		case d := <-pacing:
+			pacing = nil
+			if st != stateRunning || d == 0 {
+				break
+			}
+			d = min(d, opts.paceMax-paceExtended)
+			paceExtended += d
+			extended += d
+			paced = false
+			in.log.Printf("Downstream is overloaded, extending run period by %s.", d)
+
+		case reason := <-hostChecking:
+			hostChecking = nil
+			if st != stateRunning || reason == "" {
+				break
+			}
+			d := min(opts.deferInterval, opts.deferMax-hostDeferred)
+			hostDeferred += d
+			extended += d
+			hostChecked = false
+			in.log.Printf("Deferring the periodic restart by %s: %s.", d, reason)
+
+		case <-timer.C():
+			if late := timer.fired(); late > time.Second {
+				in.log.Printf("Timer fired %s late.", late.Round(time.Millisecond))
+			}
+			if st == stateStarting {
+				in.log.Printf("Program is not ready after %s, stopping it.", opts.startTimeout)
+				killErr = fmt.Errorf("%w: not ready in %s", errStartFailed, opts.startTimeout)
+				stop()
+				break
+			}
+			if st == stateRunning {
+				if opts.paceFeedback != "" && !paced && paceExtended < opts.paceMax {
+					paced = true
+					pacing = make(chan time.Duration, 1)
+					go func() {
+						d, err := pollPace(ctx, opts)
+						if err != nil && ctx.Err() == nil {
+							in.log.Printf("Failed to poll -pace-feedback: %s", err)
+						}
+						pacing <- d
+					}()
+					break
+				}
+				if len(opts.hostConds) > 0 && !hostChecked && hostDeferred < opts.deferMax {
+					hostChecked = true
+					hostChecking = make(chan string, 1)
+					go func() {
+						hostChecking <- checkHostConditions(ctx, opts, in)
+					}()
+					break
+				}
+				timed = true
+				if opts.recycling != nil && !in.recycling {
+					select {
+					case opts.recycling <- struct{}{}:
+						in.recycling = true
+					default:
+						in.log.Printf("Waiting for other replicas to be recycled...")
+						waitSlot = opts.recycling
+					}
+				}
+				if waitSlot != nil {
+					break
+				}
+				if opts.fleetSlots != nil && in.fleetSlot == nil {
+					in.log.Printf("Waiting for a fleet recycle slot...")
+					fleetSlot = make(chan locker, 1)
+					go func() {
+						fleetSlot <- opts.fleetSlots.acquire(fleetCtx, opts.lockInterval, in)
+					}()
+					break
+				}
+				if opts.chaos {
+					in.log.Printf("Chaos mode: stopping program after %s.", clk.Now().Sub(runStart).Round(time.Millisecond))
+				}
+				recycle()
+				break
+			}
+
+			if opts.forensicsDir != "" {
+				in.log.Printf("Grace period expired, capturing forensic snapshot...")
+				if dir, err := snapshot(opts.forensicsDir, cmd.Process.Pid, opts.forensicsGcore, opts.forensicsTimeout); err != nil {
+					in.log.Printf("Failed to capture forensic snapshot: %s", err)
+				} else {
+					in.log.Printf("Forensic snapshot saved to %s.", dir)
+				}
+			}
+			kill()
+
+		case <-prestartTimer.C():
+			prestartTimer.fired()
+			prestarted = true
+			next, err := startCommand(opts, in, true)
+			if err != nil {
+				in.log.Printf("Failed to prestart the next program instance: %s", err)
+				break
+			}
+			in.log.Printf("Prestarted %s (PID %d, run %s, seed %d), holding it until the current one exits.", opts.output.redact.redactString(strings.Join(in.args, " ")), next.Process.Pid, next.runID, next.seed)
+			in.prestarted = next
+
+		case <-unreadyTimer.C():
+			unreadyTimer.fired()
+			if st == stateRunning {
+				unready = true
+				in.log.Printf("Marking program as not ready %s before the end of the run period.", opts.unreadyBefore)
+				deregister()
+			}
+
+		case <-countdownTimer.C():
+			countdownTimer.fired()
+			in.log.Printf("Next restart in %s.", opts.countdown[countdownNext])
+			countdownNext++
+
+		case <-progressTimer.C():
+			progressTimer.fired()
+			in.log.Printf("Still waiting for program to exit, %s of grace period left.", deadline.Sub(clk.Now()).Round(time.Second))
+			progressAt = progressAt.Add(opts.graceProgress)
+
+		case l := <-fleetSlot:
+			fleetSlot = nil
+			if l == nil {
+				break // ctx is canceled
+			}
+			in.fleetSlot = l
+			if st == stateRunning {
+				recycle()
+			} else {
+				in.releaseSlot(opts)
+			}
+
+		case waitSlot <- struct{}{}:
+			waitSlot = nil
+			in.recycling = true
+			if st == stateRunning {
+				recycle()
+			} else {
+				in.releaseSlot(opts)
+			}
+
+		case <-changed:
+			// recalculate deadline
+
+		case <-in.clockJumped:
+			// recalculate deadline
+
+		case d := <-in.suspended:
+			switch opts.suspend {
+			case suspendInclude:
+				// move periods' starts back as if the program was running all that time
+				runStart = runStart.Add(-d)
+				graceStart = graceStart.Add(-d)
+			case suspendRecycle:
+				if st == stateStarting || st == stateRunning {
+					in.log.Printf("Restarting program: system was suspended.")
+					stop()
+				}
+			}
+
+		case s := <-stopped:
+			now := clk.Now()
+			switch {
+			case s && pausedAt.IsZero():
+				in.log.Printf("Program is stopped, pausing the run period.")
+				pausedAt = now
+			case !s && !pausedAt.IsZero():
+				d := now.Sub(pausedAt)
+				in.log.Printf("Program is continued after %s, resuming the run period.", d.Round(time.Second))
+				pausedAt = time.Time{}
+				if st == stateRunning {
+					runStart = runStart.Add(d)
+				}
+			}
+
+		case reason := <-in.trigger:
+			// the next iteration starts right after this one, however it ends
+			in.triggered = true
+			if st == stateStarting || st == stateRunning {
+				in.log.Printf("Restarting program: trigger fired (%s).", reason)
+				recycle()
+			}
+
+		case reason := <-in.restart:
+			if st == stateStarting || st == stateRunning {
+				in.log.Printf("Restarting program: %s.", reason)
+				recycle()
+			}
+
+		case t := <-cmd.triggers:
+			switch {
+			case t.ready:
+				if st == stateStarting && ready == nil {
+					becomeReady()
+				}
+			case t.extend > 0:
+				if st == stateRunning {
+					extended += t.extend
+					in.log.Printf("Extending run period by %s: %s.", t.extend, t.reason)
+				}
+			case t.kill && st != stateKilling:
+				in.log.Printf("Killing program: %s.", t.reason)
+				killErr = fmt.Errorf("program killed: %s", t.reason)
+				kill()
+			case st == stateStarting || st == stateRunning:
+				in.log.Printf("Restarting program: %s.", t.reason)
+				recycle()
+			}
+		}
+	}
+}
+
+// iterate runs a single iteration, holding the external lock (if configured) for its duration.
+func iterate(ctx context.Context, opts *options, in *instance) error {
+	in.status.update(func(s *status) {
+		s.Iteration++
+		s.State = stateWaiting
+	})
+
+	if in.backoffDelay > 0 {
+		in.log.Printf("Program is not stable, backing off for %s.", in.backoffDelay)
+		if err := sleepUntil(ctx, clk.Now().Add(in.backoffDelay)); err != nil {
+			return nil // ctx is canceled
+		}
+	}
+
+	if prev := in.status.get().StartedAt; prev != nil && opts.minInterval > 0 {
+		if err := sleepUntil(ctx, prev.Add(opts.minInterval)); err != nil {
+			return nil // ctx is canceled
+		}
+	}
+
+	if opts.paceFeedback != "" && in.status.get().StartedAt != nil {
+		if err := waitPace(ctx, opts, in); err != nil {
+			return nil // ctx is canceled
+		}
+	}
+
+	if opts.drainFile != "" {
+		if err := waitDrainFile(ctx, opts, in); err != nil {
+			return nil // ctx is canceled
+		}
+	}
+
+	if opts.trigger != nil {
+		if err := waitTrigger(ctx, in); err != nil {
+			return nil // ctx is canceled
+		}
+	}
+
+	if opts.schedule != "" {
+		if err := waitSchedule(ctx, opts, in); err != nil {
+			return nil // ctx is canceled
+		}
+	}
+
+	if err := in.waitDeps(ctx); err != nil {
+		return nil // ctx is canceled
+	}
+
+	if err := in.waitRelease(ctx); err != nil {
+		return nil // ctx is canceled
+	}
+
+	if opts.lock != nil {
+		lockCtx, lockCancel, err := acquireLock(ctx, opts.lock, opts.lockInterval)
+		if err != nil {
+			return nil // ctx is canceled
+		}
+		defer func() {
+			lockCancel()
+			opts.lock.unlock()
+		}()
+
+		// stop program if the lock is lost
+		ctx = lockCtx
+	}
+
+	if opts.finishing() {
+		return nil
+	}
+
+	if len(opts.altDirs) > 0 {
+		if err := nextDataDir(opts, in); err != nil {
+			return err
+		}
+	}
+
+	start := clk.Now()
+	in.graceExceeded = false
+	in.exit = nil
+	err := run(ctx, opts, in)
+	in.backoffDelay = in.backoff.next(clk.Now().Sub(start))
+
+	prev := in.status.get()
+	in.status.update(func(s *status) {
+		s.State = stateExited
+		s.ChildPID = 0
+		s.Deadline = nil
+		if err != nil {
+			s.LastExit = err.Error()
+		} else {
+			s.LastExit = "exit status 0"
+		}
+		s.RecentExits = append(s.RecentExits, exitRecord{Time: clk.Now(), Result: s.LastExit, GraceExceeded: in.graceExceeded})
+		if len(s.RecentExits) > maxRecentExits {
+			s.RecentExits = s.RecentExits[len(s.RecentExits)-maxRecentExits:]
+		}
+	})
+
+	if prev.ChildPID != 0 {
+		result := "exit status 0"
+		if err != nil {
+			result = err.Error()
+		}
+		opts.notifier.notify(in, eventExit, prev.ChildPID, prev.RunID, result)
+		if errors.Is(err, errOOMKilled) {
+			opts.notifier.notify(in, eventOOM, prev.ChildPID, prev.RunID, result)
+		}
+		if err != nil {
+			opts.notifier.notify(in, eventFailure, prev.ChildPID, prev.RunID, result)
+		}
+		if opts.cleanupCmd != "" {
+			cleanup(opts, in, prev.ChildPID, prev.RunID, err)
+		}
+	}
+
+	if err != nil {
+		in.failures++
+	} else {
+		in.failures = 0
+	}
+	if opts.bundleDir != "" && err != nil && in.failures == opts.bundleAfter {
+		if path, berr := saveBundle(opts, prev.RunID); berr != nil {
+			in.log.Printf("Failed to save failure bundle: %s", berr)
+		} else {
+			in.log.Printf("Saved failure bundle after %d consecutive failures: %s.", in.failures, path)
+		}
+	}
+
+	if errors.Is(err, errOOMKilled) && opts.restartOnOOM {
+		in.log.Printf("Restarting program killed by the OOM killer.")
+		return nil
+	}
+
+	return err
+}
+
+// clk is the time source of the restart loop; tests may replace it with clock.Fake.
+var clk = clock.Real
+
+// sleepUntil sleeps until the given time or until ctx is canceled.
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := t.Sub(clk.Now())
+	if d <= 0 {
+		return nil
+	}
+
+	timer := clk.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
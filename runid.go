@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+)
+
+// Environment variables with per-run identifiers passed to the program.
+const (
+	runIDEnv = "RUC_RUN_ID"
+	seedEnv  = "RUC_SEED"
+)
+
+// logPrefix is ruc's log prefix outside of runs.
+const logPrefix = "ruc: "
+
+// newRunID returns a random (version 4) UUID identifying a single run.
+func newRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// newSeed returns a random non-negative seed for the program's pseudo-random generators.
+func newSeed() int64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]) >> 1)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// schedule is a cron-style shorthand aligned to calendar boundaries in the -timezone (local by default).
+type schedule string
+
+// scheduleLocation is the time zone of schedule boundaries.
+var scheduleLocation = time.Local
+
+// schedules lists supported schedules.
+var schedules = []schedule{"@yearly", "@annually", "@monthly", "@weekly", "@daily", "@midnight", "@hourly"}
+
+// parseSchedule parses schedule shorthand.
+func parseSchedule(s string) (schedule, error) {
+	for _, sch := range schedules {
+		if s == string(sch) {
+			return sch, nil
+		}
+	}
+
+	names := make([]string, len(schedules))
+	for i, sch := range schedules {
+		names[i] = string(sch)
+	}
+	return "", fmt.Errorf("unknown schedule %q, expected one of %s", s, strings.Join(names, ", "))
+}
+
+// next returns the first boundary after t.
+func (s schedule) next(t time.Time) time.Time {
+	t = t.In(scheduleLocation)
+	y, m, d := t.Date()
+
+	switch s {
+	case "@yearly", "@annually":
+		return wallTime(y+1, 1, 1, 0)
+	case "@monthly":
+		return wallTime(y, m+1, 1, 0)
+	case "@weekly":
+		// weeks start on Sunday, like in cron
+		return wallTime(y, m, d+7-int(t.Weekday()), 0)
+	case "@daily", "@midnight":
+		return wallTime(y, m, d+1, 0)
+	case "@hourly":
+		// count elapsed hours, so an hour repeated when DST ends is not skipped
+		start := t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+		if next := start.Add(time.Hour); next.Minute() == 0 {
+			return next
+		}
+		// zones with DST shifts that are not whole hours
+		return wallTime(y, m, d, t.Hour()+1)
+	default:
+		panic("unknown schedule " + string(s))
+	}
+}
+
+// parseStartAt parses -start-at value: RFC 3339 timestamp, or HH:MM wall clock time in scheduleLocation,
+// today or tomorrow (if it already passed).
+func parseStartAt(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	hm, err := time.Parse("15:04", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid start time %q, expected HH:MM or RFC 3339 timestamp", s)
+	}
+
+	now = now.In(scheduleLocation)
+	y, m, d := now.Date()
+	t := time.Date(y, m, d, hm.Hour(), hm.Minute(), 0, 0, scheduleLocation)
+	if !t.After(now) {
+		t = time.Date(y, m, d+1, hm.Hour(), hm.Minute(), 0, 0, scheduleLocation)
+	}
+	return t, nil
+}
+
+// wallTime returns the first instant of the given hour in scheduleLocation.
+// If that hour is skipped when DST starts, it returns the end of the skipped interval.
+// If it is repeated when DST ends, it returns its first occurrence.
+func wallTime(y int, m time.Month, d, h int) time.Time {
+	t := time.Date(y, m, d, h, 0, 0, 0, scheduleLocation)
+
+	// for skipped wall clock times, time.Date returns time before the transition
+	want := time.Date(y, m, d, h, 0, 0, 0, time.UTC)
+	got := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	if got.Before(want) {
+		if _, end := t.ZoneBounds(); !end.IsZero() {
+			return end
+		}
+	}
+
+	return t
+}
+
+// period is either a fixed duration or a schedule.
+// It implements flag.Value.
+type period struct {
+	d time.Duration
+	s schedule
+}
+
+// end returns the end of the period that started at the given time.
+// The end of a schedule keeps start's monotonic clock reading, so wall clock jumps do not move it.
+func (p *period) end(start time.Time) time.Time {
+	if p.s != "" {
+		return start.Add(p.s.next(start).Sub(start))
+	}
+	return start.Add(p.d)
+}
+
+func (p *period) String() string {
+	if p.s != "" {
+		return string(p.s)
+	}
+	return p.d.String()
+}
+
+func (p *period) Set(s string) error {
+	if strings.HasPrefix(s, "@") {
+		sch, err := parseSchedule(s)
+		if err != nil {
+			return err
+		}
+		*p = period{s: sch}
+		return nil
+	}
+
+	d, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return fmt.Errorf("invalid period %q: must be positive", s)
+	}
+
+	*p = period{d: d}
+	return nil
+}
+
+// runCmdEnv is the environment variable with the configured run period for -run-cmd.
+const runCmdEnv = "RUC_RUN"
+
+// runCmdTimeout is the maximal time of a single -run-cmd execution.
+const runCmdTimeout = 10 * time.Second
+
+// periodFromCommand runs -run-cmd with /bin/sh -c and parses the run period (a duration or a schedule)
+// from its stdout; the configured period is passed to it.
+func periodFromCommand(ctx context.Context, command string, configured period) (period, error) {
+	ctx, cancel := context.WithTimeout(ctx, runCmdTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), runCmdEnv+"="+configured.String())
+	cmd.Stderr = os.Stderr
+	b, err := cmd.Output()
+	if err != nil {
+		return period{}, err
+	}
+
+	var p period
+	if err = p.Set(strings.TrimSpace(string(b))); err != nil {
+		return period{}, err
+	}
+	return p, nil
+}
+
+// -catch-up policies for schedule boundaries missed because ruc was down, the host was suspended,
+// or the previous run overran.
+const (
+	catchUpSkip    = "skip"     // wait for the next boundary
+	catchUpRunOnce = "run-once" // run once for all missed boundaries
+	catchUpRunAll  = "run-all"  // run for each missed boundary
+)
+
+// waitSchedule waits until the next schedule boundary (or returns immediately if missed boundaries are caught up),
+// or until ctx is canceled. The boundary of the start is recorded in the instance and -catch-up-file.
+func waitSchedule(ctx context.Context, opts *options, in *instance) error {
+	s := opts.schedule
+	now := clk.Now().Round(0)
+
+	if last := in.lastBoundary; !last.IsZero() && opts.catchUp != catchUpSkip {
+		first := s.next(last)
+		if !first.After(now) {
+			latest, missed := first, 1
+			for b := s.next(first); !b.After(now); b = s.next(b) {
+				latest = b
+				missed++
+			}
+
+			b := latest
+			if opts.catchUp == catchUpRunAll {
+				b = first
+			}
+			in.log.Printf("Missed %d %s run(s) since %s; running for %s now.", missed, s, last.Format(time.DateTime), b.Format(time.DateTime))
+			in.setLastBoundary(opts, b)
+			return nil
+		}
+	}
+
+	next := s.next(now)
+	in.log.Printf("Waiting for %s schedule until %s.", s, next.Format(time.DateTime))
+
+	if err := sleepUntilWall(ctx, next); err != nil {
+		return err
+	}
+
+	in.setLastBoundary(opts, next)
+	return nil
+}
+
+// readCatchUpFile reads the last schedule boundary from -catch-up-file; a missing file is not an error.
+func readCatchUpFile(path string) (time.Time, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(b)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return t.In(scheduleLocation), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	prev := scheduleLocation
+	scheduleLocation = time.UTC
+	t.Cleanup(func() { scheduleLocation = prev })
+
+	now := time.Date(2024, 2, 14, 10, 30, 15, 0, time.UTC) // Wednesday
+
+	for s, expected := range map[schedule]time.Time{
+		"@hourly":  time.Date(2024, 2, 14, 11, 0, 0, 0, time.UTC),
+		"@daily":   time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC),
+		"@weekly":  time.Date(2024, 2, 18, 0, 0, 0, 0, time.UTC),
+		"@monthly": time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"@yearly":  time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		if actual := s.next(now); !actual.Equal(expected) {
+			t.Errorf("%s: expected %s, got %s", s, expected, actual)
+		}
+	}
+}
+
+func TestRunSchedulerRate(t *testing.T) {
+	origin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := period{d: time.Hour}
+	s := runScheduler{mode: intervalModeRate}
+
+	for i, tc := range []struct {
+		start   time.Duration // since origin
+		end     time.Duration // since origin
+		skipped int64
+	}{
+		{0, time.Hour, 0},
+		{time.Hour + 30*time.Second, 2 * time.Hour, 0}, // shutdown took 30s, the next period is not stretched
+		{4*time.Hour + time.Minute, 5 * time.Hour, 2},  // the previous iteration overran two periods
+	} {
+		end, skipped := s.end(p, origin.Add(tc.start))
+		if expected := origin.Add(tc.end); !end.Equal(expected) {
+			t.Errorf("iteration %d: expected end %s, got %s", i+1, expected, end)
+		}
+		if skipped != tc.skipped {
+			t.Errorf("iteration %d: expected %d skipped, got %d", i+1, tc.skipped, skipped)
+		}
+	}
+
+	s = runScheduler{mode: intervalModeDelay}
+	start := origin.Add(time.Hour + 30*time.Second)
+	if end, _ := s.end(p, start); !end.Equal(start.Add(time.Hour)) {
+		t.Errorf("delay mode: expected end %s, got %s", start.Add(time.Hour), end)
+	}
+}
+
+func TestDeadlineTimer(t *testing.T) {
+	fake := fakeClock(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	var dt deadlineTimer
+	if dt.C() != nil {
+		t.Fatal("unarmed timer should have nil channel")
+	}
+
+	deadline := fake.Now().Add(time.Hour)
+	dt.set(deadline)
+	dt.set(deadline) // does not re-arm
+	if n := fake.Timers(); n != 1 {
+		t.Fatalf("expected 1 armed timer, got %d", n)
+	}
+
+	// moving the deadline reuses the timer
+	dt.set(deadline.Add(time.Minute))
+	fake.Advance(time.Hour)
+	select {
+	case <-dt.C():
+		t.Fatal("timer fired before the moved deadline")
+	default:
+	}
+
+	fake.Advance(time.Minute)
+	<-dt.C()
+	if late := dt.fired(); late != 0 {
+		t.Errorf("expected no lateness, got %s", late)
+	}
+	if dt.C() != nil {
+		t.Error("fired timer should be disarmed")
+	}
+}
+
+func TestWaitSchedule(t *testing.T) {
+	prev := scheduleLocation
+	scheduleLocation = time.UTC
+	t.Cleanup(func() { scheduleLocation = prev })
+
+	fake := fakeClock(t, time.Date(2024, 2, 14, 10, 30, 0, 0, time.UTC))
+	opts := &options{schedule: "@hourly", catchUp: catchUpSkip}
+	in := &instance{log: log.New(testWriter{t}, "", 0)}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- waitSchedule(context.Background(), opts, in)
+	}()
+
+	// sleepUntilWall re-checks the wall clock at least every minute
+	for i := 0; i < 29; i++ {
+		fake.WaitTimers(1)
+		fake.Advance(time.Minute)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("returned %v before the boundary", err)
+	default:
+	}
+
+	fake.WaitTimers(1)
+	fake.Advance(time.Minute)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2024, 2, 14, 11, 0, 0, 0, time.UTC); !in.lastBoundary.Equal(expected) {
+		t.Errorf("expected last boundary %s, got %s", expected, in.lastBoundary)
+	}
+}
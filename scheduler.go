@@ -0,0 +1,101 @@
+package main
+
+import (
+	"time"
+
+	"github.com/AlekSi/ruc/clock"
+)
+
+// Interval modes define how -run periods of consecutive iterations relate to each other.
+const (
+	intervalModeDelay = "delay" // each period starts when the program starts (or becomes ready), so shutdown time stretches the cycle
+	intervalModeRate  = "rate"  // periods follow each other from the first start, regardless of how long shutdown took
+)
+
+// deadlineTimer is a single time.Timer for a deadline that may change while it is waited for.
+// Deadlines keep monotonic clock readings, so wall clock changes do not affect them.
+type deadlineTimer struct {
+	t        clock.Timer
+	deadline time.Time
+	armed    bool
+}
+
+// set arms the timer for the given deadline, if it is not armed for it already;
+// zero deadline disarms the timer.
+func (dt *deadlineTimer) set(deadline time.Time) {
+	if dt.armed && dt.deadline.Equal(deadline) {
+		return
+	}
+
+	dt.stop()
+	if deadline.IsZero() {
+		return
+	}
+
+	if dt.t == nil {
+		dt.t = clk.NewTimer(deadline.Sub(clk.Now()))
+	} else {
+		dt.t.Reset(deadline.Sub(clk.Now()))
+	}
+	dt.deadline = deadline
+	dt.armed = true
+}
+
+// C returns the channel that receives when the deadline is reached, or nil if the timer is not armed.
+func (dt *deadlineTimer) C() <-chan time.Time {
+	if !dt.armed {
+		return nil
+	}
+	return dt.t.C()
+}
+
+// fired must be called after receiving from C; it returns how late the timer fired.
+func (dt *deadlineTimer) fired() time.Duration {
+	dt.armed = false
+	return clk.Now().Sub(dt.deadline)
+}
+
+// stop disarms the timer.
+func (dt *deadlineTimer) stop() {
+	if dt.armed && !dt.t.Stop() {
+		// drain the expired timer's value, if it is not received yet
+		select {
+		case <-dt.t.C():
+		default:
+		}
+	}
+	dt.armed = false
+}
+
+// runScheduler computes the ends of -run periods across iterations of a single instance.
+type runScheduler struct {
+	mode string
+
+	origin  time.Time // start of the first period in rate mode
+	start   time.Time // of the current period
+	periods int64     // since origin, at the end of the current period
+}
+
+// end returns the end of the period p that started at the given time.
+// The second result is the number of rate mode periods skipped because the previous iteration overran them;
+// it is reported once per start.
+func (s *runScheduler) end(p period, start time.Time) (time.Time, int64) {
+	if p.s != "" || s.mode != intervalModeRate {
+		return p.end(start), 0
+	}
+
+	if s.origin.IsZero() {
+		s.origin = start
+	}
+
+	var skipped int64
+	periods := int64(start.Sub(s.origin)/p.d) + 1
+	if !start.Equal(s.start) {
+		// the previous period ended at s.periods
+		s.start = start
+		skipped = max(periods-s.periods-1, 0)
+	}
+	s.periods = periods
+
+	return s.origin.Add(time.Duration(periods) * p.d), skipped
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationPattern matches durations accepted by parseDuration.
+const durationPattern = `^([0-9.]+[dw])*(-?([0-9.]+(ns|us|µs|ms|s|m|h))+|0)?$`
+
+// configSchema returns JSON Schema of -config files generated from the given flags, so it can't drift from them:
+// a file is an object with flags outside sections, and [profile] sections are nested objects with the same flags.
+// Flags which usage says they may be repeated accept arrays of values.
+func configSchema(fs *flag.FlagSet) map[string]any {
+	props := map[string]any{
+		"include": map[string]any{
+			"description": "Read flags from that file or files matching that pattern (e.g. conf.d/*.conf), relative to the including file",
+			"anyOf":       []any{map[string]any{"type": "string"}, map[string]any{"type": "array", "items": map[string]any{"type": "string"}}},
+		},
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "config", "profile":
+			// can't be used in config file
+			return
+		}
+		props[f.Name] = flagSchema(f)
+	})
+
+	return map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "ruc -config file",
+		"description": "Flags as name = value lines, with [profile] sections used only with -profile",
+		"type":        "object",
+		"properties":  props,
+		"additionalProperties": map[string]any{
+			"description":          "[profile] section used only with -profile",
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		},
+	}
+}
+
+// flagSchema returns JSON Schema of a single flag value.
+func flagSchema(f *flag.Flag) map[string]any {
+	value := make(map[string]any)
+	var def any
+	switch v := f.Value.(type) {
+	case *durationValue:
+		value["type"] = "string"
+		value["pattern"] = durationPattern
+		def = f.DefValue
+	case *byteSize:
+		value["type"] = []string{"integer", "string"}
+		value["pattern"] = `^[0-9]+([KMGTkmgt][Ii]?)?[Bb]?$`
+		def = f.DefValue
+	case flag.Getter:
+		switch v.Get().(type) {
+		case bool:
+			value["type"] = "boolean"
+			def, _ = strconv.ParseBool(f.DefValue)
+		case int, int64, uint, uint64:
+			value["type"] = "integer"
+			def, _ = strconv.ParseInt(f.DefValue, 10, 64)
+		case float64:
+			value["type"] = "number"
+			def, _ = strconv.ParseFloat(f.DefValue, 64)
+		case time.Duration:
+			value["type"] = "string"
+			value["pattern"] = durationPattern
+			def = f.DefValue
+		default:
+			value["type"] = "string"
+			def = f.DefValue
+		}
+	default:
+		value["type"] = "string"
+		def = f.DefValue
+	}
+
+	res := map[string]any{"description": f.Usage}
+	if strings.Contains(f.Usage, "may be repeated") {
+		res["anyOf"] = []any{value, map[string]any{"type": "array", "items": value}}
+		return res
+	}
+
+	for k, v := range value {
+		res[k] = v
+	}
+	if def != "" && def != nil {
+		res["default"] = def
+	}
+	return res
+}
+
+// genSchema writes JSON Schema of -config files for ruc's command-line flags.
+func genSchema(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", "  ")
+	return e.Encode(configSchema(flag.CommandLine))
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// seccompProfile is OCI runtime spec's (and Docker's) seccomp profile.
+// Only the native architecture's system calls are filtered; architectures field is ignored.
+type seccompProfile struct {
+	DefaultAction   string        `json:"defaultAction"`
+	DefaultErrnoRet *uint32       `json:"defaultErrnoRet"`
+	Syscalls        []seccompRule `json:"syscalls"`
+}
+
+// seccompRule is a single rule of seccompProfile.
+type seccompRule struct {
+	Names    []string      `json:"names"`
+	Action   string        `json:"action"`
+	ErrnoRet *uint32       `json:"errnoRet"`
+	Args     []seccompArg  `json:"args"`
+	Includes seccompFilter `json:"includes"`
+	Excludes seccompFilter `json:"excludes"`
+}
+
+// seccompFilter is Docker profile's condition of seccompRule on the architecture, the program's capabilities,
+// and the kernel version. A rule applies only if all its includes match, and none of its excludes do.
+type seccompFilter struct {
+	Arches    []string `json:"arches"`    // GOARCH values
+	Caps      []string `json:"caps"`      // all should be effective to include, any to exclude
+	MinKernel string   `json:"minKernel"` // "major.minor"
+}
+
+// seccompEnv is the environment seccompFilter conditions are checked against.
+type seccompEnv struct {
+	caps   uint64 // the program's effective capabilities
+	kernel [2]int // major and minor version
+}
+
+// newSeccompEnv returns the environment of the current thread, which should execute the program next.
+func newSeccompEnv() (*seccompEnv, error) {
+	caps, err := effectiveCaps()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return nil, err
+	}
+	kernel, err := parseKernelVersion(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &seccompEnv{caps: caps, kernel: kernel}, nil
+}
+
+// parseKernelVersion parses major and minor version from "6.1", "6.1.0-18-amd64", or "6.1-rc1".
+func parseKernelVersion(s string) ([2]int, error) {
+	major, rest, _ := strings.Cut(s, ".")
+	minor := rest
+	if i := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minor = rest[:i]
+	}
+
+	var v [2]int
+	var err1, err2 error
+	v[0], err1 = strconv.Atoi(major)
+	v[1], err2 = strconv.Atoi(minor)
+	if err1 != nil || err2 != nil {
+		return v, fmt.Errorf("invalid kernel version %q", s)
+	}
+	return v, nil
+}
+
+// applies returns true if the rule applies in the environment.
+func (r *seccompRule) applies(env *seccompEnv) (bool, error) {
+	if in := r.Includes.Arches; len(in) > 0 && !slices.Contains(in, runtime.GOARCH) {
+		return false, nil
+	}
+	if slices.Contains(r.Excludes.Arches, runtime.GOARCH) {
+		return false, nil
+	}
+
+	if len(r.Includes.Caps) > 0 {
+		caps, err := parseCaps(strings.Join(r.Includes.Caps, ","))
+		if err != nil {
+			return false, err
+		}
+		if env.caps&caps != caps {
+			return false, nil
+		}
+	}
+	if len(r.Excludes.Caps) > 0 {
+		caps, err := parseCaps(strings.Join(r.Excludes.Caps, ","))
+		if err != nil {
+			return false, err
+		}
+		if env.caps&caps != 0 {
+			return false, nil
+		}
+	}
+
+	for _, k := range []struct {
+		v       string
+		include bool
+	}{
+		{r.Includes.MinKernel, true},
+		{r.Excludes.MinKernel, false},
+	} {
+		if k.v == "" {
+			continue
+		}
+		min, err := parseKernelVersion(k.v)
+		if err != nil {
+			return false, err
+		}
+		newer := env.kernel[0] > min[0] || (env.kernel[0] == min[0] && env.kernel[1] >= min[1])
+		if newer != k.include {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// seccompArg is a system call argument condition of seccompRule.
+type seccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo"`
+	Op       string `json:"op"`
+}
+
+// seccomp filter return values (see seccomp(2)).
+const (
+	seccompRetKillProcess uint32 = 0x80000000
+	seccompRetKillThread  uint32 = 0x00000000
+	seccompRetTrap        uint32 = 0x00030000
+	seccompRetErrno       uint32 = 0x00050000
+	seccompRetTrace       uint32 = 0x7ff00000
+	seccompRetLog         uint32 = 0x7ffc0000
+	seccompRetAllow       uint32 = 0x7fff0000
+)
+
+// seccompAction returns filter return value for OCI action.
+func seccompAction(action string, errnoRet *uint32) (uint32, error) {
+	errno := uint32(syscall.EPERM)
+	if errnoRet != nil {
+		errno = *errnoRet
+	}
+
+	switch action {
+	case "SCMP_ACT_ALLOW":
+		return seccompRetAllow, nil
+	case "SCMP_ACT_ERRNO":
+		return seccompRetErrno | errno&0xffff, nil
+	case "SCMP_ACT_KILL", "SCMP_ACT_KILL_THREAD":
+		return seccompRetKillThread, nil
+	case "SCMP_ACT_KILL_PROCESS":
+		return seccompRetKillProcess, nil
+	case "SCMP_ACT_TRAP":
+		return seccompRetTrap, nil
+	case "SCMP_ACT_TRACE":
+		return seccompRetTrace | errno&0xffff, nil
+	case "SCMP_ACT_LOG":
+		return seccompRetLog, nil
+	default:
+		return 0, fmt.Errorf("unknown seccomp action %q", action)
+	}
+}
+
+// bpfAssembler builds classic BPF program with forward jumps to labels.
+type bpfAssembler struct {
+	prog   []syscall.SockFilter
+	jumps  map[int][2]int // instruction index -> jt and jf labels (-1 for the next instruction)
+	labels []int          // label -> instruction index
+}
+
+// label returns a new label; it should be placed with mark.
+func (a *bpfAssembler) label() int {
+	a.labels = append(a.labels, -1)
+	return len(a.labels) - 1
+}
+
+// mark places the label before the next instruction.
+func (a *bpfAssembler) mark(l int) {
+	a.labels[l] = len(a.prog)
+}
+
+// stmt adds a non-jump instruction.
+func (a *bpfAssembler) stmt(code uint16, k uint32) {
+	a.prog = append(a.prog, syscall.SockFilter{Code: code, K: k})
+}
+
+// jump adds a conditional jump instruction to labels jt and jf (-1 for the next instruction).
+func (a *bpfAssembler) jump(code uint16, k uint32, jt, jf int) {
+	if a.jumps == nil {
+		a.jumps = make(map[int][2]int)
+	}
+	a.jumps[len(a.prog)] = [2]int{jt, jf}
+	a.stmt(code, k)
+}
+
+// assemble resolves jumps.
+func (a *bpfAssembler) assemble() ([]syscall.SockFilter, error) {
+	for i, j := range a.jumps {
+		for n, l := range j {
+			if l < 0 {
+				continue
+			}
+			off := a.labels[l] - i - 1
+			if off < 0 || off > 255 {
+				return nil, errors.New("seccomp profile is too large")
+			}
+			if n == 0 {
+				a.prog[i].Jt = uint8(off)
+			} else {
+				a.prog[i].Jf = uint8(off)
+			}
+		}
+	}
+	return a.prog, nil
+}
+
+// BPF instruction codes.
+const (
+	bpfLdAbsW = syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS
+	bpfRet    = syscall.BPF_RET | syscall.BPF_K
+	bpfAnd    = syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K
+	bpfJeq    = syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K
+	bpfJgt    = syscall.BPF_JMP | syscall.BPF_JGT | syscall.BPF_K
+	bpfJge    = syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K
+)
+
+// seccomp_data offsets; arguments are 64-bit little-endian values.
+const (
+	seccompDataNR   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+)
+
+// compileArg adds instructions that jump to fail if the argument condition is false, and fall through otherwise.
+func (a *bpfAssembler) compileArg(arg seccompArg, fail int) error {
+	if arg.Index > 5 {
+		return fmt.Errorf("invalid seccomp argument index %d", arg.Index)
+	}
+
+	lo := uint32(seccompDataArgs + 8*arg.Index)
+	hi := lo + 4
+	v := arg.Value
+	vLo, vHi := uint32(v), uint32(v>>32)
+
+	ok := a.label()
+	switch arg.Op {
+	case "SCMP_CMP_EQ":
+		a.stmt(bpfLdAbsW, hi)
+		a.jump(bpfJeq, vHi, -1, fail)
+		a.stmt(bpfLdAbsW, lo)
+		a.jump(bpfJeq, vLo, -1, fail)
+	case "SCMP_CMP_NE":
+		a.stmt(bpfLdAbsW, hi)
+		a.jump(bpfJeq, vHi, -1, ok)
+		a.stmt(bpfLdAbsW, lo)
+		a.jump(bpfJeq, vLo, fail, -1)
+	case "SCMP_CMP_MASKED_EQ":
+		w := arg.ValueTwo
+		a.stmt(bpfLdAbsW, hi)
+		a.stmt(bpfAnd, vHi)
+		a.jump(bpfJeq, uint32(w>>32), -1, fail)
+		a.stmt(bpfLdAbsW, lo)
+		a.stmt(bpfAnd, vLo)
+		a.jump(bpfJeq, uint32(w), -1, fail)
+	case "SCMP_CMP_GT", "SCMP_CMP_GE":
+		a.stmt(bpfLdAbsW, hi)
+		a.jump(bpfJgt, vHi, ok, -1)
+		a.jump(bpfJeq, vHi, -1, fail)
+		a.stmt(bpfLdAbsW, lo)
+		if arg.Op == "SCMP_CMP_GT" {
+			a.jump(bpfJgt, vLo, -1, fail)
+		} else {
+			a.jump(bpfJge, vLo, -1, fail)
+		}
+	case "SCMP_CMP_LT", "SCMP_CMP_LE":
+		a.stmt(bpfLdAbsW, hi)
+		a.jump(bpfJgt, vHi, fail, -1)
+		a.jump(bpfJeq, vHi, -1, ok)
+		a.stmt(bpfLdAbsW, lo)
+		if arg.Op == "SCMP_CMP_LT" {
+			a.jump(bpfJge, vLo, fail, -1)
+		} else {
+			a.jump(bpfJgt, vLo, fail, -1)
+		}
+	default:
+		return fmt.Errorf("unknown seccomp argument operator %q", arg.Op)
+	}
+	a.mark(ok)
+	return nil
+}
+
+// compileSeccomp compiles the profile into a BPF program for the native architecture and the environment.
+// System calls unknown for it are skipped, as libseccomp does; their names are returned.
+func compileSeccomp(p *seccompProfile, env *seccompEnv) ([]syscall.SockFilter, []string, error) {
+	if seccompSyscalls == nil {
+		return nil, nil, fmt.Errorf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+
+	def, err := seccompAction(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var a bpfAssembler
+
+	// kill processes using other architectures' system calls
+	a.stmt(bpfLdAbsW, seccompDataArch)
+	native := a.label()
+	a.jump(bpfJeq, seccompAuditArch, native, -1)
+	a.stmt(bpfRet, seccompRetKillProcess)
+	a.mark(native)
+	a.stmt(bpfLdAbsW, seccompDataNR)
+
+	// and other ABIs' system calls with the same arch value
+	if seccompX32SyscallBit != 0 {
+		nativeABI := a.label()
+		a.jump(bpfJge, seccompX32SyscallBit, -1, nativeABI)
+		a.stmt(bpfRet, seccompRetKillProcess)
+		a.mark(nativeABI)
+	}
+
+	var unknown []string
+	for i, r := range p.Syscalls {
+		action, err := seccompAction(r.Action, r.ErrnoRet)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ok, err := r.applies(env)
+		if err != nil {
+			return nil, nil, fmt.Errorf("seccomp rule %d: %w", i, err)
+		}
+		if !ok {
+			continue
+		}
+
+		for _, name := range r.Names {
+			nr, ok := seccompSyscalls[name]
+			if !ok {
+				unknown = append(unknown, name)
+				continue
+			}
+
+			next := a.label()
+			a.jump(bpfJeq, nr, -1, next)
+			if len(r.Args) == 0 {
+				a.stmt(bpfRet, action)
+				a.mark(next)
+				continue
+			}
+
+			// arguments' loads replace the system call number; restore it for the next rule
+			fail := a.label()
+			for _, arg := range r.Args {
+				if err = a.compileArg(arg, fail); err != nil {
+					return nil, nil, err
+				}
+			}
+			a.stmt(bpfRet, action)
+			a.mark(fail)
+			a.stmt(bpfLdAbsW, seccompDataNR)
+			a.mark(next)
+		}
+	}
+
+	a.stmt(bpfRet, def)
+	prog, err := a.assemble()
+	if err != nil {
+		return nil, nil, err
+	}
+	return prog, unknown, nil
+}
+
+// loadSeccomp reads and compiles -seccomp profile for the current thread's environment.
+func loadSeccomp(path string) ([]syscall.SockFilter, []string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var p seccompProfile
+	if err = json.Unmarshal(b, &p); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	env, err := newSeccompEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prog, unknown, err := compileSeccomp(&p, env)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return prog, unknown, nil
+}
+
+// checkSeccomp checks that -seccomp profile can be compiled, and warns about unknown system calls in it.
+func checkSeccomp(path string) error {
+	_, unknown, err := loadSeccomp(path)
+	if err != nil {
+		return err
+	}
+
+	if len(unknown) > 0 {
+		log.Printf("Seccomp profile %s names system calls unknown on %s, their rules are skipped: %s.", path, runtime.GOARCH, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// applySeccomp installs -seccomp profile for the current thread, which should execute the program next;
+// the thread should be locked.
+func applySeccomp(path string) error {
+	prog, _, err := loadSeccomp(path)
+	if err != nil {
+		return err
+	}
+
+	// unprivileged processes can install filters only with no_new_privs
+	if err = setNoNewPrivs(); err != nil {
+		return err
+	}
+
+	const seccompModeFilter = 2
+	fprog := syscall.SockFprog{
+		Len:    uint16(len(prog)),
+		Filter: &prog[0],
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, syscall.PR_SET_SECCOMP, seccompModeFilter, uintptr(unsafe.Pointer(&fprog)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("PR_SET_SECCOMP: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build linux && !amd64 && !arm64
+
+package main
+
+// seccompAuditArch is not known for this architecture.
+const seccompAuditArch = 0
+
+// seccompX32SyscallBit is not known for this architecture.
+const seccompX32SyscallBit = 0
+
+// seccompSyscalls is nil: system call numbers are not known for this architecture.
+var seccompSyscalls map[string]uint32
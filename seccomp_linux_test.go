@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/binary"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// seccompData is seccomp_data the program is run against.
+type seccompData struct {
+	nr   uint32
+	arch uint32
+	args [6]uint64
+}
+
+// runBPF interprets the classic BPF program (of instructions used by compileSeccomp) against data,
+// and returns its return value.
+func runBPF(t *testing.T, prog []syscall.SockFilter, d seccompData) uint32 {
+	t.Helper()
+
+	b := make([]byte, 64)
+	binary.LittleEndian.PutUint32(b[seccompDataNR:], d.nr)
+	binary.LittleEndian.PutUint32(b[seccompDataArch:], d.arch)
+	for i, v := range d.args {
+		binary.LittleEndian.PutUint64(b[seccompDataArgs+8*i:], v)
+	}
+
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		jump := func(cond bool) {
+			if cond {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		}
+
+		switch ins.Code {
+		case bpfLdAbsW:
+			acc = binary.LittleEndian.Uint32(b[ins.K:])
+		case bpfAnd:
+			acc &= ins.K
+		case bpfJeq:
+			jump(acc == ins.K)
+		case bpfJgt:
+			jump(acc > ins.K)
+		case bpfJge:
+			jump(acc >= ins.K)
+		case bpfRet:
+			return ins.K
+		default:
+			t.Fatalf("unexpected instruction %#x at %d", ins.Code, pc)
+		}
+	}
+
+	t.Fatal("program did not return")
+	return 0
+}
+
+// testSeccompEnv returns the environment with the given capabilities and recent kernel.
+func testSeccompEnv(caps uint64) *seccompEnv {
+	return &seccompEnv{caps: caps, kernel: [2]int{6, 1}}
+}
+
+// compileTestSeccomp compiles the profile, skipping the test on architectures without seccomp support.
+func compileTestSeccomp(t *testing.T, p *seccompProfile, env *seccompEnv) []syscall.SockFilter {
+	t.Helper()
+
+	if seccompSyscalls == nil {
+		t.Skipf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+
+	prog, _, err := compileSeccomp(p, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestBPFAssemblerJumps(t *testing.T) {
+	var a bpfAssembler
+	l1, l2 := a.label(), a.label()
+	a.jump(bpfJeq, 1, l1, l2) // 0
+	a.jump(bpfJeq, 2, -1, l2) // 1
+	a.stmt(bpfRet, 0)         // 2
+	a.mark(l1)
+	a.stmt(bpfRet, 1) // 3
+	a.stmt(bpfRet, 2) // 4
+	a.mark(l2)
+	a.stmt(bpfRet, 3) // 5
+
+	prog, err := a.assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range [][2]uint8{{2, 4}, {0, 3}} {
+		if actual := [2]uint8{prog[i].Jt, prog[i].Jf}; actual != expected {
+			t.Errorf("instruction %d: expected jt, jf %v, got %v", i, expected, actual)
+		}
+	}
+
+	// offsets are 8-bit
+	a = bpfAssembler{}
+	far := a.label()
+	a.jump(bpfJeq, 0, far, -1)
+	for i := 0; i < 256; i++ {
+		a.stmt(bpfRet, 0)
+	}
+	a.mark(far)
+	a.stmt(bpfRet, 1)
+	if _, err = a.assemble(); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("expected too large error, got %v", err)
+	}
+}
+
+func TestSeccompArch(t *testing.T) {
+	p := &seccompProfile{DefaultAction: "SCMP_ACT_ALLOW"}
+	prog := compileTestSeccomp(t, p, testSeccompEnv(0))
+
+	if actual := runBPF(t, prog, seccompData{nr: 0, arch: seccompAuditArch}); actual != seccompRetAllow {
+		t.Errorf("native: expected %#x, got %#x", seccompRetAllow, actual)
+	}
+	if actual := runBPF(t, prog, seccompData{nr: 0, arch: 0x40000003}); actual != seccompRetKillProcess {
+		t.Errorf("i386: expected %#x, got %#x", seccompRetKillProcess, actual)
+	}
+
+	x32 := uint32(seccompX32SyscallBit)
+	if x32 == 0 {
+		return
+	}
+	if actual := runBPF(t, prog, seccompData{nr: x32 | 1, arch: seccompAuditArch}); actual != seccompRetKillProcess {
+		t.Errorf("x32: expected %#x, got %#x", seccompRetKillProcess, actual)
+	}
+	if actual := runBPF(t, prog, seccompData{nr: x32 - 1, arch: seccompAuditArch}); actual != seccompRetAllow {
+		t.Errorf("below x32: expected %#x, got %#x", seccompRetAllow, actual)
+	}
+}
+
+func TestSeccompArgs(t *testing.T) {
+	const big = 0x1_0000_0002 // both halves are non-zero
+
+	for name, tc := range map[string]struct {
+		args    []seccompArg
+		matches []uint64
+		misses  []uint64
+	}{
+		"EQ": {
+			args:    []seccompArg{{Op: "SCMP_CMP_EQ", Value: big}},
+			matches: []uint64{big},
+			misses:  []uint64{2, 0x2_0000_0002, big + 1},
+		},
+		"NE": {
+			args:    []seccompArg{{Op: "SCMP_CMP_NE", Value: big}},
+			matches: []uint64{2, 0x2_0000_0002, big + 1},
+			misses:  []uint64{big},
+		},
+		"GT": {
+			args:    []seccompArg{{Op: "SCMP_CMP_GT", Value: big}},
+			matches: []uint64{big + 1, 0x2_0000_0000},
+			misses:  []uint64{big, big - 1, 0xffff_ffff},
+		},
+		"GE": {
+			args:    []seccompArg{{Op: "SCMP_CMP_GE", Value: big}},
+			matches: []uint64{big, big + 1, 0x2_0000_0000},
+			misses:  []uint64{big - 1, 0xffff_ffff},
+		},
+		"LT": {
+			args:    []seccompArg{{Op: "SCMP_CMP_LT", Value: big}},
+			matches: []uint64{big - 1, 0xffff_ffff},
+			misses:  []uint64{big, big + 1, 0x2_0000_0000},
+		},
+		"LE": {
+			args:    []seccompArg{{Op: "SCMP_CMP_LE", Value: big}},
+			matches: []uint64{big, big - 1, 0xffff_ffff},
+			misses:  []uint64{big + 1, 0x2_0000_0000},
+		},
+		"MaskedEQ": {
+			args:    []seccompArg{{Op: "SCMP_CMP_MASKED_EQ", Value: 0xff_0000_00ff, ValueTwo: 0x01_0000_0002}},
+			matches: []uint64{0x01_0000_0002, 0xf01_ffff_ff02},
+			misses:  []uint64{0x02_0000_0002, 0x01_0000_0003},
+		},
+		"SeveralArgs": {
+			args: []seccompArg{
+				{Op: "SCMP_CMP_EQ", Value: big},
+				{Index: 1, Op: "SCMP_CMP_EQ", Value: big},
+			},
+			matches: []uint64{big},
+			misses:  []uint64{2},
+		},
+	} {
+		p := &seccompProfile{
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls: []seccompRule{
+				{Names: []string{"read", "write"}, Action: "SCMP_ACT_ERRNO", Args: tc.args},
+			},
+		}
+		prog := compileTestSeccomp(t, p, testSeccompEnv(0))
+		errno := seccompRetErrno | uint32(syscall.EPERM)
+
+		for _, nr := range []uint32{seccompSyscalls["read"], seccompSyscalls["write"]} {
+			for _, v := range tc.matches {
+				d := seccompData{nr: nr, arch: seccompAuditArch, args: [6]uint64{v, v}}
+				if actual := runBPF(t, prog, d); actual != errno {
+					t.Errorf("%s: %d(%#x): expected %#x, got %#x", name, nr, v, errno, actual)
+				}
+			}
+			for _, v := range tc.misses {
+				d := seccompData{nr: nr, arch: seccompAuditArch, args: [6]uint64{v, v}}
+				if actual := runBPF(t, prog, d); actual != seccompRetAllow {
+					t.Errorf("%s: %d(%#x): expected %#x, got %#x", name, nr, v, seccompRetAllow, actual)
+				}
+			}
+		}
+
+		// other system calls are not affected
+		d := seccompData{nr: seccompSyscalls["close"], arch: seccompAuditArch, args: [6]uint64{big, big}}
+		if actual := runBPF(t, prog, d); actual != seccompRetAllow {
+			t.Errorf("%s: close: expected %#x, got %#x", name, seccompRetAllow, actual)
+		}
+	}
+}
+
+func TestSeccompActions(t *testing.T) {
+	errno := uint32(5)
+
+	for action, expected := range map[string]uint32{
+		"SCMP_ACT_ALLOW":        seccompRetAllow,
+		"SCMP_ACT_ERRNO":        seccompRetErrno | uint32(syscall.EPERM),
+		"SCMP_ACT_KILL":         seccompRetKillThread,
+		"SCMP_ACT_KILL_THREAD":  seccompRetKillThread,
+		"SCMP_ACT_KILL_PROCESS": seccompRetKillProcess,
+		"SCMP_ACT_TRAP":         seccompRetTrap,
+		"SCMP_ACT_TRACE":        seccompRetTrace | uint32(syscall.EPERM),
+		"SCMP_ACT_LOG":          seccompRetLog,
+	} {
+		actual, err := seccompAction(action, nil)
+		if err != nil {
+			t.Errorf("%s: %s", action, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("%s: expected %#x, got %#x", action, expected, actual)
+		}
+	}
+
+	if actual, _ := seccompAction("SCMP_ACT_ERRNO", &errno); actual != seccompRetErrno|errno {
+		t.Errorf("errnoRet: expected %#x, got %#x", seccompRetErrno|errno, actual)
+	}
+
+	if seccompSyscalls == nil {
+		t.Skipf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+
+	for name, p := range map[string]*seccompProfile{
+		"Default": {DefaultAction: "SCMP_ACT_NOPE"},
+		"Rule": {
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls:      []seccompRule{{Names: []string{"read"}, Action: "SCMP_ACT_NOTIFY"}},
+		},
+		"Operator": {
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls:      []seccompRule{{Names: []string{"read"}, Action: "SCMP_ACT_ERRNO", Args: []seccompArg{{Op: "SCMP_CMP_BETWEEN"}}}},
+		},
+		"Index": {
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls:      []seccompRule{{Names: []string{"read"}, Action: "SCMP_ACT_ERRNO", Args: []seccompArg{{Index: 6, Op: "SCMP_CMP_EQ"}}}},
+		},
+		"Capability": {
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls:      []seccompRule{{Names: []string{"read"}, Action: "SCMP_ACT_ERRNO", Includes: seccompFilter{Caps: []string{"CAP_NOPE"}}}},
+		},
+		"MinKernel": {
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls:      []seccompRule{{Names: []string{"read"}, Action: "SCMP_ACT_ERRNO", Excludes: seccompFilter{MinKernel: "six"}}},
+		},
+	} {
+		if _, _, err := compileSeccomp(p, testSeccompEnv(0)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSeccompFilters(t *testing.T) {
+	sysAdmin, err := parseCaps("SYS_ADMIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := "arm"
+	if runtime.GOARCH == other {
+		other = "amd64"
+	}
+
+	for name, tc := range map[string]struct {
+		rule    seccompRule
+		env     *seccompEnv
+		applies bool
+	}{
+		"None":               {seccompRule{}, testSeccompEnv(0), true},
+		"IncludesArch":       {seccompRule{Includes: seccompFilter{Arches: []string{other, runtime.GOARCH}}}, testSeccompEnv(0), true},
+		"IncludesOtherArch":  {seccompRule{Includes: seccompFilter{Arches: []string{other}}}, testSeccompEnv(0), false},
+		"ExcludesArch":       {seccompRule{Excludes: seccompFilter{Arches: []string{runtime.GOARCH}}}, testSeccompEnv(0), false},
+		"ExcludesOtherArch":  {seccompRule{Excludes: seccompFilter{Arches: []string{other}}}, testSeccompEnv(0), true},
+		"IncludesCap":        {seccompRule{Includes: seccompFilter{Caps: []string{"CAP_SYS_ADMIN"}}}, testSeccompEnv(sysAdmin), true},
+		"IncludesNoCap":      {seccompRule{Includes: seccompFilter{Caps: []string{"CAP_SYS_ADMIN"}}}, testSeccompEnv(0), false},
+		"IncludesSomeCaps":   {seccompRule{Includes: seccompFilter{Caps: []string{"CAP_SYS_ADMIN", "CAP_BPF"}}}, testSeccompEnv(sysAdmin), false},
+		"ExcludesCap":        {seccompRule{Excludes: seccompFilter{Caps: []string{"CAP_SYS_ADMIN"}}}, testSeccompEnv(sysAdmin), false},
+		"ExcludesNoCap":      {seccompRule{Excludes: seccompFilter{Caps: []string{"CAP_SYS_ADMIN"}}}, testSeccompEnv(0), true},
+		"IncludesOldKernel":  {seccompRule{Includes: seccompFilter{MinKernel: "4.8"}}, testSeccompEnv(0), true},
+		"IncludesSameKernel": {seccompRule{Includes: seccompFilter{MinKernel: "6.1"}}, testSeccompEnv(0), true},
+		"IncludesNewKernel":  {seccompRule{Includes: seccompFilter{MinKernel: "6.10"}}, testSeccompEnv(0), false},
+		"ExcludesOldKernel":  {seccompRule{Excludes: seccompFilter{MinKernel: "5.4"}}, testSeccompEnv(0), false},
+		"ExcludesNewKernel":  {seccompRule{Excludes: seccompFilter{MinKernel: "7.0"}}, testSeccompEnv(0), true},
+	} {
+		actual, err := tc.rule.applies(tc.env)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if actual != tc.applies {
+			t.Errorf("%s: expected %t, got %t", name, tc.applies, actual)
+		}
+	}
+}
+
+func TestSeccompUnknownSyscalls(t *testing.T) {
+	if seccompSyscalls == nil {
+		t.Skipf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+
+	p := &seccompProfile{
+		DefaultAction: "SCMP_ACT_ERRNO",
+		Syscalls: []seccompRule{
+			{Names: []string{"read", "no_such_syscall"}, Action: "SCMP_ACT_ALLOW"},
+			{Names: []string{"other_arch_syscall"}, Action: "SCMP_ACT_ALLOW", Excludes: seccompFilter{Arches: []string{runtime.GOARCH}}},
+		},
+	}
+	prog, unknown, err := compileSeccomp(p, testSeccompEnv(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unknown) != 1 || unknown[0] != "no_such_syscall" {
+		t.Errorf("expected only no_such_syscall to be unknown, got %q", unknown)
+	}
+	if actual := runBPF(t, prog, seccompData{nr: seccompSyscalls["read"], arch: seccompAuditArch}); actual != seccompRetAllow {
+		t.Errorf("read: expected %#x, got %#x", seccompRetAllow, actual)
+	}
+}
+
+func TestParseKernelVersion(t *testing.T) {
+	for s, expected := range map[string][2]int{
+		"6.1":            {6, 1},
+		"6.1.0-18-amd64": {6, 1},
+		"6.10-rc1":       {6, 10},
+		"4.8":            {4, 8},
+	} {
+		actual, err := parseKernelVersion(s)
+		if err != nil {
+			t.Errorf("%q: %s", s, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("%q: expected %v, got %v", s, expected, actual)
+		}
+	}
+
+	for _, s := range []string{"", "6", "six.1", "6.x"} {
+		if _, err := parseKernelVersion(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// errNoSeccomp is returned on systems without seccomp.
+var errNoSeccomp = errors.New("seccomp is Linux-specific")
+
+// checkSeccomp returns an error: seccomp is Linux-specific.
+func checkSeccomp(path string) error { return errNoSeccomp }
+
+// applySeccomp returns an error: seccomp is Linux-specific.
+func applySeccomp(path string) error { return errNoSeccomp }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// secretTimeout is the maximum time for resolving a single secret.
+const secretTimeout = 10 * time.Second
+
+// secretEnv is an environment variable which value is resolved from a secret source before each start.
+type secretEnv struct {
+	name   string
+	source string
+}
+
+// secrets is a list of environment variables with secret values.
+type secrets []secretEnv
+
+// add adds a secret environment variable defined by NAME=source spec:
+//
+//	NAME=file:/path          - file content without trailing newline
+//	NAME=vault:path/field    - field of Vault secret at path ($VAULT_ADDR, $VAULT_TOKEN or ~/.vault-token)
+func (s *secrets) add(spec string) error {
+	name, source, ok := strings.Cut(spec, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid secret environment variable specification %q", spec)
+	}
+
+	switch {
+	case strings.HasPrefix(source, "file:"):
+	case strings.HasPrefix(source, "vault:"):
+		if !strings.Contains(strings.TrimPrefix(source, "vault:"), "/") {
+			return fmt.Errorf("invalid Vault secret %q: no field", source)
+		}
+	default:
+		return fmt.Errorf("unknown secret source %q", source)
+	}
+
+	*s = append(*s, secretEnv{name: name, source: source})
+	return nil
+}
+
+// env resolves all secrets and returns environment variables for the program.
+// Resolved values are also added to the redactor.
+func (s secrets) env(r *redactor) ([]string, error) {
+	res := make([]string, len(s))
+	for i, se := range s {
+		v, err := resolveSecret(se.source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve secret %s: %w", se.name, err)
+		}
+
+		r.addValue(v)
+		res[i] = se.name + "=" + v
+	}
+	return res, nil
+}
+
+// resolveSecret returns the current value of the secret.
+func resolveSecret(source string) (string, error) {
+	if path, ok := strings.CutPrefix(source, "file:"); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+
+	path, field, _ := cutLast(strings.TrimPrefix(source, "vault:"), "/")
+	return vaultSecret(path, field)
+}
+
+// cutLast is strings.Cut for the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// vaultSecret reads the field of the Vault secret at path; both KV v1 and v2 engines are supported.
+func vaultSecret(path, field string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		addr = "http://127.0.0.1:8200"
+	}
+
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			b, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(b))
+		}
+	}
+	if token == "" {
+		return "", errors.New("Vault token is not set")
+	}
+
+	h := make(http.Header)
+	h.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		h.Set("X-Vault-Namespace", ns)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+
+	var resp struct {
+		Data map[string]any `json:"data"`
+	}
+	u := strings.TrimSuffix(addr, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	if err := postJSON(ctx, "GET", u, h, nil, &resp); err != nil {
+		return "", err
+	}
+
+	// KV v2 engine wraps secret data and metadata
+	data := resp.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+
+	v, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("no field %q in Vault secret %s", field, path)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
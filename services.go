@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+)
+
+// services are ruc's auxiliary listeners (HTTP, control socket, remote control)
+// that are closed on exit.
+type services struct {
+	m       sync.Mutex
+	closers []func()
+}
+
+// add registers a function that closes a started service.
+func (s *services) add(f func()) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.closers = append(s.closers, f)
+}
+
+// close closes services in reverse order of their start.
+func (s *services) close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+	s.closers = nil
+}
+
+// holdOn keeps ruc and its services running after the final failure until ctx is canceled by a signal,
+// so the status and the last output can be inspected before the evidence is gone.
+func holdOn(ctx context.Context, err error) {
+	current.update(func(s *status) {
+		s.Held = true
+	})
+	log.Printf("Holding on after failure (%s) for inspection; send SIGINT or SIGTERM to exit.", err)
+	<-ctx.Done()
+}
+
+// exit is the main shutdown path after the program exits for the last time:
+// it lets waiting stop requests report the final status, delivers pending notifications,
+// writes the final status file, closes services, and exits with the given code.
+func exit(opts *options, code int) {
+	opts.stopper.exited()
+	opts.notifier.wait()
+
+	current.update(func(s *status) {
+		s.ChildPID = 0
+	})
+
+	opts.services.close()
+
+	if code != 0 {
+		os.Exit(code)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// settings holds settings that can be changed while ruc is running.
+type settings struct {
+	m       sync.Mutex
+	run     period
+	grace   time.Duration
+	changed chan struct{} // closed and replaced on change
+}
+
+// get returns current values, and a channel that is closed when they are changed.
+func (s *settings) get() (run period, grace time.Duration, changed <-chan struct{}) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+
+	return s.run, s.grace, s.changed
+}
+
+// set applies changes in the key=value form.
+func (s *settings) set(kvs []string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	run, grace := s.run, s.grace
+	for _, kv := range kvs {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid setting %q, expected key=value", kv)
+		}
+
+		var d time.Duration
+		var err error
+		switch k {
+		case "run":
+			err = run.Set(v)
+		case "grace":
+			if d, err = parseDuration(v); err == nil && d < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+			grace = d
+		default:
+			return fmt.Errorf("unknown setting %q", k)
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid %s value %q: %w", k, v, err)
+		}
+	}
+
+	s.run, s.grace = run, grace
+	if s.changed != nil {
+		close(s.changed)
+	}
+	s.changed = make(chan struct{})
+
+	return nil
+}
+
+// String returns current values in the key=value form.
+func (s *settings) String() string {
+	run, grace, _ := s.get()
+	return fmt.Sprintf("run=%s grace=%s", &run, grace)
+}
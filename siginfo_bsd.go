@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// watchInfoSignal logs a one-line status on SIGINFO (Ctrl-T in a terminal), like dd(1) and other BSD tools.
+func watchInfoSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINFO)
+	go func() {
+		for range ch {
+			log.Print(statusLine(current.get(), time.Now()))
+		}
+	}()
+}
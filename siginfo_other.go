@@ -0,0 +1,6 @@
+//go:build !(darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+// watchInfoSignal does nothing: there is no SIGINFO on this platform.
+func watchInfoSignal() {}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Kill modes compatible with systemd's KillMode= (see systemd.kill(5)).
+// The program's process group stands for the control group.
+const (
+	killModeProcess      = "process"       // SIGTERM and SIGKILL are sent to the main process only
+	killModeMixed        = "mixed"         // SIGTERM to the main process, SIGKILL to the whole group
+	killModeControlGroup = "control-group" // both to the whole group
+)
+
+// signalNames maps signal names (without SIG prefix) to signals.
+var signalNames = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ILL":    syscall.SIGILL,
+	"TRAP":   syscall.SIGTRAP,
+	"ABRT":   syscall.SIGABRT,
+	"BUS":    syscall.SIGBUS,
+	"FPE":    syscall.SIGFPE,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"SEGV":   syscall.SIGSEGV,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"WINCH":  syscall.SIGWINCH,
+	"IO":     syscall.SIGIO,
+	"SYS":    syscall.SIGSYS,
+}
+
+// parseSignal parses signal name (with or without SIG prefix, case-insensitive) or number.
+// Unknown names are refused with suggestions, so typos are reported before signals are sent.
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > maxSignal {
+			return 0, fmt.Errorf("invalid signal number %d, expected 1-%d", n, maxSignal)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	if sig, ok := signalNames[name]; ok {
+		return sig, nil
+	}
+	if sig, ok := platformSignals[name]; ok {
+		return sig, nil
+	}
+	if sig, ok := parseRealtimeSignal(name); ok {
+		return sig, nil
+	}
+
+	if similar := similarSignals(name); len(similar) > 0 {
+		return 0, fmt.Errorf("unknown signal %q, did you mean %s? (ruc signals lists supported ones)", s, strings.Join(similar, " or "))
+	}
+	return 0, fmt.Errorf("unknown signal %q (ruc signals lists supported ones)", s)
+}
+
+// signalName returns signal name without SIG prefix, or its number if it is unknown.
+func signalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	for name, s := range platformSignals {
+		if s == sig {
+			return name
+		}
+	}
+	if name, ok := realtimeSignalName(sig); ok {
+		return name
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// similarSignals returns names of known signals that differ from name by one or two edits, sorted.
+func similarSignals(name string) []string {
+	var res []string
+	for _, m := range []map[string]syscall.Signal{signalNames, platformSignals} {
+		for n := range m {
+			if editDistance(name, n) <= min(2, len(n)/2) {
+				res = append(res, n)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// editDistance returns Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// signalsCommand implements `ruc signals` subcommand.
+func signalsCommand(args []string) {
+	fs := flag.NewFlagSet("signals", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s signals\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Prints numbers and names of signals supported on this platform by flags like -trigger and ruc timeout -s.\n")
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	for n := 1; n <= maxSignal; n++ {
+		if name := signalName(syscall.Signal(n)); name != strconv.Itoa(n) {
+			fmt.Printf("%2d %s\n", n, name)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"syscall"
+)
+
+// maxSignal is the largest signal number with a name; BSDs differ in real-time signals, so they are not supported.
+const maxSignal = 31
+
+// platformSignals maps BSD-specific signal names (without SIG prefix) to signals.
+var platformSignals = map[string]syscall.Signal{
+	"EMT":  syscall.SIGEMT,
+	"INFO": syscall.SIGINFO,
+}
+
+// parseRealtimeSignal returns false: real-time signals are not supported on BSDs.
+func parseRealtimeSignal(name string) (syscall.Signal, bool) { return 0, false }
+
+// realtimeSignalName returns false: real-time signals are not supported on BSDs.
+func realtimeSignalName(sig syscall.Signal) (string, bool) { return "", false }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// maxSignal is the largest signal number.
+const maxSignal = 64
+
+// platformSignals maps Linux-specific signal names (without SIG prefix) to signals.
+var platformSignals = map[string]syscall.Signal{
+	"PWR": syscall.SIGPWR,
+}
+
+// Real-time signals available to programs, as numbered by glibc (it reserves the first two).
+const (
+	sigRTMin = 34
+	sigRTMax = maxSignal
+)
+
+// parseRealtimeSignal parses RTMIN, RTMIN+n, RTMAX, or RTMAX-n name of a real-time signal.
+func parseRealtimeSignal(name string) (syscall.Signal, bool) {
+	base, sign := sigRTMin, 1
+	rest, ok := strings.CutPrefix(name, "RTMIN")
+	if !ok {
+		if rest, ok = strings.CutPrefix(name, "RTMAX"); !ok {
+			return 0, false
+		}
+		base, sign = sigRTMax, -1
+	}
+
+	n := base
+	if rest != "" {
+		op := "+"
+		if sign < 0 {
+			op = "-"
+		}
+		v, ok := strings.CutPrefix(rest, op)
+		d, err := strconv.Atoi(v)
+		if !ok || err != nil || d < 0 {
+			return 0, false
+		}
+		n += sign * d
+	}
+
+	if n < sigRTMin || n > sigRTMax {
+		return 0, false
+	}
+	return syscall.Signal(n), true
+}
+
+// realtimeSignalName returns RTMIN+n or RTMAX-n name of a real-time signal, whichever is closer.
+func realtimeSignalName(sig syscall.Signal) (string, bool) {
+	n := int(sig)
+	switch {
+	case n < sigRTMin || n > sigRTMax:
+		return "", false
+	case n == sigRTMin:
+		return "RTMIN", true
+	case n == sigRTMax:
+		return "RTMAX", true
+	case n-sigRTMin <= sigRTMax-n:
+		return "RTMIN+" + strconv.Itoa(n-sigRTMin), true
+	default:
+		return "RTMAX-" + strconv.Itoa(sigRTMax-n), true
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseRealtimeSignal(t *testing.T) {
+	for s, expected := range map[string]syscall.Signal{
+		"PWR":      syscall.SIGPWR,
+		"RTMIN":    34,
+		"SIGRTMIN": 34,
+		"rtmin+1":  35,
+		"RTMIN+30": 64,
+		"RTMAX":    64,
+		"RTMAX-1":  63,
+		"RTMAX-30": 34,
+		"34":       34,
+		"64":       64,
+	} {
+		actual, err := parseSignal(s)
+		if err != nil {
+			t.Errorf("%q: %s", s, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("%q: expected %d, got %d", s, expected, actual)
+		}
+	}
+
+	for _, s := range []string{"RTMIN+31", "RTMAX-31", "RTMIN-1", "RTMAX+1", "RTMIN+", "RTMIN+x", "RTMIN+-1", "RTMID", "65"} {
+		if sig, err := parseSignal(s); err == nil {
+			t.Errorf("%q: expected error, got %d", s, sig)
+		}
+	}
+
+	for sig, expected := range map[syscall.Signal]string{
+		34: "RTMIN",
+		35: "RTMIN+1",
+		49: "RTMIN+15",
+		50: "RTMAX-14",
+		63: "RTMAX-1",
+		64: "RTMAX",
+		33: "33",
+	} {
+		if actual := signalName(sig); actual != expected {
+			t.Errorf("%d: expected %q, got %q", sig, expected, actual)
+		}
+	}
+}
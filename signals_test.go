@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	for s, expected := range map[string]syscall.Signal{
+		"TERM":    syscall.SIGTERM,
+		"SIGTERM": syscall.SIGTERM,
+		"term":    syscall.SIGTERM,
+		"sigusr2": syscall.SIGUSR2,
+		"SigHup":  syscall.SIGHUP,
+		"VTALRM":  syscall.SIGVTALRM,
+		"9":       syscall.SIGKILL,
+		"15":      syscall.SIGTERM,
+	} {
+		actual, err := parseSignal(s)
+		if err != nil {
+			t.Errorf("%q: %s", s, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("%q: expected %d, got %d", s, expected, actual)
+		}
+	}
+
+	for s, msg := range map[string]string{
+		"":        `unknown signal ""`,
+		"SIG":     `unknown signal "SIG"`,
+		"TREM":    "did you mean TERM or TRAP?",
+		"SIGUSR3": "did you mean USR1 or USR2?",
+		"hupp":    "did you mean HUP?",
+		"XYZZY":   `unknown signal "XYZZY" (ruc signals`,
+		"0":       "invalid signal number 0",
+		"-1":      "invalid signal number -1",
+		"1000":    "invalid signal number 1000",
+	} {
+		if _, err := parseSignal(s); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expected %q error, got %v", s, msg, err)
+		}
+	}
+}
+
+func TestSignalName(t *testing.T) {
+	for sig, expected := range map[syscall.Signal]string{
+		syscall.SIGTERM: "TERM",
+		syscall.SIGKILL: "KILL",
+		syscall.SIGUSR1: "USR1",
+	} {
+		if actual := signalName(sig); actual != expected {
+			t.Errorf("%d: expected %q, got %q", sig, expected, actual)
+		}
+	}
+
+	// names round-trip through parseSignal
+	for n := 1; n <= maxSignal; n++ {
+		sig := syscall.Signal(n)
+		actual, err := parseSignal(signalName(sig))
+		if err != nil {
+			t.Errorf("%d (%s): %s", n, signalName(sig), err)
+			continue
+		}
+		if actual != sig {
+			t.Errorf("%d (%s): got %d", n, signalName(sig), actual)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"TERM", "TERM", 0},
+		{"", "TERM", 4},
+		{"TREM", "TERM", 2},
+		{"TERMS", "TERM", 1},
+		{"HUP", "HIP", 1},
+		{"USR3", "USR1", 1},
+	} {
+		if actual := editDistance(tc.a, tc.b); actual != tc.expected {
+			t.Errorf("%q, %q: expected %d, got %d", tc.a, tc.b, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// state represents the state of the supervised program.
+type state string
+
+const (
+	stateWaiting  state = "waiting"  // waiting for the schedule or the external lock
+	stateStarting state = "starting" // started, but not ready yet
+	stateRunning  state = "running"  // started and ready
+	stateBusy     state = "busy"     // stop deferred until the program releases -busy-lock
+	stateDraining state = "draining" // drain request sent
+	stateStopping state = "stopping" // SIGTERM sent
+	stateKilling  state = "killing"  // SIGKILL sent
+	stateExited   state = "exited"   // exited, not restarted yet
+)
+
+// status describes the current state of ruc and its program.
+type status struct {
+	Name      string     `json:"name,omitempty"` // program name and replica index of an instance
+	Ruc       *buildInfo `json:"ruc,omitempty"`  // of the top-level status only
+	PID       int        `json:"pid"`
+	State     state      `json:"state"`
+	ChildPID  int        `json:"child_pid,omitempty"`
+	Iteration int        `json:"iteration"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	RunID     string     `json:"run_id,omitempty"`
+	Seed      int64      `json:"seed,omitempty"`
+
+	// ProgramStatus is reported by the program itself with "RUC: STATUS=..." stdout lines.
+	ProgramStatus string `json:"program_status,omitempty"`
+
+	// Degraded is the reason of the program's degraded health (it is not restarted for that), if any.
+	Degraded string `json:"degraded,omitempty"`
+
+	// RunPeriod is the run period of the current run computed by -run-cmd, if any.
+	RunPeriod string `json:"run_period,omitempty"`
+
+	// Deadline is the time of the next stop step: SIGTERM while running, SIGKILL while stopping.
+	Deadline *time.Time `json:"deadline,omitempty"`
+
+	LastExit    string       `json:"last_exit,omitempty"`
+	RecentExits []exitRecord `json:"recent_exits,omitempty"` // the most recent exit is the last one
+
+	// Instances are statuses of individual instances in -replicas and -program modes.
+	// State and ChildPID are aggregated then: the best instance state, and the first running instance's PID.
+	Instances     []status `json:"instances,omitempty"`
+	OOMKills      int      `json:"oom_kills,omitempty"`       // number of times the program was killed by the OOM killer
+	PIDsLimitHits int      `json:"pids_limit_hits,omitempty"` // number of times the program hit -max-pids limit
+	ForkFailures  int      `json:"fork_failures,omitempty"`   // number of starts failed because of exhausted resources (ENOMEM, EAGAIN)
+	GraceExceeded int      `json:"grace_exceeded,omitempty"`  // number of runs that needed SIGKILL after the grace period
+	Held          bool     `json:"held,omitempty"`            // ruc gave up on the program and holds on with -hold-on-failure
+	Serving       bool     `json:"serving,omitempty"`         // the program is ready and not within its stop window (see -unready-before)
+	Drained       bool     `json:"drained,omitempty"`         // the program is not started while -drain-file exists
+
+	// Resources is the running program's resource usage, sampled every -resources-interval.
+	Resources *resourceUsage `json:"resources,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// maxRecentExits is the maximal number of exits kept in status.
+const maxRecentExits = 10
+
+// exitRecord describes a single program exit.
+type exitRecord struct {
+	Time          time.Time `json:"time"`
+	Result        string    `json:"result"`
+	GraceExceeded bool      `json:"grace_exceeded,omitempty"` // program was sent SIGKILL after the grace period
+}
+
+// stateRanks orders states from the best to the worst for aggregation.
+var stateRanks = []state{stateRunning, stateBusy, stateStarting, stateDraining, stateStopping, stateKilling, stateExited, stateWaiting}
+
+// aggregate sets State, ChildPID, and Degraded from Instances.
+func (s *status) aggregate() {
+	best := len(stateRanks)
+	s.ChildPID = 0
+	s.Degraded = ""
+	s.Serving = false
+	s.Drained = false
+	for _, r := range s.Instances {
+		s.Serving = s.Serving || r.Serving
+		s.Drained = s.Drained || r.Drained
+		for i, st := range stateRanks {
+			if r.State == st && i < best {
+				best = i
+			}
+		}
+		if r.State == stateRunning && s.ChildPID == 0 {
+			s.ChildPID = r.ChildPID
+		}
+		if r.Degraded != "" && s.Degraded == "" {
+			s.Degraded = r.Name + ": " + r.Degraded
+		}
+	}
+	if best < len(stateRanks) {
+		s.State = stateRanks[best]
+	}
+}
+
+// statusTracker tracks the current status and writes it to the status file (if set).
+type statusTracker struct {
+	m        sync.Mutex
+	s        status
+	path     string
+	onUpdate func(s status) // called with the updated status, if set
+}
+
+// current is the global status tracker.
+var current = &statusTracker{
+	s: status{
+		Ruc:   &rucBuild,
+		PID:   os.Getpid(),
+		State: stateWaiting,
+	},
+}
+
+// get returns a copy of the current status.
+func (t *statusTracker) get() status {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return t.s
+}
+
+// update calls f to modify the current status, then writes the status file.
+func (t *statusTracker) update(f func(s *status)) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	f(&t.s)
+	t.s.UpdatedAt = time.Now()
+
+	if t.onUpdate != nil {
+		t.onUpdate(t.s)
+	}
+
+	if t.path == "" {
+		return
+	}
+
+	if err := writeFileAtomic(t.path, t.s); err != nil {
+		log.Printf("Failed to write status file: %s", err)
+	}
+}
+
+// setState updates the current state.
+func (t *statusTracker) setState(st state) {
+	t.update(func(s *status) {
+		s.State = st
+	})
+}
+
+// remove removes the status file (if set).
+func (t *statusTracker) remove() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.path == "" {
+		return
+	}
+
+	if err := os.Remove(t.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove status file: %s", err)
+	}
+}
+
+// writeFileAtomic writes JSON-encoded v to a temporary file, then renames it to path,
+// so readers never see partially written content.
+func writeFileAtomic(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(b); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// statusLine returns one-line summary of the status: program's state, PID, uptime, and the next stop step.
+func statusLine(s status, now time.Time) string {
+	line := fmt.Sprintf("Program is %s", s.State)
+	if s.ChildPID != 0 {
+		line += fmt.Sprintf(", PID %d", s.ChildPID)
+		if s.StartedAt != nil {
+			line += fmt.Sprintf(", up %s", now.Sub(*s.StartedAt).Round(time.Second))
+		}
+	}
+	if s.Deadline != nil {
+		step := "restart"
+		if s.State == stateStopping {
+			step = "SIGKILL"
+		}
+		line += fmt.Sprintf(", %s in %s", step, s.Deadline.Sub(now).Round(time.Second))
+	}
+	if len(s.Instances) > 0 {
+		line += fmt.Sprintf(", %d instances", len(s.Instances))
+	} else {
+		line += fmt.Sprintf(", iteration %d", s.Iteration)
+	}
+	return line + "."
+}
+
+// readStatusFile reads status from the given file.
+func readStatusFile(path string) (*status, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s status
+	if err = json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// statusCommand implements `ruc status` subcommand.
+func statusCommand(args []string) {
+	fs, socketF := controlFlagSet("status", "status [flags]")
+	statusFileF := fs.String("status-file", os.Getenv(statusFileEnv), "Status file of the running ruc instance, used if -control-socket is not set; defaults to $"+statusFileEnv)
+	jsonF := fs.Bool("json", false, "Print status as JSON")
+	_ = fs.Parse(args)
+
+	if (*socketF == "" && *statusFileF == "") || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var s *status
+	var err error
+	if *socketF != "" {
+		var res string
+		if res, err = controlRequest(*socketF, "status"); err == nil {
+			s = new(status)
+			err = json.Unmarshal([]byte(res), s)
+		}
+	} else {
+		s, err = readStatusFile(*statusFileF)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	if *jsonF {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		_ = e.Encode(s)
+		return
+	}
+
+	printStatus(os.Stdout, s, time.Now())
+}
+
+// printStatus prints human-readable status summary.
+func printStatus(w io.Writer, s *status, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	defer tw.Flush()
+
+	ruc := fmt.Sprintf("%d", s.PID)
+	if !alive(s.PID) {
+		ruc += " (not running)"
+	}
+	fmt.Fprintf(tw, "ruc PID:\t%s\n", ruc)
+	if s.Ruc != nil {
+		fmt.Fprintf(tw, "ruc version:\t%s\n", s.Ruc)
+	}
+	fmt.Fprintf(tw, "State:\t%s\n", s.State)
+	fmt.Fprintf(tw, "Iteration:\t%d\n", s.Iteration)
+
+	if s.ChildPID != 0 {
+		fmt.Fprintf(tw, "Program PID:\t%d\n", s.ChildPID)
+		if s.StartedAt != nil {
+			fmt.Fprintf(tw, "Uptime:\t%s\n", now.Sub(*s.StartedAt).Round(time.Second))
+		}
+	}
+	if r := s.Resources; r != nil {
+		fmt.Fprintf(tw, "Resources:\tCPU %.1f%%, RSS %s, %d FDs, %d threads\n", r.CPUPercent, formatRSS(r.RSS), r.FDs, r.Threads)
+	}
+	if s.RunID != "" {
+		fmt.Fprintf(tw, "Run ID:\t%s\n", s.RunID)
+	}
+	if s.ProgramStatus != "" {
+		fmt.Fprintf(tw, "Program status:\t%s\n", s.ProgramStatus)
+	}
+	if s.RunPeriod != "" {
+		fmt.Fprintf(tw, "Run period:\t%s (from -run-cmd)\n", s.RunPeriod)
+	}
+	if s.Degraded != "" {
+		fmt.Fprintf(tw, "Degraded:\t%s\n", s.Degraded)
+	}
+	if s.Deadline != nil {
+		step := "restart"
+		if s.State == stateStopping {
+			step = "SIGKILL"
+		}
+		fmt.Fprintf(tw, "Next %s:\tin %s (%s)\n", step, s.Deadline.Sub(now).Round(time.Second), s.Deadline.Local().Format(time.DateTime))
+	}
+	if s.OOMKills > 0 {
+		fmt.Fprintf(tw, "OOM kills:\t%d\n", s.OOMKills)
+	}
+	if s.PIDsLimitHits > 0 {
+		fmt.Fprintf(tw, "Tasks limit hits:\t%d\n", s.PIDsLimitHits)
+	}
+	if s.ForkFailures > 0 {
+		fmt.Fprintf(tw, "Fork failures:\t%d\n", s.ForkFailures)
+	}
+	if s.Drained {
+		fmt.Fprintf(tw, "Drained:\tnot starting program until the drain file is removed\n")
+	}
+	if s.Held {
+		fmt.Fprintf(tw, "Holding on:\tafter the final failure, until SIGINT or SIGTERM\n")
+	}
+	if s.GraceExceeded > 0 {
+		fmt.Fprintf(tw, "Grace period exceeded:\t%d\n", s.GraceExceeded)
+	}
+
+	for _, r := range s.Instances {
+		line := string(r.State)
+		if r.ChildPID != 0 {
+			line += fmt.Sprintf(", PID %d", r.ChildPID)
+			if r.StartedAt != nil {
+				line += fmt.Sprintf(", up %s", now.Sub(*r.StartedAt).Round(time.Second))
+			}
+		}
+		if u := r.Resources; u != nil {
+			line += fmt.Sprintf(", CPU %.1f%%, RSS %s", u.CPUPercent, formatRSS(u.RSS))
+		}
+		line += fmt.Sprintf(", iteration %d", r.Iteration)
+		if r.Deadline != nil && r.State == stateRunning {
+			line += fmt.Sprintf(", restart in %s", r.Deadline.Sub(now).Round(time.Second))
+		}
+		if r.LastExit != "" {
+			line += ", last exit: " + r.LastExit
+		}
+		fmt.Fprintf(tw, "Instance %s:\t%s\n", r.Name, line)
+	}
+
+	if len(s.RecentExits) > 0 {
+		fmt.Fprintf(tw, "Recent exits:\t\n")
+		for i := len(s.RecentExits) - 1; i >= 0; i-- {
+			e := s.RecentExits[i]
+			result := e.Result
+			if e.GraceExceeded {
+				result += " (SIGKILL after the grace period)"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\n", e.Time.Local().Format(time.DateTime), result)
+		}
+	} else if s.LastExit != "" {
+		fmt.Fprintf(tw, "Last exit:\t%s\n", s.LastExit)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// feedStdin writes lines sent with `ruc send`, and -stdin-heartbeat ones, to the program's stdin pipe
+// until ctx is canceled or the program stops reading it.
+func feedStdin(ctx context.Context, opts *options, in *instance, w io.Writer) {
+	var tick <-chan time.Time
+	if opts.heartbeat != "" {
+		t := time.NewTicker(opts.stdinInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		var line string
+		select {
+		case <-ctx.Done():
+			return
+		case line = <-in.stdin:
+		case <-tick:
+			line = opts.heartbeat
+		}
+
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			// the pipe is closed after the exit
+			if ctx.Err() == nil {
+				in.log.Printf("Failed to write to program's stdin: %s", err)
+			}
+			return
+		}
+	}
+}
+
+// requestSend sends a line for programs' stdin unless there are enough pending ones.
+func requestSend(lines chan<- string, line string) error {
+	select {
+	case lines <- line:
+		return nil
+	default:
+		return fmt.Errorf("too many pending lines for program's stdin")
+	}
+}
+
+// send implements `ruc send` subcommand.
+func send(args []string) {
+	fs, socketF := controlFlagSet("send", "send [flags] text...\nWrites a line with the text to the program's stdin (requires -stdin-pipe).")
+	_ = fs.Parse(args)
+
+	if *socketF == "" || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	res, err := controlRequest(*socketF, "send", strings.Join(fs.Args(), " "))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Print(res)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// stopper handles requests to stop ruc made via the control socket.
+type stopper struct {
+	cancel  context.CancelFunc
+	done    chan struct{} // closed when the program exits for the last time
+	waiters sync.WaitGroup
+}
+
+// newStopper returns a stopper that cancels the main context.
+func newStopper(cancel context.CancelFunc) *stopper {
+	return &stopper{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// stop finishes the current iteration gracefully and disables further restarts.
+// If wait is true, it waits for the program to exit and writes the final status to w.
+func (s *stopper) stop(w io.Writer, wait bool) error {
+	log.Printf("Stop requested via control socket, shutting down...")
+
+	if !wait {
+		s.cancel()
+		_, err := fmt.Fprintln(w, "stopping")
+		return err
+	}
+
+	s.waiters.Add(1)
+	defer s.waiters.Done()
+
+	s.cancel()
+	<-s.done
+
+	return json.NewEncoder(w).Encode(current.get())
+}
+
+// exited is called by main after the program exits for the last time;
+// it lets waiting stop requests report the final status.
+func (s *stopper) exited() {
+	close(s.done)
+
+	waited := make(chan struct{})
+	go func() {
+		s.waiters.Wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// stop implements `ruc stop` subcommand.
+func stop(args []string) {
+	fs, socketF := controlFlagSet("stop", "stop [flags]\nStops the program gracefully without restarting it, and makes ruc exit.")
+	waitF := fs.Bool("wait", false, "Wait for the program to exit and print its final status; exit with 1 if it failed")
+	_ = fs.Parse(args)
+
+	if *socketF == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if !*waitF {
+		res, err := controlRequest(*socketF, "stop")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(res)
+		return
+	}
+
+	res, err := controlRequest(*socketF, "stop", "wait")
+	if err == nil && res == "" {
+		err = errors.New("ruc exited without reporting the final status")
+	}
+	var s status
+	if err == nil {
+		err = json.Unmarshal([]byte(res), &s)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	exits := []status{s}
+	if len(s.Instances) > 0 {
+		exits = s.Instances
+	}
+
+	var failed bool
+	for _, e := range exits {
+		if e.Name != "" {
+			fmt.Printf("%s ", e.Name)
+		}
+		fmt.Printf("stopped: %s\n", e.LastExit)
+		if e.LastExit != "exit status 0" {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
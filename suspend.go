@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// How time spent with the system suspended is accounted.
+const (
+	suspendExclude = "exclude" // does not count toward periods (monotonic clock does not advance)
+	suspendInclude = "include" // counts toward periods
+	suspendRecycle = "recycle" // program is restarted after resume
+)
+
+// suspendThreshold is the minimal difference between wall and monotonic clocks considered a suspension.
+const suspendThreshold = 5 * time.Second
+
+// parseSuspend checks -suspend flag value.
+func parseSuspend(s string) (string, error) {
+	switch s {
+	case suspendExclude, suspendInclude, suspendRecycle:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown suspend mode %q", s)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	systemdDest  = "org.freedesktop.systemd1"
+	systemdPath  = "/org/freedesktop/systemd1"
+	systemdIface = "org.freedesktop.systemd1.Manager"
+)
+
+// startSystemdScope asks systemd to create a transient scope unit with the given name and TasksMax (if positive),
+// and to move process with the given PID into it.
+//
+// Processes forked by the program before it is moved stay in ruc's cgroup.
+func startSystemdScope(user bool, name, slice string, pid int, tasksMax int) error {
+	c, err := dialDBus(user)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	props := []any{
+		dbusStruct{"Description", dbusVariant{"s", "Program supervised by ruc"}},
+		dbusStruct{"PIDs", dbusVariant{"au", []uint32{uint32(pid)}}},
+		dbusStruct{"CollectMode", dbusVariant{"s", "inactive-or-failed"}},
+	}
+	if slice != "" {
+		props = append(props, dbusStruct{"Slice", dbusVariant{"s", slice}})
+	}
+	if tasksMax > 0 {
+		props = append(props, dbusStruct{"TasksMax", dbusVariant{"t", uint64(tasksMax)}})
+	}
+
+	_, err = c.call(systemdDest, systemdPath, systemdIface, "StartTransientUnit", "ssa(sv)a(sa(sv))", name, "fail", props, []any{})
+	return err
+}
+
+// stopSystemdUnit asks systemd to stop the unit, killing all remaining processes in it.
+// It is not an error if the unit does not exist (anymore).
+func stopSystemdUnit(user bool, name string) error {
+	c, err := dialDBus(user)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	_, err = c.call(systemdDest, systemdPath, systemdIface, "StopUnit", "ss", name, "replace")
+	if err != nil && strings.Contains(err.Error(), "org.freedesktop.systemd1.NoSuchUnit") {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tailBuffer keeps recent program's output and copies live output to subscribers (`ruc tail`).
+type tailBuffer struct {
+	m    sync.Mutex
+	buf  []byte
+	size int
+	subs map[*tailSub]struct{}
+}
+
+// tailSub is a single subscriber.
+type tailSub struct {
+	c       chan []byte
+	dropped int // bytes dropped because the subscriber is too slow; protected by tailBuffer.m
+}
+
+// minTailSize is the size tailBuffer is never shrunk below.
+const minTailSize = 4 << 10
+
+// shrink halves the buffer size (but not below minTailSize), dropping the oldest output,
+// and releases the memory. It returns the new size.
+func (t *tailBuffer) shrink() int {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.size = max(t.size/2, minTailSize)
+	if over := len(t.buf) - t.size; over > 0 {
+		if i := bytes.IndexByte(t.buf[over:], '\n'); i >= 0 {
+			over += i + 1
+		}
+		t.buf = t.buf[min(over, len(t.buf)):]
+	}
+	t.buf = bytes.Clone(t.buf)
+	return t.size
+}
+
+// newTailBuffer returns a buffer keeping up to size bytes of recent output.
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{
+		size: size,
+		subs: make(map[*tailSub]struct{}),
+	}
+}
+
+// Write implements io.Writer. It never blocks on subscribers: output is dropped for slow ones.
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.size; over > 0 {
+		// keep whole lines if possible
+		if i := bytes.IndexByte(t.buf[over:], '\n'); i >= 0 {
+			over += i + 1
+		}
+		t.buf = append(t.buf[:0], t.buf[min(over, len(t.buf)):]...)
+	}
+
+	for s := range t.subs {
+		b := bytes.Clone(p)
+		if s.dropped > 0 {
+			b = append([]byte(fmt.Sprintf("ruc: %d bytes of output dropped\n", s.dropped)), b...)
+		}
+		select {
+		case s.c <- b:
+			s.dropped = 0
+		default:
+			s.dropped += len(p)
+		}
+	}
+
+	return len(p), nil
+}
+
+// subscribe returns up to n last lines of recent output (all of it if n is negative),
+// and a subscription for live output; it should be canceled by the caller.
+func (t *tailBuffer) subscribe(n int) ([]byte, *tailSub, func()) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	recent := t.buf
+	switch {
+	case n == 0:
+		recent = nil
+	case n > 0:
+		i := len(recent)
+		if i > 0 && recent[i-1] == '\n' {
+			i--
+		}
+		for ; n > 0 && i >= 0; n-- {
+			i = bytes.LastIndexByte(recent[:i], '\n')
+		}
+		// i is the position of the newline before the first kept line, or -1
+		if i >= 0 {
+			recent = recent[i+1:]
+		}
+	}
+
+	s := &tailSub{c: make(chan []byte, 64)}
+	t.subs[s] = struct{}{}
+	cancel := func() {
+		t.m.Lock()
+		defer t.m.Unlock()
+		delete(t.subs, s)
+	}
+	return bytes.Clone(recent), s, cancel
+}
+
+// recent returns all recent output.
+func (t *tailBuffer) recent() []byte {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return bytes.Clone(t.buf)
+}
+
+// runTail streams recent and live output to w until writing fails or closed is closed.
+func runTail(w io.Writer, t *tailBuffer, n int, closed <-chan struct{}) error {
+	recent, s, cancel := t.subscribe(n)
+	defer cancel()
+
+	if _, err := w.Write(recent); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case b := <-s.c:
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+		case <-closed:
+			return nil
+		}
+	}
+}
+
+// connClosed returns a channel that is closed when the client closes the connection;
+// nothing else is expected to be read from it.
+func connClosed(conn net.Conn) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+	return closed
+}
+
+// tail implements `ruc tail` subcommand.
+func tail(args []string) {
+	fs, socketF := controlFlagSet("tail", "tail [flags]")
+	linesF := fs.Int("n", 10, "Number of recent output lines to print before live output; -1 means all buffered output")
+	_ = fs.Parse(args)
+
+	if *socketF == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := streamTail(os.Stdout, *socketF, *linesF); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// attach implements `ruc attach` subcommand.
+func attach(args []string) {
+	fs, socketF := controlFlagSet("attach", "attach [flags]")
+	linesF := fs.Int("n", 10, "Number of recent output lines to print before live output; -1 means all buffered output")
+	_ = fs.Parse(args)
+
+	if *socketF == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	exit := func(err error) {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	res, err := controlRequest(*socketF, "status")
+	if err != nil {
+		exit(err)
+	}
+	var s status
+	if err = json.Unmarshal([]byte(res), &s); err != nil {
+		exit(err)
+	}
+	printStatus(os.Stdout, &s, time.Now())
+	fmt.Printf("Attached; press Ctrl-C to detach, use `ruc set` to change settings.\n\n")
+
+	if err = streamTail(os.Stdout, *socketF, *linesF); err != nil {
+		exit(err)
+	}
+}
+
+// streamTail copies program's recent and live output from the control socket to w.
+func streamTail(w io.Writer, socket string, lines int) error {
+	conn, err := dialControl(socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = fmt.Fprintf(conn, "tail %d\n", lines); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	if b, _ := r.Peek(len(controlErrorPrefix)); string(b) == controlErrorPrefix {
+		line, _ := r.ReadString('\n')
+		return errors.New(strings.TrimSpace(strings.TrimPrefix(line, controlErrorPrefix)))
+	}
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// parseTailLines parses the argument of the tail control command.
+func parseTailLines(args []string) (int, error) {
+	if len(args) == 0 {
+		return 10, nil
+	}
+	return strconv.Atoi(args[0])
+}
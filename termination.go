@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// terminationDeadline is the time by which ruc itself is going to be forcefully killed
+// (for example, by kubelet after pod's terminationGracePeriodSeconds); nil if unknown.
+var terminationDeadline atomic.Pointer[time.Time]
+
+// terminationGracePeriodEnv is the environment variable that could be set from the pod spec
+// to the same value as terminationGracePeriodSeconds.
+const terminationGracePeriodEnv = "TERMINATION_GRACE_PERIOD_SECONDS"
+
+// envTerminationGracePeriod returns termination grace period from the environment, or zero.
+func envTerminationGracePeriod() time.Duration {
+	v := os.Getenv(terminationGracePeriodEnv)
+	if v == "" {
+		return 0
+	}
+
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		log.Printf("Ignoring invalid %s value %q.", terminationGracePeriodEnv, v)
+		return 0
+	}
+
+	return time.Duration(secs) * time.Second
+}
+
+// setTerminationDeadline records the termination deadline after receiving a termination signal.
+func setTerminationDeadline(period, margin time.Duration) {
+	if period <= 0 {
+		return
+	}
+
+	d := clk.Now().Add(period - margin)
+	terminationDeadline.Store(&d)
+}
+
+// fitGrace returns grace period reduced to fit before the termination deadline, if any.
+func fitGrace(grace time.Duration) time.Duration {
+	d := terminationDeadline.Load()
+	if d == nil {
+		return grace
+	}
+
+	left := d.Sub(clk.Now())
+	if left >= grace {
+		return grace
+	}
+
+	// leave the program at least some time
+	left = max(left, time.Millisecond)
+	log.Printf("Reducing grace period from %s to %s to fit termination grace period.", grace, left.Round(time.Millisecond))
+	return left
+}
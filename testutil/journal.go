@@ -0,0 +1,171 @@
+package testutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Kinds of fake children events.
+const (
+	EventStart = "start" // the child started
+	EventTerm  = "term"  // the child received SIGTERM
+	EventExit  = "exit"  // the child is exiting by itself or after SIGTERM; SIGKILL leaves no event
+)
+
+// Event is a single fake child lifecycle event.
+type Event struct {
+	Time time.Time `json:"time"`
+	PID  int       `json:"pid"`
+	Run  int       `json:"run"` // index of the child's run, starting from 0
+	Kind string    `json:"kind"`
+	Code int       `json:"code,omitempty"` // exit code of EventExit
+}
+
+// Journal is a file where fake children record their lifecycle events as JSON lines.
+// It is safe for concurrent use by several children and the test.
+type Journal struct {
+	path string
+	run  int // of the child process recording events
+}
+
+// NewJournal returns a new empty Journal in the test's temporary directory.
+func NewJournal(tb testing.TB) *Journal {
+	tb.Helper()
+
+	j := &Journal{path: filepath.Join(tb.TempDir(), "journal.jsonl")}
+	if err := os.WriteFile(j.path, nil, 0o666); err != nil {
+		tb.Fatal(err)
+	}
+	return j
+}
+
+// Path returns the journal file path.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// start records EventStart with the next run index, and returns that index.
+func (j *Journal) start() (int, error) {
+	f, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// count previous starts and append this one atomically
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return 0, err
+	}
+
+	events, err := readEvents(j.path)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range events {
+		if e.Kind == EventStart {
+			j.run++
+		}
+	}
+
+	return j.run, writeEvent(f, Event{Kind: EventStart}, j.run)
+}
+
+// record appends the event of the current process.
+func (j *Journal) record(e Event) error {
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeEvent(f, e, j.run)
+}
+
+// writeEvent fills the event's time, PID, and run index, and appends it as a single write.
+func writeEvent(f *os.File, e Event, run int) error {
+	e.Time = time.Now()
+	e.PID = os.Getpid()
+	e.Run = run
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// readEvents reads all events from the file.
+func readEvents(path string) ([]Event, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		var e Event
+		if err = json.Unmarshal(s.Bytes(), &e); err != nil {
+			// a partially written line
+			break
+		}
+		events = append(events, e)
+	}
+	return events, s.Err()
+}
+
+// Events returns all events recorded so far, in order.
+func (j *Journal) Events(tb testing.TB) []Event {
+	tb.Helper()
+
+	events, err := readEvents(j.path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return events
+}
+
+// Count returns the number of events of the given kind recorded so far.
+func (j *Journal) Count(tb testing.TB, kind string) int {
+	tb.Helper()
+
+	var n int
+	for _, e := range j.Events(tb) {
+		if e.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Wait waits until at least n events of the given kind are recorded, and returns all events.
+// It fails the test if that does not happen in timeout.
+func (j *Journal) Wait(tb testing.TB, kind string, n int, timeout time.Duration) []Event {
+	tb.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		events := j.Events(tb)
+		var got int
+		for _, e := range events {
+			if e.Kind == kind {
+				got++
+			}
+		}
+		if got >= n {
+			return events
+		}
+
+		if time.Now().After(deadline) {
+			tb.Fatalf("testutil: got %d %q events in %s, expected at least %d; events: %+v", got, kind, timeout, n, events)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,140 @@
+package testutil
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// rucPackage is the import path of ruc's main package.
+const rucPackage = "github.com/AlekSi/ruc"
+
+// BuildRuc builds ruc into the test's temporary directory with the go command, and returns the binary path.
+func BuildRuc(tb testing.TB) string {
+	tb.Helper()
+
+	bin := filepath.Join(tb.TempDir(), "ruc")
+	cmd := exec.Command("go", "build", "-o", bin, rucPackage)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		tb.Fatalf("testutil: failed to build ruc: %s\n%s", err, out)
+	}
+	return bin
+}
+
+// Ruc is a ruc process supervising fake children.
+type Ruc struct {
+	tb   testing.TB
+	cmd  *exec.Cmd
+	out  *syncBuffer
+	done chan struct{} // closed after the process exits
+	err  error         // returned by Wait
+}
+
+// StartRuc starts ruc binary bin with the given flags supervising fake children following the script,
+// recording events into j (which may be nil). Flags such as -sanitize-env that drop the environment
+// should not be used, as it configures children.
+// The process is killed when the test ends, if it is still running.
+func StartRuc(tb testing.TB, bin string, flags []string, s Script, j *Journal) *Ruc {
+	tb.Helper()
+
+	args := append(append(append([]string(nil), flags...), "--"), s.Argv()...)
+	r := &Ruc{
+		tb:   tb,
+		cmd:  exec.Command(bin, args...),
+		out:  new(syncBuffer),
+		done: make(chan struct{}),
+	}
+	r.cmd.Env = append(os.Environ(), s.Env(j))
+	r.cmd.Stdout = r.out
+	r.cmd.Stderr = r.out
+	if err := r.cmd.Start(); err != nil {
+		tb.Fatalf("testutil: failed to start ruc: %s", err)
+	}
+
+	go func() {
+		r.err = r.cmd.Wait()
+		close(r.done)
+	}()
+
+	tb.Cleanup(func() {
+		select {
+		case <-r.done:
+		default:
+			_ = r.cmd.Process.Kill()
+			<-r.done
+		}
+	})
+
+	return r
+}
+
+// Signal sends the signal to ruc.
+func (r *Ruc) Signal(sig syscall.Signal) {
+	r.tb.Helper()
+
+	if err := r.cmd.Process.Signal(sig); err != nil {
+		r.tb.Fatalf("testutil: failed to signal ruc: %s", err)
+	}
+}
+
+// Stop sends SIGTERM to ruc, and waits for it to exit; see Wait.
+func (r *Ruc) Stop(timeout time.Duration) int {
+	r.tb.Helper()
+
+	r.Signal(syscall.SIGTERM)
+	return r.Wait(timeout)
+}
+
+// Wait waits for ruc to exit, and returns its exit code.
+// It fails the test if ruc does not exit in timeout, or is killed by a signal.
+func (r *Ruc) Wait(timeout time.Duration) int {
+	r.tb.Helper()
+
+	select {
+	case <-r.done:
+	case <-time.After(timeout):
+		r.tb.Fatalf("testutil: ruc did not exit in %s; output:\n%s", timeout, r.Output())
+	}
+
+	var ee *exec.ExitError
+	switch {
+	case r.err == nil:
+		return 0
+	case errors.As(r.err, &ee) && ee.ExitCode() >= 0:
+		return ee.ExitCode()
+	default:
+		r.tb.Fatalf("testutil: ruc failed: %s; output:\n%s", r.err, r.Output())
+		return -1
+	}
+}
+
+// Output returns ruc's and children's combined stdout and stderr so far.
+func (r *Ruc) Output() string {
+	return r.out.String()
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	m sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.b.String()
+}
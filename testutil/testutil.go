@@ -0,0 +1,200 @@
+// Package testutil helps testing ruc and other code supervising programs
+// with scripted fake children: their exit codes, SIGTERM handling, and shutdown delays are set by the test,
+// and their lifecycle events are recorded into a Journal, so restart and escalation behavior can be asserted
+// deterministically.
+//
+// Fake children are the test binary itself started again, so tests using them should call Main from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		testutil.Main(m)
+//	}
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// childEnv is the environment variable containing JSON-encoded childConfig
+// that makes the test binary act as a fake child.
+const childEnv = "RUC_TESTUTIL_CHILD"
+
+// Child describes the behavior of a single fake child run.
+type Child struct {
+	// ExitCode is the exit code used both when the child exits by itself and after SIGTERM.
+	ExitCode int `json:"exit_code,omitempty"`
+
+	// RunFor makes the child exit by itself after that long; 0 runs it until it is signaled.
+	RunFor time.Duration `json:"run_for,omitempty"`
+
+	// IgnoreTerm makes the child record and ignore SIGTERM, so only SIGKILL stops it.
+	IgnoreTerm bool `json:"ignore_term,omitempty"`
+
+	// ShutdownDelay is the delay between SIGTERM and the exit, for slow shutdowns.
+	ShutdownDelay time.Duration `json:"shutdown_delay,omitempty"`
+
+	// Output is written to stdout after the start.
+	Output string `json:"output,omitempty"`
+
+	// Listen makes the child accept TCP connections on that address, and echo received data back.
+	Listen string `json:"listen,omitempty"`
+}
+
+// Script describes fake children runs in order: the n-th started child behaves as Script[n],
+// and the last element is used for all the following runs. Empty Script runs a zero Child.
+// Runs are counted by the Journal, so it is required for scripts of several runs.
+type Script []Child
+
+// childConfig is passed to the fake child via childEnv.
+type childConfig struct {
+	Journal string `json:"journal,omitempty"`
+	Script  Script `json:"script"`
+}
+
+// Env returns the environment variable that makes the test binary act as a fake child following the script,
+// recording events into j (which may be nil).
+// It should be passed through supervisors that do not inherit the environment as is.
+func (s Script) Env(j *Journal) string {
+	c := childConfig{Script: s}
+	if j != nil {
+		c.Journal = j.path
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return childEnv + "=" + string(b)
+}
+
+// Argv returns the command line of the fake child: the test binary itself.
+func (s Script) Argv() []string {
+	self, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+	return []string{self}
+}
+
+// Command returns a new, not started command for the next fake child run following the script,
+// recording events into j (which may be nil), e.g. for a supervisor under test that starts commands itself:
+//
+//	cmd := script.Command(j)
+func (s Script) Command(j *Journal) *exec.Cmd {
+	argv := s.Argv()
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), s.Env(j))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// Main acts as a fake child if the test binary was started as one, and runs tests otherwise.
+// It should be called from TestMain.
+func Main(m *testing.M) {
+	if config := os.Getenv(childEnv); config != "" {
+		runChild(config)
+	}
+
+	os.Exit(m.Run())
+}
+
+// runChild acts as a fake child; it never returns.
+func runChild(config string) {
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "testutil: fake child: %s\n", err)
+		os.Exit(125)
+	}
+
+	var cc childConfig
+	if err := json.Unmarshal([]byte(config), &cc); err != nil {
+		fatal(err)
+	}
+
+	// handle signals before recording the start, so the test can send them right after it
+	terms := make(chan os.Signal, 1)
+	signal.Notify(terms, syscall.SIGTERM)
+
+	var j *Journal
+	var run int
+	if cc.Journal != "" {
+		j = &Journal{path: cc.Journal}
+		var err error
+		if run, err = j.start(); err != nil {
+			fatal(err)
+		}
+	}
+
+	var c Child
+	if len(cc.Script) > 0 {
+		c = cc.Script[min(run, len(cc.Script)-1)]
+	}
+
+	if c.Output != "" {
+		fmt.Print(c.Output)
+	}
+
+	if c.Listen != "" {
+		l, err := net.Listen("tcp", c.Listen)
+		if err != nil {
+			fatal(err)
+		}
+		go echo(l)
+	}
+
+	exit := func() {
+		if j != nil {
+			if err := j.record(Event{Kind: EventExit, Code: c.ExitCode}); err != nil {
+				fatal(err)
+			}
+		}
+		os.Exit(c.ExitCode)
+	}
+
+	var timeout <-chan time.Time
+	if c.RunFor > 0 {
+		timeout = time.After(c.RunFor)
+	}
+
+	for {
+		select {
+		case <-timeout:
+			exit()
+
+		case <-terms:
+			if j != nil {
+				if err := j.record(Event{Kind: EventTerm}); err != nil {
+					fatal(err)
+				}
+			}
+			if c.IgnoreTerm {
+				continue
+			}
+			time.Sleep(c.ShutdownDelay)
+			exit()
+		}
+	}
+}
+
+// echo accepts connections, and writes received data back.
+func echo(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Exit codes of GNU timeout(1).
+const (
+	timeoutExitTimedOut = 124
+	timeoutExitFailed   = 125
+	timeoutExitCannot   = 126
+	timeoutExitNotFound = 127
+)
+
+// parseTimeoutDuration parses duration in GNU timeout(1) format: a floating point number
+// with optional s (default), m, h, or d suffix; other formats accepted by parseDuration work too.
+func parseTimeoutDuration(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour}
+
+	num, unit := s, time.Second
+	if n := len(s); n > 0 {
+		if u, ok := units[s[n-1]]; ok {
+			num, unit = s[:n-1], u
+		}
+	}
+
+	if v, err := strconv.ParseFloat(num, 64); err == nil && v >= 0 {
+		return time.Duration(v * float64(unit)), nil
+	}
+
+	return parseDuration(s)
+}
+
+// timeout implements `ruc timeout` subcommand compatible with GNU timeout(1):
+// it runs the program once, sends it a signal after the duration, and optionally kills it later.
+func timeout(args []string) {
+	fs := flag.NewFlagSet("timeout", flag.ExitOnError)
+	signalF := fs.String("signal", "TERM", "Signal to send on timeout: name or number")
+	fs.StringVar(signalF, "s", "TERM", "Shorthand for -signal")
+	killAfterF := fs.String("kill-after", "", "Also send KILL that long after the initial signal (e.g. 10s)")
+	fs.StringVar(killAfterF, "k", "", "Shorthand for -kill-after")
+	preserveF := fs.Bool("preserve-status", false, "Exit with the same status as the program, even on timeout")
+	foregroundF := fs.Bool("foreground", false, "Run the program in ruc's process group, so it can read from the TTY and receive TTY signals")
+	verboseF := fs.Bool("verbose", false, "Print a message for each signal sent on timeout")
+	fs.BoolVar(verboseF, "v", false, "Shorthand for -verbose")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s timeout [flags] duration program [program arguments]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Runs program, and sends it a signal if it is still running after duration (0 disables the timeout).\n")
+		fmt.Fprintf(fs.Output(), "Exits with 124 if it timed out (unless -preserve-status is used), 125 if ruc failed,\n")
+		fmt.Fprintf(fs.Output(), "126 if program can't be invoked, 127 if it can't be found, and program's status otherwise.\n")
+		fmt.Fprintf(fs.Output(), "Flags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	fail := func(code int, format string, a ...any) {
+		fmt.Fprintf(os.Stderr, "ruc timeout: "+format+"\n", a...)
+		os.Exit(code)
+	}
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(timeoutExitFailed)
+	}
+
+	sig, err := parseSignal(*signalF)
+	if err != nil {
+		fail(timeoutExitFailed, "%s", err)
+	}
+
+	d, err := parseTimeoutDuration(fs.Arg(0))
+	if err != nil {
+		fail(timeoutExitFailed, "invalid duration %q", fs.Arg(0))
+	}
+
+	var killAfter time.Duration
+	if *killAfterF != "" {
+		if killAfter, err = parseTimeoutDuration(*killAfterF); err != nil {
+			fail(timeoutExitFailed, "invalid kill-after duration %q", *killAfterF)
+		}
+	}
+
+	cmd := exec.Command(fs.Arg(1), fs.Args()[2:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: !*foregroundF,
+	}
+
+	// forward signals that would normally terminate us
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT)
+
+	if err = cmd.Start(); err != nil {
+		code := timeoutExitCannot
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			code = timeoutExitNotFound
+		}
+		fail(code, "%s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var timedOut, killed bool
+	var timeoutC, killC <-chan time.Time
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeoutC = t.C
+	}
+
+	// the program has its own process group unless -foreground is used, so its children are signaled too,
+	// like GNU timeout(1) does
+	signalGroup := func(s syscall.Signal) error {
+		if *foregroundF {
+			return cmd.Process.Signal(s)
+		}
+		return syscall.Kill(-cmd.Process.Pid, s)
+	}
+
+	send := func(s syscall.Signal) {
+		if *verboseF {
+			fmt.Fprintf(os.Stderr, "ruc timeout: sending signal %s to command '%s'\n", signalName(s), fs.Arg(1))
+		}
+		if err := signalGroup(s); err != nil && !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH) {
+			fmt.Fprintf(os.Stderr, "ruc timeout: failed to send signal: %s\n", err)
+		}
+	}
+
+wait:
+	for {
+		select {
+		case err = <-done:
+			break wait
+
+		case s := <-signals:
+			_ = signalGroup(s.(syscall.Signal))
+
+		case <-timeoutC:
+			timedOut = true
+			timeoutC = nil
+			send(sig)
+			if sig == syscall.SIGKILL {
+				killed = true
+			} else {
+				// let stopped program handle the signal
+				_ = signalGroup(syscall.SIGCONT)
+			}
+
+			if killAfter > 0 {
+				t := time.NewTimer(killAfter)
+				defer t.Stop()
+				killC = t.C
+			}
+
+		case <-killC:
+			killed = true
+			killC = nil
+			send(syscall.SIGKILL)
+		}
+	}
+
+	code, ok := exitStatus(err)
+	if !ok {
+		fail(timeoutExitFailed, "%s", err)
+	}
+
+	// GNU timeout(1) exits with the same status as killed programs in that case
+	switch {
+	case killed:
+		os.Exit(128 + int(syscall.SIGKILL))
+	case timedOut && !*preserveF:
+		os.Exit(timeoutExitTimedOut)
+	default:
+		os.Exit(code)
+	}
+}
+
+// exitStatus returns shell-like exit status for the error returned by exec.Cmd.Wait:
+// the exit code, or 128+signal number if the program was killed by a signal.
+// It returns false if err is not about the program's exit.
+func exitStatus(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal()), true
+	}
+	return exitErr.ExitCode(), true
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/AlekSi/ruc/testutil"
+)
+
+// processGone returns true if the process does not exist or is a zombie.
+func processGone(pid int) bool {
+	if b, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat"); err == nil {
+		// the state follows the command name in parentheses
+		if i := bytes.LastIndexByte(b, ')'); i > 0 && i+2 < len(b) {
+			return b[i+2] == 'Z'
+		}
+	}
+	return syscall.Kill(pid, 0) != nil
+}
+
+func TestTimeoutSignalsProcessGroup(t *testing.T) {
+	bin := testutil.BuildRuc(t)
+
+	cmd := exec.Command(bin, "timeout", "0.5", "/bin/sh", "-c", "sleep 300 >/dev/null 2>&1 & echo $!; wait")
+	out, err := cmd.Output()
+	if code := cmd.ProcessState.ExitCode(); code != timeoutExitTimedOut {
+		t.Fatalf("expected exit code %d, got %d (%v)", timeoutExitTimedOut, code, err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = syscall.Kill(pid, syscall.SIGKILL) })
+
+	for deadline := time.Now().Add(5 * time.Second); !processGone(pid); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("program's child %d is still running after timeout", pid)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// trampolineEnv is the environment variable containing JSON-encoded trampolineConfig.
+// When it is set, ruc acts as a trampoline: it sets up its own process
+// in ways not supported by exec.Cmd, and then replaces itself with the program.
+const trampolineEnv = "RUC_TRAMPOLINE"
+
+// trampolineConfig describes what the trampoline should do before executing the program.
+type trampolineConfig struct {
+	ListenPID  bool        `json:"listen_pid,omitempty"`   // set LISTEN_PID to the program's PID
+	Gate       int         `json:"gate,omitempty"`         // wait for a byte on that file descriptor; exit if it is closed
+	Argv0      string      `json:"argv0,omitempty"`        // program's argv[0], if different from its name
+	Mounts     []bindMount `json:"mounts,omitempty"`       // bind mounts to make in the new mount namespace
+	Loopback   bool        `json:"loopback,omitempty"`     // bring up the loopback interface in the new network namespace
+	Caps       *uint64     `json:"caps,omitempty"`         // limit capabilities to that set
+	NoNewPrivs bool        `json:"no_new_privs,omitempty"` // set no_new_privs
+	Seccomp    string      `json:"seccomp,omitempty"`      // seccomp profile file to apply
+}
+
+// trampolineCommand returns a command that executes args via the trampoline.
+// Environment is inherited if env is nil.
+func trampolineCommand(tc *trampolineConfig, args, env []string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(tc)
+	if err != nil {
+		return nil, err
+	}
+
+	if env == nil {
+		env = os.Environ()
+	}
+
+	cmd := exec.Command(self, args...)
+	cmd.Env = append(env, trampolineEnv+"="+string(b))
+	return cmd, nil
+}
+
+// trampoline sets up the process and executes the program; it never returns.
+func trampoline(config string, args []string) {
+	fatal := func(code int, err error) {
+		fmt.Fprintf(os.Stderr, "ruc: trampoline: %s\n", err)
+		os.Exit(code)
+	}
+
+	var tc trampolineConfig
+	if err := json.Unmarshal([]byte(config), &tc); err != nil {
+		fatal(125, err)
+	}
+
+	if len(args) == 0 {
+		fatal(125, fmt.Errorf("no program"))
+	}
+
+	if tc.Loopback {
+		if err := loopbackUp(); err != nil {
+			fatal(125, err)
+		}
+	}
+
+	// the program is searched for in the new mounts
+	if len(tc.Mounts) > 0 {
+		if err := bindMounts(tc.Mounts); err != nil {
+			fatal(125, err)
+		}
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		fatal(127, err)
+	}
+
+	if tc.Gate != 0 {
+		gate := os.NewFile(uintptr(tc.Gate), "gate")
+		n, _ := gate.Read(make([]byte, 1))
+		gate.Close()
+		if n == 0 {
+			fatal(125, fmt.Errorf("gate closed"))
+		}
+	}
+
+	env := make([]string, 0, len(os.Environ())+1)
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, trampolineEnv+"=") {
+			env = append(env, e)
+		}
+	}
+
+	if tc.ListenPID {
+		env = append(env, "LISTEN_PID="+strconv.Itoa(os.Getpid()))
+	}
+
+	if tc.Argv0 != "" {
+		args = append([]string{tc.Argv0}, args[1:]...)
+	}
+
+	// credentials and seccomp filters are per-thread, and the program inherits them from the thread calling execve
+	runtime.LockOSThread()
+
+	if tc.Caps != nil {
+		if err = limitCaps(*tc.Caps); err != nil {
+			fatal(125, err)
+		}
+	}
+
+	if tc.NoNewPrivs {
+		if err = setNoNewPrivs(); err != nil {
+			fatal(125, err)
+		}
+	}
+
+	// the filter applies to everything after it, so it goes last
+	if tc.Seccomp != "" {
+		if err = applySeccomp(tc.Seccomp); err != nil {
+			fatal(125, err)
+		}
+	}
+
+	err = syscall.Exec(path, args, env)
+	fatal(126, err)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// externalTrigger is the -trigger source that starts each iteration instead of the run period timer.
+type externalTrigger struct {
+	kind string         // "fifo", "http", or "signal"
+	path string         // of the named pipe
+	sig  syscall.Signal // for the signal kind
+}
+
+// parseTrigger parses -trigger flag value: fifo:path, http, signal, or signal:name.
+func parseTrigger(s string) (*externalTrigger, error) {
+	kind, arg, _ := strings.Cut(s, ":")
+	switch kind {
+	case "fifo":
+		if arg == "" {
+			return nil, errors.New("fifo trigger requires a path")
+		}
+		return &externalTrigger{kind: kind, path: arg}, nil
+	case "http":
+		if arg != "" {
+			return nil, fmt.Errorf("invalid http trigger %q", s)
+		}
+		return &externalTrigger{kind: kind}, nil
+	case "signal":
+		t := &externalTrigger{kind: kind, sig: syscall.SIGUSR2}
+		if arg != "" {
+			var err error
+			if t.sig, err = parseSignal(arg); err != nil {
+				return nil, err
+			}
+		}
+		return t, nil
+	default:
+		return nil, fmt.Errorf("unknown trigger %q, expected fifo:path, http, or signal[:name]", s)
+	}
+}
+
+// watch sends fired triggers' descriptions until ctx is canceled.
+// The HTTP trigger is served by the -http listener instead.
+func (t *externalTrigger) watch(ctx context.Context, triggers chan<- string) error {
+	switch t.kind {
+	case "fifo":
+		if err := syscall.Mkfifo(t.path, 0o600); err != nil && !os.IsExist(err) {
+			return err
+		}
+
+		// open for writing too, so the pipe is not closed when writers come and go
+		f, err := os.OpenFile(t.path, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		log.Printf("Waiting for triggers on %s.", t.path)
+
+		go func() {
+			<-ctx.Done()
+			f.Close()
+		}()
+
+		// each line is a trigger
+		go func() {
+			s := bufio.NewScanner(f)
+			for s.Scan() {
+				requestRestart(triggers, "written to "+t.path)
+			}
+		}()
+
+	case "signal":
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, t.sig)
+		log.Printf("Waiting for SIG%s triggers.", signalName(t.sig))
+
+		go func() {
+			defer signal.Stop(ch)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ch:
+					requestRestart(triggers, "got SIG"+signalName(t.sig))
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// waitTrigger waits for the trigger starting the iteration, unless the previous one was stopped by it.
+func waitTrigger(ctx context.Context, in *instance) error {
+	if in.triggered {
+		in.triggered = false
+		return nil
+	}
+
+	in.log.Printf("Waiting for trigger...")
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case reason := <-in.trigger:
+		in.log.Printf("Starting program: trigger fired (%s).", reason)
+		return nil
+	}
+}
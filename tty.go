@@ -0,0 +1,28 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// tcsetpgrp makes the process group the foreground one of the terminal on fd.
+func tcsetpgrp(fd int, pgrp int) error {
+	p := int32(pgrp)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCSPGRP), uintptr(unsafe.Pointer(&p))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// hasTerminal returns true if fd is ruc's controlling terminal.
+func hasTerminal(fd int) bool {
+	var p int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCGPGRP), uintptr(unsafe.Pointer(&p)))
+	return errno == 0
+}
+
+// reclaimTerminal makes ruc's process group the foreground one again after the program exits.
+// SIGTTOU should be ignored, otherwise ruc would be stopped as a background process.
+func reclaimTerminal() error {
+	return tcsetpgrp(0, syscall.Getpgrp())
+}
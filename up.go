@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// procfileLineRE matches Procfile entries.
+var procfileLineRE = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// readProcfile reads foreman-style Procfile with name: command lines.
+func readProcfile(path string) (programs, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ps programs
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		m := procfileLineRE.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("%s:%d: invalid Procfile entry %q", path, n, line)
+		}
+		if err = ps.add(m[1] + "=" + m[2]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	if err = s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ps) == 0 {
+		return nil, fmt.Errorf("%s: no entries", path)
+	}
+	return ps, nil
+}
+
+// parseDotEnv parses .env file with NAME=value lines.
+// Lines may start with "export "; values may be single-quoted (literally),
+// or double-quoted (with \n, \t, \", and \\ escapes).
+func parseDotEnv(r io.Reader) (envVars, error) {
+	var vars envVars
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: invalid variable %q", n, line)
+		}
+
+		value = strings.TrimSpace(value)
+		switch {
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			value = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+		default:
+			// strip inline comment
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+
+		_ = vars.add(name + "=" + value)
+	}
+
+	return vars, s.Err()
+}
+
+// readDotEnv reads .env file; a missing file is not an error if it is not required.
+func readDotEnv(path string, required bool) (envVars, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	vars, err := parseDotEnv(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return vars, nil
+}
+
+// prefixColors are ANSI colors of output prefixes, as used by foreman.
+var prefixColors = []int{36, 33, 32, 35, 34, 31}
+
+// isTerminal returns true if f is a character device, such as terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// setPrefixes sets output prefixes of instances: labels padded to the same width,
+// colored by program if ruc's stdout is a terminal.
+func setPrefixes(instances []*instance) {
+	var width int
+	for _, in := range instances {
+		width = max(width, len(in.label()))
+	}
+
+	color := isTerminal(os.Stdout)
+	var programs []string
+	for _, in := range instances {
+		in.prefix = fmt.Sprintf("%-*s | ", width, in.label())
+		if !color {
+			continue
+		}
+
+		i := len(programs)
+		for j, name := range programs {
+			if name == in.name {
+				i = j
+			}
+		}
+		if i == len(programs) {
+			programs = append(programs, in.name)
+		}
+		in.color = "\x1b[" + strconv.Itoa(prefixColors[i%len(prefixColors)]) + "m"
+	}
+}
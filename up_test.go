@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadProcfile(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		content  string
+		expected map[string]string // program name -> command
+		err      string
+	}{
+		"Entries": {
+			content: "web: ./server -port $PORT\nworker:./worker\n",
+			expected: map[string]string{
+				"web":    "./server -port $PORT",
+				"worker": "./worker",
+			},
+		},
+		"CommentsAndBlankLines": {
+			content:  "# comment\n\n  web:  ./server  \n\t# indented comment\n",
+			expected: map[string]string{"web": "./server"},
+		},
+		"ColonInCommand": {
+			content:  "web: ./server -listen 127.0.0.1:8080\n",
+			expected: map[string]string{"web": "./server -listen 127.0.0.1:8080"},
+		},
+		"InvalidName": {
+			content: "web server: ./server\n",
+			err:     ":1: invalid Procfile entry",
+		},
+		"NoCommand": {
+			content: "# comment\nweb:\n",
+			err:     ":2: invalid Procfile entry",
+		},
+		"Duplicate": {
+			content: "web: a\nweb: b\n",
+			err:     `:2: duplicate program "web"`,
+		},
+		"Empty": {
+			content: "# nothing\n",
+			err:     "no entries",
+		},
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(tc.content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+
+		ps, err := readProcfile(path)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected %q error, got %v", name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if len(ps) != len(tc.expected) {
+			t.Errorf("%s: expected %d programs, got %d", name, len(tc.expected), len(ps))
+		}
+		for _, p := range ps {
+			expected := []string{"/bin/sh", "-c", tc.expected[p.name]}
+			if !slices.Equal(p.args, expected) {
+				t.Errorf("%s: %s: expected %q, got %q", name, p.name, expected, p.args)
+			}
+		}
+	}
+
+	if _, err := readProcfile(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestParseDotEnv(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content  string
+		expected envVars
+		err      string
+	}{
+		"Plain": {
+			content:  "A=1\nB = two words \nC=\n",
+			expected: envVars{"A=1", "B=two words", "C="},
+		},
+		"Export": {
+			content:  "export A=1\n",
+			expected: envVars{"A=1"},
+		},
+		"CommentsAndBlankLines": {
+			content:  "# comment\n\n  A=1\n",
+			expected: envVars{"A=1"},
+		},
+		"InlineComment": {
+			content:  "A=1 # comment\nB=x#y\n",
+			expected: envVars{"A=1", "B=x#y"},
+		},
+		"SingleQuoted": {
+			content:  `A='a\nb # c'` + "\n",
+			expected: envVars{`A=a\nb # c`},
+		},
+		"DoubleQuoted": {
+			content:  `A="a\nb\t\"c\" \\d # e"` + "\n",
+			expected: envVars{"A=a\nb\t\"c\" \\d # e"},
+		},
+		"UnmatchedQuote": {
+			content:  `A="a` + "\n",
+			expected: envVars{`A="a`},
+		},
+		"EqualsInValue": {
+			content:  "A=b=c\n",
+			expected: envVars{"A=b=c"},
+		},
+		"NoEquals": {
+			content: "A=1\nB\n",
+			err:     `line 2: invalid variable "B"`,
+		},
+		"NoName": {
+			content: "=1\n",
+			err:     `line 1: invalid variable "=1"`,
+		},
+	} {
+		vars, err := parseDotEnv(strings.NewReader(tc.content))
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected %q error, got %v", name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !slices.Equal(vars, tc.expected) {
+			t.Errorf("%s: expected %q, got %q", name, tc.expected, vars)
+		}
+	}
+}
+
+func TestReadDotEnv(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), ".env")
+
+	vars, err := readDotEnv(missing, false)
+	if err != nil || vars != nil {
+		t.Errorf("expected no variables and no error for optional file, got %q, %v", vars, err)
+	}
+
+	if _, err = readDotEnv(missing, true); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for required file, got %v", err)
+	}
+}
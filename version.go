@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// version is ruc's version set for release builds with -ldflags="-X main.version=v1.2.3";
+// the module version from build info is used if it is empty.
+var version string
+
+// buildInfo describes ruc's build.
+type buildInfo struct {
+	Version  string `json:"version"`            // release or module version, or (devel)
+	Revision string `json:"revision,omitempty"` // VCS revision
+	Time     string `json:"time,omitempty"`     // VCS revision time
+	Modified bool   `json:"modified,omitempty"` // built from the working tree with local modifications
+	Go       string `json:"go"`
+	Platform string `json:"platform"` // GOOS/GOARCH
+}
+
+// rucBuild is ruc's build info.
+var rucBuild = readBuildInfo()
+
+// readBuildInfo returns build info embedded into the binary.
+func readBuildInfo() buildInfo {
+	res := buildInfo{
+		Version:  version,
+		Go:       runtime.Version(),
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		if res.Version == "" {
+			res.Version = "(unknown)"
+		}
+		return res
+	}
+
+	if res.Version == "" {
+		res.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			res.Revision = s.Value
+		case "vcs.time":
+			res.Time = s.Value
+		case "vcs.modified":
+			res.Modified = s.Value == "true"
+		}
+	}
+	return res
+}
+
+// String returns a short description like "v1.2.3 (0123456789ab, modified) go1.22.1 linux/amd64".
+func (b buildInfo) String() string {
+	res := b.Version
+	if b.Revision != "" {
+		rev := b.Revision
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		if b.Modified {
+			rev += ", modified"
+		}
+		res += " (" + rev + ")"
+	}
+	return res + " " + b.Go + " " + b.Platform
+}
+
+// printVersion implements `ruc version` subcommand and -version flag.
+func printVersion(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	jsonF := fs.Bool("json", false, "Print build info as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s version [flags]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Prints ruc's version, VCS revision, Go version, and platform.\n")
+		fmt.Fprintf(fs.Output(), "Flags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *jsonF {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		_ = e.Encode(rucBuild)
+		return
+	}
+
+	fmt.Printf("ruc %s\n", rucBuild)
+}
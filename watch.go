@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// watchContent checks the file content hash every interval, and requests a graceful restart when it changes.
+// Read errors are logged and otherwise ignored, so a temporarily missing file does not cause a restart.
+func watchContent(ctx context.Context, path string, interval time.Duration, restart chan<- string) {
+	t := clk.NewTimer(interval)
+	defer t.Stop()
+
+	var failing bool
+	prev, err := hashFile(path)
+	for {
+		if err != nil && !failing {
+			log.Printf("Failed to read watched file: %s", err)
+		}
+		failing = err != nil
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			t.Reset(interval)
+		}
+
+		var h []byte
+		if h, err = hashFile(path); err != nil {
+			continue
+		}
+		if prev != nil && string(h) != string(prev) {
+			requestRestart(restart, "content of "+path+" changed")
+		}
+		prev = h
+	}
+}
+
+// watchBinary checks the program executable every -watch-interval, and requests a graceful restart
+// when it is a different file than the running one at path (replaced, or a symlink switched).
+// The new file should be the same for two checks in a row, so a binary that is still being copied is not started.
+func watchBinary(ctx context.Context, opts *options, in *instance, path string) {
+	prev, err := os.Stat(path)
+	if err != nil {
+		in.log.Printf("Failed to watch program executable: %s", err)
+		return
+	}
+
+	t := clk.NewTimer(opts.watchInterval)
+	defer t.Stop()
+
+	var pending os.FileInfo
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			t.Reset(opts.watchInterval)
+		}
+
+		// the executable may be missing in the middle of a deploy
+		p, err := lookProgram(opts, in.args[0])
+		if err != nil {
+			continue
+		}
+		fi, err := os.Stat(p)
+		if err != nil || sameBinary(prev, fi) {
+			pending = nil
+			continue
+		}
+
+		if pending == nil || !sameBinary(pending, fi) {
+			pending = fi
+			continue
+		}
+
+		requestRestart(in.restart, "program executable "+p+" changed")
+		return
+	}
+}
+
+// watchOutput checks that the program writes some output at least every -expect-output-every,
+// and reports it as degraded (without restarting it) while it does not.
+func watchOutput(ctx context.Context, opts *options, in *instance, cmd *command) {
+	start := clk.Now()
+	interval := max(opts.outputEvery/10, 100*time.Millisecond)
+	t := clk.NewTimer(interval)
+	defer t.Stop()
+
+	var degraded bool
+	for {
+		select {
+		case <-ctx.Done():
+			if degraded {
+				in.status.update(func(s *status) {
+					s.Degraded = ""
+				})
+			}
+			return
+		case <-t.C():
+			t.Reset(interval)
+		}
+
+		last := start
+		if n := cmd.lastOutput.Load(); n != 0 {
+			last = time.Unix(0, n)
+		}
+		silent := clk.Now().Sub(last) >= opts.outputEvery
+
+		switch {
+		case silent && !degraded:
+			reason := fmt.Sprintf("no output for %s", opts.outputEvery)
+			in.log.Printf("Program is degraded: %s.", reason)
+			in.status.update(func(s *status) {
+				s.Degraded = reason
+			})
+			opts.notifier.notify(in, eventDegraded, cmd.Process.Pid, cmd.runID, reason)
+		case !silent && degraded:
+			in.log.Printf("Program is not degraded anymore: it wrote output.")
+			in.status.update(func(s *status) {
+				s.Degraded = ""
+			})
+		}
+		degraded = silent
+	}
+}
+
+// sameBinary returns true if both describe the same unmodified file.
+func sameBinary(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// requestRestart sends a restart request unless there is a pending one already.
+func requestRestart(restart chan<- string, reason string) {
+	select {
+	case restart <- reason:
+	default:
+	}
+}
+
+// hashFile returns SHA-256 hash of the file content.
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
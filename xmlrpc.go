@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supervisord XML-RPC process states.
+const (
+	supervisorStopped  = 0
+	supervisorStarting = 10
+	supervisorRunning  = 20
+	supervisorStopping = 40
+	supervisorExited   = 100
+)
+
+// supervisorStateNames are names of supervisord process states.
+var supervisorStateNames = map[int]string{
+	supervisorStopped:  "STOPPED",
+	supervisorStarting: "STARTING",
+	supervisorRunning:  "RUNNING",
+	supervisorStopping: "STOPPING",
+	supervisorExited:   "EXITED",
+}
+
+// States of supervisord itself (as opposed to process states), reported by supervisor.getState.
+const (
+	supervisordFatal    = 2
+	supervisordRunning  = 1
+	supervisordShutdown = -1
+)
+
+// supervisordStateNames are names of supervisord states.
+var supervisordStateNames = map[int]string{
+	supervisordFatal:    "FATAL",
+	supervisordRunning:  "RUNNING",
+	supervisordShutdown: "SHUTDOWN",
+}
+
+// supervisord XML-RPC fault codes.
+const (
+	faultUnknownMethod  = 1
+	faultIncorrectParam = 2
+	faultBadName        = 10
+	faultSpawnError     = 50
+	faultAlreadyStarted = 60
+	faultNotRunning     = 70
+)
+
+// faultNames are names of supervisord fault codes, used as prefixes of fault strings.
+var faultNames = map[int]string{
+	faultUnknownMethod:  "UNKNOWN_METHOD",
+	faultIncorrectParam: "INCORRECT_PARAMETERS",
+	faultBadName:        "BAD_NAME",
+	faultSpawnError:     "SPAWN_ERROR",
+	faultAlreadyStarted: "ALREADY_STARTED",
+	faultNotRunning:     "NOT_RUNNING",
+}
+
+// xmlrpcFault is an XML-RPC fault response.
+type xmlrpcFault struct {
+	code int
+	msg  string
+}
+
+func (f *xmlrpcFault) Error() string {
+	if f.msg == "" {
+		return faultNames[f.code]
+	}
+	return faultNames[f.code] + ": " + f.msg
+}
+
+// xmlrpcValue is a parsed XML-RPC value of a method call parameter.
+type xmlrpcValue struct {
+	String  *string `xml:"string"`
+	Int     *int    `xml:"int"`
+	I4      *int    `xml:"i4"`
+	Boolean *int    `xml:"boolean"`
+	Text    string  `xml:",chardata"` // untyped values are strings
+}
+
+// xmlrpcCall is a parsed XML-RPC method call.
+type xmlrpcCall struct {
+	Method string        `xml:"methodName"`
+	Params []xmlrpcValue `xml:"params>param>value"`
+}
+
+// stringParam returns the i-th string parameter.
+func (c *xmlrpcCall) stringParam(i int) (string, error) {
+	if i >= len(c.Params) {
+		return "", &xmlrpcFault{code: faultIncorrectParam}
+	}
+	if v := c.Params[i].String; v != nil {
+		return *v, nil
+	}
+	return strings.TrimSpace(c.Params[i].Text), nil
+}
+
+// boolParam returns the i-th boolean parameter, or def if it is not present.
+func (c *xmlrpcCall) boolParam(i int, def bool) (bool, error) {
+	if i >= len(c.Params) {
+		return def, nil
+	}
+	if v := c.Params[i].Boolean; v != nil {
+		return *v != 0, nil
+	}
+	return false, &xmlrpcFault{code: faultIncorrectParam}
+}
+
+// writeXMLRPCValue writes v (string, int, bool, []any, or map[string]any) as XML-RPC value.
+func writeXMLRPCValue(w io.Writer, v any) {
+	io.WriteString(w, "<value>")
+	switch v := v.(type) {
+	case string:
+		io.WriteString(w, "<string>")
+		_ = xml.EscapeText(w, []byte(v))
+		io.WriteString(w, "</string>")
+	case int:
+		fmt.Fprintf(w, "<int>%d</int>", v)
+	case bool:
+		b := 0
+		if v {
+			b = 1
+		}
+		fmt.Fprintf(w, "<boolean>%d</boolean>", b)
+	case []any:
+		io.WriteString(w, "<array><data>")
+		for _, e := range v {
+			writeXMLRPCValue(w, e)
+		}
+		io.WriteString(w, "</data></array>")
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		io.WriteString(w, "<struct>")
+		for _, k := range keys {
+			fmt.Fprintf(w, "<member><name>%s</name>", k)
+			writeXMLRPCValue(w, v[k])
+			io.WriteString(w, "</member>")
+		}
+		io.WriteString(w, "</struct>")
+	default:
+		panic(fmt.Sprintf("unexpected XML-RPC value type %T", v))
+	}
+	io.WriteString(w, "</value>")
+}
+
+// xmlrpcHandler serves a subset of supervisord XML-RPC API for the instances.
+type xmlrpcHandler struct {
+	ctx       context.Context // canceled when ruc shuts down
+	instances []*instance
+	token     string // -control-token-file token required by methods that stop and start programs; empty disables them
+}
+
+// xmlrpcControlMethods are XML-RPC methods that change the state of programs.
+var xmlrpcControlMethods = []string{"supervisor.startProcess", "supervisor.stopProcess"}
+
+// authorized returns true if the request carries the token as a bearer token,
+// or as a basic authentication password (supervisorctl's password option).
+func (h *xmlrpcHandler) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return false
+	}
+
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, got, _ = r.BasicAuth()
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) == 1
+}
+
+// reject responds to the request for what (XML-RPC method or HTTP path) without the token.
+func (h *xmlrpcHandler) reject(w http.ResponseWriter, r *http.Request, what string) {
+	log.Printf("Rejected unauthenticated %s request from %s.", what, r.RemoteAddr)
+	if h.token == "" {
+		http.Error(w, what+" requires -control-token-file", http.StatusForbidden)
+		return
+	}
+
+	// like supervisord, so supervisorctl asks for credentials
+	w.Header().Set("WWW-Authenticate", `Basic realm="ruc"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// requireToken returns handler that calls next only for requests with the token.
+func (h *xmlrpcHandler) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.authorized(r) {
+			h.reject(w, r, r.URL.Path)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *xmlrpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "XML-RPC requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var call xmlrpcCall
+	res, err := any(nil), xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&call)
+	if err == nil && slices.Contains(xmlrpcControlMethods, call.Method) && !h.authorized(r) {
+		h.reject(w, r, call.Method)
+		return
+	}
+	if err == nil {
+		res, err = h.call(r, &call)
+	} else {
+		err = &xmlrpcFault{code: faultIncorrectParam, msg: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	io.WriteString(w, xml.Header+"<methodResponse>")
+	if err != nil {
+		f, ok := err.(*xmlrpcFault)
+		if !ok {
+			f = &xmlrpcFault{code: faultSpawnError, msg: err.Error()}
+		}
+		io.WriteString(w, "<fault>")
+		writeXMLRPCValue(w, map[string]any{"faultCode": f.code, "faultString": f.Error()})
+		io.WriteString(w, "</fault>")
+	} else {
+		io.WriteString(w, "<params><param>")
+		writeXMLRPCValue(w, res)
+		io.WriteString(w, "</param></params>")
+	}
+	io.WriteString(w, "</methodResponse>\n")
+}
+
+// xmlrpcMethods are supported XML-RPC methods.
+var xmlrpcMethods = []any{
+	"supervisor.getAPIVersion",
+	"supervisor.getState",
+	"supervisor.getProcessInfo",
+	"supervisor.getAllProcessInfo",
+	"supervisor.startProcess",
+	"supervisor.stopProcess",
+	"system.listMethods",
+}
+
+// call executes XML-RPC method call.
+func (h *xmlrpcHandler) call(r *http.Request, call *xmlrpcCall) (any, error) {
+	switch call.Method {
+	case "system.listMethods":
+		return xmlrpcMethods, nil
+
+	case "supervisor.getAPIVersion":
+		return "3.0", nil
+
+	case "supervisor.getState":
+		code := supervisordRunning
+		switch {
+		case h.ctx.Err() != nil:
+			code = supervisordShutdown
+		case current.get().Held:
+			code = supervisordFatal
+		}
+		return map[string]any{"statecode": code, "statename": supervisordStateNames[code]}, nil
+
+	case "supervisor.getAllProcessInfo":
+		res := make([]any, len(h.instances))
+		for i, in := range h.instances {
+			res[i] = supervisorProcessInfo(in, clk.Now())
+		}
+		return res, nil
+	}
+
+	name, err := call.stringParam(0)
+	if err != nil {
+		return nil, err
+	}
+	in := h.find(name)
+
+	switch call.Method {
+	case "supervisor.getProcessInfo":
+		if in == nil {
+			return nil, &xmlrpcFault{code: faultBadName, msg: name}
+		}
+		return supervisorProcessInfo(in, clk.Now()), nil
+
+	case "supervisor.startProcess", "supervisor.stopProcess":
+		if in == nil {
+			return nil, &xmlrpcFault{code: faultBadName, msg: name}
+		}
+		wait, err := call.boolParam(1, true)
+		if err != nil {
+			return nil, err
+		}
+		if call.Method == "supervisor.startProcess" {
+			return true, startHeld(r, in, wait)
+		}
+		return true, stopHeld(r, in, wait)
+
+	default:
+		return nil, &xmlrpcFault{code: faultUnknownMethod}
+	}
+}
+
+// find returns instance by supervisord process name ("name" or "group:name"), or nil.
+func (h *xmlrpcHandler) find(name string) *instance {
+	for _, in := range h.instances {
+		n := supervisorName(in)
+		if name == n || name == supervisorGroup(in)+":"+n {
+			return in
+		}
+	}
+	return nil
+}
+
+// supervisorGroup returns supervisord group name of the instance: program name, or executable name.
+func supervisorGroup(in *instance) string {
+	if in.name != "" {
+		return in.name
+	}
+	return filepath.Base(in.args[0])
+}
+
+// supervisorName returns supervisord process name of the instance.
+func supervisorName(in *instance) string {
+	if in.replicas > 1 {
+		return fmt.Sprintf("%s_%02d", supervisorGroup(in), in.index)
+	}
+	return supervisorGroup(in)
+}
+
+// supervisorState returns supervisord process state of the instance.
+func supervisorState(in *instance, s *status) int {
+	switch s.State {
+	case stateStarting:
+		return supervisorStarting
+	case stateRunning, stateBusy, stateDraining:
+		return supervisorRunning
+	case stateStopping, stateKilling:
+		return supervisorStopping
+	}
+
+	switch {
+	case in.isHeld():
+		return supervisorStopped
+	case s.State == stateExited:
+		return supervisorExited
+	default:
+		// waiting for the schedule, lock, dependencies, or backoff
+		return supervisorStarting
+	}
+}
+
+// supervisorProcessInfo returns supervisord process info struct of the instance.
+func supervisorProcessInfo(in *instance, now time.Time) map[string]any {
+	s := in.status.get()
+	state := supervisorState(in, &s)
+
+	var start, stop, exitStatus int
+	desc := "Not started"
+	if s.StartedAt != nil {
+		start = int(s.StartedAt.Unix())
+	}
+	if n := len(s.RecentExits); n > 0 {
+		e := s.RecentExits[n-1]
+		stop = int(e.Time.Unix())
+		desc = e.Result
+		if c, ok := strings.CutPrefix(e.Result, "exit status "); ok {
+			exitStatus, _ = strconv.Atoi(c)
+		}
+	}
+	if s.ChildPID != 0 && s.StartedAt != nil {
+		up := now.Sub(*s.StartedAt) / time.Second
+		desc = fmt.Sprintf("pid %d, uptime %d:%02d:%02d", s.ChildPID, up/3600, up/60%60, up%60)
+	}
+
+	return map[string]any{
+		"name":           supervisorName(in),
+		"group":          supervisorGroup(in),
+		"description":    desc,
+		"start":          start,
+		"stop":           stop,
+		"now":            int(now.Unix()),
+		"state":          state,
+		"statename":      supervisorStateNames[state],
+		"spawnerr":       "",
+		"exitstatus":     exitStatus,
+		"logfile":        "",
+		"stdout_logfile": "",
+		"stderr_logfile": "",
+		"pid":            s.ChildPID,
+	}
+}
+
+// stopHeld stops the program and prevents its restarts until startHeld.
+// If wait is true, it waits for the program to exit.
+func stopHeld(r *http.Request, in *instance, wait bool) error {
+	if !in.hold() {
+		return &xmlrpcFault{code: faultNotRunning, msg: supervisorName(in)}
+	}
+
+	in.log.Printf("Stop requested via XML-RPC.")
+	requestRestart(in.restart, "stopped via XML-RPC")
+	if !wait {
+		return nil
+	}
+
+	return pollStatus(r, in, func(s *status) (bool, error) {
+		return s.ChildPID == 0, nil
+	})
+}
+
+// startHeld allows program stopped by stopHeld to start again.
+// If wait is true, it waits for the program to start.
+func startHeld(r *http.Request, in *instance, wait bool) error {
+	iteration := in.status.get().Iteration
+	if !in.release() {
+		return &xmlrpcFault{code: faultAlreadyStarted, msg: supervisorName(in)}
+	}
+
+	in.log.Printf("Start requested via XML-RPC.")
+	if !wait {
+		return nil
+	}
+
+	return pollStatus(r, in, func(s *status) (bool, error) {
+		switch {
+		case s.State == stateRunning:
+			return true, nil
+		case s.State == stateExited && s.Iteration > iteration:
+			return false, &xmlrpcFault{code: faultSpawnError, msg: s.LastExit}
+		default:
+			return false, nil
+		}
+	})
+}
+
+// pollStatus calls f with the instance status until it returns true or an error, or the request is canceled.
+func pollStatus(r *http.Request, in *instance, f func(s *status) (bool, error)) error {
+	const interval = 100 * time.Millisecond
+	t := clk.NewTimer(interval)
+	defer t.Stop()
+
+	for {
+		s := in.status.get()
+		if ok, err := f(&s); ok || err != nil {
+			return err
+		}
+
+		select {
+		case <-r.Context().Done():
+			log.Printf("XML-RPC client for %s is gone.", supervisorName(in))
+			return r.Context().Err()
+		case <-t.C():
+			t.Reset(interval)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// xmlrpcPost calls the XML-RPC method with a single string parameter, and returns the response.
+func xmlrpcPost(t *testing.T, h http.Handler, method, param string, auth func(r *http.Request)) (int, string) {
+	t.Helper()
+
+	body := "<methodCall><methodName>" + method + "</methodName><params><param><value><string>" + param +
+		"</string></value></param></params></methodCall>"
+	r := httptest.NewRequest("POST", "/RPC2", strings.NewReader(body))
+	if auth != nil {
+		auth(r)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	b, _ := io.ReadAll(w.Result().Body)
+	return w.Code, string(b)
+}
+
+func TestXMLRPCGetState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &xmlrpcHandler{ctx: ctx}
+
+	if _, res := xmlrpcPost(t, h, "supervisor.getState", "", nil); !strings.Contains(res, "<string>RUNNING</string>") {
+		t.Errorf("expected RUNNING state, got %s", res)
+	}
+
+	cancel()
+	if _, res := xmlrpcPost(t, h, "supervisor.getState", "", nil); !strings.Contains(res, "<string>SHUTDOWN</string>") {
+		t.Errorf("expected SHUTDOWN state, got %s", res)
+	}
+}
+
+func TestXMLRPCControlAuth(t *testing.T) {
+	ctx := context.Background()
+
+	for name, tc := range map[string]struct {
+		token string
+		auth  func(r *http.Request)
+		code  int
+	}{
+		"NoToken": {
+			auth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer ") },
+			code: http.StatusForbidden,
+		},
+		"Missing": {
+			token: "secret",
+			code:  http.StatusUnauthorized,
+		},
+		"Wrong": {
+			token: "secret",
+			auth:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") },
+			code:  http.StatusUnauthorized,
+		},
+		"Bearer": {
+			token: "secret",
+			auth:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") },
+			code:  http.StatusOK,
+		},
+		"BasicPassword": {
+			token: "secret",
+			auth:  func(r *http.Request) { r.SetBasicAuth("user", "secret") },
+			code:  http.StatusOK,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := &xmlrpcHandler{ctx: ctx, token: tc.token}
+			for _, method := range xmlrpcControlMethods {
+				code, res := xmlrpcPost(t, h, method, "missing", tc.auth)
+				if code != tc.code {
+					t.Fatalf("%s: expected status %d, got %d: %s", method, tc.code, code, res)
+				}
+
+				// authorized calls reach the method, that does not find the program
+				if code == http.StatusOK && !strings.Contains(res, "BAD_NAME") {
+					t.Errorf("%s: expected BAD_NAME fault, got %s", method, res)
+				}
+			}
+		})
+	}
+
+	// read-only methods do not require the token
+	h := &xmlrpcHandler{ctx: ctx, token: "secret"}
+	if code, res := xmlrpcPost(t, h, "supervisor.getProcessInfo", "missing", nil); code != http.StatusOK || !strings.Contains(res, "BAD_NAME") {
+		t.Errorf("getProcessInfo: expected BAD_NAME fault, got %d: %s", code, res)
+	}
+}